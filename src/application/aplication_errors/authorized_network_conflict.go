@@ -0,0 +1,12 @@
+package aplication_errors
+
+import "fmt"
+
+// ErrAuthorizedNetworkConflict is returned when a network overlaps a network already authorized by another user.
+type ErrAuthorizedNetworkConflict struct {
+	Network string
+}
+
+func (e ErrAuthorizedNetworkConflict) Error() string {
+	return fmt.Sprintf("network overlaps %s authorized by another user", e.Network)
+}
@@ -0,0 +1,15 @@
+package aplication_errors
+
+import (
+	"fmt"
+	"time"
+)
+
+// ErrLockedOut is returned when authentication is refused after too many failed attempts.
+type ErrLockedOut struct {
+	RetryAfter time.Duration
+}
+
+func (e ErrLockedOut) Error() string {
+	return fmt.Sprintf("too many failed authentication attempts, retry after %v", e.RetryAfter)
+}
@@ -0,0 +1,11 @@
+package commands
+
+import "time"
+
+type PostProxyCredential struct {
+	UserId            int
+	Name              string
+	ExpiresAt         time.Time
+	AllowedNetworks   []string
+	TrafficQuotaBytes int64
+}
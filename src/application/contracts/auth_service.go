@@ -7,4 +7,5 @@ import (
 
 type AuthService interface {
 	AuthorizeBasic(user aggregates.User, credentials valueobjects.BasicCredentials) (bool, error)
+	AuthorizeCredential(credential aggregates.ProxyCredential, key string) (bool, error)
 }
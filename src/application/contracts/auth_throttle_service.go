@@ -0,0 +1,17 @@
+package contracts
+
+import (
+	"net"
+	"time"
+)
+
+// AuthThrottleService counts failed authentication attempts by username and by source IP and locks them out
+// when they fail too often. Attempts without an IP are only counted by username.
+type AuthThrottleService interface {
+	// LockedOut returns how long attempts of the username from the ip are refused for, 0 if they are not.
+	LockedOut(username string, ip net.IP) time.Duration
+	// RegisterFailure counts the failed attempt and returns how long the username or the ip got locked out for.
+	RegisterFailure(username string, ip net.IP) time.Duration
+	// RegisterSuccess forgets failures of the username.
+	RegisterSuccess(username string)
+}
@@ -0,0 +1,10 @@
+package contracts
+
+import "net"
+
+type AuthorizedNetworkIndex interface {
+	// Lookup returns the user whose authorized network contains the IP, false if there is none.
+	Lookup(ip net.IP) (int, bool)
+	// Reload replaces the indexed networks with the stored ones.
+	Reload() error
+}
@@ -0,0 +1,11 @@
+package contracts
+
+type ConnectionLimiterService interface {
+	// AcquireNode reserves a connection slot of the node. False is returned if the node is at capacity.
+	AcquireNode() bool
+	ReleaseNode()
+
+	// AcquireUser reserves a connection slot of the user across all targets. False is returned if the user is at limit.
+	AcquireUser(userId int) bool
+	ReleaseUser(userId int)
+}
@@ -1,13 +1,26 @@
 package contracts
 
 import (
+	"context"
+	"goproxy/domain/valueobjects"
 	"net"
 	"time"
 )
 
+// Dialer connects to targets from the egress IPs chosen for a user.
+type Dialer interface {
+	Dial(network, address string) (net.Conn, error)
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+
+	// LocalIP returns the egress IP for sockets bound to a single address, IPv4 is preferred for dual-stack dialers.
+	// Nil means the dialer does not bind to a specific IP.
+	LocalIP() net.IP
+}
+
 type DialerPool interface {
-	// GetDialer retrieves a dialer for the given network and userId. If userId is bound, the corresponding IP is used.
-	GetDialer(network string, userId int) (*net.Dialer, error)
+	// GetDialer retrieves a dialer for the given network and userId. The egress IP is chosen by the policy,
+	// with the policy of the user plan filling in what the passed policy leaves unset.
+	GetDialer(network string, userId int, policy valueobjects.EgressPolicy) (Dialer, error)
 
 	// BindDialerToUser binds an IP to a user for the specified TTL without creating a dialer.
 	BindDialerToUser(userId int, ttl time.Duration) error
@@ -0,0 +1,10 @@
+package contracts
+
+import "time"
+
+// DigestNonceCounterService keeps nonce counts taken by Digest responses, so a response authenticates a single request.
+type DigestNonceCounterService interface {
+	// Use takes the count of the nonce until expiresAt. False is returned if the count is not greater
+	// than a count taken before, i.e. the response is replayed.
+	Use(nonce string, count uint64, expiresAt time.Time) (bool, error)
+}
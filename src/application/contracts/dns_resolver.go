@@ -0,0 +1,12 @@
+package contracts
+
+import (
+	"context"
+	"net"
+)
+
+// DnsResolver resolves target host names for the dial path.
+type DnsResolver interface {
+	// LookupIP returns addresses of host, network "ip4" or "ip6" restricts them to a family, "ip" returns both.
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+}
@@ -5,4 +5,6 @@ import "time"
 type Jwt interface {
 	Generate(secret string, ttl time.Duration, claims map[string]string) (string, error)
 	Validate(secret string, token string) (bool, error)
+	// Parse validates the token and returns its claims, numeric claims are formatted as decimal numbers.
+	Parse(secret string, token string) (map[string]string, error)
 }
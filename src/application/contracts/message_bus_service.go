@@ -1,9 +1,13 @@
 package contracts
 
 import (
+	"errors"
 	"goproxy/domain/events"
 )
 
+// ErrNoEvent is returned by Consume when no event arrived for a while, so that consumers can check whether to stop.
+var ErrNoEvent = errors.New("no event received")
+
 type MessageBusService interface {
 	Subscribe(topics []string) error
 	Consume() (*events.OutboxEvent, error)
@@ -1,11 +1,18 @@
 package contracts
 
 import (
+	"goproxy/domain/valueobjects"
 	"net"
 	"net/http"
 )
 
 type ProxyService interface {
-	HandleHttps(clientConn net.Conn, r *http.Request, userId int)
-	HandleHttp(clientConn net.Conn, r *http.Request, userId int)
+	HandleHttps(clientConn net.Conn, r *http.Request, userId, credentialId int, options valueobjects.ConnectionOptions)
+	HandleHttp(clientConn net.Conn, r *http.Request, userId, credentialId int, options valueobjects.ConnectionOptions)
+
+	// ServeHttp2 serves streams of an HTTP/2 client connection with the handler until the connection is closed.
+	ServeHttp2(clientConn net.Conn, handler http.Handler)
+
+	// HandleHttp2 serves a CONNECT or plain HTTP request received on an HTTP/2 stream on behalf of the authorized user.
+	HandleHttp2(w http.ResponseWriter, r *http.Request, userId, credentialId int, options valueobjects.ConnectionOptions)
 }
@@ -57,3 +57,25 @@ type OrderRepository interface {
 	Repository[dataobjects.Order]
 	GetByPlanIdAndEmail(planId int, email valueobjects.Email) ([]dataobjects.Order, error)
 }
+
+type ProxyCredentialRepository interface {
+	Repository[aggregates.ProxyCredential]
+	GetAllByUserId(userId int) ([]aggregates.ProxyCredential, error)
+	AddUsedBytes(id int, bytes int64) (aggregates.ProxyCredential, error)
+}
+
+type AuthorizedNetworkRepository interface {
+	GetById(id int) (aggregates.AuthorizedNetwork, error)
+	GetAll() ([]aggregates.AuthorizedNetwork, error)
+	GetAllByUserId(userId int) ([]aggregates.AuthorizedNetwork, error)
+	// Create stores the network unless check returns an error for the networks already stored,
+	// no network is stored by others between the check and the insertion.
+	Create(network aggregates.AuthorizedNetwork, check func(existing []aggregates.AuthorizedNetwork) error) (int, error)
+	Delete(network aggregates.AuthorizedNetwork) error
+}
+
+type DigestCredentialRepository interface {
+	GetByUsername(username string) (dataobjects.DigestCredential, error)
+	// Save stores the credential of the user instead of the previous one.
+	Save(credential dataobjects.DigestCredential) error
+}
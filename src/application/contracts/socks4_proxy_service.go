@@ -0,0 +1,18 @@
+package contracts
+
+import (
+	"goproxy/domain/valueobjects"
+	"net"
+)
+
+type Socks4ProxyService interface {
+	// ReadSocks4Request reads a SOCKS4/SOCKS4a CONNECT request and returns the client credentials and the target host.
+	// Credentials are nil if the USERID field is empty.
+	ReadSocks4Request(clientConn net.Conn) (*valueobjects.BasicCredentials, string, error)
+
+	// WriteSocks4Rejected tells the client that its request was rejected.
+	WriteSocks4Rejected(clientConn net.Conn) error
+
+	// HandleSocks4 connects to host on behalf of the authorized user and tunnels the traffic.
+	HandleSocks4(clientConn net.Conn, host string, userId, credentialId int, options valueobjects.ConnectionOptions)
+}
@@ -0,0 +1,24 @@
+package contracts
+
+import (
+	"goproxy/domain/valueobjects"
+	"net"
+)
+
+type Socks5ProxyService interface {
+	// ReadSocks5Credentials negotiates RFC 1929 username/password authentication and returns the client credentials.
+	// If allowNoAuth is set, clients offering no authentication only are accepted without credentials and nil is returned.
+	ReadSocks5Credentials(clientConn net.Conn, allowNoAuth bool) (*valueobjects.BasicCredentials, error)
+
+	// WriteSocks5AuthStatus reports the authentication result to the client.
+	WriteSocks5AuthStatus(clientConn net.Conn, authorized bool) error
+
+	// WriteSocks5NoAcceptableMethods refuses the client greeting, so the client closes the connection.
+	WriteSocks5NoAcceptableMethods(clientConn net.Conn) error
+
+	// RejectSocks5Request reads the client request and replies that the connection is not allowed.
+	RejectSocks5Request(clientConn net.Conn) error
+
+	// HandleSocks5 reads the client request and serves it on behalf of the authorized user.
+	HandleSocks5(clientConn net.Conn, userId, credentialId int, options valueobjects.ConnectionOptions)
+}
@@ -0,0 +1,9 @@
+package contracts
+
+import "goproxy/domain/dataobjects"
+
+type UserPlanLimitsService interface {
+	// GetLimits returns limits of the user active plan. Zero limits are returned if they could not be loaded.
+	GetLimits(userId int) dataobjects.UserPlanLimits
+	SetLimits(userId int, limits dataobjects.UserPlanLimits) error
+}
@@ -75,6 +75,9 @@ func (e *EventProcessor) Start(ctx context.Context) error {
 
 func (e *EventProcessor) ProcessNextEvent() error {
 	event, err := e.messageBus.Consume()
+	if errors.Is(err, contracts.ErrNoEvent) {
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("failed to consume event: %v", err)
 	}
@@ -2,43 +2,190 @@ package use_cases
 
 import (
 	"fmt"
+	"goproxy/application/aplication_errors"
 	"goproxy/application/contracts"
 	"goproxy/domain/valueobjects"
+	"net"
+	"strings"
+	"time"
 )
 
 type AuthUseCases struct {
-	authService            contracts.AuthService
-	userRepository         contracts.UserRepository
-	userRestrictionService contracts.UserRestrictionService
+	authService               contracts.AuthService
+	userRepository            contracts.UserRepository
+	proxyCredentialRepository contracts.ProxyCredentialRepository
+	userRestrictionService    contracts.UserRestrictionService
+	authorizedNetworks        contracts.AuthorizedNetworkIndex
+	authThrottle              contracts.AuthThrottleService
+	unknownUsernames          contracts.CacheWithTTL[bool]
 }
 
-func NewAuthUseCases(authService contracts.AuthService, userRepository contracts.UserRepository, userRestrictionService contracts.UserRestrictionService) AuthUseCases {
+// unknownUsernameTtl is how long a username is known to have no user, so users created meanwhile
+// may have their usernames with parameter names misread for that long.
+const unknownUsernameTtl = time.Minute
+
+func NewAuthUseCases(authService contracts.AuthService, userRepository contracts.UserRepository,
+	proxyCredentialRepository contracts.ProxyCredentialRepository, userRestrictionService contracts.UserRestrictionService) AuthUseCases {
 	return AuthUseCases{
-		authService:            authService,
-		userRepository:         userRepository,
-		userRestrictionService: userRestrictionService,
+		authService:               authService,
+		userRepository:            userRepository,
+		proxyCredentialRepository: proxyCredentialRepository,
+		userRestrictionService:    userRestrictionService,
+	}
+}
+
+// WithAuthorizedNetworks lets clients from networks authorized by users use the proxy without credentials.
+func (a AuthUseCases) WithAuthorizedNetworks(authorizedNetworks contracts.AuthorizedNetworkIndex) AuthUseCases {
+	a.authorizedNetworks = authorizedNetworks
+	return a
+}
+
+// WithAuthThrottle locks usernames and client IPs out of password authentication after too many failed attempts.
+func (a AuthUseCases) WithAuthThrottle(authThrottle contracts.AuthThrottleService) AuthUseCases {
+	a.authThrottle = authThrottle
+	return a
+}
+
+// WithUnknownUsernames remembers usernames without a user looked up to parse proxy usernames for unknownUsernameTtl.
+func (a AuthUseCases) WithUnknownUsernames(unknownUsernames contracts.CacheWithTTL[bool]) AuthUseCases {
+	a.unknownUsernames = unknownUsernames
+	return a
+}
+
+// CheckLockout returns aplication_errors.ErrLockedOut if attempts of the username from clientIp are refused
+// after too many of them failed.
+func (a *AuthUseCases) CheckLockout(username string, clientIp net.IP) error {
+	if a.authThrottle == nil {
+		return nil
+	}
+
+	if lockedFor := a.authThrottle.LockedOut(username, clientIp); lockedFor > 0 {
+		return aplication_errors.ErrLockedOut{RetryAfter: lockedFor}
+	}
+	return nil
+}
+
+// RegisterAttempt counts the password attempt of the username from clientIp, failed attempts lead to lockouts.
+func (a *AuthUseCases) RegisterAttempt(username string, clientIp net.IP, succeeded bool) {
+	if a.authThrottle == nil {
+		return
+	}
+
+	if succeeded {
+		a.authThrottle.RegisterSuccess(username)
+		return
 	}
+	a.authThrottle.RegisterFailure(username, clientIp)
 }
 
-func (a *AuthUseCases) AuthorizeBasic(credentials valueobjects.Credentials) (bool, int, error) {
+// AuthorizeIp authorizes the client at clientIp by the authorized networks of users and returns the user id.
+// False is returned without an error if the IP belongs to no authorized network.
+func (a *AuthUseCases) AuthorizeIp(clientIp net.IP) (bool, int, error) {
+	if a.authorizedNetworks == nil || clientIp == nil {
+		return false, 0, nil
+	}
+
+	userId, found := a.authorizedNetworks.Lookup(clientIp)
+	if !found {
+		return false, 0, nil
+	}
+
+	if err := a.AuthorizeUserId(userId); err != nil {
+		return false, 0, err
+	}
+
+	return true, userId, nil
+}
+
+// AuthorizeUserId checks that the user authenticated by a scheme verifying credentials on its own,
+// e.g. by a network or a token, exists and is not restricted.
+func (a *AuthUseCases) AuthorizeUserId(userId int) error {
+	user, err := a.userRepository.GetById(userId)
+	if err != nil {
+		return fmt.Errorf("user not found")
+	}
+
+	if a.userRestrictionService.IsRestricted(user) {
+		return fmt.Errorf("user is restricted")
+	}
+
+	return nil
+}
+
+// AuthorizeBasic authorizes the user of the client at clientIp by the account password, or by the secret of an
+// active proxy credential of the user if the password is a secret. The user id and the id of the credential used are
+// returned, the credential id is 0 for the account password. Locked out attempts are refused before passwords are validated.
+func (a *AuthUseCases) AuthorizeBasic(credentials valueobjects.Credentials, clientIp net.IP) (bool, int, int, error) {
 	bCredentials, ok := credentials.(*valueobjects.BasicCredentials)
 	if ok {
+		if err := a.CheckLockout(bCredentials.Username, clientIp); err != nil {
+			return false, 0, 0, err
+		}
+
 		user, err := a.userRepository.GetByUsername(bCredentials.Username)
 		if err != nil {
-			return false, 0, fmt.Errorf("user not found")
+			a.RegisterAttempt(bCredentials.Username, clientIp, false)
+			return false, 0, 0, fmt.Errorf("user not found")
 		}
 
 		if a.userRestrictionService.IsRestricted(user) {
-			return false, 0, fmt.Errorf("user is restricted")
+			return false, 0, 0, fmt.Errorf("user is restricted")
+		}
+
+		// passwords with the secret prefix are never validated as account passwords
+		if secret, isSecret := valueobjects.ParseProxyCredentialSecret(bCredentials.Password); isSecret {
+			credentialValid, err := a.authorizeCredential(user.Id(), secret, clientIp)
+			a.RegisterAttempt(bCredentials.Username, clientIp, err == nil && credentialValid)
+			if err != nil || !credentialValid {
+				return false, 0, 0, err
+			}
+
+			return true, user.Id(), secret.CredentialId, nil
 		}
 
 		credentialsValid, err := a.authService.AuthorizeBasic(user, *bCredentials)
+		a.RegisterAttempt(bCredentials.Username, clientIp, err == nil && credentialsValid)
 		if err != nil {
-			return false, 0, err
+			return false, 0, 0, err
 		}
 
-		return credentialsValid, user.Id(), nil
+		return credentialsValid, user.Id(), 0, nil
+	}
+
+	return false, 0, 0, fmt.Errorf("invalid credentials")
+}
+
+// hasUser tells if a user with the username exists. Usernames without a user are remembered,
+// as the repository caches found users only.
+func (a *AuthUseCases) hasUser(username string) bool {
+	if a.unknownUsernames != nil {
+		if _, err := a.unknownUsernames.Get(username); err == nil {
+			return false
+		}
+	}
+
+	_, err := a.userRepository.GetByUsername(username)
+	if err != nil && a.unknownUsernames != nil && strings.Contains(err.Error(), "not found") {
+		_ = a.unknownUsernames.Set(username, true)
+		_ = a.unknownUsernames.Expire(username, unknownUsernameTtl)
+	}
+
+	return err == nil
+}
+
+func (a *AuthUseCases) authorizeCredential(userId int, secret valueobjects.ProxyCredentialSecret, clientIp net.IP) (bool, error) {
+	credential, err := a.proxyCredentialRepository.GetById(secret.CredentialId)
+	if err != nil || credential.UserId() != userId {
+		return false, fmt.Errorf("proxy credential not found")
+	}
+
+	if !credential.IsActive(time.Now().UTC()) {
+		return false, fmt.Errorf("proxy credential %d is not active", credential.Id())
+	}
+
+	if !credential.AllowsIp(clientIp) {
+		return false, fmt.Errorf("proxy credential %d is not allowed from %s", credential.Id(), clientIp)
 	}
 
-	return false, 0, fmt.Errorf("invalid credentials")
+	return a.authService.AuthorizeCredential(credential, secret.Key)
 }
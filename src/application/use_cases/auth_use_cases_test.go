@@ -0,0 +1,442 @@
+package use_cases
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"goproxy/application/aplication_errors"
+	"goproxy/domain/aggregates"
+	"goproxy/domain/valueobjects"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPasswordHash = "$argon2id$v=19$m=65536,t=3,p=2$c29tZXNhbHQ$RdescudvJCsgt3ub+b+dWRWJTmaaJObG"
+
+type fakeUserRepository struct {
+	users map[int]aggregates.User
+}
+
+func (r fakeUserRepository) GetById(id int) (aggregates.User, error) {
+	user, found := r.users[id]
+	if !found {
+		return aggregates.User{}, fmt.Errorf("user not found")
+	}
+	return user, nil
+}
+
+func (r fakeUserRepository) GetByUsername(username string) (aggregates.User, error) {
+	for _, user := range r.users {
+		if user.Username() == username {
+			return user, nil
+		}
+	}
+	return aggregates.User{}, fmt.Errorf("user not found")
+}
+
+func (r fakeUserRepository) GetByEmail(string) (aggregates.User, error) {
+	return aggregates.User{}, fmt.Errorf("user not found")
+}
+
+func (r fakeUserRepository) Create(aggregates.User) (int, error) { return 0, nil }
+func (r fakeUserRepository) Update(aggregates.User) error        { return nil }
+func (r fakeUserRepository) Delete(aggregates.User) error        { return nil }
+
+type fakeUserRestrictionService struct {
+	restricted map[int]bool
+}
+
+func (s fakeUserRestrictionService) IsRestricted(user aggregates.User) bool {
+	return s.restricted[user.Id()]
+}
+
+func (s fakeUserRestrictionService) AddToRestrictionList(aggregates.User) error      { return nil }
+func (s fakeUserRestrictionService) RemoveFromRestrictionList(aggregates.User) error { return nil }
+
+// fakeAuthorizedNetworkIndex authorizes single client IPs.
+type fakeAuthorizedNetworkIndex map[string]int
+
+func (i fakeAuthorizedNetworkIndex) Lookup(ip net.IP) (int, bool) {
+	userId, found := i[ip.String()]
+	return userId, found
+}
+
+func (i fakeAuthorizedNetworkIndex) Reload() error {
+	return nil
+}
+
+// remoteAddrConn is a connection of a client at the given address.
+type remoteAddrConn struct {
+	net.Conn
+	remoteAddr net.Addr
+}
+
+func (c remoteAddrConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func newTestAuthUseCases(t *testing.T) AuthUseCases {
+	alice, err := aggregates.NewUser(1, "alice", "alice@example.com", testPasswordHash)
+	require.NoError(t, err)
+	bob, err := aggregates.NewUser(2, "bob", "bob@example.com", testPasswordHash)
+	require.NoError(t, err)
+
+	users := fakeUserRepository{users: map[int]aggregates.User{1: alice, 2: bob}}
+	restrictions := fakeUserRestrictionService{restricted: map[int]bool{2: true}}
+	return NewAuthUseCases(nil, users, nil, restrictions).
+		WithAuthorizedNetworks(fakeAuthorizedNetworkIndex{"198.51.100.1": 1, "198.51.100.2": 2})
+}
+
+func TestAuthUseCases_AuthorizeIp(t *testing.T) {
+	authUseCases := newTestAuthUseCases(t)
+
+	tests := []struct {
+		name       string
+		ip         net.IP
+		authorized bool
+		wantErr    bool
+	}{
+		{name: "authorized network", ip: net.ParseIP("198.51.100.1"), authorized: true},
+		{name: "restricted user", ip: net.ParseIP("198.51.100.2"), wantErr: true},
+		{name: "unknown network", ip: net.ParseIP("192.0.2.1")},
+		{name: "unknown client address"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authorized, userId, err := authUseCases.AuthorizeIp(tt.ip)
+			assert.Equal(t, tt.authorized, authorized)
+			assert.Equal(t, tt.wantErr, err != nil)
+			if tt.authorized {
+				assert.Equal(t, 1, userId)
+			}
+		})
+	}
+}
+
+func TestAuthUseCases_AuthorizeIp_Disabled(t *testing.T) {
+	authUseCases := newTestAuthUseCases(t).WithAuthorizedNetworks(nil)
+
+	authorized, _, err := authUseCases.AuthorizeIp(net.ParseIP("198.51.100.1"))
+	assert.NoError(t, err)
+	assert.False(t, authorized)
+}
+
+func TestProxyUseCases_HandleAuthorization_ByIp(t *testing.T) {
+	proxyUseCases := NewProxyUseCases(nil, nil, nil, nil, nil, newTestAuthUseCases(t))
+
+	tests := []struct {
+		name       string
+		clientIp   string
+		wantUserId string
+	}{
+		{name: "authorized network", clientIp: "198.51.100.1", wantUserId: "1"},
+		{name: "unknown network", clientIp: "192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer func() {
+				_ = clientConn.Close()
+				_ = serverConn.Close()
+			}()
+			conn := remoteAddrConn{Conn: serverConn, remoteAddr: &net.TCPAddr{IP: net.ParseIP(tt.clientIp), Port: 40000}}
+
+			responses := make(chan *http.Response, 1)
+			go func() {
+				response, _ := http.ReadResponse(bufio.NewReader(clientConn), nil)
+				responses <- response
+			}()
+
+			request, err := http.NewRequest(http.MethodConnect, "http://example.com:443", nil)
+			require.NoError(t, err)
+
+			_, _, err = proxyUseCases.HandleAuthorization(conn, request)
+			if tt.wantUserId != "" {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantUserId, request.Header.Get("Proxy-Authorization"))
+				return
+			}
+
+			assert.Error(t, err)
+			response := <-responses
+			require.NotNil(t, response)
+			assert.Equal(t, http.StatusProxyAuthRequired, response.StatusCode)
+		})
+	}
+}
+
+// fakeAuthService accepts the password "secret" of any user.
+type fakeAuthService struct {
+	calls *int
+}
+
+func (s fakeAuthService) AuthorizeBasic(_ aggregates.User, credentials valueobjects.BasicCredentials) (bool, error) {
+	*s.calls++
+	if credentials.Password != "secret" {
+		return false, fmt.Errorf("invalid credentials")
+	}
+	return true, nil
+}
+
+func (s fakeAuthService) AuthorizeCredential(aggregates.ProxyCredential, string) (bool, error) {
+	return false, fmt.Errorf("invalid credentials")
+}
+
+// fakeAuthThrottle locks usernames out for a minute after 2 failures.
+type fakeAuthThrottle struct {
+	failures map[string]int
+}
+
+func (t fakeAuthThrottle) LockedOut(username string, _ net.IP) time.Duration {
+	if t.failures[username] >= 2 {
+		return time.Minute
+	}
+	return 0
+}
+
+func (t fakeAuthThrottle) RegisterFailure(username string, _ net.IP) time.Duration {
+	t.failures[username]++
+	return t.LockedOut(username, nil)
+}
+
+func (t fakeAuthThrottle) RegisterSuccess(username string) {
+	delete(t.failures, username)
+}
+
+// fakeProxyCredentialRepository finds no credentials.
+type fakeProxyCredentialRepository struct{}
+
+func (fakeProxyCredentialRepository) GetById(int) (aggregates.ProxyCredential, error) {
+	return aggregates.ProxyCredential{}, fmt.Errorf("proxy credential not found")
+}
+
+func (fakeProxyCredentialRepository) GetAllByUserId(int) ([]aggregates.ProxyCredential, error) {
+	return nil, nil
+}
+
+func (fakeProxyCredentialRepository) AddUsedBytes(int, int64) (aggregates.ProxyCredential, error) {
+	return aggregates.ProxyCredential{}, fmt.Errorf("proxy credential not found")
+}
+
+func (fakeProxyCredentialRepository) Create(aggregates.ProxyCredential) (int, error) { return 0, nil }
+func (fakeProxyCredentialRepository) Update(aggregates.ProxyCredential) error        { return nil }
+func (fakeProxyCredentialRepository) Delete(aggregates.ProxyCredential) error        { return nil }
+
+func TestAuthUseCases_AuthorizeBasic_SecretPrefixIsReserved(t *testing.T) {
+	authServiceCalls := 0
+	authUseCases := newTestAuthUseCases(t)
+	authUseCases.proxyCredentialRepository = fakeProxyCredentialRepository{}
+	authUseCases.authService = fakeAuthService{calls: &authServiceCalls}
+
+	// the secret of an unknown credential is refused without validating it as the account password
+	authorized, userId, credentialId, err := authUseCases.AuthorizeBasic(&valueobjects.BasicCredentials{Username: "alice", Password: "pxc.1.abcdefgh"}, net.ParseIP("192.0.2.1"))
+	assert.Error(t, err)
+	assert.False(t, authorized)
+	assert.Zero(t, userId)
+	assert.Zero(t, credentialId)
+	assert.Zero(t, authServiceCalls)
+}
+
+func TestAuthUseCases_AuthorizeBasic_LockOut(t *testing.T) {
+	authServiceCalls := 0
+	throttle := fakeAuthThrottle{failures: map[string]int{}}
+	authUseCases := newTestAuthUseCases(t).WithAuthThrottle(throttle)
+	authUseCases.authService = fakeAuthService{calls: &authServiceCalls}
+	clientIp := net.ParseIP("192.0.2.1")
+
+	// a successful attempt forgets failures
+	_, _, _, err := authUseCases.AuthorizeBasic(&valueobjects.BasicCredentials{Username: "alice", Password: "guess"}, clientIp)
+	assert.Error(t, err)
+	authorized, userId, _, err := authUseCases.AuthorizeBasic(&valueobjects.BasicCredentials{Username: "alice", Password: "secret"}, clientIp)
+	require.NoError(t, err)
+	assert.True(t, authorized)
+	assert.Equal(t, 1, userId)
+	assert.Empty(t, throttle.failures)
+
+	// unknown usernames are counted, restricted users are not
+	_, _, _, err = authUseCases.AuthorizeBasic(&valueobjects.BasicCredentials{Username: "mallory", Password: "guess"}, clientIp)
+	assert.Error(t, err)
+	_, _, _, err = authUseCases.AuthorizeBasic(&valueobjects.BasicCredentials{Username: "bob", Password: "secret"}, clientIp)
+	assert.Error(t, err)
+	assert.Equal(t, map[string]int{"mallory": 1}, throttle.failures)
+
+	// locked out usernames are refused without validating the password
+	for i := 0; i < 2; i++ {
+		_, _, _, err = authUseCases.AuthorizeBasic(&valueobjects.BasicCredentials{Username: "alice", Password: "guess"}, clientIp)
+		assert.Error(t, err)
+	}
+	calls := authServiceCalls
+	authorized, _, _, err = authUseCases.AuthorizeBasic(&valueobjects.BasicCredentials{Username: "alice", Password: "secret"}, clientIp)
+	assert.False(t, authorized)
+	assert.Equal(t, aplication_errors.ErrLockedOut{RetryAfter: time.Minute}, err)
+	assert.Equal(t, calls, authServiceCalls)
+}
+
+func TestProxyUseCases_HandleAuthorization_LockedOut(t *testing.T) {
+	authServiceCalls := 0
+	authUseCases := newTestAuthUseCases(t).WithAuthThrottle(fakeAuthThrottle{failures: map[string]int{"alice": 2}})
+	authUseCases.authService = fakeAuthService{calls: &authServiceCalls}
+	proxyUseCases := NewProxyUseCases(nil, nil, nil, nil, nil, authUseCases)
+
+	clientConn, serverConn := net.Pipe()
+	defer func() {
+		_ = clientConn.Close()
+		_ = serverConn.Close()
+	}()
+	conn := remoteAddrConn{Conn: serverConn, remoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40000}}
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		response, _ := http.ReadResponse(bufio.NewReader(clientConn), nil)
+		responses <- response
+	}()
+
+	request, err := http.NewRequest(http.MethodConnect, "http://example.com:443", nil)
+	require.NoError(t, err)
+	request.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("alice:secret")))
+
+	_, _, err = proxyUseCases.HandleAuthorization(conn, request)
+	assert.ErrorAs(t, err, &aplication_errors.ErrLockedOut{})
+
+	response := <-responses
+	require.NotNil(t, response)
+	assert.Equal(t, http.StatusTooManyRequests, response.StatusCode)
+	assert.Equal(t, "60", response.Header.Get("Retry-After"))
+}
+
+func TestProxyUseCases_HandleAuthorization_InvalidUsernameParameters(t *testing.T) {
+	authServiceCalls := 0
+	authUseCases := newTestAuthUseCases(t)
+	authUseCases.authService = fakeAuthService{calls: &authServiceCalls}
+	proxyUseCases := NewProxyUseCases(nil, nil, nil, nil, nil, authUseCases)
+
+	clientConn, serverConn := net.Pipe()
+	defer func() {
+		_ = clientConn.Close()
+		_ = serverConn.Close()
+	}()
+	conn := remoteAddrConn{Conn: serverConn, remoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40000}}
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		response, _ := http.ReadResponse(bufio.NewReader(clientConn), nil)
+		responses <- response
+	}()
+
+	request, err := http.NewRequest(http.MethodConnect, "http://example.com:443", nil)
+	require.NoError(t, err)
+	request.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("alice-session-xyz-region\r\neu:secret")))
+
+	_, _, err = proxyUseCases.HandleAuthorization(conn, request)
+	assert.ErrorAs(t, err, &valueobjects.InvalidProxyUsernameError{})
+
+	response := <-responses
+	require.NotNil(t, response)
+	assert.Equal(t, http.StatusProxyAuthRequired, response.StatusCode)
+	assert.Equal(t, `unknown parameter "region\r\neu"`, response.Header.Get(proxyAuthReasonHeader))
+	assert.Equal(t, []string{`Basic realm="Proxy"`}, response.Header.Values("Proxy-Authenticate"))
+	assert.Zero(t, authServiceCalls)
+}
+
+func TestAuthorizeBasic_UsernamesContainingParameterNames(t *testing.T) {
+	users := fakeUserRepository{users: map[int]aggregates.User{}}
+	for id, username := range map[int]string{1: "alice", 3: "tom-speed", 4: "ann-country-x", 5: "alice-country-de"} {
+		user, err := aggregates.NewUser(id, username, username+"@example.com", testPasswordHash)
+		require.NoError(t, err)
+		users.users[id] = user
+	}
+	authServiceCalls := 0
+	authUseCases := NewAuthUseCases(fakeAuthService{calls: &authServiceCalls}, users, nil, fakeUserRestrictionService{})
+
+	tests := []struct {
+		name        string
+		username    string
+		wantUserId  int
+		wantCountry string
+	}{
+		{name: "parameter name without value", username: "tom-speed", wantUserId: 3},
+		{name: "invalid parameter value", username: "ann-country-x", wantUserId: 4},
+		{name: "valid parameters", username: "alice-country-de", wantUserId: 5},
+		{name: "parameters of a dashed username", username: "tom-speed-country-de", wantUserId: 3, wantCountry: "de"},
+		{name: "parameters of a username", username: "alice-country-fr", wantUserId: 1, wantCountry: "fr"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authorized, userId, _, options, err := authorizeBasic(&authUseCases,
+				&valueobjects.BasicCredentials{Username: tt.username, Password: "secret"}, "192.0.2.1:40000")
+			require.NoError(t, err)
+			assert.True(t, authorized)
+			assert.Equal(t, tt.wantUserId, userId)
+			assert.Equal(t, tt.wantCountry, options.Country())
+		})
+	}
+}
+
+// countingUserRepository counts lookups of users by username.
+type countingUserRepository struct {
+	fakeUserRepository
+	lookups map[string]int
+}
+
+func (r countingUserRepository) GetByUsername(username string) (aggregates.User, error) {
+	r.lookups[username]++
+	return r.fakeUserRepository.GetByUsername(username)
+}
+
+// fakeUnknownUsernames keeps entries without expiring them.
+type fakeUnknownUsernames map[string]bool
+
+func (c fakeUnknownUsernames) Get(key string) (bool, error) {
+	value, found := c[key]
+	if !found {
+		return false, fmt.Errorf("not found")
+	}
+	return value, nil
+}
+
+func (c fakeUnknownUsernames) Set(key string, value bool) error {
+	c[key] = value
+	return nil
+}
+
+func (c fakeUnknownUsernames) Delete(key string) error {
+	delete(c, key)
+	return nil
+}
+
+func (c fakeUnknownUsernames) Expire(string, time.Duration) error { return nil }
+
+func TestAuthorizeBasic_BoundsUserLookupsOfUsernameParameters(t *testing.T) {
+	users := countingUserRepository{fakeUserRepository: fakeUserRepository{users: map[int]aggregates.User{}}, lookups: map[string]int{}}
+	throttle := fakeAuthThrottle{failures: map[string]int{}}
+	authUseCases := NewAuthUseCases(fakeAuthService{calls: new(int)}, users, nil, fakeUserRestrictionService{}).
+		WithAuthThrottle(throttle).
+		WithUnknownUsernames(fakeUnknownUsernames{})
+
+	// "u-speed-speed", "u-speed-speed-speed-1" and "u-speed-speed-speed-1-country-de" are followed by valid parameters
+	username := "u-speed-speed-speed-1-country-de-v4"
+	_, _, _, _, err := authorizeBasic(&authUseCases, &valueobjects.BasicCredentials{Username: username, Password: "secret"}, "192.0.2.1:40000")
+	assert.ErrorAs(t, err, &valueobjects.InvalidProxyUsernameError{})
+	assert.Equal(t, map[string]int{
+		username:                           1,
+		"u-speed-speed-speed-1-country-de": 1,
+		"u-speed-speed-speed-1":            1,
+	}, users.lookups)
+
+	// usernames without users are not looked up again, and failed attempts lock the username out
+	_, _, _, _, err = authorizeBasic(&authUseCases, &valueobjects.BasicCredentials{Username: username, Password: "secret"}, "192.0.2.1:40000")
+	assert.ErrorAs(t, err, &valueobjects.InvalidProxyUsernameError{})
+	assert.Equal(t, 1, users.lookups[username])
+
+	_, _, _, _, err = authorizeBasic(&authUseCases, &valueobjects.BasicCredentials{Username: username, Password: "secret"}, "192.0.2.1:40000")
+	assert.ErrorAs(t, err, &aplication_errors.ErrLockedOut{})
+	assert.Equal(t, 1, users.lookups[username])
+}
@@ -0,0 +1,61 @@
+package use_cases
+
+import (
+	"fmt"
+	"goproxy/application/aplication_errors"
+	"goproxy/application/contracts"
+	"goproxy/domain/aggregates"
+	"time"
+)
+
+type AuthorizedNetworkUseCases struct {
+	repo contracts.AuthorizedNetworkRepository
+}
+
+func NewAuthorizedNetworkUseCases(repo contracts.AuthorizedNetworkRepository) AuthorizedNetworkUseCases {
+	return AuthorizedNetworkUseCases{
+		repo: repo,
+	}
+}
+
+func (a AuthorizedNetworkUseCases) GetAllByUserId(userId int) ([]aggregates.AuthorizedNetwork, error) {
+	return a.repo.GetAllByUserId(userId)
+}
+
+// Create authorizes the network for the user. Networks overlapping a network of another user are refused
+// with aplication_errors.ErrAuthorizedNetworkConflict, so every client IP belongs to a single user.
+func (a AuthorizedNetworkUseCases) Create(userId int, network string) (aggregates.AuthorizedNetwork, error) {
+	authorizedNetwork, err := aggregates.NewAuthorizedNetwork(-1, userId, network, time.Now().UTC())
+	if err != nil {
+		return aggregates.AuthorizedNetwork{}, err
+	}
+
+	id, err := a.repo.Create(authorizedNetwork, func(existingNetworks []aggregates.AuthorizedNetwork) error {
+		for _, existing := range existingNetworks {
+			if !existing.Overlaps(authorizedNetwork) {
+				continue
+			}
+			if existing.UserId() != userId {
+				return aplication_errors.ErrAuthorizedNetworkConflict{Network: existing.Network()}
+			}
+			if existing.Network() == authorizedNetwork.Network() {
+				return fmt.Errorf("network %s is already authorized", existing.Network())
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return aggregates.AuthorizedNetwork{}, err
+	}
+
+	return aggregates.NewAuthorizedNetwork(id, userId, authorizedNetwork.Network(), authorizedNetwork.CreatedAt())
+}
+
+func (a AuthorizedNetworkUseCases) Delete(userId, id int) error {
+	network, err := a.repo.GetById(id)
+	if err != nil || network.UserId() != userId {
+		return fmt.Errorf("authorized network not found")
+	}
+
+	return a.repo.Delete(network)
+}
@@ -0,0 +1,125 @@
+package use_cases
+
+import (
+	"errors"
+	"fmt"
+	"goproxy/application/aplication_errors"
+	"goproxy/domain/aggregates"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAuthorizedNetworkRepository struct {
+	networks []aggregates.AuthorizedNetwork
+}
+
+func (r *fakeAuthorizedNetworkRepository) GetById(id int) (aggregates.AuthorizedNetwork, error) {
+	for _, network := range r.networks {
+		if network.Id() == id {
+			return network, nil
+		}
+	}
+	return aggregates.AuthorizedNetwork{}, fmt.Errorf("authorized network not found")
+}
+
+func (r *fakeAuthorizedNetworkRepository) GetAll() ([]aggregates.AuthorizedNetwork, error) {
+	return r.networks, nil
+}
+
+func (r *fakeAuthorizedNetworkRepository) GetAllByUserId(userId int) ([]aggregates.AuthorizedNetwork, error) {
+	var networks []aggregates.AuthorizedNetwork
+	for _, network := range r.networks {
+		if network.UserId() == userId {
+			networks = append(networks, network)
+		}
+	}
+	return networks, nil
+}
+
+func (r *fakeAuthorizedNetworkRepository) Create(network aggregates.AuthorizedNetwork,
+	check func(existing []aggregates.AuthorizedNetwork) error) (int, error) {
+	if err := check(r.networks); err != nil {
+		return 0, err
+	}
+	id := len(r.networks) + 1
+	stored, err := aggregates.NewAuthorizedNetwork(id, network.UserId(), network.Network(), network.CreatedAt())
+	if err != nil {
+		return 0, err
+	}
+	r.networks = append(r.networks, stored)
+	return id, nil
+}
+
+func (r *fakeAuthorizedNetworkRepository) Delete(network aggregates.AuthorizedNetwork) error {
+	for i, stored := range r.networks {
+		if stored.Id() == network.Id() {
+			r.networks = append(r.networks[:i], r.networks[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no rows affected")
+}
+
+func TestAuthorizedNetworkUseCases_Create(t *testing.T) {
+	tests := []struct {
+		name         string
+		userId       int
+		network      string
+		wantConflict bool
+		wantErr      bool
+	}{
+		{name: "separate network", userId: 2, network: "203.0.113.0/24"},
+		{name: "network inside network of another user", userId: 2, network: "198.51.100.7", wantConflict: true},
+		{name: "network around network of another user", userId: 2, network: "198.51.0.0/16", wantConflict: true},
+		{name: "network inside own network", userId: 1, network: "198.51.100.128/25"},
+		{name: "same network twice", userId: 1, network: "198.51.100.0/24", wantErr: true},
+		{name: "too wide network", userId: 2, network: "203.0.0.0/8", wantErr: true},
+		{name: "private network", userId: 2, network: "192.168.1.0/24", wantErr: true},
+		{name: "loopback address", userId: 2, network: "127.0.0.1", wantErr: true},
+		{name: "link-local network", userId: 2, network: "169.254.0.0/16", wantErr: true},
+		{name: "shared address space", userId: 2, network: "100.64.1.0/24", wantErr: true},
+		{name: "unique local network", userId: 2, network: "fd00:1::/48", wantErr: true},
+		{name: "link-local IPv6 network", userId: 2, network: "fe80::/64", wantErr: true},
+		{name: "public IPv6 network", userId: 2, network: "2001:db8:1::/48"},
+		{name: "invalid network", userId: 2, network: "not-a-network", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeAuthorizedNetworkRepository{}
+			useCases := NewAuthorizedNetworkUseCases(repo)
+			_, err := useCases.Create(1, "198.51.100.0/24")
+			require.NoError(t, err)
+
+			network, err := useCases.Create(tt.userId, tt.network)
+			if tt.wantConflict {
+				var conflictErr aplication_errors.ErrAuthorizedNetworkConflict
+				require.True(t, errors.As(err, &conflictErr), "expected conflict, got %v", err)
+				assert.Equal(t, "198.51.100.0/24", conflictErr.Network)
+				return
+			}
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, 2, network.Id())
+			assert.Equal(t, tt.userId, network.UserId())
+		})
+	}
+}
+
+func TestAuthorizedNetworkUseCases_Delete(t *testing.T) {
+	network, err := aggregates.NewAuthorizedNetwork(1, 1, "198.51.100.0/24", time.Time{})
+	require.NoError(t, err)
+	repo := &fakeAuthorizedNetworkRepository{networks: []aggregates.AuthorizedNetwork{network}}
+	useCases := NewAuthorizedNetworkUseCases(repo)
+
+	assert.Error(t, useCases.Delete(2, 1), "networks of other users must not be deleted")
+	assert.NoError(t, useCases.Delete(1, 1))
+	assert.Empty(t, repo.networks)
+}
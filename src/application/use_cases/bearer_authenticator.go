@@ -0,0 +1,65 @@
+package use_cases
+
+import (
+	"fmt"
+	"goproxy/application/contracts"
+	"net/http"
+	"strconv"
+)
+
+// BearerAuthenticator authenticates clients by JWTs signed by our issuer, whose subject is the user id.
+// Tokens must expire, since they cannot be revoked.
+type BearerAuthenticator struct {
+	authUseCases AuthUseCases
+	jwt          contracts.Jwt
+	secret       string
+	issuer       string
+	realm        string
+}
+
+func NewBearerAuthenticator(authUseCases AuthUseCases, jwt contracts.Jwt, secret, issuer, realm string) *BearerAuthenticator {
+	return &BearerAuthenticator{
+		authUseCases: authUseCases,
+		jwt:          jwt,
+		secret:       secret,
+		issuer:       issuer,
+		realm:        realm,
+	}
+}
+
+func (b *BearerAuthenticator) Scheme() string {
+	return "Bearer"
+}
+
+func (b *BearerAuthenticator) Challenges(err error) []string {
+	if err != nil {
+		// RFC 6750, section 3.1
+		return []string{fmt.Sprintf("Bearer realm=%q, error=\"invalid_token\"", b.realm)}
+	}
+	return []string{fmt.Sprintf("Bearer realm=%q", b.realm)}
+}
+
+func (b *BearerAuthenticator) Authenticate(credentials string, _ *http.Request, _ string) (ProxyIdentity, error) {
+	claims, err := b.jwt.Parse(b.secret, credentials)
+	if err != nil {
+		return ProxyIdentity{}, err
+	}
+
+	if claims["iss"] != b.issuer {
+		return ProxyIdentity{}, fmt.Errorf("token issuer %q is not %q", claims["iss"], b.issuer)
+	}
+	if claims["exp"] == "" {
+		return ProxyIdentity{}, fmt.Errorf("token does not expire")
+	}
+
+	userId, err := strconv.Atoi(claims["sub"])
+	if err != nil || userId <= 0 {
+		return ProxyIdentity{}, fmt.Errorf("invalid token subject %q", claims["sub"])
+	}
+
+	if err = b.authUseCases.AuthorizeUserId(userId); err != nil {
+		return ProxyIdentity{}, err
+	}
+
+	return ProxyIdentity{UserId: userId}, nil
+}
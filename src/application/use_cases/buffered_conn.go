@@ -0,0 +1,34 @@
+package use_cases
+
+import (
+	"bufio"
+	"net"
+)
+
+// bufferedConn is a net.Conn that reads through a bufio.Reader,
+// so bytes peeked while detecting the protocol are not lost.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func newBufferedConn(conn net.Conn, reader *bufio.Reader) *bufferedConn {
+	return &bufferedConn{
+		Conn:   conn,
+		reader: reader,
+	}
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// Buffered returns how many bytes are read from the buffer before the connection is read again.
+func (c *bufferedConn) Buffered() int {
+	return c.reader.Buffered()
+}
+
+// NetConn returns the wrapped connection, which is read after the buffered bytes.
+func (c *bufferedConn) NetConn() net.Conn {
+	return c.Conn
+}
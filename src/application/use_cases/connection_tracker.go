@@ -0,0 +1,135 @@
+package use_cases
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// forcedCloseGracePeriod is how long handlers of forcibly closed connections are awaited,
+// so that they report the traffic and release the limits of their connections.
+const forcedCloseGracePeriod = time.Second
+
+// connectionTracker keeps client connections being served, so that they can be drained on shutdown.
+type connectionTracker struct {
+	mu       sync.Mutex
+	conns    map[*trackedConn]struct{}
+	draining bool
+	wg       sync.WaitGroup
+}
+
+// trackedConn is a client connection known to the tracker. Idle connections of keep-alive clients
+// waiting for the next request are closed as soon as draining starts.
+type trackedConn struct {
+	net.Conn
+	idle bool
+}
+
+// Buffered returns 0 as trackedConn reads nothing ahead. Along with NetConn it lets tunnels of TCP clients be spliced.
+func (c *trackedConn) Buffered() int {
+	return 0
+}
+
+func (c *trackedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func newConnectionTracker() *connectionTracker {
+	return &connectionTracker{
+		conns: make(map[*trackedConn]struct{}),
+	}
+}
+
+// add starts tracking conn. False is returned if the tracker is draining and conn must not be served.
+func (t *connectionTracker) add(conn net.Conn) (*trackedConn, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.draining {
+		return nil, false
+	}
+
+	tracked := &trackedConn{Conn: conn}
+	t.conns[tracked] = struct{}{}
+	t.wg.Add(1)
+	return tracked, true
+}
+
+func (t *connectionTracker) remove(conn *trackedConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.conns, conn)
+	t.wg.Done()
+}
+
+// setIdle marks conn as waiting for the next request or as serving one.
+// False is returned if the tracker is draining, so the connection must not wait for more requests.
+func (t *connectionTracker) setIdle(conn net.Conn, idle bool) bool {
+	if buffered, ok := conn.(*bufferedConn); ok {
+		conn = buffered.Conn
+	}
+	tracked, ok := conn.(*trackedConn)
+	if !ok {
+		return true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if idle && t.draining {
+		return false
+	}
+	tracked.idle = idle
+	return true
+}
+
+func (t *connectionTracker) isDraining() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.draining
+}
+
+// startDraining stops accepting new connections and closes idle ones.
+func (t *connectionTracker) startDraining() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.draining = true
+	for conn := range t.conns {
+		if conn.idle {
+			_ = conn.Close()
+		}
+	}
+}
+
+// wait waits until busy connections are closed by their handlers.
+// Connections still open when ctx is done are closed forcibly and ctx error is returned.
+func (t *connectionTracker) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	t.mu.Lock()
+	for conn := range t.conns {
+		_ = conn.Close()
+	}
+	t.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-time.After(forcedCloseGracePeriod):
+	}
+
+	return ctx.Err()
+}
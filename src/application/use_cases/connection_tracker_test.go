@@ -0,0 +1,80 @@
+package use_cases
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// trackPipe tracks the server end of a pipe, the handler is simulated by the returned done func.
+func trackPipe(t *testing.T, tracker *connectionTracker) (*trackedConn, net.Conn, func()) {
+	server, client := net.Pipe()
+	t.Cleanup(func() { _ = client.Close() })
+
+	tracked, ok := tracker.add(server)
+	require.True(t, ok)
+	return tracked, client, func() { tracker.remove(tracked) }
+}
+
+// assertClosed checks that the server end of the pipe was closed.
+func assertClosed(t *testing.T, client net.Conn) {
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := client.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestConnectionTracker_ClosesIdleAndWaitsForBusyConnections(t *testing.T) {
+	tracker := newConnectionTracker()
+	idle, idleClient, idleDone := trackPipe(t, tracker)
+	busy, busyClient, busyDone := trackPipe(t, tracker)
+
+	// keep-alive connections are tracked through the buffered connection they are read with
+	assert.True(t, tracker.setIdle(newBufferedConn(idle, bufio.NewReader(idle)), true))
+	assert.True(t, tracker.setIdle(busy, false))
+
+	tracker.startDraining()
+	idleDone()
+
+	assertClosed(t, idleClient)
+	assert.False(t, tracker.setIdle(busy, true), "connections must not wait for requests while draining")
+
+	_, ok := tracker.add(busyClient)
+	assert.False(t, ok, "new connections must not be served while draining")
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		busyDone()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, tracker.wait(ctx))
+}
+
+func TestConnectionTracker_ClosesBusyConnectionsOnDeadline(t *testing.T) {
+	tracker := newConnectionTracker()
+	busy, busyClient, busyDone := trackPipe(t, tracker)
+	tracker.setIdle(busy, false)
+
+	// the handler returns once its connection is closed
+	go func() {
+		_, _ = busy.Read(make([]byte, 1))
+		busyDone()
+	}()
+
+	tracker.startDraining()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	assert.ErrorIs(t, tracker.wait(ctx), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), forcedCloseGracePeriod)
+
+	assertClosed(t, busyClient)
+}
@@ -0,0 +1,350 @@
+package use_cases
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"goproxy/application/contracts"
+	"goproxy/domain/dataobjects"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	digestAlgorithmMd5    = "MD5"
+	digestAlgorithmSha256 = "SHA-256"
+	digestSessionSuffix   = "-sess"
+	digestQopAuth         = "auth"
+	// digestNonceClockSkew is how far in the future a nonce issued by another node may be
+	digestNonceClockSkew = time.Minute
+)
+
+var (
+	errDigestStaleNonce         = errors.New("digest nonce is stale")
+	errDigestInvalidNonce       = errors.New("digest nonce is invalid")
+	errDigestReplayedNonceCount = errors.New("digest nonce count was already used")
+)
+
+// NewDigestCredential computes the hashes Digest authentication of the user in the realm is verified with.
+func NewDigestCredential(userId int, username, realm, password string) dataobjects.DigestCredential {
+	a1 := fmt.Sprintf("%s:%s:%s", username, realm, password)
+	return dataobjects.DigestCredential{
+		UserId:    userId,
+		Username:  username,
+		Realm:     realm,
+		Ha1Md5:    digestHash(digestAlgorithmMd5, a1),
+		Ha1Sha256: digestHash(digestAlgorithmSha256, a1),
+	}
+}
+
+// DigestAuthenticator authenticates clients by HTTP Digest (RFC 7616) with qop "auth", so passwords are never sent.
+// Responses are verified with hashes stored when the user sets the password, users without them cannot use Digest.
+// Usernames are taken as they are, without connection parameters, since they are a part of the stored hashes.
+type DigestAuthenticator struct {
+	authUseCases AuthUseCases
+	repo         contracts.DigestCredentialRepository
+	realm        string
+	nonces       *digestNonces
+	nonceCounter contracts.DigestNonceCounterService
+}
+
+// NewDigestAuthenticator creates an authenticator issuing nonces valid for nonceTTL, signed with nonceSecret.
+// Nodes sharing the secret accept nonces of each other, an empty secret is replaced with a random one.
+// Nonce counts are kept in memory of the node, so nodes sharing the secret must share them with WithNonceCounter,
+// otherwise a captured response can be replayed once on every other node.
+func NewDigestAuthenticator(authUseCases AuthUseCases, repo contracts.DigestCredentialRepository, realm, nonceSecret string,
+	nonceTTL time.Duration) (*DigestAuthenticator, error) {
+	secret := []byte(nonceSecret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("could not generate digest nonce secret: %v", err)
+		}
+	}
+
+	return &DigestAuthenticator{
+		authUseCases: authUseCases,
+		repo:         repo,
+		realm:        realm,
+		nonces:       newDigestNonces(secret, nonceTTL),
+		nonceCounter: newLocalDigestNonceCounter(nonceTTL),
+	}, nil
+}
+
+// WithNonceCounter makes the authenticator keep nonce counts in the counter instead of memory of the node.
+func (d *DigestAuthenticator) WithNonceCounter(nonceCounter contracts.DigestNonceCounterService) *DigestAuthenticator {
+	d.nonceCounter = nonceCounter
+	return d
+}
+
+func (d *DigestAuthenticator) Scheme() string {
+	return "Digest"
+}
+
+// Challenges offers SHA-256 and, for older clients, MD5 with a fresh nonce. Clients whose nonce expired
+// are told it is stale, so they retry without asking the user for the password again.
+func (d *DigestAuthenticator) Challenges(err error) []string {
+	nonce := d.nonces.issue(time.Now())
+	stale := ""
+	if errors.Is(err, errDigestStaleNonce) {
+		stale = ", stale=true"
+	}
+
+	challenges := make([]string, 0, 2)
+	for _, algorithm := range []string{digestAlgorithmSha256, digestAlgorithmMd5} {
+		challenges = append(challenges, fmt.Sprintf("Digest realm=%q, qop=%q, algorithm=%s, nonce=%q%s",
+			d.realm, digestQopAuth, algorithm, nonce, stale))
+	}
+	return challenges
+}
+
+func (d *DigestAuthenticator) Authenticate(credentials string, request *http.Request, clientAddr string) (ProxyIdentity, error) {
+	params, err := parseAuthParams(credentials)
+	if err != nil {
+		return ProxyIdentity{}, err
+	}
+
+	if params["realm"] != d.realm {
+		return ProxyIdentity{}, fmt.Errorf("digest realm %q is not %q", params["realm"], d.realm)
+	}
+	if params["userhash"] == "true" {
+		return ProxyIdentity{}, fmt.Errorf("digest userhash is not supported")
+	}
+	if params["qop"] != digestQopAuth {
+		return ProxyIdentity{}, fmt.Errorf("digest qop %q is not supported", params["qop"])
+	}
+
+	algorithm := strings.ToUpper(params["algorithm"])
+	if algorithm == "" {
+		algorithm = digestAlgorithmMd5
+	}
+	baseAlgorithm := strings.TrimSuffix(algorithm, strings.ToUpper(digestSessionSuffix))
+	if baseAlgorithm != digestAlgorithmMd5 && baseAlgorithm != digestAlgorithmSha256 {
+		return ProxyIdentity{}, fmt.Errorf("digest algorithm %q is not supported", params["algorithm"])
+	}
+
+	// the response is bound to the request it was computed for
+	uri := requestTarget(request)
+	if params["uri"] != uri {
+		return ProxyIdentity{}, fmt.Errorf("digest uri %q does not match the request target %q", params["uri"], uri)
+	}
+
+	nonce, cnonce, nc := params["nonce"], params["cnonce"], params["nc"]
+	nonceCount, ncErr := strconv.ParseUint(nc, 16, 32)
+	if ncErr != nil || len(nc) != 8 || cnonce == "" {
+		return ProxyIdentity{}, fmt.Errorf("digest nonce count and client nonce are required")
+	}
+	issuedAt, nonceErr := d.nonces.verify(nonce, time.Now())
+	if nonceErr != nil {
+		return ProxyIdentity{}, nonceErr
+	}
+
+	username, clientIp := params["username"], clientIpOf(clientAddr)
+	if err = d.authUseCases.CheckLockout(username, clientIp); err != nil {
+		return ProxyIdentity{}, err
+	}
+
+	credential, err := d.repo.GetByUsername(username)
+	if err != nil {
+		d.authUseCases.RegisterAttempt(username, clientIp, false)
+		return ProxyIdentity{}, fmt.Errorf("no digest credential of %s: %v", username, err)
+	}
+	if credential.Realm != d.realm {
+		return ProxyIdentity{}, fmt.Errorf("digest credential of %s was stored for realm %q", username, credential.Realm)
+	}
+
+	ha1 := credential.Ha1Md5
+	if baseAlgorithm == digestAlgorithmSha256 {
+		ha1 = credential.Ha1Sha256
+	}
+	if algorithm != baseAlgorithm {
+		ha1 = digestHash(baseAlgorithm, fmt.Sprintf("%s:%s:%s", ha1, nonce, cnonce))
+	}
+	ha2 := digestHash(baseAlgorithm, fmt.Sprintf("%s:%s", request.Method, params["uri"]))
+	expected := digestHash(baseAlgorithm, fmt.Sprintf("%s:%s:%s:%s:%s:%s", ha1, nonce, nc, cnonce, digestQopAuth, ha2))
+	responseValid := subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(params["response"]))) == 1
+	d.authUseCases.RegisterAttempt(username, clientIp, responseValid)
+	if !responseValid {
+		return ProxyIdentity{}, fmt.Errorf("invalid digest response of %s", username)
+	}
+
+	// counts are only taken by valid responses, so they cannot be used up by others
+	counted, err := d.nonceCounter.Use(nonce, nonceCount, issuedAt.Add(d.nonces.ttl))
+	if err != nil {
+		return ProxyIdentity{}, fmt.Errorf("could not take digest nonce count: %v", err)
+	}
+	if !counted {
+		return ProxyIdentity{}, errDigestReplayedNonceCount
+	}
+
+	if err = d.authUseCases.AuthorizeUserId(credential.UserId); err != nil {
+		return ProxyIdentity{}, err
+	}
+
+	return ProxyIdentity{UserId: credential.UserId}, nil
+}
+
+// requestTarget returns the request-target the client sent, which Digest responses are computed over.
+func requestTarget(request *http.Request) string {
+	if request.RequestURI != "" {
+		return request.RequestURI
+	}
+	if request.Method == http.MethodConnect {
+		return request.Host
+	}
+	return request.URL.String()
+}
+
+func digestHash(algorithm, value string) string {
+	var h hash.Hash
+	if algorithm == digestAlgorithmSha256 {
+		h = sha256.New()
+	} else {
+		h = md5.New()
+	}
+	h.Write([]byte(value))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// digestNonces issues nonces carrying their issue time signed with the secret, so any node sharing the secret
+// verifies them without storing them.
+type digestNonces struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+func newDigestNonces(secret []byte, ttl time.Duration) *digestNonces {
+	return &digestNonces{
+		secret: secret,
+		ttl:    ttl,
+	}
+}
+
+func (n *digestNonces) issue(now time.Time) string {
+	nonce := make([]byte, 8, 8+sha256.Size)
+	binary.BigEndian.PutUint64(nonce, uint64(now.UnixNano()))
+	return base64.RawURLEncoding.EncodeToString(append(nonce, n.sign(nonce)...))
+}
+
+// verify returns the issue time of the nonce, errDigestStaleNonce if it expired.
+func (n *digestNonces) verify(nonce string, now time.Time) (time.Time, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(decoded) != 8+sha256.Size || !hmac.Equal(decoded[8:], n.sign(decoded[:8])) {
+		return time.Time{}, errDigestInvalidNonce
+	}
+
+	issuedAt := time.Unix(0, int64(binary.BigEndian.Uint64(decoded[:8])))
+	if issuedAt.After(now.Add(digestNonceClockSkew)) {
+		return time.Time{}, errDigestInvalidNonce
+	}
+	if now.Sub(issuedAt) > n.ttl {
+		return time.Time{}, errDigestStaleNonce
+	}
+
+	return issuedAt, nil
+}
+
+func (n *digestNonces) sign(issuedAt []byte) []byte {
+	mac := hmac.New(sha256.New, n.secret)
+	mac.Write(issuedAt)
+	return mac.Sum(nil)
+}
+
+// localDigestNonceCounter keeps nonce counts of a single node in memory until nonces expire.
+type localDigestNonceCounter struct {
+	ttl       time.Duration
+	mu        sync.Mutex
+	counts    map[string]uint64
+	expiries  map[string]time.Time
+	lastPurge time.Time
+}
+
+func newLocalDigestNonceCounter(ttl time.Duration) *localDigestNonceCounter {
+	return &localDigestNonceCounter{
+		ttl:      ttl,
+		counts:   make(map[string]uint64),
+		expiries: make(map[string]time.Time),
+	}
+}
+
+// Use takes the nonce count of the nonce, counts must grow with every request of the client.
+func (c *localDigestNonceCounter) Use(nonce string, count uint64, expiresAt time.Time) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastPurge) > c.ttl {
+		for expiredNonce, expiry := range c.expiries {
+			if now.After(expiry) {
+				delete(c.expiries, expiredNonce)
+				delete(c.counts, expiredNonce)
+			}
+		}
+		c.lastPurge = now
+	}
+
+	if count <= c.counts[nonce] {
+		return false, nil
+	}
+	c.counts[nonce] = count
+	c.expiries[nonce] = expiresAt
+
+	return true, nil
+}
+
+// parseAuthParams parses a comma-separated list of auth-params (RFC 9110, section 11.2), names are lowercased.
+func parseAuthParams(s string) (map[string]string, error) {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return params, nil
+		}
+
+		name, rest, found := strings.Cut(s, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid auth-param: %s", s)
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		rest = strings.TrimLeft(rest, " \t")
+
+		var value strings.Builder
+		if strings.HasPrefix(rest, "\"") {
+			closed := false
+			i := 1
+			for ; i < len(rest); i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+					value.WriteByte(rest[i])
+					continue
+				}
+				if rest[i] == '"' {
+					closed = true
+					break
+				}
+				value.WriteByte(rest[i])
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated auth-param %s", name)
+			}
+			s = rest[i+1:]
+		} else {
+			token, remaining, _ := strings.Cut(rest, ",")
+			value.WriteString(strings.TrimSpace(token))
+			s = remaining
+		}
+
+		params[name] = value.String()
+	}
+}
@@ -0,0 +1,120 @@
+package use_cases
+
+import (
+	"errors"
+	"fmt"
+	"goproxy/domain/valueobjects"
+	"net/http"
+	"strings"
+)
+
+// defaultProxyAuthRealm is the realm of challenges if none is configured.
+const defaultProxyAuthRealm = "Proxy"
+
+// ProxyAuthenticator authenticates clients by Proxy-Authorization headers of a single auth-scheme.
+type ProxyAuthenticator interface {
+	// Scheme returns the auth-scheme handled, e.g. "Basic".
+	Scheme() string
+
+	// Challenges returns Proxy-Authenticate header values offering the scheme to a client whose authentication
+	// with the scheme failed with err, err is nil if the client did not try the scheme.
+	Challenges(err error) []string
+
+	// Authenticate authenticates the client at clientAddr sending the request by the credentials following the scheme.
+	Authenticate(credentials string, request *http.Request, clientAddr string) (ProxyIdentity, error)
+}
+
+// ProxyIdentity is who the client is authenticated as. CredentialId is 0 unless a proxy credential was used.
+type ProxyIdentity struct {
+	UserId       int
+	CredentialId int
+	Options      valueobjects.ConnectionOptions
+}
+
+// ProxyAuthenticators picks the authenticator of the scheme of Proxy-Authorization headers.
+// Schemes are offered to clients in the order of authenticators.
+type ProxyAuthenticators struct {
+	authenticators []ProxyAuthenticator
+}
+
+func NewProxyAuthenticators(authenticators ...ProxyAuthenticator) ProxyAuthenticators {
+	return ProxyAuthenticators{
+		authenticators: authenticators,
+	}
+}
+
+// Authenticate authenticates the client by the Proxy-Authorization header. Failures are UnauthorizedError
+// carrying the scheme tried, so that it is challenged accordingly.
+func (a ProxyAuthenticators) Authenticate(header string, request *http.Request, clientAddr string) (ProxyIdentity, error) {
+	scheme, credentials, _ := strings.Cut(strings.TrimSpace(header), " ")
+	for _, authenticator := range a.authenticators {
+		// auth-schemes are case-insensitive (RFC 9110, section 11.1)
+		if !strings.EqualFold(authenticator.Scheme(), scheme) {
+			continue
+		}
+
+		identity, err := authenticator.Authenticate(strings.TrimSpace(credentials), request, clientAddr)
+		if err != nil {
+			return ProxyIdentity{}, UnauthorizedError{Scheme: authenticator.Scheme(), Err: err}
+		}
+		return identity, nil
+	}
+
+	return ProxyIdentity{}, UnauthorizedError{Err: fmt.Errorf("unsupported auth-scheme %q", scheme)}
+}
+
+// Challenges returns Proxy-Authenticate header values of all schemes for a client whose authentication failed with err.
+func (a ProxyAuthenticators) Challenges(err error) []string {
+	var unauthorizedErr UnauthorizedError
+	errors.As(err, &unauthorizedErr)
+
+	var challenges []string
+	for _, authenticator := range a.authenticators {
+		if unauthorizedErr.Scheme == authenticator.Scheme() {
+			challenges = append(challenges, authenticator.Challenges(unauthorizedErr.Err)...)
+		} else {
+			challenges = append(challenges, authenticator.Challenges(nil)...)
+		}
+	}
+
+	return challenges
+}
+
+// BasicAuthenticator authenticates clients by username and password (RFC 7617). The username may carry
+// connection parameters and the password may be the secret of a proxy credential.
+type BasicAuthenticator struct {
+	authUseCases AuthUseCases
+	realm        string
+}
+
+func NewBasicAuthenticator(authUseCases AuthUseCases, realm string) *BasicAuthenticator {
+	return &BasicAuthenticator{
+		authUseCases: authUseCases,
+		realm:        realm,
+	}
+}
+
+func (b *BasicAuthenticator) Scheme() string {
+	return "Basic"
+}
+
+func (b *BasicAuthenticator) Challenges(error) []string {
+	return []string{fmt.Sprintf("Basic realm=%q", b.realm)}
+}
+
+func (b *BasicAuthenticator) Authenticate(credentials string, _ *http.Request, clientAddr string) (ProxyIdentity, error) {
+	basicCredentials, err := extractCredentialsFromB64(credentials)
+	if err != nil {
+		return ProxyIdentity{}, fmt.Errorf("could not extract credentials: %v", err)
+	}
+
+	authorized, userId, credentialId, options, err := authorizeBasic(&b.authUseCases, basicCredentials, clientAddr)
+	if err != nil {
+		return ProxyIdentity{}, err
+	}
+	if !authorized {
+		return ProxyIdentity{}, fmt.Errorf("invalid credentials of %s", basicCredentials.Username)
+	}
+
+	return ProxyIdentity{UserId: userId, CredentialId: credentialId, Options: options}, nil
+}
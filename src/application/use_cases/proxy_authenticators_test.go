@@ -0,0 +1,286 @@
+package use_cases
+
+import (
+	"errors"
+	"fmt"
+	"goproxy/domain/dataobjects"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDigestCredentialRepository map[string]dataobjects.DigestCredential
+
+func (r fakeDigestCredentialRepository) GetByUsername(username string) (dataobjects.DigestCredential, error) {
+	credential, found := r[username]
+	if !found {
+		return dataobjects.DigestCredential{}, fmt.Errorf("digest credential not found")
+	}
+	return credential, nil
+}
+
+func (r fakeDigestCredentialRepository) Save(credential dataobjects.DigestCredential) error {
+	r[credential.Username] = credential
+	return nil
+}
+
+// fakeJwt accepts tokens which are the claims written as "name=value;name=value".
+type fakeJwt struct{}
+
+func (fakeJwt) Generate(string, time.Duration, map[string]string) (string, error) {
+	return "", errors.New("not implemented")
+}
+
+func (j fakeJwt) Validate(secret string, token string) (bool, error) {
+	_, err := j.Parse(secret, token)
+	return err == nil, err
+}
+
+func (fakeJwt) Parse(secret string, token string) (map[string]string, error) {
+	if secret != "secret" {
+		return nil, errors.New("invalid signature")
+	}
+
+	claims := make(map[string]string)
+	for _, claim := range strings.Split(token, ";") {
+		name, value, _ := strings.Cut(claim, "=")
+		claims[name] = value
+	}
+	return claims, nil
+}
+
+func newTestDigestAuthenticator(t *testing.T) *DigestAuthenticator {
+	repo := fakeDigestCredentialRepository{}
+	_ = repo.Save(NewDigestCredential(1, "alice", "Proxy", "secret"))
+	_ = repo.Save(NewDigestCredential(2, "bob", "Proxy", "secret"))
+	_ = repo.Save(NewDigestCredential(3, "carol", "Old realm", "secret"))
+
+	authenticator, err := NewDigestAuthenticator(newTestAuthUseCases(t), repo, "Proxy", "", time.Minute)
+	require.NoError(t, err)
+	return authenticator
+}
+
+// digestAuthorization computes the Proxy-Authorization credentials a client sends for the request.
+func digestAuthorization(username, password, realm, algorithm, nonce, nc, method, uri string) string {
+	baseAlgorithm := strings.TrimSuffix(algorithm, "-sess")
+	cnonce := "0a4f113b"
+	ha1 := digestHash(baseAlgorithm, fmt.Sprintf("%s:%s:%s", username, realm, password))
+	if algorithm != baseAlgorithm {
+		ha1 = digestHash(baseAlgorithm, fmt.Sprintf("%s:%s:%s", ha1, nonce, cnonce))
+	}
+	ha2 := digestHash(baseAlgorithm, fmt.Sprintf("%s:%s", method, uri))
+	response := digestHash(baseAlgorithm, fmt.Sprintf("%s:%s:%s:%s:auth:%s", ha1, nonce, nc, cnonce, ha2))
+
+	return fmt.Sprintf(`username="%s", realm="%s", nonce="%s", uri="%s", algorithm=%s, response="%s", qop=auth, nc=%s, cnonce="%s"`,
+		username, realm, nonce, uri, algorithm, response, nc, cnonce)
+}
+
+func newConnectRequest(t *testing.T, host string) *http.Request {
+	request, err := http.NewRequest(http.MethodConnect, "http://"+host, nil)
+	require.NoError(t, err)
+	request.RequestURI = host
+	return request
+}
+
+func TestDigestAuthenticator_Authenticate(t *testing.T) {
+	authenticator := newTestDigestAuthenticator(t)
+	nonce := authenticator.nonces.issue(time.Now())
+	staleNonce := authenticator.nonces.issue(time.Now().Add(-2 * time.Minute))
+	request := newConnectRequest(t, "example.com:443")
+
+	tests := []struct {
+		name        string
+		credentials string
+		wantUserId  int
+		wantErr     error
+	}{
+		{
+			name:        "SHA-256",
+			credentials: digestAuthorization("alice", "secret", "Proxy", "SHA-256", nonce, "00000001", "CONNECT", "example.com:443"),
+			wantUserId:  1,
+		},
+		{
+			name:        "MD5",
+			credentials: digestAuthorization("alice", "secret", "Proxy", "MD5", nonce, "00000002", "CONNECT", "example.com:443"),
+			wantUserId:  1,
+		},
+		{
+			name:        "SHA-256 session",
+			credentials: digestAuthorization("alice", "secret", "Proxy", "SHA-256-sess", nonce, "00000003", "CONNECT", "example.com:443"),
+			wantUserId:  1,
+		},
+		{
+			name:        "replayed nonce count",
+			credentials: digestAuthorization("alice", "secret", "Proxy", "SHA-256", nonce, "00000003", "CONNECT", "example.com:443"),
+			wantErr:     errDigestReplayedNonceCount,
+		},
+		{
+			name:        "wrong password",
+			credentials: digestAuthorization("alice", "guess", "Proxy", "SHA-256", nonce, "00000004", "CONNECT", "example.com:443"),
+		},
+		{
+			name:        "response of another target",
+			credentials: digestAuthorization("alice", "secret", "Proxy", "SHA-256", nonce, "00000005", "CONNECT", "example.org:443"),
+		},
+		{
+			name:        "stale nonce",
+			credentials: digestAuthorization("alice", "secret", "Proxy", "SHA-256", staleNonce, "00000001", "CONNECT", "example.com:443"),
+			wantErr:     errDigestStaleNonce,
+		},
+		{
+			name:        "forged nonce",
+			credentials: digestAuthorization("alice", "secret", "Proxy", "SHA-256", "AAAAAAAAAAA", "00000001", "CONNECT", "example.com:443"),
+			wantErr:     errDigestInvalidNonce,
+		},
+		{
+			name:        "restricted user",
+			credentials: digestAuthorization("bob", "secret", "Proxy", "SHA-256", nonce, "00000001", "CONNECT", "example.com:443"),
+		},
+		{
+			name:        "credential of another realm",
+			credentials: digestAuthorization("carol", "secret", "Proxy", "SHA-256", nonce, "00000001", "CONNECT", "example.com:443"),
+		},
+		{
+			name:        "unknown user",
+			credentials: digestAuthorization("dave", "secret", "Proxy", "SHA-256", nonce, "00000001", "CONNECT", "example.com:443"),
+		},
+		{
+			name:        "without qop",
+			credentials: strings.Replace(digestAuthorization("alice", "secret", "Proxy", "MD5", nonce, "00000006", "CONNECT", "example.com:443"), "qop=auth, ", "", 1),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := authenticator.Authenticate(tt.credentials, request, "192.0.2.1:40000")
+			if tt.wantUserId != 0 {
+				require.NoError(t, err)
+				assert.Equal(t, tt.wantUserId, identity.UserId)
+				return
+			}
+
+			require.Error(t, err)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDigestAuthenticator_Authenticate_SharedNonceCounter(t *testing.T) {
+	// two nodes share the nonce secret and the nonce counts
+	nonceCounter := newLocalDigestNonceCounter(time.Minute)
+	nodes := make([]*DigestAuthenticator, 2)
+	for i := range nodes {
+		authenticator, err := NewDigestAuthenticator(newTestAuthUseCases(t),
+			fakeDigestCredentialRepository{"alice": NewDigestCredential(1, "alice", "Proxy", "secret")},
+			"Proxy", strings.Repeat("s", 32), time.Minute)
+		require.NoError(t, err)
+		nodes[i] = authenticator.WithNonceCounter(nonceCounter)
+	}
+	request := newConnectRequest(t, "example.com:443")
+	credentials := digestAuthorization("alice", "secret", "Proxy", "SHA-256", nodes[0].nonces.issue(time.Now()),
+		"00000001", "CONNECT", "example.com:443")
+
+	_, err := nodes[0].Authenticate(credentials, request, "192.0.2.1:40000")
+	require.NoError(t, err)
+	_, err = nodes[1].Authenticate(credentials, request, "192.0.2.1:40000")
+	assert.ErrorIs(t, err, errDigestReplayedNonceCount)
+}
+
+func TestDigestAuthenticator_Challenges(t *testing.T) {
+	authenticator := newTestDigestAuthenticator(t)
+
+	challenges := authenticator.Challenges(nil)
+	require.Len(t, challenges, 2)
+	assert.Contains(t, challenges[0], "algorithm=SHA-256")
+	assert.Contains(t, challenges[1], "algorithm=MD5")
+	for _, challenge := range challenges {
+		params, err := parseAuthParams(strings.TrimPrefix(challenge, "Digest "))
+		require.NoError(t, err)
+		assert.Equal(t, "Proxy", params["realm"])
+		assert.Equal(t, "auth", params["qop"])
+		_, err = authenticator.nonces.verify(params["nonce"], time.Now())
+		assert.NoError(t, err)
+		assert.NotContains(t, params, "stale")
+	}
+
+	for _, challenge := range authenticator.Challenges(errDigestStaleNonce) {
+		assert.Contains(t, challenge, "stale=true")
+	}
+}
+
+func TestParseAuthParams(t *testing.T) {
+	params, err := parseAuthParams(`username="Mufasa", Realm="http-auth@example.org", uri="/dir/index.html", ` +
+		`algorithm=SHA-256, nc=00000001, opaque="a \"quoted\", value"`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"username":  "Mufasa",
+		"realm":     "http-auth@example.org",
+		"uri":       "/dir/index.html",
+		"algorithm": "SHA-256",
+		"nc":        "00000001",
+		"opaque":    `a "quoted", value`,
+	}, params)
+
+	_, err = parseAuthParams(`username="Mufasa`)
+	assert.Error(t, err)
+}
+
+func TestBearerAuthenticator_Authenticate(t *testing.T) {
+	authenticator := NewBearerAuthenticator(newTestAuthUseCases(t), fakeJwt{}, "secret", "goproxy", "Proxy")
+
+	tests := []struct {
+		name       string
+		token      string
+		wantUserId int
+	}{
+		{name: "valid token", token: "iss=goproxy;sub=1;exp=1900000000", wantUserId: 1},
+		{name: "another issuer", token: "iss=example.com;sub=1;exp=1900000000"},
+		{name: "token without expiry", token: "iss=goproxy;sub=1"},
+		{name: "invalid subject", token: "iss=goproxy;sub=alice;exp=1900000000"},
+		{name: "restricted user", token: "iss=goproxy;sub=2;exp=1900000000"},
+		{name: "unknown user", token: "iss=goproxy;sub=3;exp=1900000000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := authenticator.Authenticate(tt.token, nil, "192.0.2.1:40000")
+			if tt.wantUserId == 0 {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantUserId, identity.UserId)
+		})
+	}
+
+	assert.Equal(t, []string{`Bearer realm="Proxy", error="invalid_token"`}, authenticator.Challenges(errors.New("expired")))
+}
+
+func TestProxyAuthenticators(t *testing.T) {
+	authUseCases := newTestAuthUseCases(t)
+	authenticators := NewProxyAuthenticators(
+		NewBasicAuthenticator(authUseCases, "Proxy"),
+		NewBearerAuthenticator(authUseCases, fakeJwt{}, "secret", "goproxy", "Proxy"),
+	)
+
+	identity, err := authenticators.Authenticate("bearer  iss=goproxy;sub=1;exp=1900000000", nil, "192.0.2.1:40000")
+	require.NoError(t, err)
+	assert.Equal(t, 1, identity.UserId)
+
+	_, err = authenticators.Authenticate("Bearer iss=example.com;sub=1;exp=1900000000", nil, "192.0.2.1:40000")
+	var unauthorizedErr UnauthorizedError
+	require.ErrorAs(t, err, &unauthorizedErr)
+	assert.Equal(t, "Bearer", unauthorizedErr.Scheme)
+	assert.Equal(t, []string{`Basic realm="Proxy"`, `Bearer realm="Proxy", error="invalid_token"`}, authenticators.Challenges(err))
+
+	_, err = authenticators.Authenticate("Negotiate abc", nil, "192.0.2.1:40000")
+	require.ErrorAs(t, err, &unauthorizedErr)
+	assert.Empty(t, unauthorizedErr.Scheme)
+	assert.Equal(t, []string{`Basic realm="Proxy"`, `Bearer realm="Proxy"`}, authenticators.Challenges(err))
+}
@@ -0,0 +1,69 @@
+package use_cases
+
+import (
+	"fmt"
+	"goproxy/application/commands"
+	"goproxy/application/contracts"
+	"goproxy/domain/aggregates"
+	"goproxy/domain/valueobjects"
+	"time"
+)
+
+const proxyCredentialKeyLength = 32
+
+type ProxyCredentialUseCases struct {
+	repo          contracts.ProxyCredentialRepository
+	cryptoService contracts.CryptoService
+}
+
+func NewProxyCredentialUseCases(repo contracts.ProxyCredentialRepository, cryptoService contracts.CryptoService) ProxyCredentialUseCases {
+	return ProxyCredentialUseCases{
+		repo:          repo,
+		cryptoService: cryptoService,
+	}
+}
+
+func (p ProxyCredentialUseCases) GetAllByUserId(userId int) ([]aggregates.ProxyCredential, error) {
+	return p.repo.GetAllByUserId(userId)
+}
+
+// Create mints a credential of the user and returns its secret, only the hash of the key is stored.
+func (p ProxyCredentialUseCases) Create(command commands.PostProxyCredential) (valueobjects.ProxyCredentialSecret, error) {
+	key, err := p.cryptoService.GenerateRandomString(proxyCredentialKeyLength)
+	if err != nil {
+		return valueobjects.ProxyCredentialSecret{}, err
+	}
+
+	hash, err := p.cryptoService.HashValue(key)
+	if err != nil {
+		return valueobjects.ProxyCredentialSecret{}, err
+	}
+
+	credential, err := aggregates.NewProxyCredential(-1, command.UserId, command.Name, hash, command.ExpiresAt,
+		command.AllowedNetworks, command.TrafficQuotaBytes, 0, time.Time{}, time.Time{})
+	if err != nil {
+		return valueobjects.ProxyCredentialSecret{}, err
+	}
+
+	id, err := p.repo.Create(credential)
+	if err != nil {
+		return valueobjects.ProxyCredentialSecret{}, err
+	}
+
+	return valueobjects.NewProxyCredentialSecret(id, key)
+}
+
+// Revoke revokes the credential of the user, proxy nodes have to be told to reload it.
+func (p ProxyCredentialUseCases) Revoke(userId, credentialId int) error {
+	credential, err := p.repo.GetById(credentialId)
+	if err != nil || credential.UserId() != userId {
+		return fmt.Errorf("proxy credential not found")
+	}
+
+	if credential.IsRevoked() {
+		return nil
+	}
+
+	credential.Revoke(time.Now().UTC())
+	return p.repo.Update(credential)
+}
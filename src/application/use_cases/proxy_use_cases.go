@@ -2,11 +2,15 @@ package use_cases
 
 import (
 	"bufio"
+	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"goproxy/application/aplication_errors"
 	"goproxy/application/contracts"
 	"goproxy/domain/valueobjects"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	"strconv"
@@ -14,50 +18,145 @@ import (
 	"sync"
 )
 
+// UnauthorizedError is returned when the client could not be authenticated with the auth-scheme,
+// the scheme is empty if the client sent no credentials or used an unsupported scheme.
 type UnauthorizedError struct {
+	Scheme string
+	Err    error
 }
 
 func (e UnauthorizedError) Error() string {
 	return "Unauthorized"
 }
 
+func (e UnauthorizedError) Unwrap() error {
+	return e.Err
+}
+
+// First bytes of SOCKS requests, used to tell them apart from HTTP requests on a shared port.
+const (
+	socks4VersionByte = 0x04
+	socks5VersionByte = 0x05
+)
+
+// proxyAuthReasonHeader explains 407 responses to clients whose username parameters were rejected.
+const proxyAuthReasonHeader = "X-Proxy-Auth-Reason"
+
+// http2ClientPreface starts every HTTP/2 connection, both over TLS and with prior knowledge (RFC 9113, section 3.4).
+const http2ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
 type ProxyUseCases struct {
-	httpProxyListener contracts.HttpProxyListenerService
-	proxyService      contracts.ProxyService
-	authUseCases      AuthUseCases
-	readerPool        *sync.Pool
+	httpProxyListener  contracts.HttpProxyListenerService
+	proxyService       contracts.ProxyService
+	socks4ProxyService contracts.Socks4ProxyService
+	socks5ProxyService contracts.Socks5ProxyService
+	connectionLimiter  contracts.ConnectionLimiterService
+	authUseCases       AuthUseCases
+	authenticators     ProxyAuthenticators
+	readerPool         *sync.Pool
+	connections        *connectionTracker
+	listenersMu        sync.Mutex
+	listeners          []net.Listener
 }
 
-func NewProxyUseCases(proxy contracts.ProxyService, httpProxyListener contracts.HttpProxyListenerService, authUseCases AuthUseCases) *ProxyUseCases {
+func NewProxyUseCases(proxy contracts.ProxyService, socks4Proxy contracts.Socks4ProxyService, socks5Proxy contracts.Socks5ProxyService,
+	httpProxyListener contracts.HttpProxyListenerService, connectionLimiter contracts.ConnectionLimiterService,
+	authUseCases AuthUseCases) *ProxyUseCases {
 	return &ProxyUseCases{
-		proxyService:      proxy,
-		httpProxyListener: httpProxyListener,
-		authUseCases:      authUseCases,
+		proxyService:       proxy,
+		socks4ProxyService: socks4Proxy,
+		socks5ProxyService: socks5Proxy,
+		httpProxyListener:  httpProxyListener,
+		connectionLimiter:  connectionLimiter,
+		authUseCases:       authUseCases,
+		authenticators:     NewProxyAuthenticators(NewBasicAuthenticator(authUseCases, defaultProxyAuthRealm)),
 		readerPool: &sync.Pool{
 			New: func() interface{} {
 				return bufio.NewReader(nil)
 			},
 		},
+		connections: newConnectionTracker(),
 	}
 }
 
+// WithAuthenticators replaces Basic authentication of HTTP clients with the authenticators.
+// SOCKS clients authenticate by username and password regardless of them.
+func (p *ProxyUseCases) WithAuthenticators(authenticators ProxyAuthenticators) *ProxyUseCases {
+	p.authenticators = authenticators
+	return p
+}
+
 func (p *ProxyUseCases) ServeOnPort(port int) {
+	p.serve(port, p.handleConnection)
+}
+
+func (p *ProxyUseCases) ServeSocks5OnPort(port int) {
+	p.serve(port, p.handleSocks5Connection)
+}
+
+func (p *ProxyUseCases) serve(port int, handler func(clientConn net.Conn)) {
 	listener, listenerErr := p.httpProxyListener.Listen(port)
 	if listenerErr != nil {
 		log.Fatal(listenerErr)
 	}
 
+	if !p.addListener(listener) {
+		_ = listener.Close()
+		return
+	}
+
 	for {
 		clientConn, clientConnErr := listener.Accept()
 		if clientConnErr != nil {
+			if p.connections.isDraining() {
+				return
+			}
 			log.Printf("failed to accept client connection: %v", clientConnErr)
 			continue
 		}
 
-		go p.handleConnection(clientConn)
+		tracked, ok := p.connections.add(clientConn)
+		if !ok {
+			_ = clientConn.Close()
+			continue
+		}
+
+		go func() {
+			defer p.connections.remove(tracked)
+			handler(tracked)
+		}()
 	}
 }
 
+// addListener registers the listener to be closed on shutdown. False is returned if shutdown has already started.
+func (p *ProxyUseCases) addListener(listener net.Listener) bool {
+	p.listenersMu.Lock()
+	defer p.listenersMu.Unlock()
+
+	if p.connections.isDraining() {
+		return false
+	}
+
+	p.listeners = append(p.listeners, listener)
+	return true
+}
+
+// Shutdown stops accepting connections and waits until the served ones are closed or ctx is done,
+// then closes the remaining ones. Idle keep-alive connections are closed right away.
+func (p *ProxyUseCases) Shutdown(ctx context.Context) error {
+	p.listenersMu.Lock()
+	p.connections.startDraining()
+	for _, listener := range p.listeners {
+		_ = listener.Close()
+	}
+	p.listeners = nil
+	p.listenersMu.Unlock()
+
+	return p.connections.wait(ctx)
+}
+
+// handleConnection detects the client protocol by the first byte of the connection
+// and hands the connection to the matching protocol handler.
 func (p *ProxyUseCases) handleConnection(clientConn net.Conn) {
 	defer func(clientConn net.Conn) {
 		_ = clientConn.Close()
@@ -67,13 +166,72 @@ func (p *ProxyUseCases) handleConnection(clientConn net.Conn) {
 	reader.Reset(clientConn)
 	defer p.readerPool.Put(reader)
 
+	firstByte, peekErr := reader.Peek(1)
+	if peekErr != nil {
+		return
+	}
+
+	conn := newBufferedConn(clientConn, reader)
+
+	if !p.connectionLimiter.AcquireNode() {
+		log.Printf("Node connections limit reached, rejecting %s", clientConn.RemoteAddr())
+		p.rejectOverloaded(conn, firstByte[0])
+		return
+	}
+	defer p.connectionLimiter.ReleaseNode()
+
+	switch firstByte[0] {
+	case socks5VersionByte:
+		p.serveSocks5(conn)
+	case socks4VersionByte:
+		p.serveSocks4(conn)
+	default:
+		if isHttp2Preface(reader) {
+			p.serveHttp2(conn)
+		} else {
+			p.serveHttp(conn, reader)
+		}
+	}
+}
+
+// isHttp2Preface reports whether the connection starts with the HTTP/2 client preface.
+// The "PRI" method is reserved for the preface, so HTTP/1.x requests are told apart by the first three bytes.
+func isHttp2Preface(reader *bufio.Reader) bool {
+	method, err := reader.Peek(3)
+	if err != nil || string(method) != http2ClientPreface[:3] {
+		return false
+	}
+
+	preface, err := reader.Peek(len(http2ClientPreface))
+	return err == nil && string(preface) == http2ClientPreface
+}
+
+// rejectOverloaded replies to the client in its protocol that the node can not serve it right now.
+func (p *ProxyUseCases) rejectOverloaded(clientConn net.Conn, firstByte byte) {
+	switch firstByte {
+	case socks5VersionByte:
+		_ = p.socks5ProxyService.WriteSocks5NoAcceptableMethods(clientConn)
+	case socks4VersionByte:
+		_ = p.socks4ProxyService.WriteSocks4Rejected(clientConn)
+	default:
+		_, _ = clientConn.Write([]byte("HTTP/1.1 503 Service Unavailable\r\nConnection: close\r\n\r\n"))
+	}
+}
+
+func (p *ProxyUseCases) serveHttp(clientConn net.Conn, reader *bufio.Reader) {
 	for {
+		if !p.connections.setIdle(clientConn, true) {
+			return
+		}
+
 		request, err := http.ReadRequest(reader)
 		if err != nil {
 			return
 		}
+		p.connections.setIdle(clientConn, false)
 
-		if err := p.HandleAuthorization(clientConn, request); err != nil {
+		credentialId, options, err := p.HandleAuthorization(clientConn, request)
+		if err != nil {
 			log.Printf("Authorization failed: %v", err)
 			return
 		}
@@ -84,43 +242,335 @@ func (p *ProxyUseCases) handleConnection(clientConn net.Conn) {
 			return
 		}
 
-		if request.Method == http.MethodConnect {
-			p.proxyService.HandleHttps(clientConn, request, userID)
-		} else {
-			p.proxyService.HandleHttp(clientConn, request, userID)
+		if !p.serveHttpRequest(clientConn, request, userID, credentialId, options) {
+			return
 		}
 	}
 }
 
-func (p *ProxyUseCases) HandleAuthorization(clientConn net.Conn, request *http.Request) error {
-	if request.Header.Get("Proxy-Authorization") == "" {
-		_, _ = clientConn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Basic realm=\"Proxy\"\r\n\r\n"))
-		return UnauthorizedError{}
+// serveHttpRequest serves the authorized request within the connections limit of the user.
+// False is returned if the limit is reached and the connection must be closed.
+func (p *ProxyUseCases) serveHttpRequest(clientConn net.Conn, request *http.Request, userID, credentialId int,
+	options valueobjects.ConnectionOptions) bool {
+	if !p.connectionLimiter.AcquireUser(userID) {
+		log.Printf("User %d connections limit reached", userID)
+		_, _ = clientConn.Write([]byte("HTTP/1.1 429 Too Many Requests\r\nConnection: close\r\n\r\n"))
+		return false
+	}
+	defer p.connectionLimiter.ReleaseUser(userID)
+
+	if request.Method == http.MethodConnect {
+		p.proxyService.HandleHttps(clientConn, request, userID, credentialId, options)
+	} else {
+		p.proxyService.HandleHttp(clientConn, request, userID, credentialId, options)
+	}
+	return true
+}
+
+// serveHttp2 serves an HTTP/2 connection, authorizing and limiting every stream on its own.
+// The connection is idle while it has no active streams, so it is closed right away on shutdown.
+func (p *ProxyUseCases) serveHttp2(clientConn net.Conn) {
+	if !p.connections.setIdle(clientConn, true) {
+		return
+	}
+
+	var mu sync.Mutex
+	activeStreams := 0
+	p.proxyService.ServeHttp2(clientConn, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		activeStreams++
+		if activeStreams == 1 {
+			p.connections.setIdle(clientConn, false)
+		}
+		mu.Unlock()
+
+		defer func() {
+			mu.Lock()
+			defer mu.Unlock()
+
+			activeStreams--
+			if activeStreams == 0 && !p.connections.setIdle(clientConn, true) {
+				_ = clientConn.Close()
+			}
+		}()
+
+		p.serveHttp2Stream(w, r)
+	}))
+}
+
+func (p *ProxyUseCases) serveHttp2Stream(w http.ResponseWriter, r *http.Request) {
+	// unlike HTTP/1.1 the connection stays open for other streams, so every failed stream is answered
+	credentialId, options, err := p.authorizeRequest(r.RemoteAddr, r)
+	if err != nil {
+		log.Printf("Authorization failed: %v", err)
+		if retryAfter, lockedOut := lockedOutFor(err); lockedOut {
+			w.Header().Set("Retry-After", retryAfter)
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header()["Proxy-Authenticate"] = p.authenticators.Challenges(err)
+		if reason, invalidUsername := invalidUsernameReason(err); invalidUsername {
+			w.Header().Set(proxyAuthReasonHeader, reason)
+		}
+		w.WriteHeader(http.StatusProxyAuthRequired)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.Header.Get("Proxy-Authorization"))
+	if err != nil {
+		log.Printf("Error parsing user id: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !p.connectionLimiter.AcquireUser(userID) {
+		log.Printf("User %d connections limit reached", userID)
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	defer p.connectionLimiter.ReleaseUser(userID)
+
+	p.proxyService.HandleHttp2(w, r, userID, credentialId, options)
+}
+
+func (p *ProxyUseCases) handleSocks5Connection(clientConn net.Conn) {
+	defer func(clientConn net.Conn) {
+		_ = clientConn.Close()
+	}(clientConn)
+
+	if !p.connectionLimiter.AcquireNode() {
+		log.Printf("Node connections limit reached, rejecting %s", clientConn.RemoteAddr())
+		p.rejectOverloaded(clientConn, socks5VersionByte)
+		return
+	}
+	defer p.connectionLimiter.ReleaseNode()
+
+	p.serveSocks5(clientConn)
+}
+
+func (p *ProxyUseCases) serveSocks5(clientConn net.Conn) {
+	ipUserId, ipAuthorized := p.authorizeIp(clientConn.RemoteAddr().String())
+	credentials, credentialsErr := p.socks5ProxyService.ReadSocks5Credentials(clientConn, ipAuthorized)
+	if credentialsErr != nil {
+		log.Printf("Could not read socks5 credentials: %v", credentialsErr)
+		return
+	}
+
+	// clients of authorized networks may skip authentication
+	userId, credentialId, options := ipUserId, 0, valueobjects.ConnectionOptions{}
+	if credentials != nil {
+		var authorized bool
+		var authorizationErr error
+		authorized, userId, credentialId, options, authorizationErr = p.authorize(credentials, clientConn.RemoteAddr().String())
+		if authorizationErr != nil || !authorized {
+			log.Printf("Not authorized: %s", clientConn.RemoteAddr())
+			_ = p.socks5ProxyService.WriteSocks5AuthStatus(clientConn, false)
+			return
+		}
+
+		if writeErr := p.socks5ProxyService.WriteSocks5AuthStatus(clientConn, true); writeErr != nil {
+			return
+		}
+	}
+
+	if !p.connectionLimiter.AcquireUser(userId) {
+		log.Printf("User %d connections limit reached", userId)
+		_ = p.socks5ProxyService.RejectSocks5Request(clientConn)
+		return
 	}
+	defer p.connectionLimiter.ReleaseUser(userId)
+
+	p.socks5ProxyService.HandleSocks5(clientConn, userId, credentialId, options)
+}
 
-	credentialsHeader := strings.TrimPrefix(request.Header.Get("Proxy-Authorization"), "Basic ")
-	credentials, extractCredentialsErr := p.extractCredentialsFromB64(credentialsHeader)
-	if extractCredentialsErr != nil {
-		log.Printf("Could not extract credentials: %v", extractCredentialsErr)
-		return UnauthorizedError{}
+func (p *ProxyUseCases) serveSocks4(clientConn net.Conn) {
+	credentials, host, requestErr := p.socks4ProxyService.ReadSocks4Request(clientConn)
+	if requestErr != nil {
+		log.Printf("Could not read socks4 request: %v", requestErr)
+		return
 	}
 
-	authorized, userId, authorizationErr := p.authUseCases.AuthorizeBasic(credentials)
-	if authorizationErr != nil {
-		log.Printf("Could not authorize: %v", authorizationErr)
-		return UnauthorizedError{}
+	var authorized bool
+	var userId, credentialId int
+	var options valueobjects.ConnectionOptions
+	var authorizationErr error
+	if credentials == nil {
+		userId, authorized = p.authorizeIp(clientConn.RemoteAddr().String())
+	} else {
+		authorized, userId, credentialId, options, authorizationErr = p.authorize(credentials, clientConn.RemoteAddr().String())
 	}
-	if !authorized {
+	if authorizationErr != nil || !authorized {
 		log.Printf("Not authorized: %s", clientConn.RemoteAddr())
-		return UnauthorizedError{}
+		_ = p.socks4ProxyService.WriteSocks4Rejected(clientConn)
+		return
+	}
+
+	if !p.connectionLimiter.AcquireUser(userId) {
+		log.Printf("User %d connections limit reached", userId)
+		_ = p.socks4ProxyService.WriteSocks4Rejected(clientConn)
+		return
 	}
+	defer p.connectionLimiter.ReleaseUser(userId)
+
+	p.socks4ProxyService.HandleSocks4(clientConn, host, userId, credentialId, options)
+}
+
+// HandleAuthorization authorizes the request and replaces its Proxy-Authorization header with the user id.
+// The id of the proxy credential used and the connection options requested by the username parameters are returned.
+// Clients failing authentication are answered with challenges of all enabled schemes and, if their username
+// parameters were rejected, the reason; locked out clients are told when to retry instead.
+func (p *ProxyUseCases) HandleAuthorization(clientConn net.Conn, request *http.Request) (int, valueobjects.ConnectionOptions, error) {
+	credentialId, options, err := p.authorizeRequest(clientConn.RemoteAddr().String(), request)
+	if retryAfter, lockedOut := lockedOutFor(err); lockedOut {
+		_, _ = clientConn.Write([]byte(fmt.Sprintf("HTTP/1.1 429 Too Many Requests\r\nRetry-After: %s\r\nConnection: close\r\n\r\n", retryAfter)))
+	} else if err != nil {
+		var response strings.Builder
+		response.WriteString("HTTP/1.1 407 Proxy Authentication Required\r\n")
+		for _, challenge := range p.authenticators.Challenges(err) {
+			response.WriteString("Proxy-Authenticate: " + challenge + "\r\n")
+		}
+		if reason, invalidUsername := invalidUsernameReason(err); invalidUsername {
+			response.WriteString(proxyAuthReasonHeader + ": " + reason + "\r\n")
+		}
+		response.WriteString("\r\n")
+		_, _ = clientConn.Write([]byte(response.String()))
+	}
+
+	return credentialId, options, err
+}
+
+// authorizeRequest authorizes the request of the client at clientAddr by its Proxy-Authorization header,
+// or by the client IP if the request has no credentials, replacing the header with the user id.
+func (p *ProxyUseCases) authorizeRequest(clientAddr string, request *http.Request) (int, valueobjects.ConnectionOptions, error) {
+	if request.Header.Get("Proxy-Authorization") == "" {
+		userId, authorized := p.authorizeIp(clientAddr)
+		if !authorized {
+			return 0, valueobjects.ConnectionOptions{}, UnauthorizedError{}
+		}
+
+		request.Header.Set("Proxy-Authorization", fmt.Sprintf("%d", userId))
+		return 0, valueobjects.ConnectionOptions{}, nil
+	}
+
+	identity, err := p.authenticators.Authenticate(request.Header.Get("Proxy-Authorization"), request, clientAddr)
+	if err != nil {
+		log.Printf("Could not authorize %s: %v", clientAddr, errors.Unwrap(err))
+		return 0, valueobjects.ConnectionOptions{}, err
+	}
+
+	request.Header.Set("Proxy-Authorization", fmt.Sprintf("%d", identity.UserId))
+
+	return identity.CredentialId, identity.Options, nil
+}
 
-	request.Header.Set("Proxy-Authorization", fmt.Sprintf("%d", userId))
+// lockedOutFor returns the Retry-After value in seconds if the authentication error is a lockout.
+func lockedOutFor(err error) (string, bool) {
+	var lockedOutErr aplication_errors.ErrLockedOut
+	if !errors.As(err, &lockedOutErr) {
+		return "", false
+	}
+
+	return strconv.Itoa(int(math.Ceil(lockedOutErr.RetryAfter.Seconds()))), true
+}
+
+// invalidUsernameReason returns why the username parameters were rejected if the authentication error is due to them.
+func invalidUsernameReason(err error) (string, bool) {
+	var usernameErr valueobjects.InvalidProxyUsernameError
+	if !errors.As(err, &usernameErr) {
+		return "", false
+	}
+
+	return usernameErr.Reason, true
+}
+
+// authorize separates connection parameters from the username, e.g. "alice-session-abc123-country-de",
+// and authorizes the user of the client at clientAddr by the remaining username. Usernames may contain parameter
+// names, e.g. "tom-speed", so a username is taken as it is if a user has the whole username.
+func (p *ProxyUseCases) authorize(credentials *valueobjects.BasicCredentials, clientAddr string) (bool, int, int, valueobjects.ConnectionOptions, error) {
+	return authorizeBasic(&p.authUseCases, credentials, clientAddr)
+}
+
+func authorizeBasic(authUseCases *AuthUseCases, credentials *valueobjects.BasicCredentials, clientAddr string) (bool, int, int, valueobjects.ConnectionOptions, error) {
+	clientIp := clientIpOf(clientAddr)
+	// users are looked up to parse the username before the password is checked, so locked out clients are refused first
+	if err := authUseCases.CheckLockout(credentials.Username, clientIp); err != nil {
+		return false, 0, 0, valueobjects.ConnectionOptions{}, err
+	}
+
+	username, options, optionsErr := parseProxyUsername(authUseCases, credentials.Username)
+	if optionsErr != nil {
+		authUseCases.RegisterAttempt(credentials.Username, clientIp, false)
+		return false, 0, 0, valueobjects.ConnectionOptions{}, optionsErr
+	}
+
+	authorized, userId, credentialId, authorizationErr := authUseCases.AuthorizeBasic(&valueobjects.BasicCredentials{
+		Username: username,
+		Password: credentials.Password,
+	}, clientIp)
+	return authorized, userId, credentialId, options, authorizationErr
+}
+
+// maxProxyUsernamePrefixLookups bounds how many users are looked up for usernames containing parameter names,
+// besides the whole username, as usernames are parsed before the password is checked.
+const maxProxyUsernamePrefixLookups = 2
+
+// parseProxyUsername separates connection parameters from the username of an existing user, which may contain
+// parameter names itself. Users are only looked up if the username contains parameter names, and a username
+// is only looked up if the parameters following it are valid.
+func parseProxyUsername(authUseCases *AuthUseCases, username string) (string, valueobjects.ConnectionOptions, error) {
+	prefixes := valueobjects.ProxyUsernamePrefixes(username)
+	if len(prefixes) == 0 || authUseCases.hasUser(username) {
+		return username, valueobjects.ConnectionOptions{}, nil
+	}
+
+	user, options, err := valueobjects.ParseProxyUsername(username)
+	if err == nil {
+		return user, options, nil
+	}
+
+	// e.g. "tom-speed-country-de" of the user "tom-speed"
+	lookups := 0
+	for _, prefix := range prefixes[:len(prefixes)-1] {
+		prefixOptions, prefixErr := valueobjects.ParseProxyUsernameOf(username, prefix)
+		if prefixErr != nil {
+			continue
+		}
+
+		if lookups == maxProxyUsernamePrefixLookups {
+			break
+		}
+		lookups++
+
+		if authUseCases.hasUser(prefix) {
+			return prefix, prefixOptions, nil
+		}
+	}
+
+	return username, valueobjects.ConnectionOptions{}, err
+}
+
+// authorizeIp authorizes the client at clientAddr without credentials by the networks authorized by users.
+func (p *ProxyUseCases) authorizeIp(clientAddr string) (int, bool) {
+	authorized, userId, err := p.authUseCases.AuthorizeIp(clientIpOf(clientAddr))
+	if err != nil {
+		log.Printf("Could not authorize %s by IP: %v", clientAddr, err)
+		return 0, false
+	}
+
+	return userId, authorized
+}
+
+// clientIpOf returns the IP of the client address, nil if it has none.
+func clientIpOf(clientAddr string) net.IP {
+	host, _, err := net.SplitHostPort(clientAddr)
+	if err != nil {
+		host = clientAddr
+	}
 
-	return nil
+	return net.ParseIP(host)
 }
 
-func (p *ProxyUseCases) extractCredentialsFromB64(encoded string) (*valueobjects.BasicCredentials, error) {
+func extractCredentialsFromB64(encoded string) (*valueobjects.BasicCredentials, error) {
 	if encoded == "" {
 		return nil, fmt.Errorf("empty Base64 string")
 	}
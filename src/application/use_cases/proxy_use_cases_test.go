@@ -0,0 +1,148 @@
+package use_cases
+
+import (
+	"bufio"
+	"errors"
+	"goproxy/domain/valueobjects"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsHttp2Preface(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected bool
+	}{
+		{name: "HTTP/2 preface", input: http2ClientPreface + "\x00\x00\x12\x04", expected: true},
+		{name: "HTTP/1.1 request", input: "GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n"},
+		{name: "short HTTP/1.0 request", input: "PUT / HTTP/1.0\r\n\r\n"},
+		{name: "WebDAV request", input: "PROPFIND http://example.com/ HTTP/1.1\r\n\r\n"},
+		{name: "truncated preface", input: "PRI * HTTP/2.0\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := bufio.NewReader(strings.NewReader(tt.input))
+			assert.Equal(t, tt.expected, isHttp2Preface(reader))
+
+			// detection never consumes the request
+			peeked, _ := reader.Peek(len(tt.input))
+			assert.Equal(t, tt.input, string(peeked))
+		})
+	}
+}
+
+// panickingProxyService fails every request it is handed.
+type panickingProxyService struct{}
+
+func (panickingProxyService) HandleHttps(net.Conn, *http.Request, int, int, valueobjects.ConnectionOptions) {
+	panic("handler failed")
+}
+
+func (panickingProxyService) HandleHttp(net.Conn, *http.Request, int, int, valueobjects.ConnectionOptions) {
+	panic("handler failed")
+}
+
+func (panickingProxyService) ServeHttp2(net.Conn, http.Handler) {}
+
+func (panickingProxyService) HandleHttp2(http.ResponseWriter, *http.Request, int, int, valueobjects.ConnectionOptions) {
+}
+
+// countingConnectionLimiter counts connection slots held by users and the node.
+type countingConnectionLimiter struct {
+	users    map[int]int
+	nodes    int
+	nodeFull bool
+}
+
+func (l *countingConnectionLimiter) AcquireNode() bool {
+	if l.nodeFull {
+		return false
+	}
+	l.nodes++
+	return true
+}
+
+func (l *countingConnectionLimiter) ReleaseNode() {
+	l.nodes--
+}
+
+func (l *countingConnectionLimiter) AcquireUser(userId int) bool {
+	l.users[userId]++
+	return true
+}
+
+func (l *countingConnectionLimiter) ReleaseUser(userId int) {
+	l.users[userId]--
+}
+
+func TestProxyUseCases_ServeHttpRequest_ReleasesUserSlotOnPanic(t *testing.T) {
+	limiter := &countingConnectionLimiter{users: make(map[int]int)}
+	proxyUseCases := NewProxyUseCases(panickingProxyService{}, nil, nil, nil, limiter, newTestAuthUseCases(t))
+
+	for _, method := range []string{http.MethodConnect, http.MethodGet} {
+		request, err := http.NewRequest(method, "http://example.com:443", nil)
+		require.NoError(t, err)
+
+		assert.Panics(t, func() {
+			proxyUseCases.serveHttpRequest(nil, request, 1, 0, valueobjects.ConnectionOptions{})
+		})
+		assert.Zero(t, limiter.users[1])
+	}
+}
+
+// refusingSocks5ProxyService refuses credentials of every client and records greetings refused for overload.
+type refusingSocks5ProxyService struct {
+	greetingsRead      int
+	noAcceptableMethod int
+}
+
+func (s *refusingSocks5ProxyService) ReadSocks5Credentials(net.Conn, bool) (*valueobjects.BasicCredentials, error) {
+	s.greetingsRead++
+	return nil, errors.New("no credentials")
+}
+
+func (s *refusingSocks5ProxyService) WriteSocks5AuthStatus(net.Conn, bool) error { return nil }
+
+func (s *refusingSocks5ProxyService) WriteSocks5NoAcceptableMethods(net.Conn) error {
+	s.noAcceptableMethod++
+	return nil
+}
+
+func (s *refusingSocks5ProxyService) RejectSocks5Request(net.Conn) error { return nil }
+
+func (s *refusingSocks5ProxyService) HandleSocks5(net.Conn, int, int, valueobjects.ConnectionOptions) {}
+
+func TestProxyUseCases_HandleSocks5Connection_TakesNodeSlot(t *testing.T) {
+	tests := []struct {
+		name               string
+		nodeFull           bool
+		greetingsRead      int
+		noAcceptableMethod int
+	}{
+		{name: "node with free slots", greetingsRead: 1},
+		{name: "node at capacity", nodeFull: true, noAcceptableMethod: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := &countingConnectionLimiter{users: make(map[int]int), nodeFull: tt.nodeFull}
+			socks5 := &refusingSocks5ProxyService{}
+			proxyUseCases := NewProxyUseCases(nil, nil, socks5, nil, limiter, newTestAuthUseCases(t))
+
+			clientConn, serverConn := net.Pipe()
+			defer func() { _ = clientConn.Close() }()
+			proxyUseCases.handleSocks5Connection(serverConn)
+
+			assert.Equal(t, tt.greetingsRead, socks5.greetingsRead)
+			assert.Equal(t, tt.noAcceptableMethod, socks5.noAcceptableMethod)
+			assert.Zero(t, limiter.nodes)
+		})
+	}
+}
@@ -43,10 +43,12 @@ func (u *UserPlanInfoUseCases) FetchUserPlan(userId int) (dataobjects.UserPlan,
 	}
 
 	userPlanData := dataobjects.UserPlan{
-		Name:         plan.Name(),
-		Bandwidth:    plan.LimitBytes(),
-		CreatedAt:    plan.CreatedAt(),
-		DurationDays: plan.DurationDays(),
+		Name:              plan.Name(),
+		Bandwidth:         plan.LimitBytes(),
+		MaxBytesPerSecond: plan.MaxBytesPerSecond(),
+		MaxConnections:    plan.MaxConnections(),
+		CreatedAt:         plan.CreatedAt(),
+		DurationDays:      plan.DurationDays(),
 	}
 
 	cacheSetErr := u.planCache.Set(u.cachePlanKey(userId), userPlanData)
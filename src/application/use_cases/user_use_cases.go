@@ -2,9 +2,11 @@ package use_cases
 
 import (
 	"fmt"
+	"goproxy/application/aplication_errors"
 	"goproxy/application/commands"
 	"goproxy/application/contracts"
 	"goproxy/domain/aggregates"
+	"log"
 	"strconv"
 	"strings"
 )
@@ -20,6 +22,10 @@ type UserUseCasesContract interface {
 type UserUseCases struct {
 	repo          contracts.UserRepository
 	cryptoService contracts.CryptoService
+	// digestCredentials stores hashes for Digest proxy authentication when passwords are set, it is optional
+	digestCredentials contracts.DigestCredentialRepository
+	digestRealm       string
+	authThrottle      contracts.AuthThrottleService
 }
 
 func NewUserUseCases(repo contracts.UserRepository, cryptoService contracts.CryptoService) UserUseCases {
@@ -29,6 +35,19 @@ func NewUserUseCases(repo contracts.UserRepository, cryptoService contracts.Cryp
 	}
 }
 
+// WithDigestCredentials makes the use cases store hashes of passwords set for Digest authentication in the realm.
+func (u UserUseCases) WithDigestCredentials(repo contracts.DigestCredentialRepository, realm string) UserUseCases {
+	u.digestCredentials = repo
+	u.digestRealm = realm
+	return u
+}
+
+// WithAuthThrottle locks usernames out of password checks after too many failed attempts.
+func (u UserUseCases) WithAuthThrottle(authThrottle contracts.AuthThrottleService) UserUseCases {
+	u.authThrottle = authThrottle
+	return u
+}
+
 func (u UserUseCases) GetById(id int) (aggregates.User, error) {
 	return u.repo.GetById(id)
 }
@@ -47,7 +66,26 @@ func (u UserUseCases) Create(command commands.PostUser) (int, error) {
 	if err != nil {
 		return 0, err
 	}
-	return u.repo.Create(user)
+	id, err := u.repo.Create(user)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = u.SaveDigestCredential(id, user.Username(), command.Password.Value); err != nil {
+		// the user still authenticates with Basic, Digest is available after the password is reset
+		log.Printf("could not save digest credential of user %d: %v", id, err)
+	}
+
+	return id, nil
+}
+
+// SaveDigestCredential stores the hashes Digest authentication with the new password of the user is verified with.
+// It does nothing if the use cases store no digest credentials.
+func (u UserUseCases) SaveDigestCredential(userId int, username, password string) error {
+	if u.digestCredentials == nil {
+		return nil
+	}
+	return u.digestCredentials.Save(NewDigestCredential(userId, username, u.digestRealm, password))
 }
 
 func (u UserUseCases) Update(entity aggregates.User) error {
@@ -67,7 +105,20 @@ func (u UserUseCases) Delete(dto commands.DeleteUser) error {
 		return err
 	}
 
+	if u.authThrottle != nil {
+		if lockedFor := u.authThrottle.LockedOut(user.Username(), nil); lockedFor > 0 {
+			return aplication_errors.ErrLockedOut{RetryAfter: lockedFor}
+		}
+	}
+
 	isPasswordValid := u.cryptoService.ValidateHash(user.PasswordHash(), dto.Password.Value)
+	if u.authThrottle != nil {
+		if isPasswordValid {
+			u.authThrottle.RegisterSuccess(user.Username())
+		} else {
+			u.authThrottle.RegisterFailure(user.Username(), nil)
+		}
+	}
 	if !isPasswordValid {
 		return fmt.Errorf("invalid password")
 	}
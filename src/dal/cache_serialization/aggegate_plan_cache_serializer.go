@@ -17,6 +17,8 @@ type PlanDto struct {
 	Name       string           `json:"name"`
 	BytesLimit int64            `json:"bytes_limit"`
 	Duration   int              `json:"duration"`
+	Speed      int64            `json:"max_bytes_per_second"`
+	Conns      int              `json:"max_connections"`
 	Features   []PlanFeatureDto `json:"features"`
 	CreatedAt  time.Time        `json:"created_at"`
 }
@@ -33,7 +35,7 @@ func (a *AggegatePlanCacheSerializer) ToT(dto PlanDto) aggregates.Plan {
 		features[i] = valueobjects.NewPlanFeature(v.PlanId, v.FeatureName, v.FeatureDescription)
 	}
 
-	plan, _ := aggregates.NewPlan(dto.Id, dto.Name, dto.BytesLimit, dto.Duration, features)
+	plan, _ := aggregates.NewPlan(dto.Id, dto.Name, dto.BytesLimit, dto.Duration, dto.Speed, dto.Conns, features)
 	return plan
 }
 
@@ -60,6 +62,8 @@ func (a *AggegatePlanCacheSerializer) ToD(plan aggregates.Plan) PlanDto {
 		Name:       plan.Name(),
 		BytesLimit: plan.LimitBytes(),
 		Duration:   plan.DurationDays(),
+		Speed:      plan.MaxBytesPerSecond(),
+		Conns:      plan.MaxConnections(),
 		Features:   features,
 		CreatedAt:  plan.CreatedAt(),
 	}
@@ -0,0 +1,4 @@
+ALTER TABLE plans ADD COLUMN max_bytes_per_second BIGINT DEFAULT 0 NOT NULL;
+ALTER TABLE plans ADD COLUMN max_connections INT DEFAULT 0 NOT NULL;
+
+UPDATE plans SET max_connections = 25 WHERE name = 'Free';
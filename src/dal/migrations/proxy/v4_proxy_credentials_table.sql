@@ -0,0 +1,14 @@
+CREATE TABLE public.proxy_credentials (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES public.users (id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    key_hash VARCHAR(256) NOT NULL,
+    expires_at TIMESTAMP NULL,
+    allowed_networks TEXT NOT NULL DEFAULT '',
+    traffic_quota_bytes BIGINT NOT NULL DEFAULT 0,
+    used_bytes BIGINT NOT NULL DEFAULT 0,
+    revoked_at TIMESTAMP NULL,
+    created_at TIMESTAMP DEFAULT now()
+);
+
+CREATE INDEX idx_proxy_credentials_user_id on proxy_credentials(user_id);
@@ -0,0 +1,8 @@
+CREATE TABLE public.authorized_networks (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES public.users (id) ON DELETE CASCADE,
+    network VARCHAR(43) NOT NULL UNIQUE,
+    created_at TIMESTAMP DEFAULT now()
+);
+
+CREATE INDEX idx_authorized_networks_user_id on authorized_networks(user_id);
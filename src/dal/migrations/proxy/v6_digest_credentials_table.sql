@@ -0,0 +1,7 @@
+CREATE TABLE public.digest_credentials (
+    user_id INT PRIMARY KEY REFERENCES public.users (id) ON DELETE CASCADE,
+    realm VARCHAR(128) NOT NULL,
+    ha1_md5 VARCHAR(32) NOT NULL,
+    ha1_sha256 VARCHAR(64) NOT NULL,
+    updated_at TIMESTAMP DEFAULT now()
+);
@@ -0,0 +1,158 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"goproxy/domain/aggregates"
+	"time"
+)
+
+const (
+	// serializationFailure is the SQLSTATE of transactions aborted as they conflict with concurrent ones
+	serializationFailure = "40001"
+	// createAuthorizedNetworkAttempts is how many times a network is inserted if concurrent insertions conflict
+	createAuthorizedNetworkAttempts = 3
+)
+
+const authorizedNetworkColumns = "id, user_id, network, created_at"
+const selectAuthorizedNetworkById = "SELECT " + authorizedNetworkColumns + " FROM public.authorized_networks WHERE id = $1"
+const selectAuthorizedNetworks = "SELECT " + authorizedNetworkColumns + " FROM public.authorized_networks ORDER BY id"
+const selectAuthorizedNetworksByUserId = "SELECT " + authorizedNetworkColumns + " FROM public.authorized_networks WHERE user_id = $1 ORDER BY id"
+const insertAuthorizedNetwork = "INSERT INTO public.authorized_networks (user_id, network) VALUES ($1, $2) RETURNING id"
+const deleteAuthorizedNetwork = "DELETE FROM public.authorized_networks WHERE id = $1"
+
+type AuthorizedNetworkRepository struct {
+	db *sql.DB
+}
+
+func NewAuthorizedNetworkRepository(db *sql.DB) *AuthorizedNetworkRepository {
+	return &AuthorizedNetworkRepository{
+		db: db,
+	}
+}
+
+func (r *AuthorizedNetworkRepository) GetById(id int) (aggregates.AuthorizedNetwork, error) {
+	network, err := scanAuthorizedNetwork(r.db.QueryRow(selectAuthorizedNetworkById, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return aggregates.AuthorizedNetwork{}, fmt.Errorf("authorized network not found: %v", err)
+		}
+		return aggregates.AuthorizedNetwork{}, fmt.Errorf("could not load authorized network: %v", err)
+	}
+
+	return network, nil
+}
+
+func (r *AuthorizedNetworkRepository) GetAll() ([]aggregates.AuthorizedNetwork, error) {
+	return r.query(selectAuthorizedNetworks)
+}
+
+func (r *AuthorizedNetworkRepository) GetAllByUserId(userId int) ([]aggregates.AuthorizedNetwork, error) {
+	return r.query(selectAuthorizedNetworksByUserId, userId)
+}
+
+func (r *AuthorizedNetworkRepository) query(query string, args ...any) ([]aggregates.AuthorizedNetwork, error) {
+	return queryAuthorizedNetworks(r.db, query, args...)
+}
+
+type rowsQuerier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func queryAuthorizedNetworks(querier rowsQuerier, query string, args ...any) ([]aggregates.AuthorizedNetwork, error) {
+	rows, err := querier.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not load authorized networks: %v", err)
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	networks := make([]aggregates.AuthorizedNetwork, 0)
+	for rows.Next() {
+		network, scanErr := scanAuthorizedNetwork(rows)
+		if scanErr != nil {
+			return nil, fmt.Errorf("could not load authorized networks: %v", scanErr)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, rows.Err()
+}
+
+// Create stores the network unless check returns an error for the networks already stored.
+// Both run in a serializable transaction, so a network inserted concurrently is either seen by check or makes
+// the transaction retry.
+func (r *AuthorizedNetworkRepository) Create(network aggregates.AuthorizedNetwork,
+	check func(existing []aggregates.AuthorizedNetwork) error) (int, error) {
+	var pqErr *pq.Error
+	for attempt := 1; ; attempt++ {
+		id, err := r.create(network, check)
+		if err == nil || !errors.As(err, &pqErr) || pqErr.Code != serializationFailure || attempt == createAuthorizedNetworkAttempts {
+			return id, err
+		}
+	}
+}
+
+func (r *AuthorizedNetworkRepository) create(network aggregates.AuthorizedNetwork,
+	check func(existing []aggregates.AuthorizedNetwork) error) (int, error) {
+	tx, err := r.db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return 0, fmt.Errorf("could not create authorized network: %w", err)
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	existing, err := queryAuthorizedNetworks(tx, selectAuthorizedNetworks)
+	if err != nil {
+		return 0, err
+	}
+	if err = check(existing); err != nil {
+		return 0, err
+	}
+
+	var id int
+	if err = tx.QueryRow(insertAuthorizedNetwork, network.UserId(), network.Network()).Scan(&id); err != nil {
+		return 0, fmt.Errorf("could not create authorized network: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("could not create authorized network: %w", err)
+	}
+
+	return id, nil
+}
+
+func (r *AuthorizedNetworkRepository) Delete(network aggregates.AuthorizedNetwork) error {
+	result, err := r.db.Exec(deleteAuthorizedNetwork, network.Id())
+	if err != nil {
+		return fmt.Errorf("could not delete authorized network: %v", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil || affected == 0 {
+		return fmt.Errorf("no rows affected")
+	}
+
+	return nil
+}
+
+func scanAuthorizedNetwork(row rowScanner) (aggregates.AuthorizedNetwork, error) {
+	var id, userId int
+	var network string
+	var createdAt time.Time
+
+	if err := row.Scan(&id, &userId, &network, &createdAt); err != nil {
+		return aggregates.AuthorizedNetwork{}, err
+	}
+
+	authorizedNetwork, err := aggregates.NewAuthorizedNetwork(id, userId, network, createdAt)
+	if err != nil {
+		return aggregates.AuthorizedNetwork{}, fmt.Errorf("invalid authorized network %d stored in db: %v", id, err)
+	}
+
+	return authorizedNetwork, nil
+}
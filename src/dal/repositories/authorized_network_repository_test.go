@@ -0,0 +1,133 @@
+package repositories
+
+import (
+	"database/sql"
+	"fmt"
+	_ "github.com/lib/pq"
+	"goproxy/dal/cache"
+	"goproxy/domain/aggregates"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAuthorizedNetworkRepository(t *testing.T) {
+	setEnvErr := os.Setenv("DB_DATABASE", "proxy")
+	if setEnvErr != nil {
+		t.Fatal(setEnvErr)
+	}
+
+	defer func() {
+		_ = os.Unsetenv("DB_DATABASE")
+	}()
+
+	db, cleanup := prepareCockroachDB(t)
+	defer cleanup()
+	defer func(db *sql.DB) {
+		_ = db.Close()
+	}(db)
+
+	userCache, err := cache.NewBigCacheUserRepositoryCache(15*time.Minute, 5*time.Minute, 16, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userRepo := NewUserRepository(db, userCache)
+	repo := NewAuthorizedNetworkRepository(db)
+
+	t.Run("Create", func(t *testing.T) {
+		userId := insertTestUser(userRepo, t)
+		networkId := insertTestAuthorizedNetwork(repo, userId, "198.51.100.0/24", t)
+
+		network, err := repo.GetById(networkId)
+		assertNoError(t, err, "Failed to load authorized network by Id")
+		if network.UserId() != userId || network.Network() != "198.51.100.0/24" {
+			t.Errorf("Unexpected authorized network loaded: %+v", network)
+		}
+	})
+
+	t.Run("CreateDuplicate", func(t *testing.T) {
+		userId := insertTestUser(userRepo, t)
+		insertTestAuthorizedNetwork(repo, userId, "198.51.101.7", t)
+
+		network, err := aggregates.NewAuthorizedNetwork(-1, insertTestUser(userRepo, t), "198.51.101.7/32", time.Time{})
+		assertNoError(t, err, "Failed to create test authorized network")
+		if _, err = repo.Create(network, acceptAuthorizedNetwork); err == nil {
+			t.Errorf("Expected the same network not to be stored twice")
+		}
+	})
+
+	t.Run("CreateConcurrentlyOverlapping", func(t *testing.T) {
+		userIds := []int{insertTestUser(userRepo, t), insertTestUser(userRepo, t)}
+		networks := []string{"198.51.102.0/24", "198.51.102.128/25"}
+
+		var wg sync.WaitGroup
+		errs := make([]error, len(userIds))
+		for i := range userIds {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				network, err := aggregates.NewAuthorizedNetwork(-1, userIds[i], networks[i], time.Time{})
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				_, errs[i] = repo.Create(network, func(existing []aggregates.AuthorizedNetwork) error {
+					for _, stored := range existing {
+						if stored.Overlaps(network) {
+							return fmt.Errorf("network %s overlaps %s", network.Network(), stored.Network())
+						}
+					}
+					return nil
+				})
+			}(i)
+		}
+		wg.Wait()
+
+		if (errs[0] == nil) == (errs[1] == nil) {
+			t.Errorf("Expected exactly one of overlapping networks to be stored, got errors %v", errs)
+		}
+	})
+
+	t.Run("GetAllByUserId", func(t *testing.T) {
+		userId := insertTestUser(userRepo, t)
+		firstId := insertTestAuthorizedNetwork(repo, userId, "203.0.113.0/28", t)
+		secondId := insertTestAuthorizedNetwork(repo, userId, "2001:db8:5::/48", t)
+
+		networks, err := repo.GetAllByUserId(userId)
+		assertNoError(t, err, "Failed to load authorized networks of user")
+		if len(networks) != 2 || networks[0].Id() != firstId || networks[1].Id() != secondId {
+			t.Errorf("Expected networks %d and %d, got %+v", firstId, secondId, networks)
+		}
+
+		all, err := repo.GetAll()
+		assertNoError(t, err, "Failed to load authorized networks")
+		if len(all) < 2 {
+			t.Errorf("Expected all networks to be loaded, got %+v", all)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		userId := insertTestUser(userRepo, t)
+		networkId := insertTestAuthorizedNetwork(repo, userId, "203.0.113.64/28", t)
+		network, err := repo.GetById(networkId)
+		assertNoError(t, err, "Failed to load authorized network by Id")
+
+		assertNoError(t, repo.Delete(network), "Failed to delete authorized network")
+		if _, err = repo.GetById(networkId); err == nil {
+			t.Errorf("Expected deleted authorized network not to be found")
+		}
+	})
+}
+
+func acceptAuthorizedNetwork([]aggregates.AuthorizedNetwork) error {
+	return nil
+}
+
+func insertTestAuthorizedNetwork(repo *AuthorizedNetworkRepository, userId int, network string, t *testing.T) int {
+	authorizedNetwork, err := aggregates.NewAuthorizedNetwork(-1, userId, network, time.Time{})
+	assertNoError(t, err, "Failed to create test authorized network")
+	id, err := repo.Create(authorizedNetwork, acceptAuthorizedNetwork)
+	assertNoError(t, err, "Failed to insert test authorized network")
+	return id
+}
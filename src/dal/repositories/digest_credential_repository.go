@@ -0,0 +1,63 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"goproxy/application/contracts"
+	"goproxy/domain/dataobjects"
+	"time"
+)
+
+// digestCredentialCacheTtl bounds how long a node keeps accepting hashes of a changed password
+// if it misses the change event, e.g. because another node of the consumer group received it.
+const digestCredentialCacheTtl = time.Minute
+
+const selectDigestCredentialByUsername = "SELECT d.user_id, u.username, d.realm, d.ha1_md5, d.ha1_sha256 FROM public.digest_credentials d JOIN public.users u ON u.id = d.user_id WHERE u.username = $1"
+const upsertDigestCredential = "UPSERT INTO public.digest_credentials (user_id, realm, ha1_md5, ha1_sha256, updated_at) VALUES ($1, $2, $3, $4, now())"
+
+// DigestCredentialRepository caches credentials by username, so they are evicted by UserPasswordChangedEvent
+// or once the cache TTL passes.
+type DigestCredentialRepository struct {
+	db    *sql.DB
+	cache contracts.CacheWithTTL[dataobjects.DigestCredential]
+}
+
+func NewDigestCredentialRepository(db *sql.DB, cache contracts.CacheWithTTL[dataobjects.DigestCredential]) *DigestCredentialRepository {
+	return &DigestCredentialRepository{
+		db:    db,
+		cache: cache,
+	}
+}
+
+func (r *DigestCredentialRepository) GetByUsername(username string) (dataobjects.DigestCredential, error) {
+	cachedCredential, cachedCredentialErr := r.cache.Get(username)
+	if cachedCredentialErr == nil {
+		return cachedCredential, nil
+	}
+
+	var credential dataobjects.DigestCredential
+	err := r.db.QueryRow(selectDigestCredentialByUsername, username).
+		Scan(&credential.UserId, &credential.Username, &credential.Realm, &credential.Ha1Md5, &credential.Ha1Sha256)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return dataobjects.DigestCredential{}, fmt.Errorf("digest credential not found: %v", err)
+		}
+		return dataobjects.DigestCredential{}, fmt.Errorf("could not load digest credential: %v", err)
+	}
+
+	_ = r.cache.Set(username, credential)
+	_ = r.cache.Expire(username, digestCredentialCacheTtl)
+
+	return credential, nil
+}
+
+func (r *DigestCredentialRepository) Save(credential dataobjects.DigestCredential) error {
+	_, err := r.db.Exec(upsertDigestCredential, credential.UserId, credential.Realm, credential.Ha1Md5, credential.Ha1Sha256)
+	if err != nil {
+		return fmt.Errorf("could not save digest credential: %v", err)
+	}
+
+	_ = r.cache.Delete(credential.Username)
+	return nil
+}
@@ -0,0 +1,66 @@
+package repositories
+
+import (
+	"database/sql"
+	_ "github.com/lib/pq"
+	"goproxy/dal/cache"
+	"goproxy/dal/repositories/mocks"
+	"goproxy/domain/dataobjects"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDigestCredentialRepository(t *testing.T) {
+	setEnvErr := os.Setenv("DB_DATABASE", "proxy")
+	if setEnvErr != nil {
+		t.Fatal(setEnvErr)
+	}
+
+	defer func() {
+		_ = os.Unsetenv("DB_DATABASE")
+	}()
+
+	db, cleanup := prepareCockroachDB(t)
+	defer cleanup()
+	defer func(db *sql.DB) {
+		_ = db.Close()
+	}(db)
+
+	userCache, err := cache.NewBigCacheUserRepositoryCache(15*time.Minute, 5*time.Minute, 16, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userRepo := NewUserRepository(db, userCache)
+	repo := NewDigestCredentialRepository(db, mocks.NewMockCacheWithTTL[dataobjects.DigestCredential]())
+
+	t.Run("Save", func(t *testing.T) {
+		userId := insertTestUser(userRepo, t)
+		user, err := userRepo.GetById(userId)
+		assertNoError(t, err, "Failed to load test user")
+
+		credential := dataobjects.DigestCredential{
+			UserId:    userId,
+			Username:  user.Username(),
+			Realm:     "Proxy",
+			Ha1Md5:    "939e7578ed9e3c518a452acee763bce9",
+			Ha1Sha256: "7987c3f3a0de1a46ef7d0b6e7e2b6f1d5a4e9c1b7f3f2e8a1c0d9b8a7f6e5d4c",
+		}
+		assertNoError(t, repo.Save(credential), "Failed to save digest credential")
+
+		credential.Ha1Md5 = "0123456789abcdef0123456789abcdef"
+		assertNoError(t, repo.Save(credential), "Failed to replace digest credential")
+
+		loaded, err := repo.GetByUsername(user.Username())
+		assertNoError(t, err, "Failed to load digest credential")
+		if loaded != credential {
+			t.Errorf("Expected %+v, got %+v", credential, loaded)
+		}
+	})
+
+	t.Run("GetByUsername not found", func(t *testing.T) {
+		if _, err := repo.GetByUsername("nobody"); err == nil {
+			t.Errorf("Expected missing digest credential not to be found")
+		}
+	})
+}
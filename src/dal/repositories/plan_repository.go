@@ -15,7 +15,7 @@ const (
 	planRepositoryCacheTtl = time.Hour
 
 	selectPlans = `
-SELECT id, name, limit_bytes, duration_days, created_at
+SELECT id, name, limit_bytes, duration_days, max_bytes_per_second, max_connections, created_at
 FROM plans
 `
 
@@ -25,6 +25,8 @@ SELECT
     plans.name,
     plans.limit_bytes,
     plans.duration_days,
+    plans.max_bytes_per_second,
+    plans.max_connections,
     features.name AS feature_name,
     features.description AS feature_description,
     plans.created_at
@@ -34,24 +36,24 @@ FROM plans
 `
 
 	selectPlanByNameQuery = `
-SELECT id, name, limit_bytes, duration_days, created_at 
+SELECT id, name, limit_bytes, duration_days, max_bytes_per_second, max_connections, created_at 
 FROM plans.public.plans 
 WHERE name = $1`
 
 	selectPlanByIdQuery = `
-SELECT id, name, limit_bytes, duration_days, created_at 
+SELECT id, name, limit_bytes, duration_days, max_bytes_per_second, max_connections, created_at 
 FROM plans.public.plans 
 WHERE id = $1`
 
 	insertPlanQuery = `
-INSERT INTO plans.public.plans (name, limit_bytes, duration_days) 
-VALUES ($1, $2, $3) 
+INSERT INTO plans.public.plans (name, limit_bytes, duration_days, max_bytes_per_second, max_connections) 
+VALUES ($1, $2, $3, $4, $5) 
 RETURNING id`
 
 	updatePlanQuery = `
 UPDATE plans.public.plans 
-SET name=$1, limit_bytes=$2, duration_days=$3 
-WHERE id = $4 
+SET name=$1, limit_bytes=$2, duration_days=$3, max_bytes_per_second=$4, max_connections=$5 
+WHERE id = $6 
 RETURNING id`
 
 	deletePlanQuery = `
@@ -63,6 +65,8 @@ SELECT
     plans.name,
     plans.limit_bytes,
     plans.duration_days,
+    plans.max_bytes_per_second,
+    plans.max_connections,
     features.id AS feature_id,
     features.name AS feature_name,
     features.description AS feature_description,
@@ -79,6 +83,8 @@ SELECT
     plans.name,
     plans.limit_bytes,
     plans.duration_days,
+    plans.max_bytes_per_second,
+    plans.max_connections,
     features.id AS feature_id,
     features.name AS feature_name,
     features.description AS feature_description,
@@ -124,14 +130,17 @@ func (p *PlanRepository) GetAll() ([]aggregates.Plan, error) {
 		var name string
 		var limitBytes int64
 		var durationDays int
+		var maxBytesPerSecond int64
+		var maxConnections int
 		var createdAt time.Time
 
-		err = rows.Scan(&id, &name, &limitBytes, &durationDays, &createdAt)
+		err = rows.Scan(&id, &name, &limitBytes, &durationDays, &maxBytesPerSecond, &maxConnections, &createdAt)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan row: %v", err)
 		}
 
-		plan, err := aggregates.NewPlan(id, name, limitBytes, durationDays, []valueobjects.PlanFeature{})
+		plan, err := aggregates.NewPlan(id, name, limitBytes, durationDays, maxBytesPerSecond, maxConnections,
+			[]valueobjects.PlanFeature{})
 		if err != nil {
 			fmt.Printf("plan validation err (invalid plan stored in db?): %s, plan id: %d\n", err, id)
 			continue
@@ -168,6 +177,8 @@ func (p *PlanRepository) GetAllWithFeatures() ([]aggregates.Plan, error) {
 		name       string
 		limitBytes int64
 		duration   int
+		speed      int64
+		conns      int
 		createdAt  time.Time
 		features   []valueobjects.PlanFeature
 	}
@@ -179,11 +190,14 @@ func (p *PlanRepository) GetAllWithFeatures() ([]aggregates.Plan, error) {
 		var name string
 		var limitBytes int64
 		var durationDays int
+		var maxBytesPerSecond int64
+		var maxConnections int
 		var createdAt time.Time
 		var featureName sql.NullString
 		var featureDescription sql.NullString
 
-		err = rows.Scan(&planId, &name, &limitBytes, &durationDays, &featureName, &featureDescription, &createdAt)
+		err = rows.Scan(&planId, &name, &limitBytes, &durationDays, &maxBytesPerSecond, &maxConnections,
+			&featureName, &featureDescription, &createdAt)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan row: %v", err)
 		}
@@ -195,6 +209,8 @@ func (p *PlanRepository) GetAllWithFeatures() ([]aggregates.Plan, error) {
 				name:       name,
 				limitBytes: limitBytes,
 				duration:   durationDays,
+				speed:      maxBytesPerSecond,
+				conns:      maxConnections,
 				createdAt:  createdAt,
 				features:   []valueobjects.PlanFeature{},
 			}
@@ -213,7 +229,7 @@ func (p *PlanRepository) GetAllWithFeatures() ([]aggregates.Plan, error) {
 
 	var plans []aggregates.Plan
 	for _, data := range plansMap {
-		plan, err := aggregates.NewPlan(data.id, data.name, data.limitBytes, data.duration, data.features)
+		plan, err := aggregates.NewPlan(data.id, data.name, data.limitBytes, data.duration, data.speed, data.conns, data.features)
 		if err != nil {
 			fmt.Printf("plan validation err (invalid plan data?): %s, plan id: %d\n", err, data.id)
 			continue
@@ -270,9 +286,12 @@ func (p *PlanRepository) getPlan(query string, args ...interface{}) (aggregates.
 	var Name string
 	var LimitBytes int64
 	var DurationDays int
+	var MaxBytesPerSecond int64
+	var MaxConnections int
 	var CreatedAt time.Time
 
-	err := p.db.QueryRow(query, args...).Scan(&Id, &Name, &LimitBytes, &DurationDays, &CreatedAt)
+	err := p.db.QueryRow(query, args...).
+		Scan(&Id, &Name, &LimitBytes, &DurationDays, &MaxBytesPerSecond, &MaxConnections, &CreatedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return aggregates.Plan{}, fmt.Errorf("plan not found")
@@ -280,7 +299,8 @@ func (p *PlanRepository) getPlan(query string, args ...interface{}) (aggregates.
 		return aggregates.Plan{}, err
 	}
 
-	plan, err := aggregates.NewPlan(Id, Name, LimitBytes, DurationDays, make([]valueobjects.PlanFeature, 0))
+	plan, err := aggregates.NewPlan(Id, Name, LimitBytes, DurationDays, MaxBytesPerSecond, MaxConnections,
+		make([]valueobjects.PlanFeature, 0))
 	if err != nil {
 		fmt.Printf("plan validation err (invalid plan stored in db?): %s, plan id: %d", err, Id)
 	}
@@ -291,14 +311,16 @@ func (p *PlanRepository) getPlan(query string, args ...interface{}) (aggregates.
 func (p *PlanRepository) Create(plan aggregates.Plan) (int, error) {
 	var id int
 	err := p.db.
-		QueryRow(insertPlanQuery, plan.Name(), plan.LimitBytes(), plan.DurationDays()).
+		QueryRow(insertPlanQuery, plan.Name(), plan.LimitBytes(), plan.DurationDays(), plan.MaxBytesPerSecond(),
+			plan.MaxConnections()).
 		Scan(&id)
 	return id, err
 }
 
 func (p *PlanRepository) Update(plan aggregates.Plan) error {
 	_, err := p.db.
-		Exec(updatePlanQuery, plan.Name(), plan.LimitBytes(), plan.DurationDays(), plan.Id())
+		Exec(updatePlanQuery, plan.Name(), plan.LimitBytes(), plan.DurationDays(), plan.MaxBytesPerSecond(),
+			plan.MaxConnections(), plan.Id())
 
 	if err != nil {
 		return fmt.Errorf("could not update plan: %v", err)
@@ -332,6 +354,8 @@ func (p *PlanRepository) getPlanWithFeatures(query string, arg interface{}) (agg
 	var name string
 	var limitBytes int64
 	var durationDays int
+	var maxBytesPerSecond int64
+	var maxConnections int
 	var createdAt time.Time
 
 	var features []valueobjects.PlanFeature
@@ -349,7 +373,8 @@ func (p *PlanRepository) getPlanWithFeatures(query string, arg interface{}) (agg
 		var featureName sql.NullString
 		var featureDescription sql.NullString
 
-		err = rows.Scan(&planId, &name, &limitBytes, &durationDays, &featureId, &featureName, &featureDescription, &createdAt)
+		err = rows.Scan(&planId, &name, &limitBytes, &durationDays, &maxBytesPerSecond, &maxConnections,
+			&featureId, &featureName, &featureDescription, &createdAt)
 		if err != nil {
 			return aggregates.Plan{}, fmt.Errorf("failed to scan row (query: %s, arg: %v): %w", query, arg, err)
 		}
@@ -366,7 +391,7 @@ func (p *PlanRepository) getPlanWithFeatures(query string, arg interface{}) (agg
 		return aggregates.Plan{}, fmt.Errorf("rows iteration error: %v", rowsErr)
 	}
 
-	return aggregates.NewPlan(planId, name, limitBytes, durationDays, features)
+	return aggregates.NewPlan(planId, name, limitBytes, durationDays, maxBytesPerSecond, maxConnections, features)
 }
 
 func (p *PlanRepository) planIdToCacheKey(id int) string {
@@ -127,7 +127,7 @@ func TestPlansRepository(t *testing.T) {
 		plan, err := planRepository.GetById(planId)
 		assertNoError(t, err, "Failed to load plan by Id")
 
-		updatedPlan, _ := aggregates.NewPlan(planId, "Updated Plan", 2000000, 60,
+		updatedPlan, _ := aggregates.NewPlan(planId, "Updated Plan", 2000000, 60, 250000, 50,
 			make([]valueobjects.PlanFeature, 0))
 		assertNoError(t, planRepository.Update(updatedPlan), "Failed to update plan")
 
@@ -181,7 +181,7 @@ func TestPlansRepository(t *testing.T) {
 
 func insertTestPlan(repo contracts.PlanRepository, t *testing.T) int {
 	name := fmt.Sprintf("Test Plan %d", time.Now().UTC().UnixNano())
-	plan, err := aggregates.NewPlan(-1, name, 1000000, 30, make([]valueobjects.PlanFeature, 0))
+	plan, err := aggregates.NewPlan(-1, name, 1000000, 30, 125000, 10, make([]valueobjects.PlanFeature, 0))
 	assertNoError(t, err, "Failed to create test plan plan")
 
 	id, err := repo.Create(plan)
@@ -199,6 +199,12 @@ func assertPlansEqual(t *testing.T, expected, actual aggregates.Plan) {
 	if expected.DurationDays() != actual.DurationDays() {
 		t.Errorf("Expected DurationDays %d, got %d", expected.DurationDays(), actual.DurationDays())
 	}
+	if expected.MaxBytesPerSecond() != actual.MaxBytesPerSecond() {
+		t.Errorf("Expected MaxBytesPerSecond %d, got %d", expected.MaxBytesPerSecond(), actual.MaxBytesPerSecond())
+	}
+	if expected.MaxConnections() != actual.MaxConnections() {
+		t.Errorf("Expected MaxConnections %d, got %d", expected.MaxConnections(), actual.MaxConnections())
+	}
 	if len(expected.Features()) != len(actual.Features()) {
 		t.Errorf("Expected %d features, got %d", len(expected.Features()), len(actual.Features()))
 	} else {
@@ -220,11 +226,17 @@ func assertPlansNotEqual(t *testing.T, expected, actual aggregates.Plan) {
 	if expected.DurationDays() == actual.DurationDays() {
 		t.Errorf("Unexpected equal DurationDays: %d", expected.DurationDays())
 	}
+	if expected.MaxBytesPerSecond() == actual.MaxBytesPerSecond() {
+		t.Errorf("Unexpected equal MaxBytesPerSecond: %d", expected.MaxBytesPerSecond())
+	}
+	if expected.MaxConnections() == actual.MaxConnections() {
+		t.Errorf("Unexpected equal MaxConnections: %d", expected.MaxConnections())
+	}
 }
 
 func insertTestPlanWithFeatures(db *sql.DB, repo contracts.PlanRepository, t *testing.T, featureCount int) int {
 	name := fmt.Sprintf("Test Plan With Features %d", time.Now().UTC().UnixNano())
-	plan, err := aggregates.NewPlan(-1, name, time.Now().UnixMilli(), time.Now().Day(), make([]valueobjects.PlanFeature, 0))
+	plan, err := aggregates.NewPlan(-1, name, time.Now().UnixMilli(), time.Now().Day(), 0, 0, make([]valueobjects.PlanFeature, 0))
 	assertNoError(t, err, "Failed to create test plan")
 
 	planId, err := repo.Create(plan)
@@ -0,0 +1,167 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"goproxy/application/contracts"
+	"goproxy/domain/aggregates"
+	"strings"
+	"time"
+)
+
+// proxyCredentialCacheTtl bounds how long a node keeps accepting a revoked or changed credential
+// if it misses the change event, e.g. because another node of the consumer group received it.
+const proxyCredentialCacheTtl = time.Minute
+
+const proxyCredentialColumns = "id, user_id, name, key_hash, expires_at, allowed_networks, traffic_quota_bytes, used_bytes, revoked_at, created_at"
+const selectProxyCredentialById = "SELECT " + proxyCredentialColumns + " FROM public.proxy_credentials WHERE id = $1"
+const selectProxyCredentialsByUserId = "SELECT " + proxyCredentialColumns + " FROM public.proxy_credentials WHERE user_id = $1 ORDER BY id"
+const insertProxyCredential = "INSERT INTO public.proxy_credentials (user_id, name, key_hash, expires_at, allowed_networks, traffic_quota_bytes) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id"
+const updateProxyCredential = "UPDATE public.proxy_credentials SET name = $1, expires_at = $2, allowed_networks = $3, traffic_quota_bytes = $4, revoked_at = $5 WHERE id = $6"
+const addProxyCredentialUsedBytes = "UPDATE public.proxy_credentials SET used_bytes = used_bytes + $1 WHERE id = $2 RETURNING " + proxyCredentialColumns
+const deleteProxyCredential = "DELETE FROM public.proxy_credentials WHERE id = $1"
+
+type ProxyCredentialRepository struct {
+	db    *sql.DB
+	cache contracts.CacheWithTTL[aggregates.ProxyCredential]
+}
+
+func NewProxyCredentialRepository(db *sql.DB, cache contracts.CacheWithTTL[aggregates.ProxyCredential]) *ProxyCredentialRepository {
+	return &ProxyCredentialRepository{
+		db:    db,
+		cache: cache,
+	}
+}
+
+func (r *ProxyCredentialRepository) GetById(id int) (aggregates.ProxyCredential, error) {
+	cachedCredential, cachedCredentialErr := r.cache.Get(fmt.Sprintf("%v", id))
+	if cachedCredentialErr == nil {
+		return cachedCredential, nil
+	}
+
+	credential, err := scanProxyCredential(r.db.QueryRow(selectProxyCredentialById, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return aggregates.ProxyCredential{}, fmt.Errorf("proxy credential not found: %v", err)
+		}
+		return aggregates.ProxyCredential{}, fmt.Errorf("could not load proxy credential: %v", err)
+	}
+
+	_ = r.cache.Set(fmt.Sprintf("%v", id), credential)
+	_ = r.cache.Expire(fmt.Sprintf("%v", id), proxyCredentialCacheTtl)
+
+	return credential, nil
+}
+
+func (r *ProxyCredentialRepository) GetAllByUserId(userId int) ([]aggregates.ProxyCredential, error) {
+	rows, err := r.db.Query(selectProxyCredentialsByUserId, userId)
+	if err != nil {
+		return nil, fmt.Errorf("could not load proxy credentials: %v", err)
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	credentials := make([]aggregates.ProxyCredential, 0)
+	for rows.Next() {
+		credential, scanErr := scanProxyCredential(rows)
+		if scanErr != nil {
+			return nil, fmt.Errorf("could not load proxy credentials: %v", scanErr)
+		}
+		credentials = append(credentials, credential)
+	}
+
+	return credentials, rows.Err()
+}
+
+func (r *ProxyCredentialRepository) Create(credential aggregates.ProxyCredential) (int, error) {
+	var id int
+	err := r.db.QueryRow(insertProxyCredential,
+		credential.UserId(), credential.Name(), credential.KeyHash(), nullTime(credential.ExpiresAt()),
+		strings.Join(credential.AllowedNetworks(), ","), credential.TrafficQuotaBytes(),
+	).Scan(&id)
+	return id, err
+}
+
+// Update saves the settings and revocation of the credential, its key and used traffic are never updated.
+func (r *ProxyCredentialRepository) Update(credential aggregates.ProxyCredential) error {
+	result, err := r.db.Exec(updateProxyCredential,
+		credential.Name(), nullTime(credential.ExpiresAt()), strings.Join(credential.AllowedNetworks(), ","),
+		credential.TrafficQuotaBytes(), nullTime(credential.RevokedAt()), credential.Id())
+	if err != nil {
+		return fmt.Errorf("could not update proxy credential: %v", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil || affected == 0 {
+		return fmt.Errorf("no rows updated for proxy credential id: %d", credential.Id())
+	}
+
+	_ = r.cache.Delete(fmt.Sprintf("%v", credential.Id()))
+	return nil
+}
+
+// AddUsedBytes adds traffic to the used traffic of the credential and returns the updated credential.
+func (r *ProxyCredentialRepository) AddUsedBytes(id int, bytes int64) (aggregates.ProxyCredential, error) {
+	credential, err := scanProxyCredential(r.db.QueryRow(addProxyCredentialUsedBytes, bytes, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return aggregates.ProxyCredential{}, fmt.Errorf("proxy credential not found: %v", err)
+		}
+		return aggregates.ProxyCredential{}, fmt.Errorf("could not update proxy credential traffic: %v", err)
+	}
+
+	_ = r.cache.Delete(fmt.Sprintf("%v", id))
+	return credential, nil
+}
+
+func (r *ProxyCredentialRepository) Delete(credential aggregates.ProxyCredential) error {
+	result, err := r.db.Exec(deleteProxyCredential, credential.Id())
+	if err != nil {
+		return fmt.Errorf("could not delete proxy credential: %v", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil || affected == 0 {
+		return fmt.Errorf("no rows affected")
+	}
+
+	_ = r.cache.Delete(fmt.Sprintf("%v", credential.Id()))
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanProxyCredential(row rowScanner) (aggregates.ProxyCredential, error) {
+	var id, userId int
+	var name, keyHash, allowedNetworks string
+	var expiresAt, revokedAt sql.NullTime
+	var trafficQuotaBytes, usedBytes int64
+	var createdAt time.Time
+
+	err := row.Scan(&id, &userId, &name, &keyHash, &expiresAt, &allowedNetworks, &trafficQuotaBytes, &usedBytes, &revokedAt, &createdAt)
+	if err != nil {
+		return aggregates.ProxyCredential{}, err
+	}
+
+	var networks []string
+	if allowedNetworks != "" {
+		networks = strings.Split(allowedNetworks, ",")
+	}
+
+	credential, credentialErr := aggregates.NewProxyCredential(id, userId, name, keyHash, expiresAt.Time, networks,
+		trafficQuotaBytes, usedBytes, revokedAt.Time, createdAt)
+	if credentialErr != nil {
+		return aggregates.ProxyCredential{}, fmt.Errorf("invalid proxy credential %d stored in db: %v", id, credentialErr)
+	}
+
+	return credential, nil
+}
+
+// nullTime stores zero times as NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -0,0 +1,116 @@
+package repositories
+
+import (
+	"database/sql"
+	_ "github.com/lib/pq"
+	"goproxy/dal/cache"
+	"goproxy/dal/repositories/mocks"
+	"goproxy/domain/aggregates"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestProxyCredentialRepository(t *testing.T) {
+	setEnvErr := os.Setenv("DB_DATABASE", "proxy")
+	if setEnvErr != nil {
+		t.Fatal(setEnvErr)
+	}
+
+	defer func() {
+		_ = os.Unsetenv("DB_DATABASE")
+	}()
+
+	db, cleanup := prepareCockroachDB(t)
+	defer cleanup()
+	defer func(db *sql.DB) {
+		_ = db.Close()
+	}(db)
+
+	userCache, err := cache.NewBigCacheUserRepositoryCache(15*time.Minute, 5*time.Minute, 16, 512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userRepo := NewUserRepository(db, userCache)
+	repo := NewProxyCredentialRepository(db, mocks.NewMockCacheWithTTL[aggregates.ProxyCredential]())
+
+	t.Run("Create", func(t *testing.T) {
+		userId := insertTestUser(userRepo, t)
+		credentialId := insertTestProxyCredential(repo, userId, t)
+
+		credential, err := repo.GetById(credentialId)
+		assertNoError(t, err, "Failed to load proxy credential by Id")
+		if credential.UserId() != userId || credential.Name() != "laptop" || credential.KeyHash() != sampleValidArgon2idHash {
+			t.Errorf("Unexpected proxy credential loaded: %+v", credential)
+		}
+		if !equalSlices(credential.AllowedNetworks(), []string{"10.0.0.0/8", "192.0.2.1/32"}) {
+			t.Errorf("Expected allowed networks to be stored, got %v", credential.AllowedNetworks())
+		}
+		if credential.ExpiresAt().IsZero() || credential.IsRevoked() {
+			t.Errorf("Expected expiring active credential, got %+v", credential)
+		}
+	})
+
+	t.Run("GetAllByUserId", func(t *testing.T) {
+		userId := insertTestUser(userRepo, t)
+		firstId := insertTestProxyCredential(repo, userId, t)
+		secondId := insertTestProxyCredential(repo, userId, t)
+
+		credentials, err := repo.GetAllByUserId(userId)
+		assertNoError(t, err, "Failed to load proxy credentials of user")
+		if len(credentials) != 2 || credentials[0].Id() != firstId || credentials[1].Id() != secondId {
+			t.Errorf("Expected credentials %d and %d, got %+v", firstId, secondId, credentials)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		userId := insertTestUser(userRepo, t)
+		credentialId := insertTestProxyCredential(repo, userId, t)
+		credential, err := repo.GetById(credentialId)
+		assertNoError(t, err, "Failed to load proxy credential by Id")
+
+		credential.Revoke(time.Now().UTC())
+		assertNoError(t, repo.Update(credential), "Failed to update proxy credential")
+
+		loadedCredential, err := repo.GetById(credentialId)
+		assertNoError(t, err, "Failed to load updated proxy credential")
+		if !loadedCredential.IsRevoked() {
+			t.Errorf("Expected proxy credential to be revoked")
+		}
+	})
+
+	t.Run("AddUsedBytes", func(t *testing.T) {
+		userId := insertTestUser(userRepo, t)
+		credentialId := insertTestProxyCredential(repo, userId, t)
+
+		_, err := repo.AddUsedBytes(credentialId, 600)
+		assertNoError(t, err, "Failed to add used bytes")
+		credential, err := repo.AddUsedBytes(credentialId, 600)
+		assertNoError(t, err, "Failed to add used bytes")
+
+		if credential.UsedBytes() != 1200 || !credential.QuotaExceeded() {
+			t.Errorf("Expected 1200 used bytes exceeding the quota, got %d", credential.UsedBytes())
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		userId := insertTestUser(userRepo, t)
+		credentialId := insertTestProxyCredential(repo, userId, t)
+		credential, err := repo.GetById(credentialId)
+		assertNoError(t, err, "Failed to load proxy credential by Id")
+
+		assertNoError(t, repo.Delete(credential), "Failed to delete proxy credential")
+		if _, err = repo.GetById(credentialId); err == nil {
+			t.Errorf("Expected deleted proxy credential not to be found")
+		}
+	})
+}
+
+func insertTestProxyCredential(repo *ProxyCredentialRepository, userId int, t *testing.T) int {
+	credential, err := aggregates.NewProxyCredential(-1, userId, "laptop", sampleValidArgon2idHash,
+		time.Now().UTC().Add(time.Hour), []string{"10.0.0.0/8", "192.0.2.1"}, 1000, 0, time.Time{}, time.Time{})
+	assertNoError(t, err, "Failed to create test proxy credential")
+	id, err := repo.Create(credential)
+	assertNoError(t, err, "Failed to insert test proxy credential")
+	return id
+}
@@ -0,0 +1,80 @@
+package aggregates
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Networks wider than these prefixes cannot be claimed, so a user cannot take over a whole provider or region.
+const (
+	minAuthorizedIpv4PrefixLength = 16
+	minAuthorizedIpv6PrefixLength = 48
+)
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), its addresses are shared by clients of many providers.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0).To4(), Mask: net.CIDRMask(10, 32)}
+
+// AuthorizedNetwork is a network of a user whose clients use the proxy without credentials.
+type AuthorizedNetwork struct {
+	id        int
+	userId    int
+	network   *net.IPNet
+	createdAt time.Time
+}
+
+// NewAuthorizedNetwork creates a network written as a CIDR or a single IP address. Only public unicast networks
+// can be authorized, as private, loopback, link-local and shared addresses do not identify clients of the user.
+func NewAuthorizedNetwork(id, userId int, network string, createdAt time.Time) (AuthorizedNetwork, error) {
+	ipNet, err := parseAllowedNetwork(network)
+	if err != nil {
+		return AuthorizedNetwork{}, err
+	}
+
+	ones, bits := ipNet.Mask.Size()
+	if bits == 32 && ones < minAuthorizedIpv4PrefixLength {
+		return AuthorizedNetwork{}, fmt.Errorf("IPv4 networks must not be wider than /%d", minAuthorizedIpv4PrefixLength)
+	}
+	if bits == 128 && ones < minAuthorizedIpv6PrefixLength {
+		return AuthorizedNetwork{}, fmt.Errorf("IPv6 networks must not be wider than /%d", minAuthorizedIpv6PrefixLength)
+	}
+	// the minimum prefix lengths are longer than the prefixes of non-public ranges,
+	// so networks are either inside of such a range or outside of all of them
+	if !ipNet.IP.IsGlobalUnicast() || ipNet.IP.IsPrivate() || sharedAddressSpace.Contains(ipNet.IP) {
+		return AuthorizedNetwork{}, fmt.Errorf("network %s is not a public unicast network", ipNet)
+	}
+
+	return AuthorizedNetwork{
+		id:        id,
+		userId:    userId,
+		network:   ipNet,
+		createdAt: createdAt,
+	}, nil
+}
+
+func (n *AuthorizedNetwork) Id() int {
+	return n.id
+}
+
+func (n *AuthorizedNetwork) UserId() int {
+	return n.userId
+}
+
+// Network returns the network in CIDR notation.
+func (n *AuthorizedNetwork) Network() string {
+	return n.network.String()
+}
+
+// IPNet returns the parsed network, the IP of IPv4 networks is 4 bytes long.
+func (n *AuthorizedNetwork) IPNet() *net.IPNet {
+	return n.network
+}
+
+func (n *AuthorizedNetwork) CreatedAt() time.Time {
+	return n.createdAt
+}
+
+// Overlaps tells if any address belongs to both networks.
+func (n *AuthorizedNetwork) Overlaps(other AuthorizedNetwork) bool {
+	return n.network.Contains(other.network.IP) || other.network.Contains(n.network.IP)
+}
@@ -10,16 +10,29 @@ type Plan struct {
 	name       valueobjects.PlanName
 	limitBytes valueobjects.PlanBytesLimit
 	duration   valueobjects.PlanDuration
+	speed      valueobjects.PlanSpeedLimit
+	conns      valueobjects.PlanConnectionsLimit
 	features   []valueobjects.PlanFeature
 	createdAt  time.Time
 }
 
-func NewPlan(id int, name string, limitBytes int64, durationDays int, features []valueobjects.PlanFeature) (Plan, error) {
+func NewPlan(id int, name string, limitBytes int64, durationDays int, maxBytesPerSecond int64,
+	maxConnections int, features []valueobjects.PlanFeature) (Plan, error) {
 	limit, limitErr := valueobjects.PlanBytesLimitFromInt64(limitBytes)
 	if limitErr != nil {
 		return Plan{}, limitErr
 	}
 
+	speed, speedErr := valueobjects.PlanSpeedLimitFromInt64(maxBytesPerSecond)
+	if speedErr != nil {
+		return Plan{}, speedErr
+	}
+
+	conns, connsErr := valueobjects.PlanConnectionsLimitFromInt(maxConnections)
+	if connsErr != nil {
+		return Plan{}, connsErr
+	}
+
 	planName, nameErr := valueobjects.ParsePlanNameFromString(name)
 	if nameErr != nil {
 		return Plan{}, nameErr
@@ -35,6 +48,8 @@ func NewPlan(id int, name string, limitBytes int64, durationDays int, features [
 		name:       planName,
 		limitBytes: limit,
 		duration:   planDuration,
+		speed:      speed,
+		conns:      conns,
 		features:   features,
 		createdAt:  time.Now().UTC(),
 	}, nil
@@ -56,6 +71,16 @@ func (p *Plan) DurationDays() int {
 	return p.duration.DurationDays()
 }
 
+// MaxBytesPerSecond returns the plan speed limit, 0 means unlimited.
+func (p *Plan) MaxBytesPerSecond() int64 {
+	return p.speed.BytesPerSecond()
+}
+
+// MaxConnections returns the plan concurrent connections limit, 0 means unlimited.
+func (p *Plan) MaxConnections() int {
+	return p.conns.Value()
+}
+
 func (p *Plan) Features() []valueobjects.PlanFeature {
 	return p.features
 }
@@ -0,0 +1,171 @@
+package aggregates
+
+import (
+	"fmt"
+	"goproxy/domain/valueobjects"
+	"net"
+	"strings"
+	"time"
+)
+
+const maxProxyCredentialNameLength = 64
+
+// ProxyCredential is a named proxy password of a user, e.g. for a single device or app, revocable on its own.
+// A zero expiry never expires, an empty allow-list allows any client IP and a zero traffic quota is unlimited.
+type ProxyCredential struct {
+	id                int
+	userId            int
+	name              string
+	keyHash           valueobjects.Argon2idHash
+	expiresAt         time.Time
+	allowedNetworks   []*net.IPNet
+	trafficQuotaBytes int64
+	usedBytes         int64
+	revokedAt         time.Time
+	createdAt         time.Time
+}
+
+// NewProxyCredential creates a credential, allowed networks are written as CIDRs or single IP addresses.
+func NewProxyCredential(id, userId int, name, keyHash string, expiresAt time.Time, allowedNetworks []string,
+	trafficQuotaBytes, usedBytes int64, revokedAt, createdAt time.Time) (ProxyCredential, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ProxyCredential{}, fmt.Errorf("credential name cannot be empty")
+	}
+	if len(name) > maxProxyCredentialNameLength {
+		return ProxyCredential{}, fmt.Errorf("credential name must not be longer than %d characters", maxProxyCredentialNameLength)
+	}
+
+	keyHashObject, keyHashErr := valueobjects.NewHash(keyHash)
+	if keyHashErr != nil {
+		return ProxyCredential{}, keyHashErr
+	}
+
+	if trafficQuotaBytes < 0 || usedBytes < 0 {
+		return ProxyCredential{}, fmt.Errorf("credential traffic must not be negative")
+	}
+
+	networks := make([]*net.IPNet, 0, len(allowedNetworks))
+	for _, allowed := range allowedNetworks {
+		network, networkErr := parseAllowedNetwork(allowed)
+		if networkErr != nil {
+			return ProxyCredential{}, networkErr
+		}
+		networks = append(networks, network)
+	}
+
+	return ProxyCredential{
+		id:                id,
+		userId:            userId,
+		name:              name,
+		keyHash:           keyHashObject,
+		expiresAt:         expiresAt,
+		allowedNetworks:   networks,
+		trafficQuotaBytes: trafficQuotaBytes,
+		usedBytes:         usedBytes,
+		revokedAt:         revokedAt,
+		createdAt:         createdAt,
+	}, nil
+}
+
+func parseAllowedNetwork(value string) (*net.IPNet, error) {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, "/") {
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed network: %s", value)
+		}
+		return network, nil
+	}
+
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid allowed network: %s", value)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+func (c *ProxyCredential) Id() int {
+	return c.id
+}
+
+func (c *ProxyCredential) UserId() int {
+	return c.userId
+}
+
+func (c *ProxyCredential) Name() string {
+	return c.name
+}
+
+func (c *ProxyCredential) KeyHash() string {
+	return c.keyHash.Value
+}
+
+func (c *ProxyCredential) ExpiresAt() time.Time {
+	return c.expiresAt
+}
+
+// AllowedNetworks returns the allowed networks as CIDRs.
+func (c *ProxyCredential) AllowedNetworks() []string {
+	networks := make([]string, 0, len(c.allowedNetworks))
+	for _, network := range c.allowedNetworks {
+		networks = append(networks, network.String())
+	}
+	return networks
+}
+
+func (c *ProxyCredential) TrafficQuotaBytes() int64 {
+	return c.trafficQuotaBytes
+}
+
+func (c *ProxyCredential) UsedBytes() int64 {
+	return c.usedBytes
+}
+
+func (c *ProxyCredential) RevokedAt() time.Time {
+	return c.revokedAt
+}
+
+func (c *ProxyCredential) CreatedAt() time.Time {
+	return c.createdAt
+}
+
+func (c *ProxyCredential) IsRevoked() bool {
+	return !c.revokedAt.IsZero()
+}
+
+func (c *ProxyCredential) IsExpired(now time.Time) bool {
+	return !c.expiresAt.IsZero() && !now.Before(c.expiresAt)
+}
+
+func (c *ProxyCredential) QuotaExceeded() bool {
+	return c.trafficQuotaBytes > 0 && c.usedBytes >= c.trafficQuotaBytes
+}
+
+// IsActive reports whether the credential can be used to authorize at the moment.
+func (c *ProxyCredential) IsActive(now time.Time) bool {
+	return !c.IsRevoked() && !c.IsExpired(now) && !c.QuotaExceeded()
+}
+
+// AllowsIp reports whether clients with the IP may use the credential.
+func (c *ProxyCredential) AllowsIp(ip net.IP) bool {
+	if len(c.allowedNetworks) == 0 {
+		return true
+	}
+
+	for _, network := range c.allowedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ProxyCredential) Revoke(at time.Time) {
+	if !c.IsRevoked() {
+		c.revokedAt = at
+	}
+}
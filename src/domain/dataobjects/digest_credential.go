@@ -0,0 +1,11 @@
+package dataobjects
+
+// DigestCredential holds hashes of the user password for HTTP Digest authentication (RFC 7616), one per algorithm.
+// HA1 is H(username:realm:password), so it is only valid in the realm it was computed for.
+type DigestCredential struct {
+	UserId    int
+	Username  string
+	Realm     string
+	Ha1Md5    string
+	Ha1Sha256 string
+}
@@ -3,8 +3,10 @@ package dataobjects
 import "time"
 
 type UserPlan struct {
-	Name         string
-	Bandwidth    int64
-	CreatedAt    time.Time
-	DurationDays int
+	Name              string
+	Bandwidth         int64
+	MaxBytesPerSecond int64
+	MaxConnections    int
+	CreatedAt         time.Time
+	DurationDays      int
 }
@@ -0,0 +1,9 @@
+package dataobjects
+
+// UserPlanLimits are speed and connection limits of the user active plan. Zero values mean no limit.
+// PlanId is 0 if the plan is not known.
+type UserPlanLimits struct {
+	PlanId            int
+	MaxBytesPerSecond int64
+	MaxConnections    int
+}
@@ -0,0 +1,6 @@
+package events
+
+// AuthorizedNetworksChangedEvent tells proxy nodes to reload authorized networks after the user added or removed one.
+type AuthorizedNetworksChangedEvent struct {
+	UserId int
+}
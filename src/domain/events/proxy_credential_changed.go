@@ -0,0 +1,6 @@
+package events
+
+// ProxyCredentialChangedEvent tells proxy nodes to reload the credential, e.g. after it was revoked or ran out of traffic.
+type ProxyCredentialChangedEvent struct {
+	CredentialId int
+}
@@ -3,17 +3,20 @@ package events
 import "time"
 
 type UserConsumedTrafficEvent struct {
-	UserId    int
-	Timestamp time.Time
-	InBytes   int64
-	OutBytes  int64
+	UserId int
+	// CredentialId is the proxy credential the traffic was consumed with, 0 for the account password
+	CredentialId int `json:",omitempty"`
+	Timestamp    time.Time
+	InBytes      int64
+	OutBytes     int64
 }
 
-func NewUserConsumedTrafficEvent(userId int, in, out int64) UserConsumedTrafficEvent {
+func NewUserConsumedTrafficEvent(userId, credentialId int, in, out int64) UserConsumedTrafficEvent {
 	return UserConsumedTrafficEvent{
-		UserId:    userId,
-		Timestamp: time.Now().UTC(),
-		InBytes:   in,
-		OutBytes:  out,
+		UserId:       userId,
+		CredentialId: credentialId,
+		Timestamp:    time.Now().UTC(),
+		InBytes:      in,
+		OutBytes:     out,
 	}
 }
@@ -0,0 +1,21 @@
+package events
+
+import "time"
+
+// UserLockedOutEvent tells that authentication attempts of the username, or from the source IP if the username
+// is empty, are refused until LockedUntil after too many of them failed.
+type UserLockedOutEvent struct {
+	Username    string
+	Ip          string
+	Failures    int
+	LockedUntil time.Time
+}
+
+func NewUserLockedOutEvent(username, ip string, failures int, lockedUntil time.Time) UserLockedOutEvent {
+	return UserLockedOutEvent{
+		Username:    username,
+		Ip:          ip,
+		Failures:    failures,
+		LockedUntil: lockedUntil.UTC(),
+	}
+}
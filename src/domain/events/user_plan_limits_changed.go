@@ -0,0 +1,23 @@
+package events
+
+import "time"
+
+// UserPlanLimitsChanged carries speed and connection limits of the user active plan to proxy nodes.
+// Zero values mean no limit.
+type UserPlanLimitsChanged struct {
+	UserId            int
+	PlanId            int
+	MaxBytesPerSecond int64
+	MaxConnections    int
+	Timestamp         time.Time
+}
+
+func NewUserPlanLimitsChanged(userId, planId int, maxBytesPerSecond int64, maxConnections int) UserPlanLimitsChanged {
+	return UserPlanLimitsChanged{
+		UserId:            userId,
+		PlanId:            planId,
+		MaxBytesPerSecond: maxBytesPerSecond,
+		MaxConnections:    maxConnections,
+		Timestamp:         time.Now().UTC(),
+	}
+}
@@ -0,0 +1,285 @@
+package valueobjects
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type EgressPolicyKind int
+
+const (
+	// EgressDefault means no policy was requested, the plan or proxy default applies.
+	EgressDefault EgressPolicyKind = iota
+	// EgressRotateEvery keeps the egress IP of the user for an interval.
+	EgressRotateEvery
+	// EgressRotatePerRequest picks a new egress IP for every connection.
+	EgressRotatePerRequest
+	// EgressSticky keeps the egress IP of a session for as long as the session is used.
+	EgressSticky
+	// EgressPinned always uses the given egress IP.
+	EgressPinned
+)
+
+// IPFamily restricts egress IPs to an address family.
+type IPFamily int
+
+const (
+	// IPFamilyDefault means no family was requested, IPv4 is used if the node has IPv4 egress addresses.
+	IPFamilyDefault IPFamily = iota
+	IPFamilyV4
+	IPFamilyV6
+	// IPFamilyDual connects over IPv6 or IPv4, whichever is faster.
+	IPFamilyDual
+)
+
+const (
+	maxEgressSessionLength = 64
+	maxEgressSessionTTL    = 24 * time.Hour
+)
+
+// EgressPolicy decides which egress IP is used for the user connections.
+// Policies are written as "rotate", "rotate-<minutes>", "sticky", "session-<id>" or "ip-<address>",
+// optionally combined with an address family "v4", "v6" or "dual", e.g. "session-abc123-v6".
+// IPv6 addresses may be written with dashes instead of colons, e.g. "ip-2001-db8--7", as usernames
+// of Basic credentials can not contain colons.
+type EgressPolicy struct {
+	kind     EgressPolicyKind
+	family   IPFamily
+	interval time.Duration
+	session  string
+	// sessionTTL is 0 unless the client asked to keep the session IP for other than the configured time
+	sessionTTL time.Duration
+	ip         net.IP
+}
+
+func NewRotateEveryEgressPolicy(interval time.Duration) (EgressPolicy, error) {
+	if interval <= 0 {
+		return EgressPolicy{}, fmt.Errorf("rotation interval must be positive")
+	}
+
+	return EgressPolicy{kind: EgressRotateEvery, interval: interval}, nil
+}
+
+func NewRotatePerRequestEgressPolicy() EgressPolicy {
+	return EgressPolicy{kind: EgressRotatePerRequest}
+}
+
+// NewStickyEgressPolicy creates a sticky policy for the session. An empty session makes the whole user sticky.
+func NewStickyEgressPolicy(session string) (EgressPolicy, error) {
+	if len(session) > maxEgressSessionLength {
+		return EgressPolicy{}, fmt.Errorf("session id must not be longer than %d characters", maxEgressSessionLength)
+	}
+
+	for _, v := range session {
+		if v > unicode.MaxASCII || !(unicode.IsLetter(v) || unicode.IsDigit(v) || v == '_') {
+			return EgressPolicy{}, fmt.Errorf("session id must contain only letters, digits and underscores")
+		}
+	}
+
+	return EgressPolicy{kind: EgressSticky, session: session}, nil
+}
+
+func NewPinnedEgressPolicy(ip string) (EgressPolicy, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return EgressPolicy{}, fmt.Errorf("invalid egress ip: %s", ip)
+	}
+
+	family := IPFamilyV6
+	if parsed.To4() != nil {
+		family = IPFamilyV4
+	}
+
+	return EgressPolicy{kind: EgressPinned, family: family, ip: parsed}, nil
+}
+
+// ParseEgressPolicy parses a policy written as "rotate", "rotate-<minutes>", "sticky", "session-<id>" or "ip-<address>",
+// optionally combined with an address family "v4", "v6" or "dual".
+func ParseEgressPolicy(policy string) (EgressPolicy, error) {
+	return parseEgressPolicyTokens(strings.Split(policy, "-"))
+}
+
+func isEgressPolicyToken(token string) bool {
+	switch token {
+	case "rotate", "sticky", "session", "ip", "v4", "v6", "dual":
+		return true
+	}
+	return false
+}
+
+func parseEgressPolicyTokens(tokens []string) (EgressPolicy, error) {
+	var policy EgressPolicy
+
+	for i := 0; i < len(tokens); i++ {
+		var family IPFamily
+		switch tokens[i] {
+		case "v4":
+			family = IPFamilyV4
+		case "v6":
+			family = IPFamilyV6
+		case "dual":
+			family = IPFamilyDual
+		}
+		if family != IPFamilyDefault {
+			if policy.family != IPFamilyDefault && policy.family != family {
+				return EgressPolicy{}, fmt.Errorf("conflicting egress address families")
+			}
+			policy.family = family
+			continue
+		}
+
+		var parsed EgressPolicy
+		var err error
+		switch tokens[i] {
+		case "rotate":
+			if i+1 < len(tokens) && isDigits(tokens[i+1]) {
+				minutes, _ := strconv.Atoi(tokens[i+1])
+				parsed, err = NewRotateEveryEgressPolicy(time.Duration(minutes) * time.Minute)
+				i++
+			} else {
+				parsed = NewRotatePerRequestEgressPolicy()
+			}
+		case "sticky":
+			parsed, err = NewStickyEgressPolicy("")
+		case "session":
+			if i+1 >= len(tokens) || tokens[i+1] == "" {
+				return EgressPolicy{}, fmt.Errorf("egress policy %s requires a value", tokens[i])
+			}
+			parsed, err = NewStickyEgressPolicy(tokens[i+1])
+			i++
+		case "ip":
+			ip, ipTokens := egressIpValue(tokens[i+1:])
+			if ip == "" {
+				return EgressPolicy{}, fmt.Errorf("egress policy %s requires a value", tokens[i])
+			}
+			parsed, err = NewPinnedEgressPolicy(ip)
+			i += ipTokens
+		default:
+			return EgressPolicy{}, fmt.Errorf("unknown egress policy: %s", tokens[i])
+		}
+		if err != nil {
+			return EgressPolicy{}, err
+		}
+
+		if policy.kind != EgressDefault {
+			return EgressPolicy{}, fmt.Errorf("only one egress policy can be set")
+		}
+
+		// pinned ip has its own family, which must match the requested one
+		if parsed.family == IPFamilyDefault {
+			parsed.family = policy.family
+		} else if policy.family != IPFamilyDefault && policy.family != parsed.family {
+			return EgressPolicy{}, fmt.Errorf("conflicting egress address families")
+		}
+		policy = parsed
+	}
+
+	return policy, nil
+}
+
+// egressIpValue returns the address written in the first tokens following "ip" and the number of tokens it takes.
+// IPv6 addresses written with dashes take a token per group, e.g. "2001", "db8", "", "7" is "2001:db8::7".
+func egressIpValue(tokens []string) (string, int) {
+	if len(tokens) == 0 {
+		return "", 0
+	}
+
+	groups := 0
+	for groups < len(tokens) && groups < 9 && isHexGroup(tokens[groups]) {
+		groups++
+	}
+	// the longest run of groups which is an address, so following parameters are left out
+	for n := groups; n >= 2; n-- {
+		ip := strings.Join(tokens[:n], ":")
+		if net.ParseIP(ip) != nil {
+			return ip, n
+		}
+	}
+
+	return tokens[0], 1
+}
+
+// isHexGroup reports whether s can be a group of an IPv6 address, empty groups are parts of "::".
+func isHexGroup(s string) bool {
+	if len(s) > 4 {
+		return false
+	}
+	for _, v := range s {
+		if !(v >= '0' && v <= '9' || v >= 'a' && v <= 'f' || v >= 'A' && v <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, v := range s {
+		if v < '0' || v > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// WithFallback fills the policy and the address family left unset with the ones of fallback.
+func (p EgressPolicy) WithFallback(fallback EgressPolicy) EgressPolicy {
+	result := p
+	if p.kind == EgressDefault {
+		result = fallback
+		result.family = p.family
+	}
+	if result.family == IPFamilyDefault {
+		result.family = fallback.family
+	}
+
+	return result
+}
+
+func (p EgressPolicy) Kind() EgressPolicyKind {
+	return p.kind
+}
+
+func (p EgressPolicy) Interval() time.Duration {
+	return p.interval
+}
+
+func (p EgressPolicy) Session() string {
+	return p.session
+}
+
+// WithSessionTTL makes the sticky policy keep the session IP for ttl after the session was last used.
+func (p EgressPolicy) WithSessionTTL(ttl time.Duration) (EgressPolicy, error) {
+	if p.kind != EgressSticky {
+		return EgressPolicy{}, fmt.Errorf("session ttl requires a sticky egress policy")
+	}
+	if ttl <= 0 || ttl > maxEgressSessionTTL {
+		return EgressPolicy{}, fmt.Errorf("session ttl must be positive and not longer than %v", maxEgressSessionTTL)
+	}
+
+	p.sessionTTL = ttl
+	return p, nil
+}
+
+// SessionTTL returns how long the sticky session keeps its IP, 0 means the configured time.
+func (p EgressPolicy) SessionTTL() time.Duration {
+	return p.sessionTTL
+}
+
+func (p EgressPolicy) IP() net.IP {
+	return p.ip
+}
+
+func (p EgressPolicy) Family() IPFamily {
+	return p.family
+}
+
+func (p EgressPolicy) IsDefault() bool {
+	return p.kind == EgressDefault && p.family == IPFamilyDefault
+}
@@ -0,0 +1,209 @@
+package valueobjects
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseProxyUsername_Egress(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        string
+		wantUsername string
+		wantKind     EgressPolicyKind
+		wantFamily   IPFamily
+		wantInterval time.Duration
+		wantSession  string
+		wantIP       string
+		wantErr      bool
+	}{
+		{
+			name:         "username without policy",
+			input:        "alice",
+			wantUsername: "alice",
+			wantKind:     EgressDefault,
+		},
+		{
+			name:         "username with dashes but without policy",
+			input:        "alice-smith",
+			wantUsername: "alice-smith",
+			wantKind:     EgressDefault,
+		},
+		{
+			name:         "username containing a policy name",
+			input:        "rotated-ipster",
+			wantUsername: "rotated-ipster",
+			wantKind:     EgressDefault,
+		},
+		{
+			name:         "sticky session",
+			input:        "alice-session-abc123",
+			wantUsername: "alice",
+			wantKind:     EgressSticky,
+			wantSession:  "abc123",
+		},
+		{
+			name:         "sticky user",
+			input:        "alice-smith-sticky",
+			wantUsername: "alice-smith",
+			wantKind:     EgressSticky,
+		},
+		{
+			name:         "rotate per request",
+			input:        "alice-rotate",
+			wantUsername: "alice",
+			wantKind:     EgressRotatePerRequest,
+		},
+		{
+			name:         "rotate every 10 minutes",
+			input:        "alice-rotate-10",
+			wantUsername: "alice",
+			wantKind:     EgressRotateEvery,
+			wantInterval: 10 * time.Minute,
+		},
+		{
+			name:         "pinned ip",
+			input:        "alice-ip-203.0.113.7",
+			wantUsername: "alice",
+			wantKind:     EgressPinned,
+			wantFamily:   IPFamilyV4,
+			wantIP:       "203.0.113.7",
+		},
+		{
+			name:         "pinned ipv6",
+			input:        "alice-ip-2001:db8::7",
+			wantUsername: "alice",
+			wantKind:     EgressPinned,
+			wantFamily:   IPFamilyV6,
+			wantIP:       "2001:db8::7",
+		},
+		{
+			name:         "pinned ipv6 with dashes",
+			input:        "alice-ip-2001-db8--7",
+			wantUsername: "alice",
+			wantKind:     EgressPinned,
+			wantFamily:   IPFamilyV6,
+			wantIP:       "2001:db8::7",
+		},
+		{
+			name:         "pinned ipv6 with dashes followed by family",
+			input:        "alice-ip---1-v6",
+			wantUsername: "alice",
+			wantKind:     EgressPinned,
+			wantFamily:   IPFamilyV6,
+			wantIP:       "::1",
+		},
+		{
+			name:         "sticky session over ipv6",
+			input:        "alice-session-abc123-v6",
+			wantUsername: "alice",
+			wantKind:     EgressSticky,
+			wantFamily:   IPFamilyV6,
+			wantSession:  "abc123",
+		},
+		{
+			name:         "dual-stack only",
+			input:        "alice-dual",
+			wantUsername: "alice",
+			wantKind:     EgressDefault,
+			wantFamily:   IPFamilyDual,
+		},
+		{
+			name:         "family before rotation",
+			input:        "alice-v4-rotate-5",
+			wantUsername: "alice",
+			wantKind:     EgressRotateEvery,
+			wantFamily:   IPFamilyV4,
+			wantInterval: 5 * time.Minute,
+		},
+		{
+			name:    "pinned ip of another family",
+			input:   "alice-ip-203.0.113.7-v6",
+			wantErr: true,
+		},
+		{
+			name:    "two policies",
+			input:   "alice-rotate-sticky",
+			wantErr: true,
+		},
+		{
+			name:    "session without id",
+			input:   "alice-session",
+			wantErr: true,
+		},
+		{
+			name:    "invalid rotation interval",
+			input:   "alice-rotate-soon",
+			wantErr: true,
+		},
+		{
+			name:    "zero rotation interval",
+			input:   "alice-rotate-0",
+			wantErr: true,
+		},
+		{
+			name:    "invalid session id",
+			input:   "alice-session-a.b",
+			wantErr: true,
+		},
+		{
+			name:    "invalid ip",
+			input:   "alice-ip-localhost",
+			wantErr: true,
+		},
+		{
+			name:    "ipv6 with dashes of too many groups",
+			input:   "alice-ip-1-2-3-4-5-6-7-8-9",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			username, options, err := ParseProxyUsername(tt.input)
+			policy := options.Egress()
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error = %v, got error = %v", tt.wantErr, err)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if username != tt.wantUsername {
+				t.Errorf("expected username = %q, got username = %q", tt.wantUsername, username)
+			}
+			if policy.Kind() != tt.wantKind {
+				t.Errorf("expected kind = %v, got kind = %v", tt.wantKind, policy.Kind())
+			}
+			if policy.Family() != tt.wantFamily {
+				t.Errorf("expected family = %v, got family = %v", tt.wantFamily, policy.Family())
+			}
+			if policy.Interval() != tt.wantInterval {
+				t.Errorf("expected interval = %v, got interval = %v", tt.wantInterval, policy.Interval())
+			}
+			if policy.Session() != tt.wantSession {
+				t.Errorf("expected session = %q, got session = %q", tt.wantSession, policy.Session())
+			}
+			if tt.wantIP != "" && policy.IP().String() != tt.wantIP {
+				t.Errorf("expected ip = %q, got ip = %v", tt.wantIP, policy.IP())
+			}
+		})
+	}
+}
+
+func TestEgressPolicy_WithFallback(t *testing.T) {
+	planPolicy, _ := ParseEgressPolicy("sticky-dual")
+
+	requested, _ := ParseEgressPolicy("v6")
+	merged := requested.WithFallback(planPolicy)
+	if merged.Kind() != EgressSticky || merged.Family() != IPFamilyV6 {
+		t.Errorf("expected sticky v6 policy, got kind = %v, family = %v", merged.Kind(), merged.Family())
+	}
+
+	requested, _ = ParseEgressPolicy("rotate")
+	merged = requested.WithFallback(planPolicy)
+	if merged.Kind() != EgressRotatePerRequest || merged.Family() != IPFamilyDual {
+		t.Errorf("expected rotating dual-stack policy, got kind = %v, family = %v", merged.Kind(), merged.Family())
+	}
+}
@@ -0,0 +1,26 @@
+package valueobjects
+
+import "fmt"
+
+// PlanConnectionsLimit is a maximum number of concurrent connections, 0 means no limit.
+type PlanConnectionsLimit struct {
+	value int
+}
+
+func PlanConnectionsLimitFromInt(val int) (PlanConnectionsLimit, error) {
+	if val < 0 {
+		return PlanConnectionsLimit{}, fmt.Errorf("connections limit can not be less than 0")
+	}
+
+	return PlanConnectionsLimit{
+		value: val,
+	}, nil
+}
+
+func (p *PlanConnectionsLimit) Value() int {
+	return p.value
+}
+
+func (p *PlanConnectionsLimit) IsLimited() bool {
+	return p.value != 0
+}
@@ -0,0 +1,26 @@
+package valueobjects
+
+import "fmt"
+
+// PlanSpeedLimit is a maximum speed in bytes per second, 0 means no limit.
+type PlanSpeedLimit struct {
+	bytesPerSecond int64
+}
+
+func PlanSpeedLimitFromInt64(bytesPerSecond int64) (PlanSpeedLimit, error) {
+	if bytesPerSecond < 0 {
+		return PlanSpeedLimit{}, fmt.Errorf("speed limit can not be less than 0")
+	}
+
+	return PlanSpeedLimit{
+		bytesPerSecond: bytesPerSecond,
+	}, nil
+}
+
+func (p *PlanSpeedLimit) BytesPerSecond() int64 {
+	return p.bytesPerSecond
+}
+
+func (p *PlanSpeedLimit) IsLimited() bool {
+	return p.bytesPerSecond != 0
+}
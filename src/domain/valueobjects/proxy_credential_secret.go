@@ -0,0 +1,59 @@
+package valueobjects
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// proxyCredentialSecretPrefix is reserved for credential secrets: passwords starting with it are only validated
+// as secrets, never as account passwords.
+const proxyCredentialSecretPrefix = "pxc."
+
+// ProxyCredentialSecret is the proxy password of a proxy credential, written as "pxc.<credential id>.<key>".
+// The credential id lets the credential be found without validating the key against every credential of the user.
+type ProxyCredentialSecret struct {
+	CredentialId int
+	Key          string
+}
+
+func NewProxyCredentialSecret(credentialId int, key string) (ProxyCredentialSecret, error) {
+	if credentialId <= 0 {
+		return ProxyCredentialSecret{}, fmt.Errorf("credential id must be positive")
+	}
+
+	if len(key) < 8 {
+		return ProxyCredentialSecret{}, fmt.Errorf("credential key must be at least 8 characters")
+	}
+
+	return ProxyCredentialSecret{CredentialId: credentialId, Key: key}, nil
+}
+
+// ParseProxyCredentialSecret parses the password as a credential secret, false is returned if it is not one.
+func ParseProxyCredentialSecret(password string) (ProxyCredentialSecret, bool) {
+	rest, found := strings.CutPrefix(password, proxyCredentialSecretPrefix)
+	if !found {
+		return ProxyCredentialSecret{}, false
+	}
+
+	idStr, key, found := strings.Cut(rest, ".")
+	if !found {
+		return ProxyCredentialSecret{}, false
+	}
+
+	credentialId, err := strconv.Atoi(idStr)
+	if err != nil {
+		return ProxyCredentialSecret{}, false
+	}
+
+	secret, err := NewProxyCredentialSecret(credentialId, key)
+	if err != nil {
+		return ProxyCredentialSecret{}, false
+	}
+
+	return secret, true
+}
+
+func (s ProxyCredentialSecret) String() string {
+	return fmt.Sprintf("%s%d.%s", proxyCredentialSecretPrefix, s.CredentialId, s.Key)
+}
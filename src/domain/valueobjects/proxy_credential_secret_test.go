@@ -0,0 +1,40 @@
+package valueobjects
+
+import "testing"
+
+func TestParseProxyCredentialSecret(t *testing.T) {
+	tests := []struct {
+		name             string
+		input            string
+		wantOk           bool
+		wantCredentialId int
+		wantKey          string
+	}{
+		{name: "credential secret", input: "pxc.42.k3y-With_Chars", wantOk: true, wantCredentialId: 42, wantKey: "k3y-With_Chars"},
+		{name: "key containing dots", input: "pxc.7.abcd.efgh", wantOk: true, wantCredentialId: 7, wantKey: "abcd.efgh"},
+		{name: "account password", input: "s3cr3t-password"},
+		{name: "missing key", input: "pxc.42"},
+		{name: "short key", input: "pxc.42.abc"},
+		{name: "invalid credential id", input: "pxc.abc.abcdefgh"},
+		{name: "zero credential id", input: "pxc.0.abcdefgh"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret, ok := ParseProxyCredentialSecret(tt.input)
+			if ok != tt.wantOk {
+				t.Fatalf("ParseProxyCredentialSecret() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if !ok {
+				return
+			}
+
+			if secret.CredentialId != tt.wantCredentialId || secret.Key != tt.wantKey {
+				t.Errorf("ParseProxyCredentialSecret() = %+v, want id %d and key %q", secret, tt.wantCredentialId, tt.wantKey)
+			}
+			if secret.String() != tt.input {
+				t.Errorf("String() = %q, want %q", secret.String(), tt.input)
+			}
+		})
+	}
+}
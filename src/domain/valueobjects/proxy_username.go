@@ -0,0 +1,232 @@
+package valueobjects
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// bytesPerMbps converts speeds requested in megabits per second to bytes per second.
+	bytesPerMbps           = 125_000
+	maxConnectionSpeedMbps = 100_000
+)
+
+// InvalidProxyUsernameError means the username carries parameters which are unknown or invalid.
+// Reason is safe to show to the client.
+type InvalidProxyUsernameError struct {
+	Reason string
+}
+
+func (e InvalidProxyUsernameError) Error() string {
+	return "invalid proxy username parameters: " + e.Reason
+}
+
+// ConnectionOptions are options of a single proxy connection requested by parameters of the proxy username.
+// Zero options leave the connection to the user plan and the proxy defaults.
+type ConnectionOptions struct {
+	egress EgressPolicy
+	// country is a lowercase ISO 3166-1 alpha-2 code, empty if the client did not ask for one
+	country string
+	// speedLimit is the connection speed in bytes per second, 0 means the connection is not slowed down below the plan
+	speedLimit int64
+}
+
+// NewConnectionOptions creates options of a connection leaving through egress IPs chosen by the policy.
+func NewConnectionOptions(egress EgressPolicy) ConnectionOptions {
+	return ConnectionOptions{egress: egress}
+}
+
+// WithCountry makes the connection leave from the country with the ISO 3166-1 alpha-2 code.
+func (o ConnectionOptions) WithCountry(country string) (ConnectionOptions, error) {
+	if len(country) != 2 || !isLetters(country) {
+		return ConnectionOptions{}, fmt.Errorf("country must be a two-letter ISO 3166-1 code")
+	}
+
+	o.country = strings.ToLower(country)
+	return o, nil
+}
+
+// WithSpeedLimit limits the speed of the connection to bytesPerSecond in both directions.
+func (o ConnectionOptions) WithSpeedLimit(bytesPerSecond int64) (ConnectionOptions, error) {
+	if bytesPerSecond <= 0 {
+		return ConnectionOptions{}, fmt.Errorf("speed must be positive")
+	}
+
+	o.speedLimit = bytesPerSecond
+	return o, nil
+}
+
+func (o ConnectionOptions) Egress() EgressPolicy {
+	return o.egress
+}
+
+func (o ConnectionOptions) Country() string {
+	return o.country
+}
+
+func (o ConnectionOptions) SpeedLimit() int64 {
+	return o.speedLimit
+}
+
+// ParseProxyUsername separates parameters from the proxy username. Parameters start at the first dash-separated
+// part of the username which is a known parameter name, everything after it must be parameters:
+//   - egress policies "rotate", "rotate-<minutes>", "sticky", "session-<id>", "ip-<address>"
+//     (IPv6 addresses with dashes instead of colons) and address families "v4", "v6", "dual", see EgressPolicy
+//   - "ttl-<minutes>" keeps the IP of a sticky session for the minutes after it was last used
+//   - "country-<code>" leaves from the country with the ISO 3166-1 alpha-2 code
+//   - "speed-<mbps>" limits the connection speed in megabits per second, plan limits still apply
+//
+// E.g. "alice-session-abc123-ttl-10-country-de" is the user "alice" with the sticky session "abc123"
+// kept for 10 minutes, leaving from Germany. Usernames without parameters have zero options.
+// Usernames may contain parameter names themselves, see ProxyUsernamePrefixes and ParseProxyUsernameOf.
+func ParseProxyUsername(username string) (string, ConnectionOptions, error) {
+	prefixes := ProxyUsernamePrefixes(username)
+	if len(prefixes) == 0 {
+		return username, ConnectionOptions{}, nil
+	}
+
+	user := prefixes[len(prefixes)-1]
+	options, err := ParseProxyUsernameOf(username, user)
+	if err != nil {
+		return username, ConnectionOptions{}, err
+	}
+	return user, options, nil
+}
+
+// ProxyUsernamePrefixes returns the usernames the proxy username may consist of along with parameters,
+// the longest first. E.g. "tom-speed-country-de" is either the user "tom-speed" leaving from Germany
+// or the user "tom" with invalid parameters, while the username itself may be taken as well.
+func ProxyUsernamePrefixes(username string) []string {
+	parts := strings.Split(username, "-")
+	var prefixes []string
+	for i := len(parts) - 1; i > 0; i-- {
+		if isProxyUsernameParameter(parts[i]) {
+			prefixes = append(prefixes, strings.Join(parts[:i], "-"))
+		}
+	}
+	return prefixes
+}
+
+// ParseProxyUsernameOf parses the parameters following the username of the user in the proxy username.
+func ParseProxyUsernameOf(username, user string) (ConnectionOptions, error) {
+	parameters, found := strings.CutPrefix(username, user+"-")
+	if !found {
+		return ConnectionOptions{}, InvalidProxyUsernameError{Reason: "username does not start with the user"}
+	}
+
+	options, err := parseProxyUsernameParameters(strings.Split(parameters, "-"))
+	if err != nil {
+		// reasons may quote the username, which must not break the response they are sent in
+		return ConnectionOptions{}, InvalidProxyUsernameError{Reason: strings.Map(printableASCII, err.Error())}
+	}
+	return options, nil
+}
+
+func isProxyUsernameParameter(token string) bool {
+	switch token {
+	case "ttl", "country", "speed":
+		return true
+	}
+	return isEgressPolicyToken(token)
+}
+
+func parseProxyUsernameParameters(tokens []string) (ConnectionOptions, error) {
+	var options ConnectionOptions
+	var egressTokens []string
+	var sessionTTL time.Duration
+	seen := make(map[string]bool)
+
+	for i := 0; i < len(tokens); i++ {
+		name := tokens[i]
+		switch name {
+		case "rotate":
+			egressTokens = append(egressTokens, name)
+			if i+1 < len(tokens) && isDigits(tokens[i+1]) {
+				egressTokens = append(egressTokens, tokens[i+1])
+				i++
+			}
+			continue
+		case "sticky", "v4", "v6", "dual":
+			egressTokens = append(egressTokens, name)
+			continue
+		case "ip":
+			ip, ipTokens := egressIpValue(tokens[i+1:])
+			if ip == "" {
+				return ConnectionOptions{}, fmt.Errorf("parameter %s requires a value", name)
+			}
+			egressTokens = append(egressTokens, name, ip)
+			i += ipTokens
+			continue
+		}
+
+		if !isProxyUsernameParameter(name) {
+			return ConnectionOptions{}, fmt.Errorf("unknown parameter %q", name)
+		}
+		if i+1 >= len(tokens) || tokens[i+1] == "" {
+			return ConnectionOptions{}, fmt.Errorf("parameter %s requires a value", name)
+		}
+		value := tokens[i+1]
+		i++
+
+		if name == "session" {
+			egressTokens = append(egressTokens, name, value)
+			continue
+		}
+		if seen[name] {
+			return ConnectionOptions{}, fmt.Errorf("parameter %s can only be set once", name)
+		}
+		seen[name] = true
+
+		var err error
+		switch name {
+		case "ttl":
+			minutes, parseErr := strconv.Atoi(value)
+			if parseErr != nil || !isDigits(value) || minutes > int(maxEgressSessionTTL/time.Minute) {
+				return ConnectionOptions{}, fmt.Errorf("ttl must be a number of minutes up to %d", int(maxEgressSessionTTL/time.Minute))
+			}
+			sessionTTL = time.Duration(minutes) * time.Minute
+		case "country":
+			options, err = options.WithCountry(value)
+		case "speed":
+			mbps, parseErr := strconv.Atoi(value)
+			if parseErr != nil || !isDigits(value) || mbps > maxConnectionSpeedMbps {
+				return ConnectionOptions{}, fmt.Errorf("speed must be a number of megabits per second up to %d", maxConnectionSpeedMbps)
+			}
+			options, err = options.WithSpeedLimit(int64(mbps) * bytesPerMbps)
+		}
+		if err != nil {
+			return ConnectionOptions{}, err
+		}
+	}
+
+	egress, err := parseEgressPolicyTokens(egressTokens)
+	if err != nil {
+		return ConnectionOptions{}, err
+	}
+	if seen["ttl"] {
+		if egress, err = egress.WithSessionTTL(sessionTTL); err != nil {
+			return ConnectionOptions{}, err
+		}
+	}
+	options.egress = egress
+
+	return options, nil
+}
+
+func printableASCII(r rune) rune {
+	if r < ' ' || r > '~' {
+		return -1
+	}
+	return r
+}
+
+func isLetters(s string) bool {
+	for _, v := range s {
+		if !(v >= 'a' && v <= 'z' || v >= 'A' && v <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,178 @@
+package valueobjects
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseProxyUsername(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          string
+		wantUsername   string
+		wantKind       EgressPolicyKind
+		wantSession    string
+		wantSessionTTL time.Duration
+		wantCountry    string
+		wantSpeedLimit int64
+		wantErr        bool
+	}{
+		{
+			name:         "username containing a parameter name",
+			input:        "speedy-countryman",
+			wantUsername: "speedy-countryman",
+		},
+		{
+			name:           "session with ttl",
+			input:          "alice-session-xyz-ttl-10",
+			wantUsername:   "alice",
+			wantKind:       EgressSticky,
+			wantSession:    "xyz",
+			wantSessionTTL: 10 * time.Minute,
+		},
+		{
+			name:         "country",
+			input:        "alice-smith-country-DE",
+			wantUsername: "alice-smith",
+			wantCountry:  "de",
+		},
+		{
+			name:           "speed",
+			input:          "alice-speed-8",
+			wantUsername:   "alice",
+			wantSpeedLimit: 1_000_000,
+		},
+		{
+			name:           "all parameters in any order",
+			input:          "alice-speed-1-country-us-ttl-5-sticky",
+			wantUsername:   "alice",
+			wantKind:       EgressSticky,
+			wantSessionTTL: 5 * time.Minute,
+			wantCountry:    "us",
+			wantSpeedLimit: 125_000,
+		},
+		{
+			name:         "ipv6 with dashes followed by parameters",
+			input:        "alice-ip-2001-db8--7-country-de",
+			wantUsername: "alice",
+			wantKind:     EgressPinned,
+			wantCountry:  "de",
+		},
+		{
+			name:    "unknown parameter",
+			input:   "alice-session-xyz-region-eu",
+			wantErr: true,
+		},
+		{
+			name:    "ttl without sticky session",
+			input:   "alice-rotate-ttl-10",
+			wantErr: true,
+		},
+		{
+			name:    "zero ttl",
+			input:   "alice-sticky-ttl-0",
+			wantErr: true,
+		},
+		{
+			name:    "ttl longer than a day",
+			input:   "alice-sticky-ttl-1441",
+			wantErr: true,
+		},
+		{
+			name:    "negative speed",
+			input:   "alice-speed--1",
+			wantErr: true,
+		},
+		{
+			name:    "invalid country",
+			input:   "alice-country-deu",
+			wantErr: true,
+		},
+		{
+			name:    "repeated parameter",
+			input:   "alice-country-de-country-fr",
+			wantErr: true,
+		},
+		{
+			name:    "parameter without value",
+			input:   "alice-country",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			username, options, err := ParseProxyUsername(tt.input)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error = %v, got error = %v", tt.wantErr, err)
+			}
+			if tt.wantErr {
+				var usernameErr InvalidProxyUsernameError
+				if !errors.As(err, &usernameErr) || usernameErr.Reason == "" {
+					t.Errorf("expected InvalidProxyUsernameError with a reason, got %v", err)
+				}
+				return
+			}
+
+			if username != tt.wantUsername {
+				t.Errorf("expected username = %q, got username = %q", tt.wantUsername, username)
+			}
+			if options.Egress().Kind() != tt.wantKind {
+				t.Errorf("expected kind = %v, got kind = %v", tt.wantKind, options.Egress().Kind())
+			}
+			if options.Egress().Session() != tt.wantSession {
+				t.Errorf("expected session = %q, got session = %q", tt.wantSession, options.Egress().Session())
+			}
+			if options.Egress().SessionTTL() != tt.wantSessionTTL {
+				t.Errorf("expected session ttl = %v, got session ttl = %v", tt.wantSessionTTL, options.Egress().SessionTTL())
+			}
+			if options.Country() != tt.wantCountry {
+				t.Errorf("expected country = %q, got country = %q", tt.wantCountry, options.Country())
+			}
+			if options.SpeedLimit() != tt.wantSpeedLimit {
+				t.Errorf("expected speed limit = %d, got speed limit = %d", tt.wantSpeedLimit, options.SpeedLimit())
+			}
+		})
+	}
+}
+
+func TestProxyUsernamePrefixes(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{input: "alice", want: nil},
+		{input: "speedy-countryman", want: nil},
+		{input: "alice-country-de", want: []string{"alice"}},
+		{input: "tom-speed", want: []string{"tom"}},
+		{input: "tom-speed-country-de", want: []string{"tom-speed", "tom"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := ProxyUsernamePrefixes(tt.input); !slices.Equal(got, tt.want) {
+				t.Errorf("expected prefixes = %q, got prefixes = %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseProxyUsernameOf(t *testing.T) {
+	options, err := ParseProxyUsernameOf("tom-speed-country-de", "tom-speed")
+	if err != nil {
+		t.Fatalf("expected parameters of the user to be parsed, got error = %v", err)
+	}
+	if options.Country() != "de" {
+		t.Errorf("expected country = %q, got country = %q", "de", options.Country())
+	}
+
+	if _, err = ParseProxyUsernameOf("tom-speed-country-de", "tom"); err == nil {
+		t.Errorf("expected the parameters following the user %q to be invalid", "tom")
+	}
+	if _, err = ParseProxyUsernameOf("tom-speed-country-de", "ann"); err == nil {
+		t.Errorf("expected an error for a username of another user")
+	}
+}
@@ -33,27 +33,35 @@ type authData struct {
 }
 
 type GoogleAuthService struct {
-	userUseCases        use_cases.UserUseCases
-	cryptoService       contracts.CryptoService
-	cookieBuilder       Cookie.CookieBuilder
-	cache               contracts.CacheWithTTL[authData]
-	oauthConfigProvider config.GoogleOauthConfigProvider
-	messageBus          contracts.MessageBusService
+	userUseCases              use_cases.UserUseCases
+	proxyCredentialUseCases   use_cases.ProxyCredentialUseCases
+	authorizedNetworkUseCases use_cases.AuthorizedNetworkUseCases
+	cryptoService             contracts.CryptoService
+	cookieBuilder             Cookie.CookieBuilder
+	cache                     contracts.CacheWithTTL[authData]
+	oauthConfigProvider       config.GoogleOauthConfigProvider
+	messageBus                contracts.MessageBusService
+	// proxyTokens is nil unless Bearer authentication of the proxy is enabled
+	proxyTokens *proxyTokens
 }
 
-func NewGoogleAuthService(userUseCases use_cases.UserUseCases, cryptoService contracts.CryptoService, messageBus contracts.MessageBusService) *GoogleAuthService {
+func NewGoogleAuthService(userUseCases use_cases.UserUseCases, proxyCredentialUseCases use_cases.ProxyCredentialUseCases,
+	authorizedNetworkUseCases use_cases.AuthorizedNetworkUseCases, cryptoService contracts.CryptoService,
+	messageBus contracts.MessageBusService) *GoogleAuthService {
 	cache, cacheErr := services.NewRedisCache[authData]()
 	if cacheErr != nil {
 		log.Fatalf("failed to create cache instance: %s", cacheErr)
 	}
 
 	return &GoogleAuthService{
-		userUseCases:        userUseCases,
-		cryptoService:       cryptoService,
-		cookieBuilder:       Cookie.NewCookieBuilder(),
-		cache:               cache,
-		oauthConfigProvider: config.NewGoogleOauthConfig(),
-		messageBus:          messageBus,
+		userUseCases:              userUseCases,
+		proxyCredentialUseCases:   proxyCredentialUseCases,
+		authorizedNetworkUseCases: authorizedNetworkUseCases,
+		cryptoService:             cryptoService,
+		cookieBuilder:             Cookie.NewCookieBuilder(),
+		cache:                     cache,
+		oauthConfigProvider:       config.NewGoogleOauthConfig(),
+		messageBus:                messageBus,
 	}
 }
 
@@ -375,6 +383,10 @@ func (g *GoogleAuthService) ResetPassword(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if digestErr := g.userUseCases.SaveDigestCredential(user.Id(), user.Username(), password.Value); digestErr != nil {
+		log.Printf("failed to save digest credential of user %d: %s", user.Id(), digestErr)
+	}
+
 	g.ProduceUserChangePasswordEvent(user.Username())
 
 	updatedBasicCredentials := BasicCredentials{
@@ -0,0 +1,147 @@
+package google_auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goproxy/application/aplication_errors"
+	"goproxy/domain"
+	"goproxy/domain/events"
+	"log"
+	"net/http"
+	"time"
+)
+
+type postAuthorizedNetworkRequest struct {
+	Network string `json:"network"`
+}
+
+type deleteAuthorizedNetworkRequest struct {
+	Id int `json:"id"`
+}
+
+type authorizedNetworkResponse struct {
+	Id        int       `json:"id"`
+	Network   string    `json:"network"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuthorizedNetworks lists networks whose clients use the proxy as the user without credentials on GET
+// and authorizes a new one on POST. Networks overlapping networks of other users are refused with 409 Conflict.
+func (g *GoogleAuthService) AuthorizedNetworks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		g.listAuthorizedNetworks(w, r)
+	case http.MethodPost:
+		g.createAuthorizedNetwork(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (g *GoogleAuthService) listAuthorizedNetworks(w http.ResponseWriter, r *http.Request) {
+	user, ok := g.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	networks, err := g.authorizedNetworkUseCases.GetAllByUserId(user.Id())
+	if err != nil {
+		log.Printf("failed to list authorized networks: %s", err)
+		http.Error(w, "failed to list authorized networks", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]authorizedNetworkResponse, 0, len(networks))
+	for _, network := range networks {
+		response = append(response, authorizedNetworkResponse{
+			Id:        network.Id(),
+			Network:   network.Network(),
+			CreatedAt: network.CreatedAt(),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func (g *GoogleAuthService) createAuthorizedNetwork(w http.ResponseWriter, r *http.Request) {
+	user, ok := g.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	var request postAuthorizedNetworkRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	network, err := g.authorizedNetworkUseCases.Create(user.Id(), request.Network)
+	if err != nil {
+		log.Printf("failed to authorize network %s: %s", request.Network, err)
+		status := http.StatusBadRequest
+		if errors.As(err, &aplication_errors.ErrAuthorizedNetworkConflict{}) {
+			status = http.StatusConflict
+		}
+		http.Error(w, fmt.Sprintf("failed to authorize network: %s", err), status)
+		return
+	}
+
+	g.ProduceAuthorizedNetworksChangedEvent(user.Id())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(authorizedNetworkResponse{
+		Id:        network.Id(),
+		Network:   network.Network(),
+		CreatedAt: network.CreatedAt(),
+	})
+}
+
+// DeleteAuthorizedNetwork removes an authorized network of the user, its clients need credentials again.
+func (g *GoogleAuthService) DeleteAuthorizedNetwork(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := g.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	var request deleteAuthorizedNetworkRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := g.authorizedNetworkUseCases.Delete(user.Id(), request.Id); err != nil {
+		log.Printf("failed to delete authorized network %d: %s", request.Id, err)
+		http.Error(w, "failed to delete authorized network", http.StatusNotFound)
+		return
+	}
+
+	g.ProduceAuthorizedNetworksChangedEvent(user.Id())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *GoogleAuthService) ProduceAuthorizedNetworksChangedEvent(userId int) {
+	serializedEvent, serializationErr := json.Marshal(events.AuthorizedNetworksChangedEvent{UserId: userId})
+	if serializationErr != nil {
+		log.Printf("failed to produce authorized networks changed event - failed to serialize event: %s", serializationErr)
+		return
+	}
+
+	outboxEvent, outboxEventErr := events.NewOutboxEvent(-1, string(serializedEvent), false, "AuthorizedNetworksChangedEvent")
+	if outboxEventErr != nil {
+		log.Printf("failed to produce authorized networks changed event - failed to create outbox event: %s", outboxEventErr)
+		return
+	}
+
+	produceErr := g.messageBus.Produce(fmt.Sprintf("%s", domain.PROXY), outboxEvent)
+	if produceErr != nil {
+		log.Printf("failed to produce authorized networks changed event: %s", produceErr)
+	}
+}
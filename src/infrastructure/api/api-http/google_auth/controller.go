@@ -28,6 +28,11 @@ func (g *GoogleAuthController) Listen(port int) {
 	mux.HandleFunc("/auth/callback", g.authService.handleGoogleCallback)
 	mux.HandleFunc("/auth/user-info", g.authService.GetUserInfo)
 	mux.HandleFunc("/auth/reset-password", g.authService.ResetPassword)
+	mux.HandleFunc("/auth/proxy-credentials", g.authService.ProxyCredentials)
+	mux.HandleFunc("/auth/proxy-credentials/revoke", g.authService.RevokeProxyCredential)
+	mux.HandleFunc("/auth/authorized-networks", g.authService.AuthorizedNetworks)
+	mux.HandleFunc("/auth/authorized-networks/delete", g.authService.DeleteAuthorizedNetwork)
+	mux.HandleFunc("/auth/proxy-token", g.authService.ProxyToken)
 
 	corsHandler := g.corsManager.AddCORS(mux)
 
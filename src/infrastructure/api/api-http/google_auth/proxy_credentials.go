@@ -0,0 +1,215 @@
+package google_auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"goproxy/application/commands"
+	"goproxy/domain"
+	"goproxy/domain/aggregates"
+	"goproxy/domain/events"
+	"log"
+	"net/http"
+	"time"
+)
+
+type postProxyCredentialRequest struct {
+	Name              string    `json:"name"`
+	ExpiresAt         time.Time `json:"expires_at"`
+	AllowedNetworks   []string  `json:"allowed_networks"`
+	TrafficQuotaBytes int64     `json:"traffic_quota_bytes"`
+}
+
+type revokeProxyCredentialRequest struct {
+	Id int `json:"id"`
+}
+
+type proxyCredentialResponse struct {
+	Id                int        `json:"id"`
+	Name              string     `json:"name"`
+	ExpiresAt         *time.Time `json:"expires_at"`
+	AllowedNetworks   []string   `json:"allowed_networks"`
+	TrafficQuotaBytes int64      `json:"traffic_quota_bytes"`
+	UsedBytes         int64      `json:"used_bytes"`
+	RevokedAt         *time.Time `json:"revoked_at"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+type createdProxyCredentialResponse struct {
+	Id       int    `json:"id"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// ProxyCredentials lists the proxy credentials of the user on GET and mints a new one on POST.
+// The password of a minted credential is only returned once.
+func (g *GoogleAuthService) ProxyCredentials(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		g.listProxyCredentials(w, r)
+	case http.MethodPost:
+		g.createProxyCredential(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (g *GoogleAuthService) listProxyCredentials(w http.ResponseWriter, r *http.Request) {
+	user, ok := g.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	credentials, err := g.proxyCredentialUseCases.GetAllByUserId(user.Id())
+	if err != nil {
+		log.Printf("failed to list proxy credentials: %s", err)
+		http.Error(w, "failed to list proxy credentials", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]proxyCredentialResponse, 0, len(credentials))
+	for _, credential := range credentials {
+		response = append(response, proxyCredentialResponse{
+			Id:                credential.Id(),
+			Name:              credential.Name(),
+			ExpiresAt:         optionalTime(credential.ExpiresAt()),
+			AllowedNetworks:   credential.AllowedNetworks(),
+			TrafficQuotaBytes: credential.TrafficQuotaBytes(),
+			UsedBytes:         credential.UsedBytes(),
+			RevokedAt:         optionalTime(credential.RevokedAt()),
+			CreatedAt:         credential.CreatedAt(),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func (g *GoogleAuthService) createProxyCredential(w http.ResponseWriter, r *http.Request) {
+	user, ok := g.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	var request postProxyCredentialRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !request.ExpiresAt.IsZero() && !request.ExpiresAt.After(time.Now()) {
+		http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	secret, err := g.proxyCredentialUseCases.Create(commands.PostProxyCredential{
+		UserId:            user.Id(),
+		Name:              request.Name,
+		ExpiresAt:         request.ExpiresAt.UTC(),
+		AllowedNetworks:   request.AllowedNetworks,
+		TrafficQuotaBytes: request.TrafficQuotaBytes,
+	})
+	if err != nil {
+		log.Printf("failed to create proxy credential: %s", err)
+		http.Error(w, fmt.Sprintf("failed to create proxy credential: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(createdProxyCredentialResponse{
+		Id:       secret.CredentialId,
+		Username: user.Username(),
+		Password: secret.String(),
+	})
+}
+
+// RevokeProxyCredential revokes a proxy credential of the user and tells proxy nodes to stop accepting it.
+func (g *GoogleAuthService) RevokeProxyCredential(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := g.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	var request revokeProxyCredentialRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := g.proxyCredentialUseCases.Revoke(user.Id(), request.Id); err != nil {
+		log.Printf("failed to revoke proxy credential %d: %s", request.Id, err)
+		http.Error(w, "failed to revoke proxy credential", http.StatusNotFound)
+		return
+	}
+
+	g.ProduceProxyCredentialChangedEvent(request.Id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *GoogleAuthService) ProduceProxyCredentialChangedEvent(credentialId int) {
+	serializedEvent, serializationErr := json.Marshal(events.ProxyCredentialChangedEvent{CredentialId: credentialId})
+	if serializationErr != nil {
+		log.Printf("failed to produce proxy credential changed event - failed to serialize event: %s", serializationErr)
+		return
+	}
+
+	outboxEvent, outboxEventErr := events.NewOutboxEvent(-1, string(serializedEvent), false, "ProxyCredentialChangedEvent")
+	if outboxEventErr != nil {
+		log.Printf("failed to produce proxy credential changed event - failed to create outbox event: %s", outboxEventErr)
+		return
+	}
+
+	produceErr := g.messageBus.Produce(fmt.Sprintf("%s", domain.PROXY), outboxEvent)
+	if produceErr != nil {
+		log.Printf("failed to produce proxy credential changed event: %s", produceErr)
+	}
+}
+
+// authenticatedUser loads the user signed in with the id token cookie, the error response is written if there is none.
+func (g *GoogleAuthService) authenticatedUser(w http.ResponseWriter, r *http.Request) (aggregates.User, bool) {
+	idToken, err := GetIdTokenFromCookie(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return aggregates.User{}, false
+	}
+
+	verifiedToken, err := VerifyIDToken(idToken)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return aggregates.User{}, false
+	}
+
+	claims, ok := verifiedToken.Claims.(jwt.MapClaims)
+	if !ok {
+		http.Error(w, "Failed to parse token claims", http.StatusInternalServerError)
+		return aggregates.User{}, false
+	}
+
+	email, _ := claims["email"].(string)
+	if email == "" {
+		http.Error(w, "Invalid token data", http.StatusInternalServerError)
+		return aggregates.User{}, false
+	}
+
+	user, err := g.userUseCases.GetByEmail(email)
+	if err != nil {
+		log.Printf("failed to fetch user: %s", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return aggregates.User{}, false
+	}
+
+	return user, true
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,93 @@
+package google_auth
+
+import (
+	"encoding/json"
+	"goproxy/application/contracts"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultProxyTokenTTL = 24 * time.Hour
+	maxProxyTokenTTL     = 30 * 24 * time.Hour
+)
+
+type postProxyTokenRequest struct {
+	TtlSec int `json:"ttl_sec"`
+}
+
+type proxyTokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// proxyTokens signs Bearer tokens proxy nodes authenticate clients with.
+type proxyTokens struct {
+	jwt    contracts.Jwt
+	secret string
+	issuer string
+}
+
+// WithProxyTokens enables issuing Bearer tokens for the proxy, signed with the secret proxy nodes verify them with.
+func (g *GoogleAuthService) WithProxyTokens(jwt contracts.Jwt, secret, issuer string) *GoogleAuthService {
+	g.proxyTokens = &proxyTokens{
+		jwt:    jwt,
+		secret: secret,
+		issuer: issuer,
+	}
+	return g
+}
+
+// ProxyToken issues a Bearer token of the user for the proxy. Tokens cannot be revoked, so their lifetime is limited.
+func (g *GoogleAuthService) ProxyToken(w http.ResponseWriter, r *http.Request) {
+	if g.proxyTokens == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, ok := g.authenticatedUser(w, r)
+	if !ok {
+		return
+	}
+
+	// the body is optional, tokens live for a day by default
+	var request postProxyTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ttl := defaultProxyTokenTTL
+	if request.TtlSec != 0 {
+		ttl = time.Duration(request.TtlSec) * time.Second
+		if ttl < 0 || ttl > maxProxyTokenTTL {
+			http.Error(w, "ttl_sec must be positive and at most 30 days", http.StatusBadRequest)
+			return
+		}
+	}
+
+	expiresAt := time.Now().Add(ttl).UTC().Truncate(time.Second)
+	token, err := g.proxyTokens.jwt.Generate(g.proxyTokens.secret, ttl, map[string]string{
+		"sub": strconv.Itoa(user.Id()),
+		"iss": g.proxyTokens.issuer,
+	})
+	if err != nil {
+		log.Printf("failed to issue proxy token: %s", err)
+		http.Error(w, "failed to issue proxy token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(proxyTokenResponse{
+		Token:     token,
+		ExpiresAt: expiresAt,
+	})
+}
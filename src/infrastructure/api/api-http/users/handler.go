@@ -4,11 +4,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"goproxy/application/aplication_errors"
 	"goproxy/application/use_cases"
 	"goproxy/domain/aggregates"
 	"goproxy/infrastructure/dto"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
@@ -106,6 +108,12 @@ func (l *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	userDeletionErr := l.userUseCases.Delete(command)
+	var lockedOutErr aplication_errors.ErrLockedOut
+	if errors.As(userDeletionErr, &lockedOutErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lockedOutErr.RetryAfter.Seconds()))))
+		respondWithError(w, http.StatusTooManyRequests, lockedOutErr.Error())
+		return
+	}
 	if userDeletionErr != nil {
 		if strings.Contains(userDeletionErr.Error(), "not found") {
 			respondWithError(w, http.StatusNotFound, "user not found")
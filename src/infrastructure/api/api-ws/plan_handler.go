@@ -118,12 +118,12 @@ func (w *WSHandler) ServeHTTP(wr http.ResponseWriter, r *http.Request) {
 						Total:     plan.Bandwidth,
 					},
 					Connections: dto.ConnectionLimit{
-						IsLimited:                true,
-						MaxConcurrentConnections: 25,
+						IsLimited:                plan.MaxConnections != 0,
+						MaxConcurrentConnections: plan.MaxConnections,
 					},
 					Speed: dto.SpeedLimit{
-						IsLimited:         false,
-						MaxBytesPerSecond: 0,
+						IsLimited:         plan.MaxBytesPerSecond != 0,
+						MaxBytesPerSecond: plan.MaxBytesPerSecond,
 					},
 				},
 			},
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultAuthThrottleUserMaxFailures = 10
+	defaultAuthThrottleIpMaxFailures   = 50
+	defaultAuthThrottleLockout         = time.Minute
+	defaultAuthThrottleMaxLockout      = time.Hour
+	defaultAuthThrottleWindow          = 15 * time.Minute
+)
+
+// AuthThrottleConfig holds settings of the protection against guessing passwords.
+type AuthThrottleConfig struct {
+	Enabled         bool          // Whether failed authentication attempts are counted and locked out
+	Distributed     bool          // Share failure counters between nodes through Redis
+	UserMaxFailures int           // Failures of a username after which it is locked out
+	IpMaxFailures   int           // Failures from a source IP, or IPv6 /64 network, after which it is locked out
+	Lockout         time.Duration // First lockout, doubled with every further failure
+	MaxLockout      time.Duration // Longest lockout
+	Window          time.Duration // How long failures are remembered after the last one
+}
+
+// LoadAuthThrottleConfig reads authentication throttling configuration from environment variables.
+// It expects:
+// - AUTH_THROTTLE_ENABLED (optional; enabled by default, "false" disables throttling)
+// - AUTH_THROTTLE_BACKEND as "local" (default) or "redis"
+// - AUTH_THROTTLE_USER_MAX_FAILURES (optional; defaults to 10)
+// - AUTH_THROTTLE_IP_MAX_FAILURES (optional; defaults to 50)
+// - AUTH_THROTTLE_LOCKOUT_SEC (optional; defaults to 60 seconds)
+// - AUTH_THROTTLE_MAX_LOCKOUT_SEC (optional; defaults to 3600 seconds)
+// - AUTH_THROTTLE_WINDOW_SEC (optional; defaults to 900 seconds)
+func LoadAuthThrottleConfig() (AuthThrottleConfig, error) {
+	if enabledStr := os.Getenv("AUTH_THROTTLE_ENABLED"); enabledStr != "" {
+		enabled, err := strconv.ParseBool(enabledStr)
+		if err != nil {
+			return AuthThrottleConfig{}, fmt.Errorf("invalid AUTH_THROTTLE_ENABLED value: %s", enabledStr)
+		}
+		if !enabled {
+			return AuthThrottleConfig{}, nil
+		}
+	}
+
+	config := AuthThrottleConfig{
+		Enabled:         true,
+		UserMaxFailures: defaultAuthThrottleUserMaxFailures,
+		IpMaxFailures:   defaultAuthThrottleIpMaxFailures,
+		Lockout:         defaultAuthThrottleLockout,
+		MaxLockout:      defaultAuthThrottleMaxLockout,
+		Window:          defaultAuthThrottleWindow,
+	}
+
+	switch backend := os.Getenv("AUTH_THROTTLE_BACKEND"); backend {
+	case "", "local":
+	case "redis":
+		config.Distributed = true
+	default:
+		return AuthThrottleConfig{}, fmt.Errorf("invalid AUTH_THROTTLE_BACKEND value: %s", backend)
+	}
+
+	var err error
+	if config.UserMaxFailures, err = loadPositiveInt("AUTH_THROTTLE_USER_MAX_FAILURES", config.UserMaxFailures); err != nil {
+		return AuthThrottleConfig{}, err
+	}
+	if config.IpMaxFailures, err = loadPositiveInt("AUTH_THROTTLE_IP_MAX_FAILURES", config.IpMaxFailures); err != nil {
+		return AuthThrottleConfig{}, err
+	}
+
+	lockoutSec, err := loadPositiveInt("AUTH_THROTTLE_LOCKOUT_SEC", int(config.Lockout/time.Second))
+	if err != nil {
+		return AuthThrottleConfig{}, err
+	}
+	config.Lockout = time.Duration(lockoutSec) * time.Second
+
+	maxLockoutSec, err := loadPositiveInt("AUTH_THROTTLE_MAX_LOCKOUT_SEC", int(config.MaxLockout/time.Second))
+	if err != nil {
+		return AuthThrottleConfig{}, err
+	}
+	config.MaxLockout = time.Duration(maxLockoutSec) * time.Second
+	if config.MaxLockout < config.Lockout {
+		return AuthThrottleConfig{}, fmt.Errorf("AUTH_THROTTLE_MAX_LOCKOUT_SEC must not be less than AUTH_THROTTLE_LOCKOUT_SEC")
+	}
+
+	windowSec, err := loadPositiveInt("AUTH_THROTTLE_WINDOW_SEC", int(config.Window/time.Second))
+	if err != nil {
+		return AuthThrottleConfig{}, err
+	}
+	config.Window = time.Duration(windowSec) * time.Second
+
+	return config, nil
+}
+
+// loadPositiveInt reads a positive integer, defaultValue is returned if the variable is not set.
+func loadPositiveInt(envVarName string, defaultValue int) (int, error) {
+	valueStr := os.Getenv(envVarName)
+	if valueStr == "" {
+		return defaultValue, nil
+	}
+
+	value, err := strconv.Atoi(valueStr)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid %s value: %s", envVarName, valueStr)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,112 @@
+package config
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLoadAuthThrottleConfig(t *testing.T) {
+	defaultConfig := AuthThrottleConfig{
+		Enabled:         true,
+		UserMaxFailures: 10,
+		IpMaxFailures:   50,
+		Lockout:         time.Minute,
+		MaxLockout:      time.Hour,
+		Window:          15 * time.Minute,
+	}
+
+	tests := []struct {
+		name           string
+		envVars        map[string]string
+		expectedConfig AuthThrottleConfig
+		expectErr      bool
+	}{
+		{
+			name:           "Enabled by default",
+			envVars:        map[string]string{},
+			expectedConfig: defaultConfig,
+		},
+		{
+			name: "Disabled",
+			envVars: map[string]string{
+				"AUTH_THROTTLE_ENABLED":           "false",
+				"AUTH_THROTTLE_USER_MAX_FAILURES": "3",
+			},
+			expectedConfig: AuthThrottleConfig{},
+		},
+		{
+			name: "Custom values",
+			envVars: map[string]string{
+				"AUTH_THROTTLE_ENABLED":           "true",
+				"AUTH_THROTTLE_BACKEND":           "redis",
+				"AUTH_THROTTLE_USER_MAX_FAILURES": "3",
+				"AUTH_THROTTLE_IP_MAX_FAILURES":   "20",
+				"AUTH_THROTTLE_LOCKOUT_SEC":       "10",
+				"AUTH_THROTTLE_MAX_LOCKOUT_SEC":   "600",
+				"AUTH_THROTTLE_WINDOW_SEC":        "300",
+			},
+			expectedConfig: AuthThrottleConfig{
+				Enabled:         true,
+				Distributed:     true,
+				UserMaxFailures: 3,
+				IpMaxFailures:   20,
+				Lockout:         10 * time.Second,
+				MaxLockout:      10 * time.Minute,
+				Window:          5 * time.Minute,
+			},
+		},
+		{
+			name: "Invalid backend",
+			envVars: map[string]string{
+				"AUTH_THROTTLE_BACKEND": "memcached",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Invalid max failures",
+			envVars: map[string]string{
+				"AUTH_THROTTLE_IP_MAX_FAILURES": "0",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Max lockout shorter than lockout",
+			envVars: map[string]string{
+				"AUTH_THROTTLE_LOCKOUT_SEC":     "120",
+				"AUTH_THROTTLE_MAX_LOCKOUT_SEC": "60",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Invalid flag",
+			envVars: map[string]string{
+				"AUTH_THROTTLE_ENABLED": "sometimes",
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.envVars {
+				_ = os.Setenv(key, value)
+			}
+
+			config, err := LoadAuthThrottleConfig()
+			if tt.expectErr && err == nil {
+				t.Errorf("expected an error, got nil")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("expected no error, got: %v", err)
+			}
+			if !tt.expectErr && config != tt.expectedConfig {
+				t.Errorf("expected %+v, got %+v", tt.expectedConfig, config)
+			}
+
+			for key := range tt.envVars {
+				_ = os.Unsetenv(key)
+			}
+		})
+	}
+}
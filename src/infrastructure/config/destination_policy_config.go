@@ -0,0 +1,144 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultDeniedDestinations are networks proxy users must never reach: the proxy host itself,
+// internal services, cloud metadata endpoints and other non-public ranges.
+var defaultDeniedDestinations = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+}
+
+// DestinationPolicyConfig holds rules checked before the proxy connects to a target.
+type DestinationPolicyConfig struct {
+	DeniedNetworks []*net.IPNet     // Target IPs users can not connect to, checked after DNS resolution
+	TunnelPorts    map[int]bool     // Ports allowed for CONNECT and SOCKS tunnels, empty means any port
+	HttpPorts      map[int]bool     // Ports allowed for plain HTTP requests, empty means any port
+	PlanAllowed    map[int][]string // Domains users of the plan are limited to, by plan id
+	PlanDenied     map[int][]string // Domains users of the plan can not reach, by plan id
+}
+
+// LoadDestinationPolicyConfig reads destination rules from environment variables.
+// It expects:
+// - DESTINATION_ALLOW_PRIVATE_NETWORKS as "true" to allow loopback, private and link-local targets (optional)
+// - DESTINATION_DENY_CIDRS as "cidr,cidr" denied in addition to non-public ranges (optional)
+// - DESTINATION_TUNNEL_PORTS as "443,8443" (optional; any port by default)
+// - DESTINATION_HTTP_PORTS as "80,8080" (optional; any port by default)
+// - DESTINATION_PLAN_ALLOW_DOMAINS and DESTINATION_PLAN_DENY_DOMAINS as "planId=domain|domain;planId=domain" (optional),
+// a domain matches itself and its subdomains
+func LoadDestinationPolicyConfig() (DestinationPolicyConfig, error) {
+	var deniedCidrs []string
+	if os.Getenv("DESTINATION_ALLOW_PRIVATE_NETWORKS") != "true" {
+		deniedCidrs = append(deniedCidrs, defaultDeniedDestinations...)
+	}
+	if deniedCidrsStr := os.Getenv("DESTINATION_DENY_CIDRS"); deniedCidrsStr != "" {
+		deniedCidrs = append(deniedCidrs, strings.Split(deniedCidrsStr, ",")...)
+	}
+
+	deniedNetworks := make([]*net.IPNet, 0, len(deniedCidrs))
+	for _, cidr := range deniedCidrs {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return DestinationPolicyConfig{}, fmt.Errorf("invalid DESTINATION_DENY_CIDRS value: %s", cidr)
+		}
+		deniedNetworks = append(deniedNetworks, network)
+	}
+
+	tunnelPorts, err := parseDestinationPorts("DESTINATION_TUNNEL_PORTS")
+	if err != nil {
+		return DestinationPolicyConfig{}, err
+	}
+
+	httpPorts, err := parseDestinationPorts("DESTINATION_HTTP_PORTS")
+	if err != nil {
+		return DestinationPolicyConfig{}, err
+	}
+
+	planAllowed, err := parsePlanDomains("DESTINATION_PLAN_ALLOW_DOMAINS")
+	if err != nil {
+		return DestinationPolicyConfig{}, err
+	}
+
+	planDenied, err := parsePlanDomains("DESTINATION_PLAN_DENY_DOMAINS")
+	if err != nil {
+		return DestinationPolicyConfig{}, err
+	}
+
+	return DestinationPolicyConfig{
+		DeniedNetworks: deniedNetworks,
+		TunnelPorts:    tunnelPorts,
+		HttpPorts:      httpPorts,
+		PlanAllowed:    planAllowed,
+		PlanDenied:     planDenied,
+	}, nil
+}
+
+func parseDestinationPorts(envVarName string) (map[int]bool, error) {
+	ports := make(map[int]bool)
+
+	portsStr := os.Getenv(envVarName)
+	if portsStr == "" {
+		return ports, nil
+	}
+
+	for _, portStr := range strings.Split(portsStr, ",") {
+		port, err := strconv.Atoi(strings.TrimSpace(portStr))
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid %s port: %s", envVarName, portStr)
+		}
+		ports[port] = true
+	}
+
+	return ports, nil
+}
+
+func parsePlanDomains(envVarName string) (map[int][]string, error) {
+	plans := make(map[int][]string)
+
+	plansStr := os.Getenv(envVarName)
+	if plansStr == "" {
+		return plans, nil
+	}
+
+	for _, planStr := range strings.Split(plansStr, ";") {
+		planIdStr, domainsStr, ok := strings.Cut(strings.TrimSpace(planStr), "=")
+		if !ok || domainsStr == "" {
+			return nil, fmt.Errorf("invalid %s value: %s", envVarName, planStr)
+		}
+
+		planId, err := strconv.Atoi(planIdStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s plan id: %s", envVarName, planIdStr)
+		}
+
+		for _, domain := range strings.Split(domainsStr, "|") {
+			domain = strings.ToLower(strings.Trim(strings.TrimSpace(domain), "."))
+			if domain == "" {
+				return nil, fmt.Errorf("empty %s domain of plan %d", envVarName, planId)
+			}
+			plans[planId] = append(plans[planId], domain)
+		}
+	}
+
+	return plans, nil
+}
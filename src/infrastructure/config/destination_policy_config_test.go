@@ -0,0 +1,103 @@
+package config
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadDestinationPolicyConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		envVars   map[string]string
+		expectErr bool
+		check     func(t *testing.T, config DestinationPolicyConfig)
+	}{
+		{
+			name:    "Defaults",
+			envVars: map[string]string{},
+			check: func(t *testing.T, config DestinationPolicyConfig) {
+				assert.Len(t, config.DeniedNetworks, len(defaultDeniedDestinations))
+				assert.True(t, denied(config, "127.0.0.1"))
+				assert.True(t, denied(config, "169.254.169.254"))
+				assert.True(t, denied(config, "::1"))
+				assert.False(t, denied(config, "203.0.113.7"))
+				assert.Empty(t, config.TunnelPorts)
+				assert.Empty(t, config.HttpPorts)
+				assert.Empty(t, config.PlanAllowed)
+			},
+		},
+		{
+			name: "Custom rules",
+			envVars: map[string]string{
+				"DESTINATION_ALLOW_PRIVATE_NETWORKS": "true",
+				"DESTINATION_DENY_CIDRS":             "203.0.113.0/24",
+				"DESTINATION_TUNNEL_PORTS":           "443, 8443",
+				"DESTINATION_HTTP_PORTS":             "80",
+				"DESTINATION_PLAN_ALLOW_DOMAINS":     "1=Example.com|.example.org",
+				"DESTINATION_PLAN_DENY_DOMAINS":      "2=facebook.com;3=tiktok.com",
+			},
+			check: func(t *testing.T, config DestinationPolicyConfig) {
+				assert.Len(t, config.DeniedNetworks, 1)
+				assert.False(t, denied(config, "127.0.0.1"))
+				assert.True(t, denied(config, "203.0.113.7"))
+				assert.Equal(t, map[int]bool{443: true, 8443: true}, config.TunnelPorts)
+				assert.Equal(t, map[int]bool{80: true}, config.HttpPorts)
+				assert.Equal(t, map[int][]string{1: {"example.com", "example.org"}}, config.PlanAllowed)
+				assert.Equal(t, map[int][]string{2: {"facebook.com"}, 3: {"tiktok.com"}}, config.PlanDenied)
+			},
+		},
+		{
+			name: "Invalid cidr",
+			envVars: map[string]string{
+				"DESTINATION_DENY_CIDRS": "10.0.0.1",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Invalid port",
+			envVars: map[string]string{
+				"DESTINATION_TUNNEL_PORTS": "https",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Invalid plan domains",
+			envVars: map[string]string{
+				"DESTINATION_PLAN_DENY_DOMAINS": "basic=facebook.com",
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.envVars {
+				_ = os.Setenv(key, value)
+			}
+
+			config, err := LoadDestinationPolicyConfig()
+			if tt.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				tt.check(t, config)
+			}
+
+			for key := range tt.envVars {
+				_ = os.Unsetenv(key)
+			}
+		})
+	}
+}
+
+func denied(config DestinationPolicyConfig, ip string) bool {
+	for _, network := range config.DeniedNetworks {
+		if network.Contains(net.ParseIP(ip)) {
+			return true
+		}
+	}
+	return false
+}
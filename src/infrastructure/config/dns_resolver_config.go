@@ -0,0 +1,104 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultDnsTimeout     = 5 * time.Second
+	defaultDnsMaxTTL      = 5 * time.Minute
+	defaultDnsNegativeTTL = 30 * time.Second
+)
+
+// DnsResolverConfig holds upstream resolvers and cache settings used to resolve target host names.
+type DnsResolverConfig struct {
+	Upstreams   []*url.URL    // Tried in order: udp://host:port, tls://host:port or https://host/path, system resolver if empty
+	Timeout     time.Duration // Timeout of a single upstream query
+	MaxTTL      time.Duration // Answers are cached for their TTL but not longer than MaxTTL, 0 disables the cache
+	NegativeTTL time.Duration // How long missing names are cached
+}
+
+// LoadDnsResolverConfig reads resolver configuration from environment variables.
+// It expects:
+// - DNS_UPSTREAMS as "udp://1.1.1.1,tls://1.1.1.1:853,https://dns.google/dns-query" (optional; the system resolver is used if not set)
+// - DNS_TIMEOUT_SEC (optional; defaults to 5 seconds)
+// - DNS_CACHE_MAX_TTL_SEC (optional; defaults to 300 seconds, 0 disables the cache)
+// - DNS_CACHE_NEGATIVE_TTL_SEC (optional; defaults to 30 seconds)
+func LoadDnsResolverConfig() (DnsResolverConfig, error) {
+	var upstreams []*url.URL
+	if upstreamsStr := os.Getenv("DNS_UPSTREAMS"); upstreamsStr != "" {
+		for _, upstreamStr := range strings.Split(upstreamsStr, ",") {
+			upstream, err := parseDnsUpstream(strings.TrimSpace(upstreamStr))
+			if err != nil {
+				return DnsResolverConfig{}, err
+			}
+			upstreams = append(upstreams, upstream)
+		}
+	}
+
+	timeout, err := loadDnsDuration("DNS_TIMEOUT_SEC", defaultDnsTimeout, false)
+	if err != nil {
+		return DnsResolverConfig{}, err
+	}
+
+	maxTTL, err := loadDnsDuration("DNS_CACHE_MAX_TTL_SEC", defaultDnsMaxTTL, true)
+	if err != nil {
+		return DnsResolverConfig{}, err
+	}
+
+	negativeTTL, err := loadDnsDuration("DNS_CACHE_NEGATIVE_TTL_SEC", defaultDnsNegativeTTL, true)
+	if err != nil {
+		return DnsResolverConfig{}, err
+	}
+
+	return DnsResolverConfig{
+		Upstreams:   upstreams,
+		Timeout:     timeout,
+		MaxTTL:      maxTTL,
+		NegativeTTL: negativeTTL,
+	}, nil
+}
+
+// parseDnsUpstream parses an upstream url, setting the default port of plain and TLS upstreams.
+func parseDnsUpstream(upstreamStr string) (*url.URL, error) {
+	upstream, err := url.Parse(upstreamStr)
+	if err != nil || upstream.Host == "" {
+		return nil, fmt.Errorf("invalid DNS_UPSTREAMS url: %s", upstreamStr)
+	}
+
+	switch upstream.Scheme {
+	case "udp":
+		if upstream.Port() == "" {
+			upstream.Host = net.JoinHostPort(upstream.Hostname(), "53")
+		}
+	case "tls":
+		if upstream.Port() == "" {
+			upstream.Host = net.JoinHostPort(upstream.Hostname(), "853")
+		}
+	case "https":
+	default:
+		return nil, fmt.Errorf("unsupported DNS_UPSTREAMS url scheme: %s", upstream.Scheme)
+	}
+
+	return upstream, nil
+}
+
+func loadDnsDuration(envVarName string, defaultValue time.Duration, allowZero bool) (time.Duration, error) {
+	valueStr := os.Getenv(envVarName)
+	if valueStr == "" {
+		return defaultValue, nil
+	}
+
+	seconds, err := strconv.Atoi(valueStr)
+	if err != nil || seconds < 0 || (seconds == 0 && !allowZero) {
+		return 0, fmt.Errorf("invalid %s value: %s", envVarName, valueStr)
+	}
+
+	return time.Duration(seconds) * time.Second, nil
+}
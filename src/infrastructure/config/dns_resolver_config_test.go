@@ -0,0 +1,89 @@
+package config
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadDnsResolverConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		envVars   map[string]string
+		expectErr bool
+		check     func(t *testing.T, config DnsResolverConfig)
+	}{
+		{
+			name:    "Defaults",
+			envVars: map[string]string{},
+			check: func(t *testing.T, config DnsResolverConfig) {
+				assert.Empty(t, config.Upstreams)
+				assert.Equal(t, 5*time.Second, config.Timeout)
+				assert.Equal(t, 5*time.Minute, config.MaxTTL)
+				assert.Equal(t, 30*time.Second, config.NegativeTTL)
+			},
+		},
+		{
+			name: "Upstreams with default ports",
+			envVars: map[string]string{
+				"DNS_UPSTREAMS":              "udp://1.1.1.1, tls://dns.google,https://cloudflare-dns.com/dns-query,udp://[2606:4700:4700::1111]:5353",
+				"DNS_TIMEOUT_SEC":            "2",
+				"DNS_CACHE_MAX_TTL_SEC":      "0",
+				"DNS_CACHE_NEGATIVE_TTL_SEC": "10",
+			},
+			check: func(t *testing.T, config DnsResolverConfig) {
+				assert.Len(t, config.Upstreams, 4)
+				assert.Equal(t, "1.1.1.1:53", config.Upstreams[0].Host)
+				assert.Equal(t, "dns.google:853", config.Upstreams[1].Host)
+				assert.Equal(t, "https://cloudflare-dns.com/dns-query", config.Upstreams[2].String())
+				assert.Equal(t, "[2606:4700:4700::1111]:5353", config.Upstreams[3].Host)
+				assert.Equal(t, 2*time.Second, config.Timeout)
+				assert.Equal(t, time.Duration(0), config.MaxTTL)
+				assert.Equal(t, 10*time.Second, config.NegativeTTL)
+			},
+		},
+		{
+			name: "Unsupported scheme",
+			envVars: map[string]string{
+				"DNS_UPSTREAMS": "tcp://1.1.1.1",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Upstream without host",
+			envVars: map[string]string{
+				"DNS_UPSTREAMS": "1.1.1.1",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Zero timeout",
+			envVars: map[string]string{
+				"DNS_TIMEOUT_SEC": "0",
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.envVars {
+				_ = os.Setenv(key, value)
+			}
+
+			config, err := LoadDnsResolverConfig()
+			if tt.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				tt.check(t, config)
+			}
+
+			for key := range tt.envVars {
+				_ = os.Unsetenv(key)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+	"goproxy/domain/valueobjects"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultEgressRotationInterval = time.Minute
+	defaultEgressStickySessionTTL = 30 * time.Minute
+)
+
+// EgressPolicyConfig holds egress IP policies applied when the proxy username does not request one.
+type EgressPolicyConfig struct {
+	Default          valueobjects.EgressPolicy         // Policy of users whose plan has no policy
+	Plans            map[int]valueobjects.EgressPolicy // Policy by plan id
+	StickySessionTTL time.Duration                     // How long an unused sticky session keeps its egress IP
+	IPv6Prefixes     []*net.IPNet                      // Routed prefixes egress IPv6 addresses are allocated from
+}
+
+// LoadEgressPolicyConfig reads egress policies from environment variables.
+// Policies are written as "rotate", "rotate-<minutes>", "sticky", "session-<id>" or "ip-<address>".
+// It expects:
+// - EGRESS_POLICY_DEFAULT (optional; defaults to "rotate-1")
+// - EGRESS_POLICY_PLANS as "planId=policy,planId=policy" (optional)
+// - EGRESS_STICKY_SESSION_TTL_MIN (optional; defaults to 30 minutes)
+// - EGRESS_IPV6_PREFIXES as "2001:db8:1::/48,2001:db8:2::/64" (optional)
+func LoadEgressPolicyConfig() (EgressPolicyConfig, error) {
+	defaultPolicy, _ := valueobjects.NewRotateEveryEgressPolicy(defaultEgressRotationInterval)
+	if defaultPolicyStr := os.Getenv("EGRESS_POLICY_DEFAULT"); defaultPolicyStr != "" {
+		policy, err := valueobjects.ParseEgressPolicy(defaultPolicyStr)
+		if err != nil {
+			return EgressPolicyConfig{}, fmt.Errorf("invalid EGRESS_POLICY_DEFAULT value: %v", err)
+		}
+		defaultPolicy = policy
+	}
+
+	plans := make(map[int]valueobjects.EgressPolicy)
+	if plansStr := os.Getenv("EGRESS_POLICY_PLANS"); plansStr != "" {
+		for _, planStr := range strings.Split(plansStr, ",") {
+			planIdStr, policyStr, ok := strings.Cut(strings.TrimSpace(planStr), "=")
+			if !ok {
+				return EgressPolicyConfig{}, fmt.Errorf("invalid EGRESS_POLICY_PLANS value: %s", planStr)
+			}
+
+			planId, err := strconv.Atoi(planIdStr)
+			if err != nil {
+				return EgressPolicyConfig{}, fmt.Errorf("invalid EGRESS_POLICY_PLANS plan id: %s", planIdStr)
+			}
+
+			policy, err := valueobjects.ParseEgressPolicy(policyStr)
+			if err != nil {
+				return EgressPolicyConfig{}, fmt.Errorf("invalid EGRESS_POLICY_PLANS policy of plan %d: %v", planId, err)
+			}
+
+			plans[planId] = policy
+		}
+	}
+
+	stickySessionTTL := defaultEgressStickySessionTTL
+	if ttlStr := os.Getenv("EGRESS_STICKY_SESSION_TTL_MIN"); ttlStr != "" {
+		ttlMin, err := strconv.Atoi(ttlStr)
+		if err != nil || ttlMin <= 0 {
+			return EgressPolicyConfig{}, fmt.Errorf("invalid EGRESS_STICKY_SESSION_TTL_MIN value: %s", ttlStr)
+		}
+		stickySessionTTL = time.Duration(ttlMin) * time.Minute
+	}
+
+	var ipv6Prefixes []*net.IPNet
+	if prefixesStr := os.Getenv("EGRESS_IPV6_PREFIXES"); prefixesStr != "" {
+		for _, prefixStr := range strings.Split(prefixesStr, ",") {
+			_, prefix, err := net.ParseCIDR(strings.TrimSpace(prefixStr))
+			if err != nil || prefix.IP.To4() != nil {
+				return EgressPolicyConfig{}, fmt.Errorf("invalid EGRESS_IPV6_PREFIXES prefix: %s", prefixStr)
+			}
+
+			// at least 64 host bits, so random addresses practically never repeat
+			if ones, _ := prefix.Mask.Size(); ones > 64 {
+				return EgressPolicyConfig{}, fmt.Errorf("EGRESS_IPV6_PREFIXES prefix is longer than /64: %s", prefixStr)
+			}
+
+			ipv6Prefixes = append(ipv6Prefixes, prefix)
+		}
+	}
+
+	return EgressPolicyConfig{
+		Default:          defaultPolicy,
+		Plans:            plans,
+		StickySessionTTL: stickySessionTTL,
+		IPv6Prefixes:     ipv6Prefixes,
+	}, nil
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"goproxy/domain/valueobjects"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadEgressPolicyConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		envVars   map[string]string
+		expectErr bool
+		check     func(t *testing.T, config EgressPolicyConfig)
+	}{
+		{
+			name:    "Defaults",
+			envVars: map[string]string{},
+			check: func(t *testing.T, config EgressPolicyConfig) {
+				assert.Equal(t, valueobjects.EgressRotateEvery, config.Default.Kind())
+				assert.Equal(t, time.Minute, config.Default.Interval())
+				assert.Empty(t, config.Plans)
+				assert.Equal(t, 30*time.Minute, config.StickySessionTTL)
+			},
+		},
+		{
+			name: "Default and plan policies",
+			envVars: map[string]string{
+				"EGRESS_POLICY_DEFAULT":         "rotate",
+				"EGRESS_POLICY_PLANS":           "2=sticky, 3=rotate-15",
+				"EGRESS_STICKY_SESSION_TTL_MIN": "60",
+				"EGRESS_IPV6_PREFIXES":          "2001:db8:1::/48, 2001:db8:2::/64",
+			},
+			check: func(t *testing.T, config EgressPolicyConfig) {
+				assert.Equal(t, valueobjects.EgressRotatePerRequest, config.Default.Kind())
+				assert.Equal(t, valueobjects.EgressSticky, config.Plans[2].Kind())
+				assert.Equal(t, valueobjects.EgressRotateEvery, config.Plans[3].Kind())
+				assert.Equal(t, 15*time.Minute, config.Plans[3].Interval())
+				assert.Equal(t, time.Hour, config.StickySessionTTL)
+				assert.Len(t, config.IPv6Prefixes, 2)
+				assert.Equal(t, "2001:db8:1::/48", config.IPv6Prefixes[0].String())
+			},
+		},
+		{
+			name: "Invalid default policy",
+			envVars: map[string]string{
+				"EGRESS_POLICY_DEFAULT": "random",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Invalid plan id",
+			envVars: map[string]string{
+				"EGRESS_POLICY_PLANS": "basic=rotate",
+			},
+			expectErr: true,
+		},
+		{
+			name: "IPv4 prefix",
+			envVars: map[string]string{
+				"EGRESS_IPV6_PREFIXES": "203.0.113.0/24",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Too long prefix",
+			envVars: map[string]string{
+				"EGRESS_IPV6_PREFIXES": "2001:db8::/96",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Invalid sticky session ttl",
+			envVars: map[string]string{
+				"EGRESS_STICKY_SESSION_TTL_MIN": "0",
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.envVars {
+				_ = os.Setenv(key, value)
+			}
+
+			config, err := LoadEgressPolicyConfig()
+			if tt.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				tt.check(t, config)
+			}
+
+			for key := range tt.envVars {
+				_ = os.Unsetenv(key)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHttpUpstreamMaxIdleConns    = 8
+	defaultHttpUpstreamIdleTimeout     = 90 * time.Second
+	defaultHttpUpstreamResponseTimeout = 60 * time.Second
+)
+
+// HttpForwardConfig holds settings of plain HTTP requests forwarded by the proxy.
+type HttpForwardConfig struct {
+	Via                   string        // Pseudonym added to Via header of requests and responses, no Via header if empty
+	ForwardedFor          bool          // Whether client IP is added to X-Forwarded-For header of requests
+	MaxIdleConnsPerHost   int           // Keep-alive connections kept per user, egress IP and target host
+	IdleConnTimeout       time.Duration // How long an unused keep-alive connection to a target is kept open
+	ResponseHeaderTimeout time.Duration // How long to wait for response headers after the request is sent
+}
+
+// LoadHttpForwardConfig reads plain HTTP forwarding configuration from environment variables.
+// It expects:
+// - HTTP_PROXY_VIA as a pseudonym of the proxy, e.g. "goproxy" (optional; Via header is not added if not set)
+// - HTTP_PROXY_FORWARDED_FOR as "true" to add client IP to X-Forwarded-For header (optional)
+// - HTTP_UPSTREAM_MAX_IDLE_CONNS_PER_HOST (optional; defaults to 8, 0 disables upstream keep-alive)
+// - HTTP_UPSTREAM_IDLE_TIMEOUT_SEC (optional; defaults to 90 seconds)
+// - HTTP_UPSTREAM_RESPONSE_TIMEOUT_SEC (optional; defaults to 60 seconds)
+func LoadHttpForwardConfig() (HttpForwardConfig, error) {
+	via := strings.TrimSpace(os.Getenv("HTTP_PROXY_VIA"))
+	if strings.ContainsAny(via, " ,()\r\n") {
+		return HttpForwardConfig{}, fmt.Errorf("invalid HTTP_PROXY_VIA value: %s", via)
+	}
+
+	maxIdleConns := defaultHttpUpstreamMaxIdleConns
+	if maxIdleConnsStr := os.Getenv("HTTP_UPSTREAM_MAX_IDLE_CONNS_PER_HOST"); maxIdleConnsStr != "" {
+		value, err := strconv.Atoi(maxIdleConnsStr)
+		if err != nil || value < 0 {
+			return HttpForwardConfig{}, fmt.Errorf("invalid HTTP_UPSTREAM_MAX_IDLE_CONNS_PER_HOST value: %s", maxIdleConnsStr)
+		}
+		maxIdleConns = value
+	}
+
+	idleTimeout, err := loadHttpForwardDuration("HTTP_UPSTREAM_IDLE_TIMEOUT_SEC", defaultHttpUpstreamIdleTimeout)
+	if err != nil {
+		return HttpForwardConfig{}, err
+	}
+
+	responseTimeout, err := loadHttpForwardDuration("HTTP_UPSTREAM_RESPONSE_TIMEOUT_SEC", defaultHttpUpstreamResponseTimeout)
+	if err != nil {
+		return HttpForwardConfig{}, err
+	}
+
+	return HttpForwardConfig{
+		Via:                   via,
+		ForwardedFor:          os.Getenv("HTTP_PROXY_FORWARDED_FOR") == "true",
+		MaxIdleConnsPerHost:   maxIdleConns,
+		IdleConnTimeout:       idleTimeout,
+		ResponseHeaderTimeout: responseTimeout,
+	}, nil
+}
+
+func loadHttpForwardDuration(envVarName string, defaultValue time.Duration) (time.Duration, error) {
+	valueStr := os.Getenv(envVarName)
+	if valueStr == "" {
+		return defaultValue, nil
+	}
+
+	seconds, err := strconv.Atoi(valueStr)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("invalid %s value: %s", envVarName, valueStr)
+	}
+
+	return time.Duration(seconds) * time.Second, nil
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadHttpForwardConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		envVars   map[string]string
+		expectErr bool
+		check     func(t *testing.T, config HttpForwardConfig)
+	}{
+		{
+			name:    "Defaults",
+			envVars: map[string]string{},
+			check: func(t *testing.T, config HttpForwardConfig) {
+				assert.Empty(t, config.Via)
+				assert.False(t, config.ForwardedFor)
+				assert.Equal(t, 8, config.MaxIdleConnsPerHost)
+				assert.Equal(t, 90*time.Second, config.IdleConnTimeout)
+				assert.Equal(t, 60*time.Second, config.ResponseHeaderTimeout)
+			},
+		},
+		{
+			name: "Custom values",
+			envVars: map[string]string{
+				"HTTP_PROXY_VIA":                        "goproxy",
+				"HTTP_PROXY_FORWARDED_FOR":              "true",
+				"HTTP_UPSTREAM_MAX_IDLE_CONNS_PER_HOST": "0",
+				"HTTP_UPSTREAM_IDLE_TIMEOUT_SEC":        "30",
+				"HTTP_UPSTREAM_RESPONSE_TIMEOUT_SEC":    "15",
+			},
+			check: func(t *testing.T, config HttpForwardConfig) {
+				assert.Equal(t, "goproxy", config.Via)
+				assert.True(t, config.ForwardedFor)
+				assert.Equal(t, 0, config.MaxIdleConnsPerHost)
+				assert.Equal(t, 30*time.Second, config.IdleConnTimeout)
+				assert.Equal(t, 15*time.Second, config.ResponseHeaderTimeout)
+			},
+		},
+		{
+			name: "Via with spaces",
+			envVars: map[string]string{
+				"HTTP_PROXY_VIA": "go proxy",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Negative idle connections",
+			envVars: map[string]string{
+				"HTTP_UPSTREAM_MAX_IDLE_CONNS_PER_HOST": "-1",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Zero idle timeout",
+			envVars: map[string]string{
+				"HTTP_UPSTREAM_IDLE_TIMEOUT_SEC": "0",
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.envVars {
+				_ = os.Setenv(key, value)
+			}
+
+			config, err := LoadHttpForwardConfig()
+			if tt.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				tt.check(t, config)
+			}
+
+			for key := range tt.envVars {
+				_ = os.Unsetenv(key)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// HttpHookSpec names a hook along with its argument, e.g. "block-url" with "*.doubleclick.net/*".
+type HttpHookSpec struct {
+	Name string
+	Arg  string
+}
+
+// HttpHooksConfig holds hooks HTTP requests forwarded by the proxy pass through, in the order they are listed.
+type HttpHooksConfig struct {
+	Default []HttpHookSpec         // Hooks of users whose plan has no hooks of its own
+	Plans   map[int][]HttpHookSpec // Hooks by plan id
+}
+
+// LoadHttpHooksConfig reads HTTP hooks configuration from environment variables.
+// It expects:
+// - HTTP_HOOKS as "name:arg|name" (optional; requests of users of plans without own hooks are not hooked if not set)
+// - HTTP_PLAN_HOOKS as "planId=name:arg|name;planId=name" (optional)
+// The argument follows the first colon, so it may contain colons but not "|" or ";".
+func LoadHttpHooksConfig() (HttpHooksConfig, error) {
+	defaultHooks, err := parseHttpHookSpecs("HTTP_HOOKS", os.Getenv("HTTP_HOOKS"))
+	if err != nil {
+		return HttpHooksConfig{}, err
+	}
+
+	plans := make(map[int][]HttpHookSpec)
+	if plansStr := os.Getenv("HTTP_PLAN_HOOKS"); plansStr != "" {
+		for _, planStr := range strings.Split(plansStr, ";") {
+			planIdStr, hooksStr, ok := strings.Cut(strings.TrimSpace(planStr), "=")
+			if !ok || hooksStr == "" {
+				return HttpHooksConfig{}, fmt.Errorf("invalid HTTP_PLAN_HOOKS value: %s", planStr)
+			}
+
+			planId, parseErr := strconv.Atoi(planIdStr)
+			if parseErr != nil {
+				return HttpHooksConfig{}, fmt.Errorf("invalid HTTP_PLAN_HOOKS plan id: %s", planIdStr)
+			}
+
+			hooks, parseErr := parseHttpHookSpecs("HTTP_PLAN_HOOKS", hooksStr)
+			if parseErr != nil {
+				return HttpHooksConfig{}, parseErr
+			}
+			plans[planId] = hooks
+		}
+	}
+
+	return HttpHooksConfig{
+		Default: defaultHooks,
+		Plans:   plans,
+	}, nil
+}
+
+func (c HttpHooksConfig) Enabled() bool {
+	return len(c.Default) != 0 || len(c.Plans) != 0
+}
+
+func parseHttpHookSpecs(envVarName, hooksStr string) ([]HttpHookSpec, error) {
+	if hooksStr == "" {
+		return nil, nil
+	}
+
+	var specs []HttpHookSpec
+	for _, hookStr := range strings.Split(hooksStr, "|") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(hookStr), ":")
+		if name == "" {
+			return nil, fmt.Errorf("invalid %s hook: %s", envVarName, hookStr)
+		}
+		specs = append(specs, HttpHookSpec{Name: name, Arg: arg})
+	}
+
+	return specs, nil
+}
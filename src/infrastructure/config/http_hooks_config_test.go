@@ -0,0 +1,77 @@
+package config
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadHttpHooksConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		envVars   map[string]string
+		expectErr bool
+		check     func(t *testing.T, config HttpHooksConfig)
+	}{
+		{
+			name:    "Disabled",
+			envVars: map[string]string{},
+			check: func(t *testing.T, config HttpHooksConfig) {
+				assert.False(t, config.Enabled())
+			},
+		},
+		{
+			name: "Default and plan hooks",
+			envVars: map[string]string{
+				"HTTP_HOOKS":      "user-agent:Mozilla/5.0 (X11; Linux x86_64)",
+				"HTTP_PLAN_HOOKS": "2=block-url:*.doubleclick.net/*|upstream-basic-auth:api.example.com=svc:s3cret; 3=remove-request-header:Cookie",
+			},
+			check: func(t *testing.T, config HttpHooksConfig) {
+				assert.True(t, config.Enabled())
+				assert.Equal(t, []HttpHookSpec{{Name: "user-agent", Arg: "Mozilla/5.0 (X11; Linux x86_64)"}}, config.Default)
+				assert.Equal(t, map[int][]HttpHookSpec{
+					2: {
+						{Name: "block-url", Arg: "*.doubleclick.net/*"},
+						{Name: "upstream-basic-auth", Arg: "api.example.com=svc:s3cret"},
+					},
+					3: {{Name: "remove-request-header", Arg: "Cookie"}},
+				}, config.Plans)
+			},
+		},
+		{
+			name: "Empty hook name",
+			envVars: map[string]string{
+				"HTTP_HOOKS": "user-agent:curl|",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Invalid plan id",
+			envVars: map[string]string{
+				"HTTP_PLAN_HOOKS": "basic=block-url:*",
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.envVars {
+				_ = os.Setenv(key, value)
+			}
+
+			config, err := LoadHttpHooksConfig()
+			if tt.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				tt.check(t, config)
+			}
+
+			for key := range tt.envVars {
+				_ = os.Unsetenv(key)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+const defaultIpAuthRefreshInterval = time.Minute
+
+// IpAuthConfig holds settings of the passwordless authentication of clients by their authorized networks.
+type IpAuthConfig struct {
+	Enabled         bool          // Whether clients without credentials are authorized by their IP
+	RefreshInterval time.Duration // How often authorized networks are reloaded besides change events
+}
+
+// LoadIpAuthConfig reads IP authentication configuration from environment variables.
+// It expects:
+// - IP_AUTH_ENABLED (optional; "true" enables the authentication, disabled by default)
+// - IP_AUTH_REFRESH_INTERVAL_SEC (optional; defaults to 60 seconds)
+func LoadIpAuthConfig() (IpAuthConfig, error) {
+	enabledStr := os.Getenv("IP_AUTH_ENABLED")
+	if enabledStr == "" {
+		return IpAuthConfig{}, nil
+	}
+
+	enabled, err := strconv.ParseBool(enabledStr)
+	if err != nil {
+		return IpAuthConfig{}, fmt.Errorf("invalid IP_AUTH_ENABLED value: %s", enabledStr)
+	}
+	if !enabled {
+		return IpAuthConfig{}, nil
+	}
+
+	refreshInterval := defaultIpAuthRefreshInterval
+	refreshIntervalStr := os.Getenv("IP_AUTH_REFRESH_INTERVAL_SEC")
+	if refreshIntervalStr != "" {
+		refreshIntervalSec, parseErr := strconv.Atoi(refreshIntervalStr)
+		if parseErr != nil || refreshIntervalSec <= 0 {
+			return IpAuthConfig{}, fmt.Errorf("invalid IP_AUTH_REFRESH_INTERVAL_SEC value: %s", refreshIntervalStr)
+		}
+		refreshInterval = time.Duration(refreshIntervalSec) * time.Second
+	}
+
+	return IpAuthConfig{
+		Enabled:         true,
+		RefreshInterval: refreshInterval,
+	}, nil
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLoadIpAuthConfig(t *testing.T) {
+	tests := []struct {
+		name           string
+		envVars        map[string]string
+		expectedConfig IpAuthConfig
+		expectErr      bool
+	}{
+		{
+			name:           "Disabled by default",
+			envVars:        map[string]string{},
+			expectedConfig: IpAuthConfig{},
+		},
+		{
+			name: "Disabled",
+			envVars: map[string]string{
+				"IP_AUTH_ENABLED":              "false",
+				"IP_AUTH_REFRESH_INTERVAL_SEC": "5",
+			},
+			expectedConfig: IpAuthConfig{},
+		},
+		{
+			name: "Default refresh interval",
+			envVars: map[string]string{
+				"IP_AUTH_ENABLED": "true",
+			},
+			expectedConfig: IpAuthConfig{Enabled: true, RefreshInterval: time.Minute},
+		},
+		{
+			name: "Custom refresh interval",
+			envVars: map[string]string{
+				"IP_AUTH_ENABLED":              "true",
+				"IP_AUTH_REFRESH_INTERVAL_SEC": "5",
+			},
+			expectedConfig: IpAuthConfig{Enabled: true, RefreshInterval: 5 * time.Second},
+		},
+		{
+			name: "Invalid refresh interval",
+			envVars: map[string]string{
+				"IP_AUTH_ENABLED":              "true",
+				"IP_AUTH_REFRESH_INTERVAL_SEC": "0",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Invalid flag",
+			envVars: map[string]string{
+				"IP_AUTH_ENABLED": "yes please",
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.envVars {
+				_ = os.Setenv(key, value)
+			}
+
+			config, err := LoadIpAuthConfig()
+			if tt.expectErr && err == nil {
+				t.Errorf("expected an error, got nil")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("expected no error, got: %v", err)
+			}
+			if config != tt.expectedConfig {
+				t.Errorf("expected %+v, got %+v", tt.expectedConfig, config)
+			}
+
+			for key := range tt.envVars {
+				_ = os.Unsetenv(key)
+			}
+		})
+	}
+}
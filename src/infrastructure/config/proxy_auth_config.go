@@ -0,0 +1,109 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ProxyAuthSchemeBasic  = "basic"
+	ProxyAuthSchemeDigest = "digest"
+	ProxyAuthSchemeBearer = "bearer"
+
+	defaultProxyAuthRealm       = "Proxy"
+	defaultProxyAuthJwtIssuer   = "goproxy"
+	defaultDigestNonceTTL       = 5 * time.Minute
+	minDigestNonceSecretLength  = 32
+	minProxyAuthJwtSecretLength = 32
+)
+
+// ProxyAuthConfig holds settings of the schemes clients authenticate to the proxy with.
+type ProxyAuthConfig struct {
+	Schemes           []string      // Enabled schemes in the order they are offered to clients
+	Realm             string        // Protection space of Basic and Digest challenges, a part of stored Digest hashes
+	DigestNonceSecret string        // Key nonces are signed with, shared by proxy nodes along with nonce counts in Redis; random per node if empty
+	DigestNonceTTL    time.Duration // How long a Digest nonce is accepted before the client is asked to renew it
+	JwtSecret         string        // HS256 key Bearer tokens are signed with
+	JwtIssuer         string        // Issuer Bearer tokens are signed as
+}
+
+// LoadProxyAuthConfig reads proxy authentication configuration from environment variables.
+// It expects:
+// - PROXY_AUTH_SCHEMES as "basic,digest,bearer" (optional; defaults to "basic")
+// - PROXY_AUTH_REALM (optional; defaults to "Proxy", changing it invalidates stored Digest hashes)
+// - PROXY_AUTH_DIGEST_NONCE_SECRET (optional; at least 32 characters; nonce counts are then kept in Redis, see TC_CACHE_HOST)
+// - PROXY_AUTH_DIGEST_NONCE_TTL_SEC (optional; defaults to 300 seconds)
+// - PROXY_AUTH_JWT_SECRET (required if bearer is enabled; at least 32 characters)
+// - PROXY_AUTH_JWT_ISSUER (optional; defaults to "goproxy")
+func LoadProxyAuthConfig() (ProxyAuthConfig, error) {
+	schemes := []string{ProxyAuthSchemeBasic}
+	if schemesStr := os.Getenv("PROXY_AUTH_SCHEMES"); schemesStr != "" {
+		schemes = nil
+		for _, scheme := range strings.Split(schemesStr, ",") {
+			scheme = strings.ToLower(strings.TrimSpace(scheme))
+			switch scheme {
+			case ProxyAuthSchemeBasic, ProxyAuthSchemeDigest, ProxyAuthSchemeBearer:
+				schemes = append(schemes, scheme)
+			default:
+				return ProxyAuthConfig{}, fmt.Errorf("invalid PROXY_AUTH_SCHEMES scheme: %s", scheme)
+			}
+		}
+	}
+
+	realm := defaultProxyAuthRealm
+	if realmStr := os.Getenv("PROXY_AUTH_REALM"); realmStr != "" {
+		if strings.ContainsAny(realmStr, "\"\\") {
+			return ProxyAuthConfig{}, fmt.Errorf("invalid PROXY_AUTH_REALM value: %s", realmStr)
+		}
+		realm = realmStr
+	}
+
+	nonceSecret := os.Getenv("PROXY_AUTH_DIGEST_NONCE_SECRET")
+	if nonceSecret != "" && len(nonceSecret) < minDigestNonceSecretLength {
+		return ProxyAuthConfig{}, fmt.Errorf("PROXY_AUTH_DIGEST_NONCE_SECRET must be at least %d characters", minDigestNonceSecretLength)
+	}
+
+	nonceTTL := defaultDigestNonceTTL
+	if nonceTTLStr := os.Getenv("PROXY_AUTH_DIGEST_NONCE_TTL_SEC"); nonceTTLStr != "" {
+		nonceTTLSec, err := strconv.Atoi(nonceTTLStr)
+		if err != nil || nonceTTLSec <= 0 {
+			return ProxyAuthConfig{}, fmt.Errorf("invalid PROXY_AUTH_DIGEST_NONCE_TTL_SEC value: %s", nonceTTLStr)
+		}
+		nonceTTL = time.Duration(nonceTTLSec) * time.Second
+	}
+
+	jwtSecret := os.Getenv("PROXY_AUTH_JWT_SECRET")
+	if jwtSecret != "" && len(jwtSecret) < minProxyAuthJwtSecretLength {
+		return ProxyAuthConfig{}, fmt.Errorf("PROXY_AUTH_JWT_SECRET must be at least %d characters", minProxyAuthJwtSecretLength)
+	}
+
+	config := ProxyAuthConfig{
+		Schemes:           schemes,
+		Realm:             realm,
+		DigestNonceSecret: nonceSecret,
+		DigestNonceTTL:    nonceTTL,
+		JwtSecret:         jwtSecret,
+		JwtIssuer:         defaultProxyAuthJwtIssuer,
+	}
+	if issuer := os.Getenv("PROXY_AUTH_JWT_ISSUER"); issuer != "" {
+		config.JwtIssuer = issuer
+	}
+
+	if config.SchemeEnabled(ProxyAuthSchemeBearer) && jwtSecret == "" {
+		return ProxyAuthConfig{}, NewEnvVarNotSetError("PROXY_AUTH_JWT_SECRET")
+	}
+
+	return config, nil
+}
+
+func (c ProxyAuthConfig) SchemeEnabled(scheme string) bool {
+	for _, enabled := range c.Schemes {
+		if enabled == scheme {
+			return true
+		}
+	}
+	return false
+}
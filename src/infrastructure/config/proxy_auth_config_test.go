@@ -0,0 +1,95 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadProxyAuthConfig(t *testing.T) {
+	jwtSecret := "0123456789abcdef0123456789abcdef"
+
+	tests := []struct {
+		name           string
+		envVars        map[string]string
+		expectedConfig ProxyAuthConfig
+		expectErr      bool
+	}{
+		{
+			name:    "Defaults",
+			envVars: map[string]string{},
+			expectedConfig: ProxyAuthConfig{
+				Schemes:        []string{"basic"},
+				Realm:          "Proxy",
+				DigestNonceTTL: 5 * time.Minute,
+				JwtIssuer:      "goproxy",
+			},
+		},
+		{
+			name: "All schemes",
+			envVars: map[string]string{
+				"PROXY_AUTH_SCHEMES":              "Digest, bearer,basic",
+				"PROXY_AUTH_REALM":                "Proxy of Example",
+				"PROXY_AUTH_DIGEST_NONCE_TTL_SEC": "60",
+				"PROXY_AUTH_JWT_SECRET":           jwtSecret,
+				"PROXY_AUTH_JWT_ISSUER":           "example.com",
+			},
+			expectedConfig: ProxyAuthConfig{
+				Schemes:        []string{"digest", "bearer", "basic"},
+				Realm:          "Proxy of Example",
+				DigestNonceTTL: time.Minute,
+				JwtSecret:      jwtSecret,
+				JwtIssuer:      "example.com",
+			},
+		},
+		{
+			name:      "Unknown scheme",
+			envVars:   map[string]string{"PROXY_AUTH_SCHEMES": "basic,ntlm"},
+			expectErr: true,
+		},
+		{
+			name:      "Bearer without secret",
+			envVars:   map[string]string{"PROXY_AUTH_SCHEMES": "bearer"},
+			expectErr: true,
+		},
+		{
+			name:      "Short nonce secret",
+			envVars:   map[string]string{"PROXY_AUTH_DIGEST_NONCE_SECRET": "secret"},
+			expectErr: true,
+		},
+		{
+			name:      "Invalid nonce TTL",
+			envVars:   map[string]string{"PROXY_AUTH_DIGEST_NONCE_TTL_SEC": "0"},
+			expectErr: true,
+		},
+		{
+			name:      "Quoted realm",
+			envVars:   map[string]string{"PROXY_AUTH_REALM": "\"Proxy\""},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.envVars {
+				_ = os.Setenv(key, value)
+			}
+
+			config, err := LoadProxyAuthConfig()
+			if tt.expectErr && err == nil {
+				t.Errorf("expected an error, got nil")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("expected no error, got: %v", err)
+			}
+			if !tt.expectErr && !reflect.DeepEqual(config, tt.expectedConfig) {
+				t.Errorf("expected %+v, got %+v", tt.expectedConfig, config)
+			}
+
+			for key := range tt.envVars {
+				_ = os.Unsetenv(key)
+			}
+		})
+	}
+}
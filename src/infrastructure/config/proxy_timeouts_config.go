@@ -0,0 +1,139 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultProxyIdleTimeout      = 2 * time.Minute
+	defaultProxyDialTimeout      = 10 * time.Second
+	defaultProxyHandshakeTimeout = 10 * time.Second
+)
+
+// ProxyTimeouts holds timeouts of client connections and the connections they open to targets.
+type ProxyTimeouts struct {
+	Idle        time.Duration // Tunnels and keep-alive connections are closed after no data flows either way for Idle
+	Dial        time.Duration // Timeout of connecting to a target
+	Handshake   time.Duration // Timeout of a client sending its request and of TLS handshakes
+	MaxLifetime time.Duration // Tunnels are closed MaxLifetime after they open regardless of activity, 0 means unlimited
+}
+
+// ProxyTimeoutsConfig holds default timeouts and their overrides by plan id.
+type ProxyTimeoutsConfig struct {
+	Default ProxyTimeouts
+	Plans   map[int]ProxyTimeouts // Timeouts by plan id, timeouts not set for a plan are the default ones
+}
+
+// LoadProxyTimeoutsConfig reads timeouts from environment variables.
+// It expects:
+// - PROXY_IDLE_TIMEOUT_SEC (optional; defaults to 120 seconds)
+// - PROXY_DIAL_TIMEOUT_SEC (optional; defaults to 10 seconds)
+// - PROXY_HANDSHAKE_TIMEOUT_SEC (optional; defaults to 10 seconds)
+// - PROXY_MAX_LIFETIME_SEC (optional; defaults to 0, tunnels live as long as they are active)
+// - PROXY_PLAN_TIMEOUTS as "planId=idle:300,lifetime:86400;planId=dial:5" (optional),
+// keys are idle, dial, handshake and lifetime
+func LoadProxyTimeoutsConfig() (ProxyTimeoutsConfig, error) {
+	idle, err := loadProxyTimeout("PROXY_IDLE_TIMEOUT_SEC", defaultProxyIdleTimeout, false)
+	if err != nil {
+		return ProxyTimeoutsConfig{}, err
+	}
+
+	dial, err := loadProxyTimeout("PROXY_DIAL_TIMEOUT_SEC", defaultProxyDialTimeout, false)
+	if err != nil {
+		return ProxyTimeoutsConfig{}, err
+	}
+
+	handshake, err := loadProxyTimeout("PROXY_HANDSHAKE_TIMEOUT_SEC", defaultProxyHandshakeTimeout, false)
+	if err != nil {
+		return ProxyTimeoutsConfig{}, err
+	}
+
+	maxLifetime, err := loadProxyTimeout("PROXY_MAX_LIFETIME_SEC", 0, true)
+	if err != nil {
+		return ProxyTimeoutsConfig{}, err
+	}
+
+	defaults := ProxyTimeouts{
+		Idle:        idle,
+		Dial:        dial,
+		Handshake:   handshake,
+		MaxLifetime: maxLifetime,
+	}
+
+	plans := make(map[int]ProxyTimeouts)
+	if plansStr := os.Getenv("PROXY_PLAN_TIMEOUTS"); plansStr != "" {
+		for _, planStr := range strings.Split(plansStr, ";") {
+			planIdStr, timeoutsStr, ok := strings.Cut(strings.TrimSpace(planStr), "=")
+			if !ok || timeoutsStr == "" {
+				return ProxyTimeoutsConfig{}, fmt.Errorf("invalid PROXY_PLAN_TIMEOUTS value: %s", planStr)
+			}
+
+			planId, parseErr := strconv.Atoi(planIdStr)
+			if parseErr != nil {
+				return ProxyTimeoutsConfig{}, fmt.Errorf("invalid PROXY_PLAN_TIMEOUTS plan id: %s", planIdStr)
+			}
+
+			timeouts, parseErr := parsePlanTimeouts(timeoutsStr, defaults)
+			if parseErr != nil {
+				return ProxyTimeoutsConfig{}, parseErr
+			}
+			plans[planId] = timeouts
+		}
+	}
+
+	return ProxyTimeoutsConfig{
+		Default: defaults,
+		Plans:   plans,
+	}, nil
+}
+
+// parsePlanTimeouts parses "idle:300,lifetime:86400", starting from the default timeouts.
+func parsePlanTimeouts(timeoutsStr string, defaults ProxyTimeouts) (ProxyTimeouts, error) {
+	timeouts := defaults
+	for _, timeoutStr := range strings.Split(timeoutsStr, ",") {
+		key, secondsStr, ok := strings.Cut(strings.TrimSpace(timeoutStr), ":")
+		seconds, err := strconv.Atoi(strings.TrimSpace(secondsStr))
+		if !ok || err != nil || seconds < 0 {
+			return ProxyTimeouts{}, fmt.Errorf("invalid PROXY_PLAN_TIMEOUTS timeout: %s", timeoutStr)
+		}
+
+		value := time.Duration(seconds) * time.Second
+		switch strings.TrimSpace(key) {
+		case "idle":
+			timeouts.Idle = value
+		case "dial":
+			timeouts.Dial = value
+		case "handshake":
+			timeouts.Handshake = value
+		case "lifetime":
+			timeouts.MaxLifetime = value
+			continue
+		default:
+			return ProxyTimeouts{}, fmt.Errorf("unknown PROXY_PLAN_TIMEOUTS timeout: %s", key)
+		}
+
+		if seconds == 0 {
+			return ProxyTimeouts{}, fmt.Errorf("invalid PROXY_PLAN_TIMEOUTS timeout: %s", timeoutStr)
+		}
+	}
+
+	return timeouts, nil
+}
+
+func loadProxyTimeout(envVarName string, defaultValue time.Duration, allowZero bool) (time.Duration, error) {
+	valueStr := os.Getenv(envVarName)
+	if valueStr == "" {
+		return defaultValue, nil
+	}
+
+	seconds, err := strconv.Atoi(valueStr)
+	if err != nil || seconds < 0 || (seconds == 0 && !allowZero) {
+		return 0, fmt.Errorf("invalid %s value: %s", envVarName, valueStr)
+	}
+
+	return time.Duration(seconds) * time.Second, nil
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadProxyTimeoutsConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		envVars   map[string]string
+		expectErr bool
+		check     func(t *testing.T, config ProxyTimeoutsConfig)
+	}{
+		{
+			name:    "Defaults",
+			envVars: map[string]string{},
+			check: func(t *testing.T, config ProxyTimeoutsConfig) {
+				assert.Equal(t, ProxyTimeouts{
+					Idle:      2 * time.Minute,
+					Dial:      10 * time.Second,
+					Handshake: 10 * time.Second,
+				}, config.Default)
+				assert.Empty(t, config.Plans)
+			},
+		},
+		{
+			name: "Custom and plan timeouts",
+			envVars: map[string]string{
+				"PROXY_IDLE_TIMEOUT_SEC":      "60",
+				"PROXY_DIAL_TIMEOUT_SEC":      "5",
+				"PROXY_HANDSHAKE_TIMEOUT_SEC": "3",
+				"PROXY_MAX_LIFETIME_SEC":      "3600",
+				"PROXY_PLAN_TIMEOUTS":         "2=idle:600, lifetime:0; 3=dial:20",
+			},
+			check: func(t *testing.T, config ProxyTimeoutsConfig) {
+				assert.Equal(t, ProxyTimeouts{
+					Idle:        time.Minute,
+					Dial:        5 * time.Second,
+					Handshake:   3 * time.Second,
+					MaxLifetime: time.Hour,
+				}, config.Default)
+				assert.Equal(t, map[int]ProxyTimeouts{
+					2: {Idle: 10 * time.Minute, Dial: 5 * time.Second, Handshake: 3 * time.Second},
+					3: {Idle: time.Minute, Dial: 20 * time.Second, Handshake: 3 * time.Second, MaxLifetime: time.Hour},
+				}, config.Plans)
+			},
+		},
+		{
+			name: "Zero idle timeout",
+			envVars: map[string]string{
+				"PROXY_IDLE_TIMEOUT_SEC": "0",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Zero plan idle timeout",
+			envVars: map[string]string{
+				"PROXY_PLAN_TIMEOUTS": "2=idle:0",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Unknown plan timeout",
+			envVars: map[string]string{
+				"PROXY_PLAN_TIMEOUTS": "2=read:30",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Invalid plan id",
+			envVars: map[string]string{
+				"PROXY_PLAN_TIMEOUTS": "basic=idle:30",
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.envVars {
+				_ = os.Setenv(key, value)
+			}
+
+			config, err := LoadProxyTimeoutsConfig()
+			if tt.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				tt.check(t, config)
+			}
+
+			for key := range tt.envVars {
+				_ = os.Unsetenv(key)
+			}
+		})
+	}
+}
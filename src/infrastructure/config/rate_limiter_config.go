@@ -16,8 +16,16 @@ type RateLimiterConfig struct {
 	Capacity         int64         // TokenBucket capacity in bytes
 	FillRate         float64       // Token fill rate in bytes per second
 	MaxConns         int           // Maximum number of concurrent connections
+	MaxUserConns     int           // Maximum number of concurrent connections of a user to all targets
+	MaxNodeConns     int           // Maximum number of concurrent connections accepted by the node (0 means unlimited)
 	BlockDur         time.Duration // Duration to block a user when rate limit is exceeded
 	CleanupInt       time.Duration // Interval to clean up old buckets
+	ShapingEnabled   bool          // Slow traffic down to speed limits instead of blocking users that exceed rate limit
+	Distributed      bool          // Share token buckets and connection counters between proxy nodes through Redis
+	UserUploadRate   float64       // Per-user client → server speed limit in bytes per second (0 means unlimited)
+	UserDownloadRate float64       // Per-user server → client speed limit in bytes per second (0 means unlimited)
+	ConnUploadRate   float64       // Per-connection client → server speed limit in bytes per second (0 means unlimited)
+	ConnDownloadRate float64       // Per-connection server → client speed limit in bytes per second (0 means unlimited)
 }
 
 // LoadRateLimiterConfig reads configuration from environment variables and applies default values if necessary.
@@ -25,6 +33,9 @@ type RateLimiterConfig struct {
 // - NET_BANDWIDTH in megabits per second (e.g., "1000" for 1 Gigabit/s)
 // - MAX_USERS as an integer (e.g., "100")
 // - SHARD_COUNT as an integer (optional; defaults based on CPU cores)
+// - RATE_LIMITER_MODE as "block" (default) or "shape"
+// - RATE_LIMITER_BACKEND as "local" (default) or "redis"
+// - USER_UPLOAD_LIMIT_MBPS, USER_DOWNLOAD_LIMIT_MBPS, CONN_UPLOAD_LIMIT_MBPS, CONN_DOWNLOAD_LIMIT_MBPS (optional; unlimited by default)
 // - Other settings can also be configured via environment variables.
 func LoadRateLimiterConfig() RateLimiterConfig {
 	var config RateLimiterConfig
@@ -124,6 +135,33 @@ func LoadRateLimiterConfig() RateLimiterConfig {
 		}
 	}
 
+	// Set MaxUserConns
+	config.MaxUserConns = 100 // 100 connections per user to all targets
+
+	// Optionally, allow overriding MaxUserConns via environment variables
+	maxUserConnsStr := os.Getenv("MAX_USER_CONNS")
+	if maxUserConnsStr != "" {
+		maxUserConns, err := strconv.Atoi(maxUserConnsStr)
+		if err == nil && maxUserConns > 0 {
+			config.MaxUserConns = maxUserConns
+			log.Printf("Overriding MaxUserConns with MAX_USER_CONNS: %d\n", maxUserConns)
+		} else {
+			log.Printf("Invalid MAX_USER_CONNS value: %s. Using existing MaxUserConns: %d\n", maxUserConnsStr, config.MaxUserConns)
+		}
+	}
+
+	// Load MAX_NODE_CONNS, node connections are not limited by default
+	maxNodeConnsStr := os.Getenv("MAX_NODE_CONNS")
+	if maxNodeConnsStr != "" {
+		maxNodeConns, err := strconv.Atoi(maxNodeConnsStr)
+		if err == nil && maxNodeConns >= 0 {
+			config.MaxNodeConns = maxNodeConns
+			log.Printf("Overriding MaxNodeConns with MAX_NODE_CONNS: %d\n", maxNodeConns)
+		} else {
+			log.Printf("Invalid MAX_NODE_CONNS value: %s. Node connections are not limited\n", maxNodeConnsStr)
+		}
+	}
+
 	// Load BLOCK_DURATION
 	blockDurStr := os.Getenv("BLOCK_DURATION_SEC")
 	if blockDurStr == "" {
@@ -152,5 +190,52 @@ func LoadRateLimiterConfig() RateLimiterConfig {
 		}
 	}
 
+	// Load RATE_LIMITER_MODE
+	modeStr := os.Getenv("RATE_LIMITER_MODE")
+	switch modeStr {
+	case "", "block":
+		config.ShapingEnabled = false
+	case "shape":
+		config.ShapingEnabled = true
+		log.Println("Rate limiter runs in shaping mode: traffic is slowed down to speed limits instead of being blocked")
+	default:
+		log.Printf("Invalid RATE_LIMITER_MODE value: %s. Using default mode: block\n", modeStr)
+	}
+
+	// Load RATE_LIMITER_BACKEND
+	backendStr := os.Getenv("RATE_LIMITER_BACKEND")
+	switch backendStr {
+	case "", "local":
+		config.Distributed = false
+	case "redis":
+		config.Distributed = true
+		log.Println("Rate limiter state is shared between proxy nodes through Redis")
+	default:
+		log.Printf("Invalid RATE_LIMITER_BACKEND value: %s. Using default backend: local\n", backendStr)
+	}
+
+	// Load speed limits
+	config.UserUploadRate = loadSpeedLimit("USER_UPLOAD_LIMIT_MBPS")
+	config.UserDownloadRate = loadSpeedLimit("USER_DOWNLOAD_LIMIT_MBPS")
+	config.ConnUploadRate = loadSpeedLimit("CONN_UPLOAD_LIMIT_MBPS")
+	config.ConnDownloadRate = loadSpeedLimit("CONN_DOWNLOAD_LIMIT_MBPS")
+
 	return config
 }
+
+// loadSpeedLimit reads a speed limit in megabits per second and converts it to bytes per second.
+// 0 is returned if the variable is not set or invalid, meaning no limit.
+func loadSpeedLimit(envVarName string) float64 {
+	limitStr := os.Getenv(envVarName)
+	if limitStr == "" {
+		return 0
+	}
+
+	limitMbps, err := strconv.ParseFloat(limitStr, 64)
+	if err != nil || limitMbps < 0 {
+		log.Printf("Invalid %s value: %s. Speed is not limited\n", envVarName, limitStr)
+		return 0
+	}
+
+	return limitMbps * 125000 // Convert Mbps to bytes per second
+}
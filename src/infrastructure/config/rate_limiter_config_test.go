@@ -23,6 +23,7 @@ func TestLoadRateLimiterConfig(t *testing.T) {
 				Capacity:         100 * 1024 * 1024,
 				FillRate:         1000 * 125000,
 				MaxConns:         25,
+				MaxUserConns:     100,
 				BlockDur:         30 * time.Second,
 				CleanupInt:       1 * time.Minute,
 			},
@@ -36,6 +37,8 @@ func TestLoadRateLimiterConfig(t *testing.T) {
 				"CAPACITY_MB":          "200",
 				"FILL_RATE_MBPS":       "1500",
 				"MAX_CONNS":            "500",
+				"MAX_USER_CONNS":       "1000",
+				"MAX_NODE_CONNS":       "10000",
 				"BLOCK_DURATION_SEC":   "60",
 				"CLEANUP_INTERVAL_SEC": "120",
 			},
@@ -46,10 +49,40 @@ func TestLoadRateLimiterConfig(t *testing.T) {
 				Capacity:         200 * 1024 * 1024,
 				FillRate:         1500 * 125000,
 				MaxConns:         500,
+				MaxUserConns:     1000,
+				MaxNodeConns:     10000,
 				BlockDur:         60 * time.Second,
 				CleanupInt:       2 * time.Minute,
 			},
 		},
+		{
+			name: "Shaping Config",
+			envVars: map[string]string{
+				"RATE_LIMITER_MODE":        "shape",
+				"RATE_LIMITER_BACKEND":     "redis",
+				"USER_UPLOAD_LIMIT_MBPS":   "8",
+				"USER_DOWNLOAD_LIMIT_MBPS": "16",
+				"CONN_UPLOAD_LIMIT_MBPS":   "2",
+				"CONN_DOWNLOAD_LIMIT_MBPS": "4",
+			},
+			expectedConfig: RateLimiterConfig{
+				NetBandwidthMbps: 1000,
+				MaxUsers:         100,
+				ShardCount:       runtime.NumCPU() * 2,
+				Capacity:         100 * 1024 * 1024,
+				FillRate:         1000 * 125000,
+				MaxConns:         25,
+				MaxUserConns:     100,
+				BlockDur:         30 * time.Second,
+				CleanupInt:       1 * time.Minute,
+				ShapingEnabled:   true,
+				Distributed:      true,
+				UserUploadRate:   8 * 125000,
+				UserDownloadRate: 16 * 125000,
+				ConnUploadRate:   2 * 125000,
+				ConnDownloadRate: 4 * 125000,
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const defaultTlsInterceptCertCacheSize = 1000
+
+// TlsInterceptionConfig holds settings of TLS interception, which lets the proxy inspect HTTPS traffic of users
+// who opted in: CONNECT tunnels are terminated with certificates minted by the configured CA.
+type TlsInterceptionConfig struct {
+	CaCertFile    string       // Path to PEM-encoded certificate of the CA the minted certificates are signed by
+	CaKeyFile     string       // Path to PEM-encoded private key of the CA
+	Users         map[int]bool // Users whose tunnels are intercepted
+	Plans         map[int]bool // Plans whose users' tunnels are intercepted
+	BypassDomains []string     // Domains pinning their certificates, tunneled untouched along with their subdomains
+	CertCacheSize int          // How many minted certificates are kept
+}
+
+// LoadTlsInterceptionConfig reads TLS interception configuration from environment variables.
+// It expects:
+// - TLS_INTERCEPT_USERS and TLS_INTERCEPT_PLANS as "id,id" (optional; interception is disabled if neither is set)
+// - TLS_INTERCEPT_CA_CERT_FILE and TLS_INTERCEPT_CA_KEY_FILE (required if interception is enabled)
+// - TLS_INTERCEPT_BYPASS_DOMAINS as "domain,domain" (optional), a domain matches itself and its subdomains
+// - TLS_INTERCEPT_CERT_CACHE_SIZE (optional; defaults to 1000)
+func LoadTlsInterceptionConfig() (TlsInterceptionConfig, error) {
+	users, err := parseTlsInterceptIds("TLS_INTERCEPT_USERS")
+	if err != nil {
+		return TlsInterceptionConfig{}, err
+	}
+
+	plans, err := parseTlsInterceptIds("TLS_INTERCEPT_PLANS")
+	if err != nil {
+		return TlsInterceptionConfig{}, err
+	}
+
+	if len(users) == 0 && len(plans) == 0 {
+		return TlsInterceptionConfig{}, nil
+	}
+
+	caCertFile := os.Getenv("TLS_INTERCEPT_CA_CERT_FILE")
+	if caCertFile == "" {
+		return TlsInterceptionConfig{}, NewEnvVarNotSetError("TLS_INTERCEPT_CA_CERT_FILE")
+	}
+
+	caKeyFile := os.Getenv("TLS_INTERCEPT_CA_KEY_FILE")
+	if caKeyFile == "" {
+		return TlsInterceptionConfig{}, NewEnvVarNotSetError("TLS_INTERCEPT_CA_KEY_FILE")
+	}
+
+	var bypassDomains []string
+	if bypassDomainsStr := os.Getenv("TLS_INTERCEPT_BYPASS_DOMAINS"); bypassDomainsStr != "" {
+		for _, domain := range strings.Split(bypassDomainsStr, ",") {
+			domain = strings.ToLower(strings.Trim(strings.TrimSpace(domain), "."))
+			if domain == "" {
+				return TlsInterceptionConfig{}, fmt.Errorf("invalid TLS_INTERCEPT_BYPASS_DOMAINS value: %s", bypassDomainsStr)
+			}
+			bypassDomains = append(bypassDomains, domain)
+		}
+	}
+
+	certCacheSize := defaultTlsInterceptCertCacheSize
+	if certCacheSizeStr := os.Getenv("TLS_INTERCEPT_CERT_CACHE_SIZE"); certCacheSizeStr != "" {
+		certCacheSize, err = strconv.Atoi(certCacheSizeStr)
+		if err != nil || certCacheSize <= 0 {
+			return TlsInterceptionConfig{}, fmt.Errorf("invalid TLS_INTERCEPT_CERT_CACHE_SIZE value: %s", certCacheSizeStr)
+		}
+	}
+
+	return TlsInterceptionConfig{
+		CaCertFile:    caCertFile,
+		CaKeyFile:     caKeyFile,
+		Users:         users,
+		Plans:         plans,
+		BypassDomains: bypassDomains,
+		CertCacheSize: certCacheSize,
+	}, nil
+}
+
+func (c TlsInterceptionConfig) Enabled() bool {
+	return len(c.Users) != 0 || len(c.Plans) != 0
+}
+
+func parseTlsInterceptIds(envVarName string) (map[int]bool, error) {
+	ids := make(map[int]bool)
+
+	idsStr := os.Getenv(envVarName)
+	if idsStr == "" {
+		return ids, nil
+	}
+
+	for _, idStr := range strings.Split(idsStr, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(idStr))
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid %s id: %s", envVarName, idStr)
+		}
+		ids[id] = true
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadTlsInterceptionConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		envVars   map[string]string
+		expectErr bool
+		check     func(t *testing.T, config TlsInterceptionConfig)
+	}{
+		{
+			name:    "Disabled",
+			envVars: map[string]string{},
+			check: func(t *testing.T, config TlsInterceptionConfig) {
+				assert.False(t, config.Enabled())
+			},
+		},
+		{
+			name: "Defaults",
+			envVars: map[string]string{
+				"TLS_INTERCEPT_PLANS":        "3",
+				"TLS_INTERCEPT_CA_CERT_FILE": "/certs/ca.crt",
+				"TLS_INTERCEPT_CA_KEY_FILE":  "/certs/ca.key",
+			},
+			check: func(t *testing.T, config TlsInterceptionConfig) {
+				assert.True(t, config.Enabled())
+				assert.Empty(t, config.Users)
+				assert.Equal(t, map[int]bool{3: true}, config.Plans)
+				assert.Empty(t, config.BypassDomains)
+				assert.Equal(t, 1000, config.CertCacheSize)
+			},
+		},
+		{
+			name: "Custom settings",
+			envVars: map[string]string{
+				"TLS_INTERCEPT_USERS":           "7, 9",
+				"TLS_INTERCEPT_CA_CERT_FILE":    "/certs/ca.crt",
+				"TLS_INTERCEPT_CA_KEY_FILE":     "/certs/ca.key",
+				"TLS_INTERCEPT_BYPASS_DOMAINS":  "Bank.example, .pinned.example.",
+				"TLS_INTERCEPT_CERT_CACHE_SIZE": "50",
+			},
+			check: func(t *testing.T, config TlsInterceptionConfig) {
+				assert.Equal(t, "/certs/ca.crt", config.CaCertFile)
+				assert.Equal(t, "/certs/ca.key", config.CaKeyFile)
+				assert.Equal(t, map[int]bool{7: true, 9: true}, config.Users)
+				assert.Equal(t, []string{"bank.example", "pinned.example"}, config.BypassDomains)
+				assert.Equal(t, 50, config.CertCacheSize)
+			},
+		},
+		{
+			name: "Missing CA key",
+			envVars: map[string]string{
+				"TLS_INTERCEPT_USERS":        "7",
+				"TLS_INTERCEPT_CA_CERT_FILE": "/certs/ca.crt",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Invalid user id",
+			envVars: map[string]string{
+				"TLS_INTERCEPT_USERS": "alice",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Invalid cache size",
+			envVars: map[string]string{
+				"TLS_INTERCEPT_PLANS":           "3",
+				"TLS_INTERCEPT_CA_CERT_FILE":    "/certs/ca.crt",
+				"TLS_INTERCEPT_CA_KEY_FILE":     "/certs/ca.key",
+				"TLS_INTERCEPT_CERT_CACHE_SIZE": "0",
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.envVars {
+				_ = os.Setenv(key, value)
+			}
+
+			config, err := LoadTlsInterceptionConfig()
+			if tt.expectErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				tt.check(t, config)
+			}
+
+			for key := range tt.envVars {
+				_ = os.Unsetenv(key)
+			}
+		})
+	}
+}
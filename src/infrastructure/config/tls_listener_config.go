@@ -0,0 +1,66 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+const defaultTlsCertReloadInterval = time.Minute
+
+// TlsListenerConfig holds settings of the optional TLS-wrapped proxy listener ("HTTPS proxy").
+type TlsListenerConfig struct {
+	Port           int           // Port to serve TLS-wrapped proxy connections on, 0 if the listener is disabled
+	CertFile       string        // Path to PEM-encoded certificate chain
+	KeyFile        string        // Path to PEM-encoded private key
+	ReloadInterval time.Duration // How often certificate files are checked for changes
+}
+
+// LoadTlsListenerConfig reads TLS listener configuration from environment variables.
+// It expects:
+// - HTTPS_LISTENER_PORT (optional; the TLS listener is disabled if not set)
+// - TLS_CERT_FILE and TLS_KEY_FILE (required if HTTPS_LISTENER_PORT is set)
+// - TLS_CERT_RELOAD_INTERVAL_SEC (optional; defaults to 60 seconds)
+func LoadTlsListenerConfig() (TlsListenerConfig, error) {
+	portStr := os.Getenv("HTTPS_LISTENER_PORT")
+	if portStr == "" {
+		return TlsListenerConfig{}, nil
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 {
+		return TlsListenerConfig{}, fmt.Errorf("invalid HTTPS_LISTENER_PORT value: %s", portStr)
+	}
+
+	certFile := os.Getenv("TLS_CERT_FILE")
+	if certFile == "" {
+		return TlsListenerConfig{}, NewEnvVarNotSetError("TLS_CERT_FILE")
+	}
+
+	keyFile := os.Getenv("TLS_KEY_FILE")
+	if keyFile == "" {
+		return TlsListenerConfig{}, NewEnvVarNotSetError("TLS_KEY_FILE")
+	}
+
+	reloadInterval := defaultTlsCertReloadInterval
+	reloadIntervalStr := os.Getenv("TLS_CERT_RELOAD_INTERVAL_SEC")
+	if reloadIntervalStr != "" {
+		reloadIntervalSec, parseErr := strconv.Atoi(reloadIntervalStr)
+		if parseErr != nil || reloadIntervalSec <= 0 {
+			return TlsListenerConfig{}, fmt.Errorf("invalid TLS_CERT_RELOAD_INTERVAL_SEC value: %s", reloadIntervalStr)
+		}
+		reloadInterval = time.Duration(reloadIntervalSec) * time.Second
+	}
+
+	return TlsListenerConfig{
+		Port:           port,
+		CertFile:       certFile,
+		KeyFile:        keyFile,
+		ReloadInterval: reloadInterval,
+	}, nil
+}
+
+func (c TlsListenerConfig) Enabled() bool {
+	return c.Port != 0
+}
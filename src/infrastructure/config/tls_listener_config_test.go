@@ -0,0 +1,89 @@
+package config
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLoadTlsListenerConfig(t *testing.T) {
+	tests := []struct {
+		name           string
+		envVars        map[string]string
+		expectedConfig TlsListenerConfig
+		expectErr      bool
+	}{
+		{
+			name:           "Disabled",
+			envVars:        map[string]string{},
+			expectedConfig: TlsListenerConfig{},
+		},
+		{
+			name: "Default reload interval",
+			envVars: map[string]string{
+				"HTTPS_LISTENER_PORT": "8443",
+				"TLS_CERT_FILE":       "/certs/tls.crt",
+				"TLS_KEY_FILE":        "/certs/tls.key",
+			},
+			expectedConfig: TlsListenerConfig{
+				Port:           8443,
+				CertFile:       "/certs/tls.crt",
+				KeyFile:        "/certs/tls.key",
+				ReloadInterval: time.Minute,
+			},
+		},
+		{
+			name: "Custom reload interval",
+			envVars: map[string]string{
+				"HTTPS_LISTENER_PORT":          "8443",
+				"TLS_CERT_FILE":                "/certs/tls.crt",
+				"TLS_KEY_FILE":                 "/certs/tls.key",
+				"TLS_CERT_RELOAD_INTERVAL_SEC": "5",
+			},
+			expectedConfig: TlsListenerConfig{
+				Port:           8443,
+				CertFile:       "/certs/tls.crt",
+				KeyFile:        "/certs/tls.key",
+				ReloadInterval: 5 * time.Second,
+			},
+		},
+		{
+			name: "Missing key file",
+			envVars: map[string]string{
+				"HTTPS_LISTENER_PORT": "8443",
+				"TLS_CERT_FILE":       "/certs/tls.crt",
+			},
+			expectErr: true,
+		},
+		{
+			name: "Invalid port",
+			envVars: map[string]string{
+				"HTTPS_LISTENER_PORT": "https",
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.envVars {
+				_ = os.Setenv(key, value)
+			}
+
+			config, err := LoadTlsListenerConfig()
+			if tt.expectErr && err == nil {
+				t.Errorf("expected an error, got nil")
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("expected no error, got: %v", err)
+			}
+			if config != tt.expectedConfig {
+				t.Errorf("expected %+v, got %+v", tt.expectedConfig, config)
+			}
+
+			for key := range tt.envVars {
+				_ = os.Unsetenv(key)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultUpstreamProxyHealthCheckInterval = 30 * time.Second
+
+// UpstreamProxyConfig holds parent proxies which users' traffic is chained through instead of dialing directly.
+type UpstreamProxyConfig struct {
+	Groups              map[string][]*url.URL // Parent proxies by group name, used in round-robin order
+	Users               map[int]string        // Group name by user id
+	Plans               map[int]string        // Group name by plan id, used if the user has no own group
+	Countries           map[string]string     // Group name by lowercase country code, used by clients asking for a country
+	HealthCheckInterval time.Duration         // How often parents are checked for availability
+}
+
+// LoadUpstreamProxyConfig reads parent proxies configuration from environment variables.
+// It expects:
+// - UPSTREAM_PROXY_GROUPS as "name=url,url;name=url" where url is http://[user:pass@]host:port
+// or socks5://[user:pass@]host:port (optional; chaining is disabled if not set)
+// - UPSTREAM_PROXY_USERS as "userId=name,userId=name" (optional)
+// - UPSTREAM_PROXY_PLANS as "planId=name,planId=name" (optional)
+// - UPSTREAM_PROXY_COUNTRIES as "code=name,code=name" with ISO 3166-1 alpha-2 codes (optional)
+// - UPSTREAM_PROXY_HEALTH_CHECK_INTERVAL_SEC (optional; defaults to 30 seconds)
+func LoadUpstreamProxyConfig() (UpstreamProxyConfig, error) {
+	groupsStr := os.Getenv("UPSTREAM_PROXY_GROUPS")
+	if groupsStr == "" {
+		return UpstreamProxyConfig{}, nil
+	}
+
+	groups, err := parseUpstreamProxyGroups(groupsStr)
+	if err != nil {
+		return UpstreamProxyConfig{}, err
+	}
+
+	users, err := parseUpstreamProxyBindings("UPSTREAM_PROXY_USERS", groups)
+	if err != nil {
+		return UpstreamProxyConfig{}, err
+	}
+
+	plans, err := parseUpstreamProxyBindings("UPSTREAM_PROXY_PLANS", groups)
+	if err != nil {
+		return UpstreamProxyConfig{}, err
+	}
+
+	countries, err := parseUpstreamProxyCountries(groups)
+	if err != nil {
+		return UpstreamProxyConfig{}, err
+	}
+
+	healthCheckInterval := defaultUpstreamProxyHealthCheckInterval
+	healthCheckIntervalStr := os.Getenv("UPSTREAM_PROXY_HEALTH_CHECK_INTERVAL_SEC")
+	if healthCheckIntervalStr != "" {
+		healthCheckIntervalSec, parseErr := strconv.Atoi(healthCheckIntervalStr)
+		if parseErr != nil || healthCheckIntervalSec <= 0 {
+			return UpstreamProxyConfig{}, fmt.Errorf("invalid UPSTREAM_PROXY_HEALTH_CHECK_INTERVAL_SEC value: %s", healthCheckIntervalStr)
+		}
+		healthCheckInterval = time.Duration(healthCheckIntervalSec) * time.Second
+	}
+
+	return UpstreamProxyConfig{
+		Groups:              groups,
+		Users:               users,
+		Plans:               plans,
+		Countries:           countries,
+		HealthCheckInterval: healthCheckInterval,
+	}, nil
+}
+
+func (c UpstreamProxyConfig) Enabled() bool {
+	return len(c.Groups) != 0
+}
+
+func parseUpstreamProxyGroups(groupsStr string) (map[string][]*url.URL, error) {
+	groups := make(map[string][]*url.URL)
+
+	for _, groupStr := range strings.Split(groupsStr, ";") {
+		name, urlsStr, ok := strings.Cut(strings.TrimSpace(groupStr), "=")
+		if !ok || name == "" || urlsStr == "" {
+			return nil, fmt.Errorf("invalid UPSTREAM_PROXY_GROUPS group: %s", groupStr)
+		}
+
+		for _, urlStr := range strings.Split(urlsStr, ",") {
+			parent, err := url.Parse(strings.TrimSpace(urlStr))
+			if err != nil {
+				return nil, fmt.Errorf("invalid UPSTREAM_PROXY_GROUPS url in group %s: %v", name, err)
+			}
+			if parent.Scheme != "http" && parent.Scheme != "socks5" {
+				return nil, fmt.Errorf("unsupported UPSTREAM_PROXY_GROUPS url scheme in group %s: %s", name, parent.Scheme)
+			}
+			if parent.Port() == "" {
+				return nil, fmt.Errorf("UPSTREAM_PROXY_GROUPS url port is not set in group %s: %s", name, parent.Redacted())
+			}
+
+			groups[name] = append(groups[name], parent)
+		}
+	}
+
+	return groups, nil
+}
+
+// parseUpstreamProxyBindings parses "id=name" pairs of envVarName and checks that every group exists.
+func parseUpstreamProxyBindings(envVarName string, groups map[string][]*url.URL) (map[int]string, error) {
+	bindings := make(map[int]string)
+
+	bindingsStr := os.Getenv(envVarName)
+	if bindingsStr == "" {
+		return bindings, nil
+	}
+
+	for _, bindingStr := range strings.Split(bindingsStr, ",") {
+		idStr, name, ok := strings.Cut(strings.TrimSpace(bindingStr), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid %s value: %s", envVarName, bindingStr)
+		}
+
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s id: %s", envVarName, idStr)
+		}
+
+		if _, exists := groups[name]; !exists {
+			return nil, fmt.Errorf("%s refers to unknown upstream proxy group: %s", envVarName, name)
+		}
+
+		bindings[id] = name
+	}
+
+	return bindings, nil
+}
+
+// parseUpstreamProxyCountries parses "code=name" pairs of UPSTREAM_PROXY_COUNTRIES and checks that every group exists.
+func parseUpstreamProxyCountries(groups map[string][]*url.URL) (map[string]string, error) {
+	countries := make(map[string]string)
+
+	countriesStr := os.Getenv("UPSTREAM_PROXY_COUNTRIES")
+	if countriesStr == "" {
+		return countries, nil
+	}
+
+	for _, countryStr := range strings.Split(countriesStr, ",") {
+		code, name, ok := strings.Cut(strings.TrimSpace(countryStr), "=")
+		if !ok || len(code) != 2 {
+			return nil, fmt.Errorf("invalid UPSTREAM_PROXY_COUNTRIES value: %s", countryStr)
+		}
+
+		if _, exists := groups[name]; !exists {
+			return nil, fmt.Errorf("UPSTREAM_PROXY_COUNTRIES refers to unknown upstream proxy group: %s", name)
+		}
+
+		countries[strings.ToLower(code)] = name
+	}
+
+	return countries, nil
+}
@@ -0,0 +1,37 @@
+package AuthorizedNetworksChangedEvent
+
+import (
+	"encoding/json"
+	"fmt"
+	"goproxy/application"
+	"goproxy/application/contracts"
+	"goproxy/domain/events"
+	"log"
+)
+
+type Handler struct {
+	index contracts.AuthorizedNetworkIndex
+}
+
+func NewAuthorizedNetworksChangedEventHandler(index contracts.AuthorizedNetworkIndex) application.EventHandler {
+	return &Handler{
+		index: index,
+	}
+}
+
+func (h *Handler) Handle(payload string) error {
+	var authorizedNetworksChangedEvent events.AuthorizedNetworksChangedEvent
+	deserializationErr := json.Unmarshal([]byte(payload), &authorizedNetworksChangedEvent)
+	if deserializationErr != nil {
+		return fmt.Errorf("invalid event: %v", deserializationErr)
+	}
+
+	// networks of all users are reloaded, so that events missed before are caught up as well
+	reloadErr := h.index.Reload()
+	if reloadErr != nil {
+		log.Printf("AuthorizedNetworksChangedEvent handling: networks of user %d were not reloaded: %s",
+			authorizedNetworksChangedEvent.UserId, reloadErr)
+	}
+
+	return nil
+}
@@ -4,11 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"goproxy/application/contracts"
+	"goproxy/domain"
 	"goproxy/domain/aggregates"
 	"goproxy/domain/dataobjects"
 	"goproxy/domain/events"
 	"goproxy/infrastructure/dto"
 	"io"
+	"log"
 	"net/http"
 	"time"
 )
@@ -83,9 +85,29 @@ func (p *PlanAssignedHandler) Handle(payload string) error {
 		return fmt.Errorf("could not create new plan record in user_plan table: %d", event.PlanId)
 	}
 
+	produceErr := p.produceUserPlanLimitsChangedEvent(userResult.Id, plan)
+	if produceErr != nil {
+		log.Printf("could not produce user plan limits changed event: %s", produceErr)
+	}
+
 	_ = p.userPlanCache.Expire(fmt.Sprintf("user:%d:plan", userResult.Id), time.Nanosecond)
 	_ = p.trafficCache.Expire(fmt.Sprintf("user:%d:traffic", userResult.Id), time.Nanosecond)
 	_ = p.trafficCache.Expire(fmt.Sprintf("user:%d:restricted", userResult.Id), time.Nanosecond)
 
 	return nil
 }
+
+func (p *PlanAssignedHandler) produceUserPlanLimitsChangedEvent(userId int, plan aggregates.Plan) error {
+	userPlanLimitsChanged := events.NewUserPlanLimitsChanged(userId, plan.Id(), plan.MaxBytesPerSecond(), plan.MaxConnections())
+	data, serializationErr := json.Marshal(userPlanLimitsChanged)
+	if serializationErr != nil {
+		return serializationErr
+	}
+
+	outboxEvent, outboxEventValidationErr := events.NewOutboxEvent(0, string(data), false, "UserPlanLimitsChanged")
+	if outboxEventValidationErr != nil {
+		return outboxEventValidationErr
+	}
+
+	return p.messageBus.Produce(fmt.Sprintf("%s", domain.PROXY), outboxEvent)
+}
@@ -0,0 +1,36 @@
+package ProxyCredentialChangedEvent
+
+import (
+	"encoding/json"
+	"fmt"
+	"goproxy/application"
+	"goproxy/application/contracts"
+	"goproxy/domain/aggregates"
+	"goproxy/domain/events"
+	"log"
+)
+
+type Handler struct {
+	cache contracts.Cache[aggregates.ProxyCredential]
+}
+
+func NewProxyCredentialChangedEventHandler(cache contracts.Cache[aggregates.ProxyCredential]) application.EventHandler {
+	return &Handler{
+		cache: cache,
+	}
+}
+
+func (h *Handler) Handle(payload string) error {
+	var proxyCredentialChangedEvent events.ProxyCredentialChangedEvent
+	deserializationErr := json.Unmarshal([]byte(payload), &proxyCredentialChangedEvent)
+	if deserializationErr != nil {
+		return fmt.Errorf("invalid event: %v", deserializationErr)
+	}
+
+	deleteErr := h.cache.Delete(fmt.Sprintf("%v", proxyCredentialChangedEvent.CredentialId))
+	if deleteErr != nil {
+		log.Printf("ProxyCredentialChangedEvent handling: cache key was not removed: %s", deleteErr)
+	}
+
+	return nil
+}
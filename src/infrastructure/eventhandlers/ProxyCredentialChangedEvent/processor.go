@@ -0,0 +1,64 @@
+package ProxyCredentialChangedEvent
+
+import (
+	"context"
+	"fmt"
+	"goproxy/application"
+	"goproxy/application/contracts"
+	"goproxy/domain"
+	"goproxy/domain/aggregates"
+	"goproxy/infrastructure/config"
+	"goproxy/infrastructure/services"
+	"log"
+)
+
+type Processor struct {
+	boundedContext domain.BoundedContexts
+	cache          contracts.Cache[aggregates.ProxyCredential]
+}
+
+func NewProxyCredentialChangedEventProcessor(boundedContext domain.BoundedContexts,
+	cache contracts.Cache[aggregates.ProxyCredential]) *Processor {
+	return &Processor{
+		boundedContext: boundedContext,
+		cache:          cache,
+	}
+}
+
+// ProcessEvents handles events in background until ctx is done.
+func (p *Processor) ProcessEvents(ctx context.Context) error {
+	kafkaConfig, kafkaConfigErr := config.NewKafkaConfig(p.boundedContext)
+	if kafkaConfigErr != nil {
+		return kafkaConfigErr
+	}
+
+	kafkaConf := config.KafkaConfig{
+		BootstrapServers: kafkaConfig.BootstrapServers,
+		GroupID:          "ProxyCredentialChangedEventProcessor",
+		AutoOffsetReset:  kafkaConfig.AutoOffsetReset,
+		Topic:            kafkaConfig.Topic,
+	}
+
+	kafka, kafkaErr := services.NewKafkaService(kafkaConf)
+	if kafkaErr != nil {
+		return kafkaErr
+	}
+
+	eventHandler := NewProxyCredentialChangedEventHandler(p.cache)
+	eventProcessor := application.NewEventProcessor(kafka).
+		RegisterTopic(fmt.Sprintf("%s", p.boundedContext)).
+		RegisterHandler("ProxyCredentialChangedEvent", eventHandler)
+
+	if buildErr := eventProcessor.Build(); buildErr != nil {
+		return buildErr
+	}
+
+	go func() {
+		processingErr := eventProcessor.Start(ctx)
+		if processingErr != nil {
+			log.Fatal(processingErr)
+		}
+	}()
+
+	return nil
+}
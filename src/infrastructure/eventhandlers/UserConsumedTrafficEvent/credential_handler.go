@@ -0,0 +1,70 @@
+package UserConsumedTrafficEvent
+
+import (
+	"encoding/json"
+	"fmt"
+	"goproxy/application"
+	"goproxy/application/contracts"
+	"goproxy/domain"
+	"goproxy/domain/events"
+	"log"
+)
+
+// CredentialHandler adds traffic consumed with proxy credentials to their used traffic
+// and tells proxy nodes to reload the credentials running out of their traffic quota.
+type CredentialHandler struct {
+	credentialRepository contracts.ProxyCredentialRepository
+	messageBus           contracts.MessageBusService
+}
+
+func NewCredentialTrafficEventHandler(credentialRepository contracts.ProxyCredentialRepository,
+	messageBus contracts.MessageBusService) application.EventHandler {
+	return &CredentialHandler{
+		credentialRepository: credentialRepository,
+		messageBus:           messageBus,
+	}
+}
+
+func (c *CredentialHandler) Handle(payload string) error {
+	var event events.UserConsumedTrafficEvent
+	err := json.Unmarshal([]byte(payload), &event)
+	if err != nil {
+		return fmt.Errorf("invalid event: %v", err)
+	}
+
+	// traffic of the account password is only billed to the user plan
+	if event.CredentialId == 0 {
+		return nil
+	}
+
+	consumed := event.InBytes + event.OutBytes
+	credential, err := c.credentialRepository.AddUsedBytes(event.CredentialId, consumed)
+	if err != nil {
+		log.Printf("could not add traffic of proxy credential %d: %s", event.CredentialId, err)
+		return nil
+	}
+
+	// the credential is reloaded once, when the traffic crosses the quota
+	if credential.QuotaExceeded() && credential.UsedBytes()-consumed < credential.TrafficQuotaBytes() {
+		produceErr := c.produceProxyCredentialChangedEvent(credential.Id())
+		if produceErr != nil {
+			log.Printf("could not produce proxy credential changed event: %s", produceErr)
+		}
+	}
+
+	return nil
+}
+
+func (c *CredentialHandler) produceProxyCredentialChangedEvent(credentialId int) error {
+	data, serializationErr := json.Marshal(events.ProxyCredentialChangedEvent{CredentialId: credentialId})
+	if serializationErr != nil {
+		return serializationErr
+	}
+
+	outboxEvent, outboxEventValidationErr := events.NewOutboxEvent(0, string(data), false, "ProxyCredentialChangedEvent")
+	if outboxEventValidationErr != nil {
+		return outboxEventValidationErr
+	}
+
+	return c.messageBus.Produce(fmt.Sprintf("%s", domain.PROXY), outboxEvent)
+}
@@ -0,0 +1,59 @@
+package UserConsumedTrafficEvent
+
+import (
+	"context"
+	"fmt"
+	"goproxy/application"
+	"goproxy/application/contracts"
+	"goproxy/domain"
+	"goproxy/infrastructure/config"
+	"goproxy/infrastructure/services"
+	"log"
+)
+
+// CredentialProcessor accounts traffic of proxy credentials, it runs next to the database storing them.
+type CredentialProcessor struct {
+	boundedContext       domain.BoundedContexts
+	credentialRepository contracts.ProxyCredentialRepository
+}
+
+func NewCredentialTrafficEventProcessor(boundedContext domain.BoundedContexts,
+	credentialRepository contracts.ProxyCredentialRepository) *CredentialProcessor {
+	return &CredentialProcessor{
+		boundedContext:       boundedContext,
+		credentialRepository: credentialRepository,
+	}
+}
+
+// ProcessEvents handles events in background until ctx is done.
+func (c *CredentialProcessor) ProcessEvents(ctx context.Context) error {
+	kafkaConfig, kafkaConfigErr := config.NewKafkaConfig(c.boundedContext)
+	if kafkaConfigErr != nil {
+		return kafkaConfigErr
+	}
+
+	kafkaConfig.GroupID = "CredentialTrafficEventProcessor"
+
+	kafka, kafkaErr := services.NewKafkaService(kafkaConfig)
+	if kafkaErr != nil {
+		return kafkaErr
+	}
+
+	eventHandler := NewCredentialTrafficEventHandler(c.credentialRepository, kafka)
+	eventProcessor := application.NewEventProcessor(kafka).
+		RegisterTopic(fmt.Sprintf("%s", c.boundedContext)).
+		RegisterHandler("UserConsumedTrafficEvent", eventHandler)
+
+	if buildErr := eventProcessor.Build(); buildErr != nil {
+		return buildErr
+	}
+
+	go func() {
+		processingErr := eventProcessor.Start(ctx)
+		if processingErr != nil {
+			log.Fatal(processingErr)
+		}
+	}()
+
+	return nil
+}
@@ -104,6 +104,12 @@ func (u *Handler) loadFromDB(userId int) (dataobjects.UserTraffic, error) {
 
 	_ = u.cache.Expire(u.cacheKey(userId), 24*time.Hour*time.Duration(activePlan.DurationDays()))
 
+	// traffic cache miss means proxy nodes may not know the plan limits yet (e.g. after restart)
+	produceErr := u.produceUserPlanLimitsChangedEvent(userId, activePlan)
+	if produceErr != nil {
+		log.Printf("could not produce user plan limits changed event: %s", produceErr)
+	}
+
 	return userTraffic, nil
 }
 
@@ -172,3 +178,18 @@ func (u *Handler) produceUserExceededTrafficLimitEvent(userId int) error {
 
 	return nil
 }
+
+func (u *Handler) produceUserPlanLimitsChangedEvent(userId int, plan aggregates.Plan) error {
+	userPlanLimitsChanged := events.NewUserPlanLimitsChanged(userId, plan.Id(), plan.MaxBytesPerSecond(), plan.MaxConnections())
+	data, serializationErr := json.Marshal(userPlanLimitsChanged)
+	if serializationErr != nil {
+		return serializationErr
+	}
+
+	outboxEvent, outboxEventValidationErr := events.NewOutboxEvent(0, string(data), false, "UserPlanLimitsChanged")
+	if outboxEventValidationErr != nil {
+		return outboxEventValidationErr
+	}
+
+	return u.messageBus.Produce(fmt.Sprintf("%s", domain.PROXY), outboxEvent)
+}
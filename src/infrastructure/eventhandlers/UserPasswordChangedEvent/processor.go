@@ -23,7 +23,8 @@ func NewUserPasswordChangedEventProcessor[T any](boundedContext domain.BoundedCo
 	}
 }
 
-func (c *Processor[T]) ProcessEvents() error {
+// ProcessEvents handles events in background until ctx is done.
+func (c *Processor[T]) ProcessEvents(ctx context.Context) error {
 	kafkaConfig, kafkaConfigErr := config.NewKafkaConfig(c.boundedContext)
 	if kafkaConfigErr != nil {
 		return kafkaConfigErr
@@ -51,7 +52,7 @@ func (c *Processor[T]) ProcessEvents() error {
 	}
 
 	go func() {
-		processingErr := repoEventProcessor.Start(context.Background())
+		processingErr := repoEventProcessor.Start(ctx)
 		if processingErr != nil {
 			log.Fatal(processingErr)
 		}
@@ -0,0 +1,39 @@
+package UserPlanLimitsChangedEvent
+
+import (
+	"encoding/json"
+	"fmt"
+	"goproxy/application"
+	"goproxy/application/contracts"
+	"goproxy/domain/dataobjects"
+	"goproxy/domain/events"
+)
+
+type Handler struct {
+	planLimitsService contracts.UserPlanLimitsService
+}
+
+func NewUserPlanLimitsChangedEventHandler(planLimitsService contracts.UserPlanLimitsService) application.EventHandler {
+	return &Handler{
+		planLimitsService: planLimitsService,
+	}
+}
+
+func (h *Handler) Handle(payload string) error {
+	var event events.UserPlanLimitsChanged
+	err := json.Unmarshal([]byte(payload), &event)
+	if err != nil {
+		return fmt.Errorf("invalid event: %v", err)
+	}
+
+	setErr := h.planLimitsService.SetLimits(event.UserId, dataobjects.UserPlanLimits{
+		PlanId:            event.PlanId,
+		MaxBytesPerSecond: event.MaxBytesPerSecond,
+		MaxConnections:    event.MaxConnections,
+	})
+	if setErr != nil {
+		return fmt.Errorf("could not set user %d plan limits: %v", event.UserId, setErr)
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package UserPlanLimitsChangedEvent
+
+import (
+	"context"
+	"fmt"
+	"goproxy/application"
+	"goproxy/application/contracts"
+	"goproxy/domain"
+	"goproxy/infrastructure/config"
+	"goproxy/infrastructure/services"
+	"log"
+)
+
+type Processor struct {
+	boundedContext    domain.BoundedContexts
+	planLimitsService contracts.UserPlanLimitsService
+}
+
+func NewUserPlanLimitsChangedEventProcessor(boundedContext domain.BoundedContexts,
+	planLimitsService contracts.UserPlanLimitsService) *Processor {
+	return &Processor{
+		boundedContext:    boundedContext,
+		planLimitsService: planLimitsService,
+	}
+}
+
+// ProcessEvents handles events in background until ctx is done.
+func (p *Processor) ProcessEvents(ctx context.Context) error {
+	kafkaConfig, kafkaConfigErr := config.NewKafkaConfig(p.boundedContext)
+	if kafkaConfigErr != nil {
+		return kafkaConfigErr
+	}
+
+	kafkaConf := config.KafkaConfig{
+		BootstrapServers: kafkaConfig.BootstrapServers,
+		GroupID:          "UserPlanLimitsChangedEventProcessor",
+		AutoOffsetReset:  kafkaConfig.AutoOffsetReset,
+		Topic:            kafkaConfig.Topic,
+	}
+
+	kafka, kafkaErr := services.NewKafkaService(kafkaConf)
+	if kafkaErr != nil {
+		return kafkaErr
+	}
+
+	eventHandler := NewUserPlanLimitsChangedEventHandler(p.planLimitsService)
+	eventProcessor := application.NewEventProcessor(kafka).
+		RegisterTopic(fmt.Sprintf("%s", p.boundedContext)).
+		RegisterHandler("UserPlanLimitsChanged", eventHandler)
+
+	if buildErr := eventProcessor.Build(); buildErr != nil {
+		return buildErr
+	}
+
+	go func() {
+		processingErr := eventProcessor.Start(ctx)
+		if processingErr != nil {
+			log.Fatal(processingErr)
+		}
+	}()
+
+	return nil
+}
@@ -1,14 +1,18 @@
 package infrastructure
 
 import (
+	"crypto/tls"
 	"fmt"
 	"goproxy/application/contracts"
 	"log"
 	"net"
+	"time"
 )
 
 type HttpListener struct {
 	httpProxyService contracts.HttpProxyService
+	tlsConfig        *tls.Config
+	handshakeTimeout time.Duration
 }
 
 func NewHttpListener(proxy contracts.HttpProxyService) *HttpListener {
@@ -17,11 +21,57 @@ func NewHttpListener(proxy contracts.HttpProxyService) *HttpListener {
 	}
 }
 
+// NewTlsHttpListener creates a listener which terminates TLS on accepted connections,
+// so clients can talk to the proxy in "HTTPS proxy" mode. HTTP/2 is offered to clients with ALPN.
+func NewTlsHttpListener(proxy contracts.HttpProxyService, tlsConfig *tls.Config) *HttpListener {
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+
+	return &HttpListener{
+		httpProxyService: proxy,
+		tlsConfig:        tlsConfig,
+	}
+}
+
+// WithHandshakeTimeout limits how long accepted clients have to complete the TLS handshake and send their request.
+// Services lift the deadline once the client is served.
+func (l *HttpListener) WithHandshakeTimeout(timeout time.Duration) *HttpListener {
+	l.handshakeTimeout = timeout
+	return l
+}
+
 func (l *HttpListener) Listen(port int) (net.Listener, error) {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return nil, fmt.Errorf("could not start server: %v", err)
 	}
+
+	if l.handshakeTimeout > 0 {
+		listener = &handshakeDeadlineListener{Listener: listener, timeout: l.handshakeTimeout}
+	}
+
+	if l.tlsConfig != nil {
+		log.Printf("Proxy is serving port %d (TLS)", port)
+		return tls.NewListener(listener, l.tlsConfig), nil
+	}
+
 	log.Printf("Proxy is serving port %d", port)
 	return listener, nil
 }
+
+// handshakeDeadlineListener sets a read deadline on accepted connections, so clients that never send a request
+// do not hold connections open.
+type handshakeDeadlineListener struct {
+	net.Listener
+	timeout time.Duration
+}
+
+func (l *handshakeDeadlineListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(l.timeout))
+	return conn, nil
+}
@@ -0,0 +1,172 @@
+package masque
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Protocol constants as defined by RFC 9297 (HTTP Datagrams and the Capsule Protocol)
+// and RFC 9298 (Proxying UDP in HTTP).
+const (
+	// Protocol is the value of the :protocol pseudo-header of extended CONNECT requests proxying UDP.
+	Protocol = "connect-udp"
+
+	// CapsuleProtocolHeader tells the peer that the stream carries capsules.
+	CapsuleProtocolHeader = "Capsule-Protocol"
+
+	CapsuleTypeDatagram = 0x00
+
+	// UdpPayloadContextId marks datagrams carrying a UDP payload.
+	UdpPayloadContextId = 0x00
+
+	// udpTargetPathPrefix starts paths of the default URI template
+	// "/.well-known/masque/udp/{target_host}/{target_port}/".
+	udpTargetPathPrefix = "/.well-known/masque/udp/"
+)
+
+var (
+	ErrInvalidTargetPath = errors.New("masque: invalid connect-udp target path")
+	ErrCapsuleTooLarge   = errors.New("masque: capsule exceeds the maximum size")
+	ErrShortDatagram     = errors.New("masque: datagram is too short")
+)
+
+// ParseUdpTarget returns the host and port of the target from the escaped path of a connect-udp request.
+func ParseUdpTarget(escapedPath string) (string, error) {
+	rest, ok := strings.CutPrefix(escapedPath, udpTargetPathPrefix)
+	if !ok {
+		return "", ErrInvalidTargetPath
+	}
+
+	segments := strings.Split(strings.TrimSuffix(rest, "/"), "/")
+	if len(segments) != 2 {
+		return "", ErrInvalidTargetPath
+	}
+
+	host, err := url.PathUnescape(segments[0])
+	if err != nil || host == "" {
+		return "", ErrInvalidTargetPath
+	}
+
+	port, err := strconv.Atoi(segments[1])
+	if err != nil || port <= 0 || port > 65535 {
+		return "", ErrInvalidTargetPath
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+// ReadCapsule reads a capsule into buf and returns its type and value.
+// Capsules with values larger than buf are rejected, as the stream can not be resynchronized after them.
+func ReadCapsule(r io.Reader, buf []byte) (uint64, []byte, error) {
+	capsuleType, err := readVarint(r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length, err := readVarint(r)
+	if err != nil {
+		return 0, nil, unexpectedEOF(err)
+	}
+	if length > uint64(len(buf)) {
+		return 0, nil, ErrCapsuleTooLarge
+	}
+
+	value := buf[:length]
+	if _, err = io.ReadFull(r, value); err != nil {
+		return 0, nil, unexpectedEOF(err)
+	}
+
+	return capsuleType, value, nil
+}
+
+// ParseDatagram returns the context ID and the payload of an HTTP Datagram.
+func ParseDatagram(datagram []byte) (uint64, []byte, error) {
+	contextId, n, err := parseVarint(datagram)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return contextId, datagram[n:], nil
+}
+
+// AppendUdpDatagramCapsule appends a DATAGRAM capsule carrying the UDP payload.
+func AppendUdpDatagramCapsule(buf []byte, payload []byte) []byte {
+	buf = AppendVarint(buf, CapsuleTypeDatagram)
+	buf = AppendVarint(buf, uint64(varintLen(UdpPayloadContextId)+len(payload)))
+	buf = AppendVarint(buf, UdpPayloadContextId)
+	return append(buf, payload...)
+}
+
+// AppendVarint appends v as a QUIC variable-length integer (RFC 9000, section 16).
+// Values above 2^62-1 can not be encoded and must not be passed.
+func AppendVarint(buf []byte, v uint64) []byte {
+	switch varintLen(v) {
+	case 1:
+		return append(buf, byte(v))
+	case 2:
+		return append(buf, byte(v>>8)|0x40, byte(v))
+	case 4:
+		return append(buf, byte(v>>24)|0x80, byte(v>>16), byte(v>>8), byte(v))
+	}
+
+	return append(buf, byte(v>>56)|0xC0, byte(v>>48), byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func varintLen(v uint64) int {
+	switch {
+	case v < 1<<6:
+		return 1
+	case v < 1<<14:
+		return 2
+	case v < 1<<30:
+		return 4
+	}
+
+	return 8
+}
+
+func readVarint(r io.Reader) (uint64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:1]); err != nil {
+		return 0, err
+	}
+
+	length := 1 << (buf[0] >> 6)
+	if _, err := io.ReadFull(r, buf[1:length]); err != nil {
+		return 0, unexpectedEOF(err)
+	}
+
+	v, _, err := parseVarint(buf[:length])
+	return v, err
+}
+
+func parseVarint(b []byte) (uint64, int, error) {
+	if len(b) == 0 {
+		return 0, 0, ErrShortDatagram
+	}
+
+	length := 1 << (b[0] >> 6)
+	if len(b) < length {
+		return 0, 0, ErrShortDatagram
+	}
+
+	v := uint64(b[0] & 0x3F)
+	for _, octet := range b[1:length] {
+		v = v<<8 | uint64(octet)
+	}
+
+	return v, length, nil
+}
+
+// unexpectedEOF reports streams ended in the middle of a capsule.
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
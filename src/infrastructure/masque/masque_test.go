@@ -0,0 +1,89 @@
+package masque
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUdpTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+		wantErr  bool
+	}{
+		{name: "domain", path: "/.well-known/masque/udp/example.com/443/", expected: "example.com:443"},
+		{name: "ipv4 without trailing slash", path: "/.well-known/masque/udp/192.0.2.6/53", expected: "192.0.2.6:53"},
+		{name: "escaped ipv6", path: "/.well-known/masque/udp/2001%3Adb8%3A%3A42/443/", expected: "[2001:db8::42]:443"},
+		{name: "other prefix", path: "/masque/udp/example.com/443/", wantErr: true},
+		{name: "missing port", path: "/.well-known/masque/udp/example.com/", wantErr: true},
+		{name: "invalid port", path: "/.well-known/masque/udp/example.com/65536/", wantErr: true},
+		{name: "extra segment", path: "/.well-known/masque/udp/example.com/443/x/", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, err := ParseUdpTarget(tt.path)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidTargetPath)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, target)
+		})
+	}
+}
+
+func TestVarintRoundTrip(t *testing.T) {
+	for _, v := range []uint64{0, 63, 64, 16383, 16384, 1<<30 - 1, 1 << 30, 1<<62 - 1} {
+		encoded := AppendVarint(nil, v)
+		assert.Len(t, encoded, varintLen(v))
+
+		decoded, err := readVarint(bytes.NewReader(encoded))
+		require.NoError(t, err)
+		assert.Equal(t, v, decoded)
+	}
+
+	// the example of RFC 9000, appendix A.1
+	decoded, err := readVarint(bytes.NewReader([]byte{0x7B, 0xBD}))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(15293), decoded)
+}
+
+func TestUdpDatagramCapsuleRoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte("x"), 100)
+	stream := bytes.NewReader(AppendUdpDatagramCapsule(AppendUdpDatagramCapsule(nil, payload), []byte("y")))
+
+	buf := make([]byte, 1024)
+	for _, expected := range [][]byte{payload, []byte("y")} {
+		capsuleType, value, err := ReadCapsule(stream, buf)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(CapsuleTypeDatagram), capsuleType)
+
+		contextId, datagram, err := ParseDatagram(value)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(UdpPayloadContextId), contextId)
+		assert.Equal(t, expected, datagram)
+	}
+
+	_, _, err := ReadCapsule(stream, buf)
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestReadCapsule_Invalid(t *testing.T) {
+	capsule := AppendUdpDatagramCapsule(nil, bytes.Repeat([]byte("x"), 100))
+
+	_, _, err := ReadCapsule(bytes.NewReader(capsule), make([]byte, 10))
+	assert.ErrorIs(t, err, ErrCapsuleTooLarge)
+
+	_, _, err = ReadCapsule(bytes.NewReader(capsule[:50]), make([]byte, 1024))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	_, _, err = ParseDatagram(nil)
+	assert.ErrorIs(t, err, ErrShortDatagram)
+}
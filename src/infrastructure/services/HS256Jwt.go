@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/golang-jwt/jwt/v4"
 	"goproxy/application/contracts"
+	"strconv"
 	"time"
 )
 
@@ -34,8 +35,16 @@ func (j HS256Jwt) Generate(secret string, ttl time.Duration, claims map[string]s
 }
 
 func (j HS256Jwt) Validate(secret string, jwtToken string) (bool, error) {
+	if _, err := j.Parse(secret, jwtToken); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (j HS256Jwt) Parse(secret string, jwtToken string) (map[string]string, error) {
 	if len(secret) > 2 && secret[:2] == "ey" {
-		return false, fmt.Errorf(
+		return nil, fmt.Errorf(
 			"expected secret key but received JWT token. Did you mix up token and secret",
 		)
 	}
@@ -48,21 +57,31 @@ func (j HS256Jwt) Validate(secret string, jwtToken string) (bool, error) {
 	})
 
 	if err != nil {
-		return false, fmt.Errorf("failed to parse token: %w", err)
+		return nil, fmt.Errorf("failed to parse token: %w", err)
 	}
 
 	if !token.Valid {
-		return false, fmt.Errorf("invalid token")
+		return nil, fmt.Errorf("invalid token")
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok {
-		if exp, expPresent := claims["exp"].(float64); expPresent {
+	claims := make(map[string]string)
+	if tokenClaims, ok := token.Claims.(jwt.MapClaims); ok {
+		if exp, expPresent := tokenClaims["exp"].(float64); expPresent {
 			expTime := time.Unix(int64(exp), 0)
 			if time.Now().After(expTime) {
-				return false, fmt.Errorf("token has expired at %v", expTime)
+				return nil, fmt.Errorf("token has expired at %v", expTime)
+			}
+		}
+
+		for k, v := range tokenClaims {
+			switch value := v.(type) {
+			case string:
+				claims[k] = value
+			case float64:
+				claims[k] = strconv.FormatFloat(value, 'f', -1, 64)
 			}
 		}
 	}
 
-	return true, nil
+	return claims, nil
 }
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"strings"
 	"testing"
 	"time"
 )
@@ -72,3 +73,29 @@ func TestJWTService_ValidateWithWrongSecret(t *testing.T) {
 		t.Fatalf("Expected token validation to fail with wrong secret, but it passed")
 	}
 }
+
+func TestJWTService_Parse(t *testing.T) {
+	jwtService := NewHS256Jwt()
+	secret := "my_test_secret"
+
+	token, err := jwtService.Generate(secret, time.Hour, map[string]string{"sub": "42", "iss": "goproxy"})
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	claims, err := jwtService.Parse(secret, token)
+	if err != nil {
+		t.Fatalf("Parsing failed: %v", err)
+	}
+
+	if claims["sub"] != "42" || claims["iss"] != "goproxy" {
+		t.Errorf("Expected generated claims, got %v", claims)
+	}
+	if claims["exp"] == "" || strings.ContainsAny(claims["exp"], "e.") {
+		t.Errorf("Expected expiry as a decimal number, got %q", claims["exp"])
+	}
+
+	if _, err = jwtService.Parse("wrong_secret", token); err == nil {
+		t.Errorf("Expected token signed with another secret to be refused")
+	}
+}
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"golang.org/x/sync/singleflight"
 	"goproxy/application/contracts"
@@ -11,7 +12,11 @@ import (
 	"time"
 )
 
-const defaultValidateCacheTTL = time.Hour * 8
+const (
+	defaultValidateCacheTTL = time.Hour * 8
+	// defaultInvalidCacheTTL is how long a wrong password is refused without validating it again
+	defaultInvalidCacheTTL = time.Minute * 5
+)
 
 type ValidateResult struct {
 	result bool
@@ -22,6 +27,7 @@ type AuthService struct {
 	cryptoService     contracts.CryptoService
 	validateCache     contracts.CacheWithTTL[ValidateResult]
 	validateCacheTTL  time.Duration
+	invalidCacheTTL   time.Duration
 	singleFlightGroup singleflight.Group
 }
 
@@ -35,18 +41,42 @@ func NewAuthService(cryptoService contracts.CryptoService, cache contracts.Cache
 		}
 	}
 
+	invalidCacheTTL := defaultInvalidCacheTTL
+	invalidTtlEnv := os.Getenv("AUTH_SERVICE_INVALID_TTL_MS")
+	if invalidTtlEnv != "" {
+		ttlMillis, err := strconv.Atoi(invalidTtlEnv)
+		if err == nil {
+			invalidCacheTTL = time.Duration(ttlMillis) * time.Millisecond
+		}
+	}
+
 	service := AuthService{
 		cryptoService:    cryptoService,
 		validateCache:    cache,
 		validateCacheTTL: validateCacheTTL,
+		invalidCacheTTL:  invalidCacheTTL,
 	}
 
 	return &service
 }
 
+// AuthorizeBasic validates the password of the user. Results are cached by a digest of the password,
+// so a password validated once does not authorize others, and a wrong one is refused without running Argon2 again.
 func (a *AuthService) AuthorizeBasic(user aggregates.User, credentials valueobjects.BasicCredentials) (bool, error) {
-	cacheKey := fmt.Sprintf("%s:%x", credentials.Username, user.PasswordHash())
+	cacheKey := fmt.Sprintf("%s:%x:%x", credentials.Username, user.PasswordHash(), sha256.Sum256([]byte(credentials.Password)))
+
+	return a.validate(cacheKey, user.PasswordHash(), credentials.Password)
+}
+
+// AuthorizeCredential validates the key of the proxy credential. Results are cached by a digest of the key,
+// so a credential validated once is not authorized by other keys.
+func (a *AuthService) AuthorizeCredential(credential aggregates.ProxyCredential, key string) (bool, error) {
+	cacheKey := fmt.Sprintf("credential:%d:%x", credential.Id(), sha256.Sum256([]byte(key)))
+
+	return a.validate(cacheKey, credential.KeyHash(), key)
+}
 
+func (a *AuthService) validate(cacheKey, hash, password string) (bool, error) {
 	// Critical section: ValidateHash involves computationally expensive Argon2 logic.
 	// Using singleflight ensures that password hash validation for a single user
 	// is performed exactly once at a time, reducing redundant processing.
@@ -56,9 +86,14 @@ func (a *AuthService) AuthorizeBasic(user aggregates.User, credentials valueobje
 			return ValidateResult{cached.result, cached.err}, nil
 		}
 
-		isPasswordValid := a.cryptoService.ValidateHash(user.PasswordHash(), credentials.Password)
+		isPasswordValid := a.cryptoService.ValidateHash(hash, password)
 		if !isPasswordValid {
-			return ValidateResult{false, fmt.Errorf("invalid credentials")}, nil
+			invalidResult := ValidateResult{false, fmt.Errorf("invalid credentials")}
+			if a.invalidCacheTTL > 0 {
+				_ = a.validateCache.Set(cacheKey, invalidResult)
+				_ = a.validateCache.Expire(cacheKey, a.invalidCacheTTL)
+			}
+			return invalidResult, nil
 		}
 
 		_ = a.validateCache.Set(cacheKey, ValidateResult{true, nil})
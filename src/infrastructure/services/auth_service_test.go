@@ -343,3 +343,84 @@ func TestAuthorizeBasic_CacheError(t *testing.T) {
 	assert.NoError(t, err)
 	assert.True(t, result)
 }
+
+func TestAuthorizeBasic_InvalidCredentialsCache(t *testing.T) {
+	var ValidateHashFuncCalls int
+	cryptoService := &mockCryptoService{
+		ValidateHashFunc: func(fullHash, password string) bool {
+			ValidateHashFuncCalls++
+			return password == "password"
+		},
+	}
+	cache := newMockCache()
+
+	username := fmt.Sprintf("test_user_%d", time.Now().UTC().UnixNano())
+	user, _ := aggregates.NewUser(1, username, fmt.Sprintf("%s@example.com", username), sampleValidArgon2idHash)
+
+	authService := AuthService{
+		cryptoService:    cryptoService,
+		validateCache:    cache,
+		validateCacheTTL: time.Second,
+		invalidCacheTTL:  time.Second,
+	}
+
+	wrongCredentials := valueobjects.BasicCredentials{
+		Username: "test_user",
+		Password: "wrong_password",
+	}
+
+	// Repeated wrong password is refused from cache without validating it again.
+	for i := 0; i < 3; i++ {
+		result, err := authService.AuthorizeBasic(user, wrongCredentials)
+		assert.Error(t, err)
+		assert.False(t, result)
+	}
+	assert.Equal(t, 1, ValidateHashFuncCalls)
+
+	// The cached wrong password does not refuse the right one.
+	result, err := authService.AuthorizeBasic(user, valueobjects.BasicCredentials{Username: "test_user", Password: "password"})
+	assert.NoError(t, err)
+	assert.True(t, result)
+	assert.Equal(t, 2, ValidateHashFuncCalls)
+
+	// The cached right password does not authorize other passwords.
+	result, err = authService.AuthorizeBasic(user, valueobjects.BasicCredentials{Username: "test_user", Password: "another_password"})
+	assert.Error(t, err)
+	assert.False(t, result)
+	assert.Equal(t, 3, ValidateHashFuncCalls)
+}
+
+// Results used to be cached by the username and the password hash only, so after one successful login
+// any password of the user was authorized from the cache.
+func TestAuthorizeBasic_WrongPasswordAfterCachedSuccess(t *testing.T) {
+	var ValidateHashFuncCalls int
+	cryptoService := &mockCryptoService{
+		ValidateHashFunc: func(fullHash, password string) bool {
+			ValidateHashFuncCalls++
+			return password == "password"
+		},
+	}
+
+	username := fmt.Sprintf("test_user_%d", time.Now().UTC().UnixNano())
+	user, _ := aggregates.NewUser(1, username, fmt.Sprintf("%s@example.com", username), sampleValidArgon2idHash)
+
+	authService := AuthService{
+		cryptoService:    cryptoService,
+		validateCache:    newMockCache(),
+		validateCacheTTL: time.Minute,
+	}
+
+	result, err := authService.AuthorizeBasic(user, valueobjects.BasicCredentials{Username: username, Password: "password"})
+	assert.NoError(t, err)
+	assert.True(t, result)
+
+	result, err = authService.AuthorizeBasic(user, valueobjects.BasicCredentials{Username: username, Password: "password"})
+	assert.NoError(t, err)
+	assert.True(t, result)
+	assert.Equal(t, 1, ValidateHashFuncCalls, "the right password is authorized from the cache")
+
+	result, err = authService.AuthorizeBasic(user, valueobjects.BasicCredentials{Username: username, Password: "wrong_password"})
+	assert.Error(t, err)
+	assert.False(t, result)
+	assert.Equal(t, 2, ValidateHashFuncCalls, "a wrong password must be validated, not authorized from the cache")
+}
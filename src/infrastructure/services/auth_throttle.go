@@ -0,0 +1,350 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"goproxy/application/contracts"
+	"goproxy/domain"
+	"goproxy/domain/events"
+	"goproxy/infrastructure/config"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	authThrottleKeyPrefix = "auth_throttle"
+	// authThrottleIpv6PrefixLength groups IPv6 clients by network, as a single client usually owns a whole /64
+	authThrottleIpv6PrefixLength = 64
+	// authThrottleRedisTimeout bounds a single Redis call, so a slow Redis does not stall authentication
+	authThrottleRedisTimeout = 100 * time.Millisecond
+	// authThrottleRedisRetryInterval is how long the local counters are used after Redis became unreachable
+	authThrottleRedisRetryInterval = 5 * time.Second
+)
+
+// authFailScript counts a failure and locks the key out once it failed maxFailures times, the lockout doubles
+// with every further failure. The counter is kept for the window after the lockout ends.
+// KEYS[1] - counter key
+// ARGV - now (ms), max failures, lockout (ms), max lockout (ms), window (ms)
+var authFailScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local maxFailures = tonumber(ARGV[2])
+local lockout = tonumber(ARGV[3])
+local maxLockout = tonumber(ARGV[4])
+local window = tonumber(ARGV[5])
+
+local failures = redis.call('HINCRBY', KEYS[1], 'failures', 1)
+local lockedUntil = tonumber(redis.call('HGET', KEYS[1], 'locked_until')) or 0
+local duration = 0
+if failures >= maxFailures then
+	duration = math.floor(math.min(lockout * math.pow(2, failures - maxFailures), maxLockout))
+	lockedUntil = now + duration
+	redis.call('HSET', KEYS[1], 'locked_until', lockedUntil)
+end
+
+redis.call('PEXPIRE', KEYS[1], window + duration)
+return {failures, lockedUntil}
+`)
+
+// authLockoutPolicy is when and for how long keys of one kind are locked out.
+type authLockoutPolicy struct {
+	maxFailures int
+	lockout     time.Duration
+	maxLockout  time.Duration
+	window      time.Duration
+}
+
+// lockoutFor returns how long a key is locked out for after the failures, 0 if they are not enough.
+func (p authLockoutPolicy) lockoutFor(failures int) time.Duration {
+	if failures < p.maxFailures {
+		return 0
+	}
+
+	lockout := p.lockout
+	for i := p.maxFailures; i < failures && lockout < p.maxLockout; i++ {
+		lockout *= 2
+	}
+	return min(lockout, p.maxLockout)
+}
+
+// authFailureStore keeps failure counters and lockouts of usernames and IPs.
+type authFailureStore interface {
+	// lockedUntil returns when the lockout of the key ends, the zero time if the key was never locked out.
+	lockedUntil(key string, now time.Time) time.Time
+	// fail counts a failure of the key and returns its failures and when its lockout ends.
+	fail(key string, now time.Time, policy authLockoutPolicy) (int, time.Time)
+	reset(key string)
+}
+
+// AuthThrottle locks usernames and source IPs out after too many failed authentication attempts,
+// so passwords cannot be guessed and every guess does not cost an Argon2 validation.
+type AuthThrottle struct {
+	store      authFailureStore
+	userPolicy authLockoutPolicy
+	ipPolicy   authLockoutPolicy
+	messageBus contracts.MessageBusService
+	now        func() time.Time
+}
+
+// NewAuthThrottle creates a throttle keeping counters in memory, or in Redis if the config is distributed,
+// so all nodes count failures together.
+func NewAuthThrottle(throttleConfig config.AuthThrottleConfig) (*AuthThrottle, error) {
+	var store authFailureStore = newLocalAuthFailureStore()
+	if throttleConfig.Distributed {
+		client, err := newRedisClient()
+		if err != nil {
+			return nil, err
+		}
+		store = newRedisAuthFailureStore(client)
+	}
+
+	return newAuthThrottle(store, throttleConfig), nil
+}
+
+func newAuthThrottle(store authFailureStore, throttleConfig config.AuthThrottleConfig) *AuthThrottle {
+	return &AuthThrottle{
+		store: store,
+		userPolicy: authLockoutPolicy{
+			maxFailures: throttleConfig.UserMaxFailures,
+			lockout:     throttleConfig.Lockout,
+			maxLockout:  throttleConfig.MaxLockout,
+			window:      throttleConfig.Window,
+		},
+		ipPolicy: authLockoutPolicy{
+			maxFailures: throttleConfig.IpMaxFailures,
+			lockout:     throttleConfig.Lockout,
+			maxLockout:  throttleConfig.MaxLockout,
+			window:      throttleConfig.Window,
+		},
+		now: time.Now,
+	}
+}
+
+// WithMessageBus makes the throttle produce a UserLockedOutEvent whenever a username or an IP is locked out.
+func (t *AuthThrottle) WithMessageBus(messageBus contracts.MessageBusService) *AuthThrottle {
+	t.messageBus = messageBus
+	return t
+}
+
+func (t *AuthThrottle) LockedOut(username string, ip net.IP) time.Duration {
+	now := t.now()
+	lockedUntil := t.store.lockedUntil(t.userKey(username), now)
+	if ip != nil {
+		if ipLockedUntil := t.store.lockedUntil(t.ipKey(ip), now); ipLockedUntil.After(lockedUntil) {
+			lockedUntil = ipLockedUntil
+		}
+	}
+
+	return max(lockedUntil.Sub(now), 0)
+}
+
+func (t *AuthThrottle) RegisterFailure(username string, ip net.IP) time.Duration {
+	now := t.now()
+	lockout := t.fail(t.userKey(username), now, t.userPolicy, username, "")
+	if ip != nil {
+		lockout = max(lockout, t.fail(t.ipKey(ip), now, t.ipPolicy, "", ip.String()))
+	}
+
+	return lockout
+}
+
+func (t *AuthThrottle) RegisterSuccess(username string) {
+	t.store.reset(t.userKey(username))
+}
+
+func (t *AuthThrottle) fail(key string, now time.Time, policy authLockoutPolicy, username, ip string) time.Duration {
+	failures, lockedUntil := t.store.fail(key, now, policy)
+	if failures < policy.maxFailures || !lockedUntil.After(now) {
+		return 0
+	}
+
+	log.Printf("%s is locked out until %v after %d failed authentication attempts", key, lockedUntil, failures)
+	if t.messageBus != nil {
+		go t.produceLockedOutEvent(events.NewUserLockedOutEvent(username, ip, failures, lockedUntil))
+	}
+
+	return lockedUntil.Sub(now)
+}
+
+func (t *AuthThrottle) produceLockedOutEvent(event events.UserLockedOutEvent) {
+	serializedEvent, serializationErr := json.Marshal(event)
+	if serializationErr != nil {
+		log.Printf("failed to produce user locked out event - failed to serialize event: %s", serializationErr)
+		return
+	}
+
+	outboxEvent, outboxEventErr := events.NewOutboxEvent(-1, string(serializedEvent), false, "UserLockedOutEvent")
+	if outboxEventErr != nil {
+		log.Printf("failed to produce user locked out event - failed to create outbox event: %s", outboxEventErr)
+		return
+	}
+
+	if produceErr := t.messageBus.Produce(fmt.Sprintf("%s", domain.PROXY), outboxEvent); produceErr != nil {
+		log.Printf("failed to produce user locked out event: %s", produceErr)
+	}
+}
+
+func (t *AuthThrottle) userKey(username string) string {
+	return fmt.Sprintf("%s:user:%s", authThrottleKeyPrefix, username)
+}
+
+func (t *AuthThrottle) ipKey(ip net.IP) string {
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return fmt.Sprintf("%s:ip:%s", authThrottleKeyPrefix, ipv4)
+	}
+
+	mask := net.CIDRMask(authThrottleIpv6PrefixLength, 8*net.IPv6len)
+	return fmt.Sprintf("%s:ip:%s", authThrottleKeyPrefix, &net.IPNet{IP: ip.Mask(mask), Mask: mask})
+}
+
+type authFailures struct {
+	failures    int
+	lockedUntil time.Time
+	expiresAt   time.Time
+}
+
+// localAuthFailureStore keeps counters of a single node in memory.
+type localAuthFailureStore struct {
+	mu        sync.Mutex
+	counters  map[string]*authFailures
+	lastPurge time.Time
+}
+
+func newLocalAuthFailureStore() *localAuthFailureStore {
+	return &localAuthFailureStore{
+		counters: make(map[string]*authFailures),
+	}
+}
+
+func (s *localAuthFailureStore) lockedUntil(key string, now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counter, exists := s.counters[key]
+	if !exists || now.After(counter.expiresAt) {
+		return time.Time{}
+	}
+	return counter.lockedUntil
+}
+
+func (s *localAuthFailureStore) fail(key string, now time.Time, policy authLockoutPolicy) (int, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// keys failing once are forgotten after the window, so counters of random usernames do not pile up
+	if now.Sub(s.lastPurge) > policy.window {
+		for expiredKey, counter := range s.counters {
+			if now.After(counter.expiresAt) {
+				delete(s.counters, expiredKey)
+			}
+		}
+		s.lastPurge = now
+	}
+
+	counter, exists := s.counters[key]
+	if !exists || now.After(counter.expiresAt) {
+		counter = &authFailures{}
+		s.counters[key] = counter
+	}
+
+	counter.failures++
+	lockout := policy.lockoutFor(counter.failures)
+	if lockout > 0 {
+		counter.lockedUntil = now.Add(lockout)
+	}
+	counter.expiresAt = now.Add(policy.window + lockout)
+
+	return counter.failures, counter.lockedUntil
+}
+
+func (s *localAuthFailureStore) reset(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.counters, key)
+}
+
+// redisAuthFailureStore shares counters between nodes through Redis.
+// If Redis is unreachable, local counters are used until Redis becomes available again.
+type redisAuthFailureStore struct {
+	client *redis.Client
+	local  *localAuthFailureStore
+
+	unavailableUntil atomic.Int64
+}
+
+func newRedisAuthFailureStore(client *redis.Client) *redisAuthFailureStore {
+	return &redisAuthFailureStore{
+		client: client,
+		local:  newLocalAuthFailureStore(),
+	}
+}
+
+func (s *redisAuthFailureStore) lockedUntil(key string, now time.Time) time.Time {
+	if !s.available() {
+		return s.local.lockedUntil(key, now)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), authThrottleRedisTimeout)
+	defer cancel()
+
+	lockedUntil, err := s.client.HGet(ctx, key, "locked_until").Int64()
+	if err == redis.Nil {
+		return time.Time{}
+	}
+	if err != nil {
+		s.markUnavailable(err)
+		return s.local.lockedUntil(key, now)
+	}
+
+	return time.UnixMilli(lockedUntil)
+}
+
+func (s *redisAuthFailureStore) fail(key string, now time.Time, policy authLockoutPolicy) (int, time.Time) {
+	if !s.available() {
+		return s.local.fail(key, now, policy)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), authThrottleRedisTimeout)
+	defer cancel()
+
+	result, err := authFailScript.Run(ctx, s.client, []string{key}, now.UnixMilli(), policy.maxFailures,
+		policy.lockout.Milliseconds(), policy.maxLockout.Milliseconds(), policy.window.Milliseconds()).Int64Slice()
+	if err != nil {
+		s.markUnavailable(err)
+		return s.local.fail(key, now, policy)
+	}
+
+	lockedUntil := time.Time{}
+	if result[1] > 0 {
+		lockedUntil = time.UnixMilli(result[1])
+	}
+	return int(result[0]), lockedUntil
+}
+
+func (s *redisAuthFailureStore) reset(key string) {
+	s.local.reset(key)
+	if !s.available() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), authThrottleRedisTimeout)
+	defer cancel()
+
+	if err := s.client.Del(ctx, key).Err(); err != nil {
+		s.markUnavailable(err)
+	}
+}
+
+func (s *redisAuthFailureStore) available() bool {
+	return time.Now().UnixNano() >= s.unavailableUntil.Load()
+}
+
+func (s *redisAuthFailureStore) markUnavailable(err error) {
+	log.Printf("Redis auth throttle is unavailable, using local counters for %v: %v", authThrottleRedisRetryInterval, err)
+	s.unavailableUntil.Store(time.Now().Add(authThrottleRedisRetryInterval).UnixNano())
+}
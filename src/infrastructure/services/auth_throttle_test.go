@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"goproxy/infrastructure/config"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+var testAuthThrottleConfig = config.AuthThrottleConfig{
+	Enabled:         true,
+	UserMaxFailures: 3,
+	IpMaxFailures:   5,
+	Lockout:         time.Minute,
+	MaxLockout:      5 * time.Minute,
+	Window:          15 * time.Minute,
+}
+
+// newTestAuthThrottle returns a throttle whose clock is moved by the returned function.
+func newTestAuthThrottle(store authFailureStore) (*AuthThrottle, func(time.Duration)) {
+	now := time.Now()
+	throttle := newAuthThrottle(store, testAuthThrottleConfig)
+	throttle.now = func() time.Time { return now }
+
+	return throttle, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestAuthLockoutPolicy_LockoutFor(t *testing.T) {
+	policy := authLockoutPolicy{maxFailures: 3, lockout: time.Minute, maxLockout: 5 * time.Minute}
+
+	assert.Equal(t, time.Duration(0), policy.lockoutFor(2))
+	assert.Equal(t, time.Minute, policy.lockoutFor(3))
+	assert.Equal(t, 2*time.Minute, policy.lockoutFor(4))
+	assert.Equal(t, 4*time.Minute, policy.lockoutFor(5))
+	assert.Equal(t, 5*time.Minute, policy.lockoutFor(6))
+	assert.Equal(t, 5*time.Minute, policy.lockoutFor(1000))
+}
+
+func TestAuthThrottle_LocksOutUsername(t *testing.T) {
+	throttle, advance := newTestAuthThrottle(newLocalAuthFailureStore())
+	ip := net.ParseIP("192.0.2.1")
+
+	assert.Equal(t, time.Duration(0), throttle.RegisterFailure("alice", ip))
+	assert.Equal(t, time.Duration(0), throttle.RegisterFailure("alice", ip))
+	assert.Equal(t, time.Duration(0), throttle.LockedOut("alice", ip))
+
+	assert.Equal(t, time.Minute, throttle.RegisterFailure("alice", ip))
+	assert.Equal(t, time.Minute, throttle.LockedOut("alice", ip))
+	// the lockout applies to the username from any IP, other usernames are not affected
+	assert.Equal(t, time.Minute, throttle.LockedOut("alice", net.ParseIP("198.51.100.1")))
+	assert.Equal(t, time.Duration(0), throttle.LockedOut("bob", ip))
+
+	// failures after the lockout ends lock the username out for twice as long
+	advance(time.Minute)
+	assert.Equal(t, time.Duration(0), throttle.LockedOut("alice", ip))
+	assert.Equal(t, 2*time.Minute, throttle.RegisterFailure("alice", ip))
+
+	// a successful attempt forgets the failures of the username, but not of the IP
+	advance(2 * time.Minute)
+	throttle.RegisterSuccess("alice")
+	assert.Equal(t, time.Duration(0), throttle.RegisterFailure("alice", nil))
+	assert.Equal(t, time.Minute, throttle.RegisterFailure("alice", ip))
+}
+
+func TestAuthThrottle_LocksOutIp(t *testing.T) {
+	throttle, _ := newTestAuthThrottle(newLocalAuthFailureStore())
+	ip := net.ParseIP("2001:db8::1")
+
+	// guessing passwords of many usernames locks the client network out
+	for i, username := range []string{"alice", "bob", "carol", "dave"} {
+		assert.Equal(t, time.Duration(0), throttle.RegisterFailure(username, ip), "failure %d", i)
+	}
+	assert.Equal(t, time.Minute, throttle.RegisterFailure("erin", net.ParseIP("2001:db8::2")))
+
+	assert.Equal(t, time.Minute, throttle.LockedOut("frank", ip))
+	assert.Equal(t, time.Duration(0), throttle.LockedOut("frank", net.ParseIP("2001:db8:0:1::1")))
+	assert.Equal(t, time.Duration(0), throttle.LockedOut("frank", nil))
+}
+
+func TestAuthThrottle_ForgetsFailuresAfterWindow(t *testing.T) {
+	throttle, advance := newTestAuthThrottle(newLocalAuthFailureStore())
+
+	throttle.RegisterFailure("alice", nil)
+	throttle.RegisterFailure("alice", nil)
+	advance(testAuthThrottleConfig.Window + time.Second)
+
+	assert.Equal(t, time.Duration(0), throttle.RegisterFailure("alice", nil))
+	assert.Equal(t, time.Duration(0), throttle.RegisterFailure("alice", nil))
+	assert.Equal(t, time.Minute, throttle.RegisterFailure("alice", nil))
+}
+
+func TestAuthThrottle_Redis(t *testing.T) {
+	redisContainer, address, err := setupRedisContainer()
+	assert.NoError(t, err)
+	defer func(redisContainer testcontainers.Container, ctx context.Context) {
+		_ = redisContainer.Terminate(ctx)
+	}(redisContainer, context.Background())
+
+	// two throttles share the counters like two proxy nodes do
+	first, _ := newTestAuthThrottle(newRedisAuthFailureStore(redis.NewClient(&redis.Options{Addr: address})))
+	second, _ := newTestAuthThrottle(newRedisAuthFailureStore(redis.NewClient(&redis.Options{Addr: address})))
+
+	assert.Equal(t, time.Duration(0), first.RegisterFailure("alice", nil))
+	assert.Equal(t, time.Duration(0), second.RegisterFailure("alice", nil))
+	assert.Equal(t, time.Minute, first.RegisterFailure("alice", nil))
+	assert.InDelta(t, time.Minute, second.LockedOut("alice", nil), float64(time.Second))
+	assert.Equal(t, 2*time.Minute, second.RegisterFailure("alice", nil))
+
+	second.RegisterSuccess("alice")
+	assert.Equal(t, time.Duration(0), first.LockedOut("alice", nil))
+}
+
+func TestAuthThrottle_RedisFallsBackToLocal(t *testing.T) {
+	// nothing listens on port 1, so every Redis call fails
+	store := newRedisAuthFailureStore(redis.NewClient(&redis.Options{
+		Addr:        "127.0.0.1:1",
+		DialTimeout: 50 * time.Millisecond,
+	}))
+	throttle, _ := newTestAuthThrottle(store)
+
+	throttle.RegisterFailure("alice", nil)
+	assert.False(t, store.available())
+
+	// the local counters still lock the username out
+	throttle.RegisterFailure("alice", nil)
+	assert.Equal(t, time.Minute, throttle.RegisterFailure("alice", nil))
+	assert.Equal(t, time.Minute, throttle.LockedOut("alice", nil))
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"goproxy/application/contracts"
+	"goproxy/domain/aggregates"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// AuthorizedNetworkIndex maps client IPs to users by their authorized networks. Networks are kept in binary
+// prefix trees replaced as a whole on reload, so lookups never wait for a lock.
+type AuthorizedNetworkIndex struct {
+	repo  contracts.AuthorizedNetworkRepository
+	tries atomic.Pointer[authorizedNetworkTries]
+}
+
+type authorizedNetworkTries struct {
+	ipv4 *ipTrieNode
+	ipv6 *ipTrieNode
+}
+
+// ipTrieNode is a node of a binary prefix tree, a node at depth n holds the user of a network with an n-bit prefix.
+type ipTrieNode struct {
+	children [2]*ipTrieNode
+	userId   int
+}
+
+func NewAuthorizedNetworkIndex(repo contracts.AuthorizedNetworkRepository) *AuthorizedNetworkIndex {
+	index := &AuthorizedNetworkIndex{repo: repo}
+	index.Replace(nil)
+	return index
+}
+
+func (i *AuthorizedNetworkIndex) Reload() error {
+	networks, err := i.repo.GetAll()
+	if err != nil {
+		return err
+	}
+
+	i.Replace(networks)
+	return nil
+}
+
+// Replace indexes the networks instead of the current ones. Networks should not overlap, if they do
+// the narrowest one wins and of the same networks the first one does.
+func (i *AuthorizedNetworkIndex) Replace(networks []aggregates.AuthorizedNetwork) {
+	tries := &authorizedNetworkTries{ipv4: &ipTrieNode{}, ipv6: &ipTrieNode{}}
+	for _, network := range networks {
+		ipNet := network.IPNet()
+		ones, _ := ipNet.Mask.Size()
+		if ip4 := ipNet.IP.To4(); ip4 != nil {
+			tries.ipv4.insert(ip4, ones, network.UserId())
+		} else {
+			tries.ipv6.insert(ipNet.IP.To16(), ones, network.UserId())
+		}
+	}
+
+	i.tries.Store(tries)
+}
+
+func (i *AuthorizedNetworkIndex) Lookup(ip net.IP) (int, bool) {
+	tries := i.tries.Load()
+	if ip4 := ip.To4(); ip4 != nil {
+		return tries.ipv4.lookup(ip4)
+	}
+	if ip16 := ip.To16(); ip16 != nil {
+		return tries.ipv6.lookup(ip16)
+	}
+	return 0, false
+}
+
+// StartRefreshing reloads the networks with the given interval until ctx is done, so that a node missing
+// change events still catches up.
+func (i *AuthorizedNetworkIndex) StartRefreshing(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := i.Reload(); err != nil {
+					log.Printf("failed to reload authorized networks, keeping the previous ones: %v", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (n *ipTrieNode) insert(ip []byte, ones, userId int) {
+	node := n
+	for bit := 0; bit < ones; bit++ {
+		next := ipBit(ip, bit)
+		if node.children[next] == nil {
+			node.children[next] = &ipTrieNode{}
+		}
+		node = node.children[next]
+	}
+
+	if node.userId == 0 {
+		node.userId = userId
+	}
+}
+
+func (n *ipTrieNode) lookup(ip []byte) (int, bool) {
+	userId := n.userId
+	node := n
+	for bit := 0; bit < len(ip)*8; bit++ {
+		node = node.children[ipBit(ip, bit)]
+		if node == nil {
+			break
+		}
+		if node.userId != 0 {
+			userId = node.userId
+		}
+	}
+
+	return userId, userId != 0
+}
+
+func ipBit(ip []byte, bit int) int {
+	return int(ip[bit/8]>>(7-bit%8)) & 1
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"goproxy/domain/aggregates"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAuthorizedNetworkIndex_Lookup(t *testing.T) {
+	index := NewAuthorizedNetworkIndex(nil)
+	index.Replace([]aggregates.AuthorizedNetwork{
+		newTestAuthorizedNetwork(t, 1, "198.51.100.0/24"),
+		newTestAuthorizedNetwork(t, 2, "198.51.100.128/25"),
+		newTestAuthorizedNetwork(t, 3, "203.0.113.7"),
+		newTestAuthorizedNetwork(t, 4, "2001:db8:1::/48"),
+	})
+
+	tests := []struct {
+		name       string
+		ip         string
+		wantUserId int
+	}{
+		{name: "inside network", ip: "198.51.100.1", wantUserId: 1},
+		{name: "narrowest network wins", ip: "198.51.100.200", wantUserId: 2},
+		{name: "single address", ip: "203.0.113.7", wantUserId: 3},
+		{name: "next to single address", ip: "203.0.113.8"},
+		{name: "IPv4-mapped IPv6 address", ip: "::ffff:198.51.100.1", wantUserId: 1},
+		{name: "IPv6 network", ip: "2001:db8:1:ffff::1", wantUserId: 4},
+		{name: "outside IPv6 network", ip: "2001:db8:2::1"},
+		{name: "unknown address", ip: "192.0.2.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userId, found := index.Lookup(net.ParseIP(tt.ip))
+			if userId != tt.wantUserId || found != (tt.wantUserId != 0) {
+				t.Errorf("Lookup(%s) = %d, %v, want user %d", tt.ip, userId, found, tt.wantUserId)
+			}
+		})
+	}
+}
+
+func TestAuthorizedNetworkIndex_Replace(t *testing.T) {
+	index := NewAuthorizedNetworkIndex(nil)
+	if _, found := index.Lookup(net.ParseIP("198.51.100.1")); found {
+		t.Fatalf("Expected empty index not to find users")
+	}
+
+	index.Replace([]aggregates.AuthorizedNetwork{newTestAuthorizedNetwork(t, 1, "198.51.100.0/24")})
+	index.Replace([]aggregates.AuthorizedNetwork{newTestAuthorizedNetwork(t, 2, "203.0.113.0/24")})
+
+	if _, found := index.Lookup(net.ParseIP("198.51.100.1")); found {
+		t.Errorf("Expected replaced network to be removed")
+	}
+	if userId, _ := index.Lookup(net.ParseIP("203.0.113.1")); userId != 2 {
+		t.Errorf("Expected new network of user 2, got user %d", userId)
+	}
+}
+
+func BenchmarkAuthorizedNetworkIndex_Lookup(b *testing.B) {
+	networks := make([]aggregates.AuthorizedNetwork, 0, 4096)
+	for i := 0; i < 4096; i++ {
+		network, err := aggregates.NewAuthorizedNetwork(i+1, i+1, net.IPv4(11, byte(i>>8), byte(i), 0).String()+"/24", time.Time{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		networks = append(networks, network)
+	}
+	index := NewAuthorizedNetworkIndex(nil)
+	index.Replace(networks)
+	ip := net.ParseIP("11.15.255.1")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.Lookup(ip)
+	}
+}
+
+func newTestAuthorizedNetwork(t *testing.T, userId int, network string) aggregates.AuthorizedNetwork {
+	authorizedNetwork, err := aggregates.NewAuthorizedNetwork(userId, userId, network, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authorizedNetwork
+}
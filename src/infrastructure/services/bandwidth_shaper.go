@@ -0,0 +1,268 @@
+package services
+
+import (
+	"context"
+	"goproxy/application/contracts"
+	"goproxy/infrastructure/config"
+	"io"
+	"math"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// BandwidthShaper slows traffic down to the configured speed limits instead of cutting connections.
+// Every user shares one pair of upload/download limiters across all of their connections,
+// and every connection additionally gets its own pair.
+type BandwidthShaper struct {
+	userUploadRate   float64
+	userDownloadRate float64
+	connUploadRate   float64
+	connDownloadRate float64
+	planLimits       contracts.UserPlanLimitsService
+	userNodes        UserNodesCounter
+
+	mu    sync.Mutex
+	users map[int]*userLimiters
+}
+
+// UserNodesCounter tells how many proxy nodes carry connections of a user.
+type UserNodesCounter interface {
+	UserNodes(userId int) int
+}
+
+type userLimiters struct {
+	upload      *rate.Limiter
+	download    *rate.Limiter
+	connections int
+}
+
+// ShapedConnection holds the limiters applied to a single proxied connection.
+type ShapedConnection struct {
+	shaper   *BandwidthShaper
+	userId   int
+	user     *userLimiters
+	upload   *rate.Limiter
+	download *rate.Limiter
+}
+
+func NewBandwidthShaper(config config.RateLimiterConfig) *BandwidthShaper {
+	return &BandwidthShaper{
+		userUploadRate:   config.UserUploadRate,
+		userDownloadRate: config.UserDownloadRate,
+		connUploadRate:   config.ConnUploadRate,
+		connDownloadRate: config.ConnDownloadRate,
+		users:            make(map[int]*userLimiters),
+	}
+}
+
+// WithPlanLimits makes the shaper use speed limits of users active plans instead of the configured per-user limits.
+func (s *BandwidthShaper) WithPlanLimits(planLimits contracts.UserPlanLimitsService) *BandwidthShaper {
+	s.planLimits = planLimits
+	return s
+}
+
+// WithUserNodes makes the shaper split per-user limits evenly between proxy nodes carrying connections of the user,
+// so a user gets the limits once across all nodes. Shares are updated whenever a connection of the user is opened.
+func (s *BandwidthShaper) WithUserNodes(userNodes UserNodesCounter) *BandwidthShaper {
+	s.userNodes = userNodes
+	return s
+}
+
+// Open registers a new connection of the user. Returned connection must be closed once traffic is relayed.
+// connRate is the speed in bytes per second the client limited the connection to, 0 if it did not;
+// it can only slow the connection down below the configured limits.
+func (s *BandwidthShaper) Open(userId int, connRate float64) *ShapedConnection {
+	uploadRate, downloadRate := s.userRates(userId)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userId]
+	if !ok {
+		user = &userLimiters{
+			upload:   newSpeedLimiter(uploadRate),
+			download: newSpeedLimiter(downloadRate),
+		}
+		s.users[userId] = user
+	} else {
+		// plan could be changed since the first connection of the user was opened
+		updateSpeedLimiter(user.upload, uploadRate)
+		updateSpeedLimiter(user.download, downloadRate)
+	}
+	user.connections++
+
+	return &ShapedConnection{
+		shaper:   s,
+		userId:   userId,
+		user:     user,
+		upload:   newSpeedLimiter(lowerSpeed(s.connUploadRate, connRate)),
+		download: newSpeedLimiter(lowerSpeed(s.connDownloadRate, connRate)),
+	}
+}
+
+// newConnectionShapedConnection limits a single connection to bytesPerSecond when traffic is not shaped otherwise.
+func newConnectionShapedConnection(bytesPerSecond float64) *ShapedConnection {
+	return &ShapedConnection{
+		user: &userLimiters{
+			upload:   newSpeedLimiter(0),
+			download: newSpeedLimiter(0),
+		},
+		upload:   newSpeedLimiter(bytesPerSecond),
+		download: newSpeedLimiter(bytesPerSecond),
+	}
+}
+
+// userRates returns the plan speed for both directions if the plan limits it, or the configured per-user limits,
+// as the share of the node.
+func (s *BandwidthShaper) userRates(userId int) (float64, float64) {
+	uploadRate, downloadRate := s.userUploadRate, s.userDownloadRate
+	if s.planLimits != nil {
+		if limits := s.planLimits.GetLimits(userId); limits.MaxBytesPerSecond > 0 {
+			uploadRate, downloadRate = float64(limits.MaxBytesPerSecond), float64(limits.MaxBytesPerSecond)
+		}
+	}
+
+	if s.userNodes != nil {
+		nodes := float64(s.userNodes.UserNodes(userId))
+		uploadRate, downloadRate = uploadRate/nodes, downloadRate/nodes
+	}
+	return uploadRate, downloadRate
+}
+
+// Close releases the connection. User limiters are dropped once the last connection of the user is closed.
+func (c *ShapedConnection) Close() {
+	if c == nil || c.shaper == nil {
+		return
+	}
+
+	c.shaper.mu.Lock()
+	defer c.shaper.mu.Unlock()
+
+	c.user.connections--
+	if c.user.connections <= 0 {
+		delete(c.shaper.users, c.userId)
+	}
+}
+
+// WaitN blocks until n bytes may be sent in the given direction.
+// direction == "in" → client → server
+// direction == "out" → server → client
+func (c *ShapedConnection) WaitN(ctx context.Context, direction string, n int) error {
+	if c == nil {
+		return nil
+	}
+
+	connLimiter, userLimiter := c.download, c.user.download
+	if direction == "in" {
+		connLimiter, userLimiter = c.upload, c.user.upload
+	}
+
+	if err := waitBytes(ctx, connLimiter, n); err != nil {
+		return err
+	}
+	return waitBytes(ctx, userLimiter, n)
+}
+
+// Reader wraps r so that reads never exceed the speed limits of the given direction.
+func (c *ShapedConnection) Reader(ctx context.Context, direction string, r io.Reader) io.Reader {
+	if c == nil {
+		return r
+	}
+
+	return &shapedReader{
+		ctx:       ctx,
+		conn:      c,
+		direction: direction,
+		reader:    r,
+		maxRead:   c.maxChunk(direction),
+	}
+}
+
+// maxChunk returns the largest amount of bytes which could be waited for at once in the given direction.
+func (c *ShapedConnection) maxChunk(direction string) int {
+	connLimiter, userLimiter := c.download, c.user.download
+	if direction == "in" {
+		connLimiter, userLimiter = c.upload, c.user.upload
+	}
+
+	return min(connLimiter.Burst(), userLimiter.Burst())
+}
+
+type shapedReader struct {
+	ctx       context.Context
+	conn      *ShapedConnection
+	direction string
+	reader    io.Reader
+	maxRead   int
+}
+
+func (r *shapedReader) Read(p []byte) (int, error) {
+	if len(p) > r.maxRead {
+		p = p[:r.maxRead]
+	}
+
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		if waitErr := r.conn.WaitN(r.ctx, r.direction, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+
+	return n, err
+}
+
+// newSpeedLimiter creates a limiter for bytesPerSecond with a burst of one second of traffic.
+// Zero or negative speed means no limit.
+func newSpeedLimiter(bytesPerSecond float64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return rate.NewLimiter(rate.Inf, math.MaxInt)
+	}
+
+	burst := int(bytesPerSecond)
+	if burst < 1 {
+		burst = 1
+	}
+
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), burst)
+}
+
+// lowerSpeed returns the lower of two speeds, where zero or negative speed means no limit.
+func lowerSpeed(a, b float64) float64 {
+	if a <= 0 {
+		return b
+	}
+	if b <= 0 {
+		return a
+	}
+	return min(a, b)
+}
+
+// updateSpeedLimiter changes the limiter speed the same way newSpeedLimiter would create it.
+func updateSpeedLimiter(limiter *rate.Limiter, bytesPerSecond float64) {
+	updated := newSpeedLimiter(bytesPerSecond)
+	if updated.Limit() == limiter.Limit() {
+		return
+	}
+
+	limiter.SetLimit(updated.Limit())
+	limiter.SetBurst(updated.Burst())
+}
+
+// waitBytes waits for n tokens in chunks not exceeding the limiter burst.
+func waitBytes(ctx context.Context, limiter *rate.Limiter, n int) error {
+	if limiter.Limit() == rate.Inf {
+		return nil
+	}
+
+	burst := limiter.Burst()
+	for n > 0 {
+		chunk := min(n, burst)
+		if err := limiter.WaitN(ctx, chunk); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+
+	return nil
+}
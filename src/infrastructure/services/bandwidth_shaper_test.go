@@ -0,0 +1,233 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"goproxy/domain/dataobjects"
+	"goproxy/domain/valueobjects"
+	"goproxy/infrastructure/config"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBandwidthShaper_ThrottlesConnection(t *testing.T) {
+	shaper := NewBandwidthShaper(config.RateLimiterConfig{
+		ConnDownloadRate: 100_000,
+	})
+
+	conn := shaper.Open(1, 0)
+	defer conn.Close()
+
+	// the first 100 KB are served by the initial burst, the other 100 KB take about a second
+	payload := make([]byte, 200_000)
+	start := time.Now()
+	read, err := io.Copy(io.Discard, conn.Reader(context.Background(), "out", bytes.NewReader(payload)))
+	elapsed := time.Since(start)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(payload)), read)
+	assert.GreaterOrEqual(t, elapsed, 900*time.Millisecond)
+
+	// upload is not limited
+	start = time.Now()
+	_, err = io.Copy(io.Discard, conn.Reader(context.Background(), "in", bytes.NewReader(payload)))
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestBandwidthShaper_ConnectionSpeedRequestedByClient(t *testing.T) {
+	shaper := NewBandwidthShaper(config.RateLimiterConfig{
+		ConnUploadRate: 50_000,
+	})
+
+	tests := []struct {
+		name string
+		conn *ShapedConnection
+	}{
+		{name: "shaped traffic", conn: shaper.Open(1, 100_000)},
+		{name: "traffic not shaped otherwise", conn: newConnectionShapedConnection(100_000)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer tt.conn.Close()
+
+			start := time.Now()
+			_, err := io.Copy(io.Discard, tt.conn.Reader(context.Background(), "out", bytes.NewReader(make([]byte, 150_000))))
+			assert.NoError(t, err)
+			assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+		})
+	}
+
+	// the client can not speed the connection up above the configured limit
+	capped := shaper.Open(1, 100_000)
+	defer capped.Close()
+	assert.Equal(t, 50_000, capped.maxChunk("in"))
+}
+
+func TestBandwidthShaper_UserLimitIsSharedByConnections(t *testing.T) {
+	shaper := NewBandwidthShaper(config.RateLimiterConfig{
+		UserUploadRate: 100_000,
+	})
+
+	first := shaper.Open(1, 0)
+	defer first.Close()
+	second := shaper.Open(1, 0)
+	defer second.Close()
+
+	// the first connection consumes the whole burst, so the second one has to wait for refill
+	assert.NoError(t, first.WaitN(context.Background(), "in", 100_000))
+
+	start := time.Now()
+	assert.NoError(t, second.WaitN(context.Background(), "in", 50_000))
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+
+	// other users have their own limiters
+	other := shaper.Open(2, 0)
+	defer other.Close()
+	start = time.Now()
+	assert.NoError(t, other.WaitN(context.Background(), "in", 100_000))
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestBandwidthShaper_PlanSpeedOverridesUserLimit(t *testing.T) {
+	planLimits := newTestUserPlanLimitsService()
+	_ = planLimits.SetLimits(1, dataobjects.UserPlanLimits{MaxBytesPerSecond: 100_000})
+
+	shaper := NewBandwidthShaper(config.RateLimiterConfig{
+		UserDownloadRate: 10_000_000,
+	}).WithPlanLimits(planLimits)
+
+	conn := shaper.Open(1, 0)
+	defer conn.Close()
+
+	assert.NoError(t, conn.WaitN(context.Background(), "out", 100_000))
+
+	start := time.Now()
+	assert.NoError(t, conn.WaitN(context.Background(), "out", 50_000))
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+}
+
+type fixedUserNodes int
+
+func (n fixedUserNodes) UserNodes(int) int {
+	return int(n)
+}
+
+func TestBandwidthShaper_SplitsUserLimitBetweenNodes(t *testing.T) {
+	shaper := NewBandwidthShaper(config.RateLimiterConfig{
+		UserDownloadRate: 200_000,
+	}).WithUserNodes(fixedUserNodes(2))
+
+	conn := shaper.Open(1, 0)
+	defer conn.Close()
+
+	// the node gets half of the user limit as the user has connections on two nodes
+	assert.Equal(t, 100_000, conn.maxChunk("out"))
+	assert.NoError(t, conn.WaitN(context.Background(), "out", 100_000))
+
+	start := time.Now()
+	assert.NoError(t, conn.WaitN(context.Background(), "out", 50_000))
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+}
+
+func TestBandwidthShaper_WaitRespectsContext(t *testing.T) {
+	shaper := NewBandwidthShaper(config.RateLimiterConfig{
+		ConnUploadRate: 1_000,
+	})
+
+	conn := shaper.Open(1, 0)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	assert.Error(t, conn.WaitN(ctx, "in", 10_000))
+}
+
+func TestBandwidthShaper_ReleasesUserLimitersOnLastClose(t *testing.T) {
+	shaper := NewBandwidthShaper(config.RateLimiterConfig{
+		UserDownloadRate: 100_000,
+	})
+
+	first := shaper.Open(1, 0)
+	second := shaper.Open(1, 0)
+
+	first.Close()
+	assert.Len(t, shaper.users, 1)
+
+	second.Close()
+	assert.Empty(t, shaper.users)
+}
+
+func TestShapedConnection_NilIsNoop(t *testing.T) {
+	var conn *ShapedConnection
+
+	reader := bytes.NewReader([]byte("payload"))
+	assert.Same(t, reader, conn.Reader(context.Background(), "in", reader))
+	assert.NoError(t, conn.WaitN(context.Background(), "out", 1_000_000))
+	conn.Close()
+}
+
+func TestProxy_Tunnel_ShapingModeEnforcesConnectionsLimit(t *testing.T) {
+	proxy := newTestProxy(t)
+	rateLimiter := NewRateLimiter(config.RateLimiterConfig{
+		MaxConns:   1,
+		BlockDur:   time.Millisecond,
+		CleanupInt: time.Minute,
+		Capacity:   100 * 1024 * 1024,
+		FillRate:   100 * 1024 * 1024,
+		ShardCount: 2,
+	})
+	t.Cleanup(rateLimiter.Stop)
+	proxy.rateLimiter = rateLimiter
+	proxy.bandwidthShaper = NewBandwidthShaper(config.RateLimiterConfig{})
+
+	startTunnel := func() (net.Conn, net.Conn, chan struct{}) {
+		client, proxyClientConn := net.Pipe()
+		proxyServerConn, target := net.Pipe()
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			proxy.tunnel(proxyClientConn, proxyServerConn, 1, 0, valueobjects.ConnectionOptions{}, "example.com:443")
+			_ = proxyClientConn.Close()
+			_ = proxyServerConn.Close()
+		}()
+		return client, target, done
+	}
+
+	client, target, done := startTunnel()
+	_, err := client.Write([]byte("ping"))
+	require.NoError(t, err)
+	received := make([]byte, 4)
+	_, err = io.ReadFull(target, received)
+	require.NoError(t, err)
+
+	// the tunnel holds the only connection slot of the target
+	_, _, refused := startTunnel()
+	select {
+	case <-refused:
+	case <-time.After(time.Second):
+		t.Fatal("expected the tunnel over the connections limit to be closed")
+	}
+
+	_ = client.Close()
+	_ = target.Close()
+	<-done
+
+	time.Sleep(2 * time.Millisecond)
+	client, target, done = startTunnel()
+	_, err = client.Write([]byte("ping"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(target, received)
+	require.NoError(t, err)
+	_ = client.Close()
+	_ = target.Close()
+	<-done
+}
@@ -0,0 +1,183 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"goproxy/domain/valueobjects"
+	"goproxy/infrastructure/masque"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	connectUdpRateLimiterTarget = "connect-udp"
+	// connectUdpMaxCapsuleSize fits the largest UDP payload along with its context ID
+	connectUdpMaxCapsuleSize = socks5UdpBufferSize + 8
+)
+
+// connectUdp relays UDP datagrams between an extended CONNECT stream (RFC 9298) and the target from the request path.
+// HTTP/2 has no unreliable delivery, so datagrams are carried in DATAGRAM capsules (RFC 9297).
+// The stream ends when either side closes it or no datagrams come from the target for the read deadline.
+func (p *Proxy) connectUdp(w http.ResponseWriter, r *http.Request, userId, credentialId int, options valueobjects.ConnectionOptions) {
+	target, err := masque.ParseUdpTarget(r.URL.EscapedPath())
+	if err != nil {
+		log.Printf("Invalid connect-udp request: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err = p.destinationPolicy.Check(userId, DatagramDestination, target); err != nil {
+		log.Println("Destination denied:", err)
+		writeHttp2DialError(w, err)
+		return
+	}
+
+	egressConn, err := p.listenUdpEgress(userId, options)
+	if err != nil {
+		log.Printf("failed to open connect-udp egress: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer func(egressConn *net.UDPConn) {
+		_ = egressConn.Close()
+	}(egressConn)
+
+	targetAddr, err := p.resolveDatagramTarget(egressConn, target)
+	if err != nil {
+		log.Printf("Could not resolve connect-udp target: %v", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	if err = p.destinationPolicy.CheckIP(targetAddr.IP); err != nil {
+		log.Println("Destination denied:", err)
+		writeHttp2DialError(w, err)
+		return
+	}
+
+	if !p.admitShaped(userId, connectUdpRateLimiterTarget) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+
+	controller := http.NewResponseController(w)
+	w.Header().Set(masque.CapsuleProtocolHeader, "?1")
+	w.WriteHeader(http.StatusOK)
+	if err = controller.Flush(); err != nil {
+		p.releaseShaped(userId, connectUdpRateLimiterTarget)
+		return
+	}
+
+	shapedConn := p.openShapedConnection(userId, options.SpeedLimit())
+	defer shapedConn.Close()
+
+	// the stream ends when no datagrams are relayed either way for the idle timeout
+	watchdog := newActivityWatchdog(p.timeouts.For(userId), func() {
+		_ = egressConn.SetDeadline(time.Now())
+		_ = controller.SetReadDeadline(time.Now())
+		_ = controller.SetWriteDeadline(time.Now())
+	})
+	defer watchdog.stop()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// client → target
+	go func() {
+		defer wg.Done()
+		defer func() {
+			_ = egressConn.Close()
+		}()
+		p.relayConnectUdpClientCapsules(r.Body, egressConn, targetAddr, shapedConn, watchdog, userId, credentialId)
+	}()
+	// target → client
+	go func() {
+		defer wg.Done()
+		defer func() {
+			_ = r.Body.Close()
+		}()
+		p.relayConnectUdpTargetDatagrams(w, controller, egressConn, targetAddr, shapedConn, watchdog, userId, credentialId)
+	}()
+
+	wg.Wait()
+}
+
+func (p *Proxy) relayConnectUdpClientCapsules(body io.Reader, egressConn *net.UDPConn, targetAddr *net.UDPAddr, shapedConn *ShapedConnection, watchdog *activityWatchdog, userId, credentialId int) {
+	defer p.rateLimiter.Done(userId, connectUdpRateLimiterTarget)
+
+	reader := bufio.NewReader(body)
+	buf := make([]byte, connectUdpMaxCapsuleSize)
+	var accumulatedBytes int64
+
+	for {
+		capsuleType, value, err := masque.ReadCapsule(reader, buf)
+		if err != nil {
+			return
+		}
+
+		// unknown capsules are skipped, as required by the capsule protocol
+		if capsuleType != masque.CapsuleTypeDatagram {
+			continue
+		}
+
+		// datagrams of other contexts are only sent by clients using extensions this proxy did not agree to
+		contextId, payload, parseErr := masque.ParseDatagram(value)
+		if parseErr != nil || contextId != masque.UdpPayloadContextId {
+			continue
+		}
+
+		if waitErr := shapedConn.WaitN(context.Background(), "in", len(payload)); waitErr != nil {
+			return
+		}
+
+		written, writeErr := egressConn.WriteToUDP(payload, targetAddr)
+		if writeErr != nil {
+			continue
+		}
+		watchdog.touch()
+
+		accumulatedBytes += int64(written)
+		if accumulatedBytes >= rateLimitAccountingThreshold {
+			if p.bandwidthShaper == nil && !p.rateLimiter.Allow(userId, connectUdpRateLimiterTarget, accumulatedBytes) {
+				return
+			}
+			accumulatedBytes = 0
+		}
+		p.trafficReporter.AddInBytes(userId, credentialId, int64(written))
+	}
+}
+
+func (p *Proxy) relayConnectUdpTargetDatagrams(w io.Writer, controller *http.ResponseController, egressConn *net.UDPConn, targetAddr *net.UDPAddr, shapedConn *ShapedConnection, watchdog *activityWatchdog, userId, credentialId int) {
+	buf := make([]byte, socks5UdpBufferSize)
+	capsule := make([]byte, 0, connectUdpMaxCapsuleSize)
+
+	for {
+		n, sourceAddr, err := egressConn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		// the socket is not connected, so datagrams of other hosts are dropped here
+		if !sourceAddr.IP.Equal(targetAddr.IP) || sourceAddr.Port != targetAddr.Port {
+			continue
+		}
+
+		if waitErr := shapedConn.WaitN(context.Background(), "out", n); waitErr != nil {
+			return
+		}
+
+		capsule = masque.AppendUdpDatagramCapsule(capsule[:0], buf[:n])
+		if _, writeErr := w.Write(capsule); writeErr != nil {
+			return
+		}
+		if flushErr := controller.Flush(); flushErr != nil {
+			return
+		}
+		watchdog.touch()
+
+		p.trafficReporter.AddOutBytes(userId, credentialId, int64(n))
+	}
+}
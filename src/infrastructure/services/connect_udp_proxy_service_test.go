@@ -0,0 +1,124 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"goproxy/infrastructure/config"
+	"goproxy/infrastructure/masque"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startUdpEchoServer starts a UDP server which sends every datagram back to its sender.
+func startUdpEchoServer(t *testing.T) *net.UDPAddr {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteToUDP(buf[:n], addr)
+		}
+	}()
+
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+// connectUdp opens a connect-udp stream to target, returning the writer of client capsules and the response.
+func connectUdp(t *testing.T, client *http.Client, target *net.UDPAddr) (*io.PipeWriter, *http.Response) {
+	bodyReader, bodyWriter := io.Pipe()
+	request, err := http.NewRequest(http.MethodConnect,
+		fmt.Sprintf("http://proxy.example/.well-known/masque/udp/%s/%d/", target.IP, target.Port), bodyReader)
+	require.NoError(t, err)
+	// the transport encodes request headers in map order, so :protocol is kept the only one:
+	// the proxy would reset streams with pseudo-headers after regular ones
+	request.Header.Set(":protocol", masque.Protocol)
+
+	response, err := client.Do(request)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = bodyWriter.Close()
+		_ = response.Body.Close()
+	})
+
+	return bodyWriter, response
+}
+
+func TestProxy_ConnectUdp_RelaysDatagrams(t *testing.T) {
+	target := startUdpEchoServer(t)
+	proxy := newTestProxy(t)
+	var connections atomic.Int32
+	client := startHttp2Proxy(t, proxy, &connections)
+
+	writer, response := connectUdp(t, client, target)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "?1", response.Header.Get(masque.CapsuleProtocolHeader))
+
+	// capsules of unknown types are skipped
+	unknownCapsule := masque.AppendVarint(masque.AppendVarint(nil, 0x2A), 3)
+	_, err := writer.Write(append(unknownCapsule, "abc"...))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(response.Body)
+	buf := make([]byte, 1024)
+	for _, payload := range []string{"ping", "pong"} {
+		_, err = writer.Write(masque.AppendUdpDatagramCapsule(nil, []byte(payload)))
+		require.NoError(t, err)
+
+		capsuleType, value, err := masque.ReadCapsule(reader, buf)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(masque.CapsuleTypeDatagram), capsuleType)
+
+		contextId, datagram, err := masque.ParseDatagram(value)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(masque.UdpPayloadContextId), contextId)
+		assert.Equal(t, payload, string(datagram))
+	}
+
+	_ = writer.Close()
+
+	var inBytes, outBytes int64
+	timeout := time.After(time.Second)
+	for inBytes < 8 || outBytes < 8 {
+		select {
+		case event := <-proxy.trafficReporter.eventQueue:
+			inBytes += event.InBytes
+			outBytes += event.OutBytes
+		case <-timeout:
+			t.Fatalf("datagrams are not reported, in = %d, out = %d", inBytes, outBytes)
+		}
+	}
+}
+
+func TestProxy_ConnectUdp_DeniedDestination(t *testing.T) {
+	proxy := newTestProxy(t)
+	proxy.destinationPolicy = NewDestinationPolicy(config.DestinationPolicyConfig{
+		DeniedNetworks: []*net.IPNet{{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}},
+	})
+	var connections atomic.Int32
+	client := startHttp2Proxy(t, proxy, &connections)
+
+	_, response := connectUdp(t, client, startUdpEchoServer(t))
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+	assert.Contains(t, response.Header.Get(destinationDeniedReasonHeader), "127.0.0.1")
+}
+
+func TestProxy_ConnectUdp_RejectsInvalidTarget(t *testing.T) {
+	var connections atomic.Int32
+	client := startHttp2Proxy(t, newTestProxy(t), &connections)
+
+	_, response := connectUdp(t, client, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
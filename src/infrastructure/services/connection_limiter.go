@@ -0,0 +1,82 @@
+package services
+
+import (
+	"goproxy/application/contracts"
+	"goproxy/infrastructure/config"
+	"sync"
+	"sync/atomic"
+)
+
+// ConnectionLimiter counts concurrent connections of the node and of every user regardless of connection targets.
+type ConnectionLimiter struct {
+	maxNodeConns int64
+	maxUserConns int
+	planLimits   contracts.UserPlanLimitsService
+
+	nodeConns atomic.Int64
+
+	mu        sync.Mutex
+	userConns map[int]int
+}
+
+func NewConnectionLimiter(config config.RateLimiterConfig) *ConnectionLimiter {
+	return &ConnectionLimiter{
+		maxNodeConns: int64(config.MaxNodeConns),
+		maxUserConns: config.MaxUserConns,
+		userConns:    make(map[int]int),
+	}
+}
+
+// WithPlanLimits makes the limiter apply connection limits of users active plans instead of MaxUserConns.
+func (c *ConnectionLimiter) WithPlanLimits(planLimits contracts.UserPlanLimitsService) *ConnectionLimiter {
+	c.planLimits = planLimits
+	return c
+}
+
+func (c *ConnectionLimiter) AcquireNode() bool {
+	conns := c.nodeConns.Add(1)
+	if c.maxNodeConns > 0 && conns > c.maxNodeConns {
+		c.nodeConns.Add(-1)
+		return false
+	}
+
+	return true
+}
+
+func (c *ConnectionLimiter) ReleaseNode() {
+	c.nodeConns.Add(-1)
+}
+
+func (c *ConnectionLimiter) AcquireUser(userId int) bool {
+	maxConns := c.userMaxConns(userId)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if maxConns > 0 && c.userConns[userId] >= maxConns {
+		return false
+	}
+
+	c.userConns[userId]++
+	return true
+}
+
+func (c *ConnectionLimiter) ReleaseUser(userId int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.userConns[userId]--
+	if c.userConns[userId] <= 0 {
+		delete(c.userConns, userId)
+	}
+}
+
+func (c *ConnectionLimiter) userMaxConns(userId int) int {
+	if c.planLimits != nil {
+		if limits := c.planLimits.GetLimits(userId); limits.MaxConnections > 0 {
+			return limits.MaxConnections
+		}
+	}
+
+	return c.maxUserConns
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"goproxy/domain/dataobjects"
+	"goproxy/infrastructure/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionLimiter_User(t *testing.T) {
+	limiter := NewConnectionLimiter(config.RateLimiterConfig{MaxUserConns: 2})
+
+	assert.True(t, limiter.AcquireUser(1))
+	assert.True(t, limiter.AcquireUser(1))
+	assert.False(t, limiter.AcquireUser(1))
+
+	// other users are counted separately
+	assert.True(t, limiter.AcquireUser(2))
+
+	limiter.ReleaseUser(1)
+	assert.True(t, limiter.AcquireUser(1))
+
+	limiter.ReleaseUser(1)
+	limiter.ReleaseUser(1)
+	limiter.ReleaseUser(2)
+	assert.Empty(t, limiter.userConns)
+}
+
+func TestConnectionLimiter_UserPlanLimits(t *testing.T) {
+	planLimits := newTestUserPlanLimitsService()
+	_ = planLimits.SetLimits(1, dataobjects.UserPlanLimits{MaxConnections: 1})
+
+	limiter := NewConnectionLimiter(config.RateLimiterConfig{MaxUserConns: 5}).WithPlanLimits(planLimits)
+
+	assert.True(t, limiter.AcquireUser(1))
+	assert.False(t, limiter.AcquireUser(1))
+
+	// users without plan limits get MaxUserConns
+	for i := 0; i < 5; i++ {
+		assert.True(t, limiter.AcquireUser(2))
+	}
+	assert.False(t, limiter.AcquireUser(2))
+}
+
+func TestConnectionLimiter_Node(t *testing.T) {
+	limiter := NewConnectionLimiter(config.RateLimiterConfig{MaxNodeConns: 1})
+
+	assert.True(t, limiter.AcquireNode())
+	assert.False(t, limiter.AcquireNode())
+
+	limiter.ReleaseNode()
+	assert.True(t, limiter.AcquireNode())
+
+	unlimited := NewConnectionLimiter(config.RateLimiterConfig{})
+	for i := 0; i < 100; i++ {
+		assert.True(t, unlimited.AcquireNode())
+	}
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"goproxy/application/contracts"
+	"goproxy/infrastructure/config"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// DestinationKind tells how the proxy is going to reach the target, as allowed ports differ.
+type DestinationKind int
+
+const (
+	// TunnelDestination is a CONNECT or SOCKS tunnel.
+	TunnelDestination DestinationKind = iota
+	// HttpDestination is a plain HTTP request forwarded by the proxy.
+	HttpDestination
+	// DatagramDestination is a UDP datagram relayed by the proxy, ports are not restricted.
+	DatagramDestination
+)
+
+// DestinationDeniedError is returned when the policy does not allow connecting to the target.
+type DestinationDeniedError struct {
+	Reason string
+}
+
+func (e DestinationDeniedError) Error() string {
+	return "destination denied: " + e.Reason
+}
+
+// DestinationPolicy decides which targets users may connect to. Targets are checked by domain and port before
+// dialing, and by IP once the dialer has resolved the target, so DNS answers can not point users to internal hosts.
+type DestinationPolicy struct {
+	deniedNetworks []*net.IPNet
+	tunnelPorts    map[int]bool
+	httpPorts      map[int]bool
+	planAllowed    map[int][]string
+	planDenied     map[int][]string
+	planLimits     contracts.UserPlanLimitsService
+}
+
+func NewDestinationPolicy(config config.DestinationPolicyConfig) *DestinationPolicy {
+	return &DestinationPolicy{
+		deniedNetworks: config.DeniedNetworks,
+		tunnelPorts:    config.TunnelPorts,
+		httpPorts:      config.HttpPorts,
+		planAllowed:    config.PlanAllowed,
+		planDenied:     config.PlanDenied,
+	}
+}
+
+// WithPlanLimits enables domain rules of users active plans.
+func (d *DestinationPolicy) WithPlanLimits(planLimits contracts.UserPlanLimitsService) *DestinationPolicy {
+	d.planLimits = planLimits
+	return d
+}
+
+// Check validates the target host:port before dialing. IP targets are checked right away,
+// domain targets are checked by IP when connecting through a dialer guarded by Guard.
+func (d *DestinationPolicy) Check(userId int, kind DestinationKind, host string) error {
+	hostname, portStr, err := net.SplitHostPort(host)
+	if err != nil {
+		return DestinationDeniedError{Reason: "invalid target address"}
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return DestinationDeniedError{Reason: "invalid target port"}
+	}
+
+	switch kind {
+	case TunnelDestination:
+		if len(d.tunnelPorts) != 0 && !d.tunnelPorts[port] {
+			return DestinationDeniedError{Reason: fmt.Sprintf("port %d is not allowed for tunnels", port)}
+		}
+	case HttpDestination:
+		if len(d.httpPorts) != 0 && !d.httpPorts[port] {
+			return DestinationDeniedError{Reason: fmt.Sprintf("port %d is not allowed for http requests", port)}
+		}
+	}
+
+	if ip := net.ParseIP(hostname); ip != nil {
+		if err = d.CheckIP(ip); err != nil {
+			return err
+		}
+	}
+
+	return d.checkDomain(userId, strings.ToLower(strings.TrimSuffix(hostname, ".")))
+}
+
+// CheckIP denies IPs of the denied networks.
+func (d *DestinationPolicy) CheckIP(ip net.IP) error {
+	for _, network := range d.deniedNetworks {
+		if network.Contains(ip) {
+			return DestinationDeniedError{Reason: fmt.Sprintf("address %s is not allowed", ip)}
+		}
+	}
+
+	return nil
+}
+
+// Guard returns a context making dialers created by DialerPool check every IP they connect to.
+func (d *DestinationPolicy) Guard(ctx context.Context) context.Context {
+	return context.WithValue(ctx, destinationGuardKey{}, d)
+}
+
+func (d *DestinationPolicy) checkDomain(userId int, hostname string) error {
+	if d.planLimits == nil || (len(d.planAllowed) == 0 && len(d.planDenied) == 0) {
+		return nil
+	}
+
+	// plan rules can not be checked without the plan, so users with unknown plan are denied
+	planId := d.planLimits.GetLimits(userId).PlanId
+	if planId == 0 {
+		return DestinationDeniedError{Reason: "plan of the user is not known"}
+	}
+
+	if denied, ok := d.planDenied[planId]; ok && matchesDomain(hostname, denied) {
+		return DestinationDeniedError{Reason: fmt.Sprintf("domain %s is not allowed by the plan", hostname)}
+	}
+
+	if allowed, ok := d.planAllowed[planId]; ok && !matchesDomain(hostname, allowed) {
+		return DestinationDeniedError{Reason: fmt.Sprintf("domain %s is not allowed by the plan", hostname)}
+	}
+
+	return nil
+}
+
+// matchesDomain reports whether hostname is one of domains or their subdomain.
+func matchesDomain(hostname string, domains []string) bool {
+	for _, domain := range domains {
+		if hostname == domain || strings.HasSuffix(hostname, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type destinationGuardKey struct{}
+
+// guardDestination is a net.Dialer control function, it runs with the resolved address right before connecting.
+func guardDestination(ctx context.Context, _, address string, _ syscall.RawConn) error {
+	policy, ok := ctx.Value(destinationGuardKey{}).(*DestinationPolicy)
+	if !ok {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return DestinationDeniedError{Reason: "unresolved target address"}
+	}
+
+	return policy.CheckIP(ip)
+}
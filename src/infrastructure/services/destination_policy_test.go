@@ -0,0 +1,108 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"goproxy/domain/dataobjects"
+	"goproxy/domain/valueobjects"
+	"goproxy/infrastructure/config"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDestinationPolicy(t *testing.T) *DestinationPolicy {
+	t.Setenv("DESTINATION_TUNNEL_PORTS", "443")
+	t.Setenv("DESTINATION_HTTP_PORTS", "80")
+	t.Setenv("DESTINATION_PLAN_ALLOW_DOMAINS", "1=example.com")
+	t.Setenv("DESTINATION_PLAN_DENY_DOMAINS", "2=facebook.com")
+	destinationConfig, err := config.LoadDestinationPolicyConfig()
+	require.NoError(t, err)
+
+	planLimits := newTestUserPlanLimitsService()
+	_ = planLimits.SetLimits(1, dataobjects.UserPlanLimits{PlanId: 1})
+	_ = planLimits.SetLimits(2, dataobjects.UserPlanLimits{PlanId: 2})
+	_ = planLimits.SetLimits(3, dataobjects.UserPlanLimits{PlanId: 3})
+
+	return NewDestinationPolicy(destinationConfig).WithPlanLimits(planLimits)
+}
+
+func TestDestinationPolicy_Check(t *testing.T) {
+	policy := newTestDestinationPolicy(t)
+
+	tests := []struct {
+		name    string
+		userId  int
+		kind    DestinationKind
+		host    string
+		allowed bool
+	}{
+		{"public tunnel", 3, TunnelDestination, "example.org:443", true},
+		{"tunnel port not allowed", 3, TunnelDestination, "example.org:22", false},
+		{"http port", 3, HttpDestination, "example.org:80", true},
+		{"http port not allowed", 3, HttpDestination, "example.org:443", false},
+		{"datagram to any port", 3, DatagramDestination, "example.org:53", true},
+		{"loopback ip", 3, TunnelDestination, "127.0.0.1:443", false},
+		{"metadata endpoint", 3, HttpDestination, "169.254.169.254:80", false},
+		{"ipv6 loopback", 3, TunnelDestination, "[::1]:443", false},
+		{"plan allowed domain", 1, TunnelDestination, "example.com:443", true},
+		{"plan allowed subdomain", 1, TunnelDestination, "www.Example.com.:443", true},
+		{"domain outside plan allow list", 1, TunnelDestination, "example.org:443", false},
+		{"plan denied domain", 2, TunnelDestination, "m.facebook.com:443", false},
+		{"domain similar to denied", 2, TunnelDestination, "notfacebook.com:443", true},
+		{"address without port", 3, TunnelDestination, "example.org", false},
+		{"unknown plan", 4, TunnelDestination, "example.org:443", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(tt.userId, tt.kind, tt.host)
+			if tt.allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorAs(t, err, &DestinationDeniedError{})
+			}
+		})
+	}
+}
+
+func TestDestinationPolicy_GuardChecksResolvedAddress(t *testing.T) {
+	policy := NewDestinationPolicy(config.DestinationPolicyConfig{
+		DeniedNetworks: []*net.IPNet{{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}},
+	})
+	listener := listenLocal(t, "tcp4", "127.0.0.1:0")
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	// the host name passes the pre-dial check, its address is denied when connecting
+	assert.NoError(t, policy.Check(1, TunnelDestination, "localhost:"+port))
+	_, err := newEgressDialer(nil, nil).DialContext(policy.Guard(context.Background()), "tcp4", "localhost:"+port)
+	assert.ErrorAs(t, err, &DestinationDeniedError{})
+
+	conn, err := newEgressDialer(nil, nil).DialContext(context.Background(), "tcp4", "localhost:"+port)
+	require.NoError(t, err)
+	_ = conn.Close()
+}
+
+func TestProxy_HandleHttps_DeniedDestination(t *testing.T) {
+	proxy := newTestProxy(t)
+	proxy.destinationPolicy = NewDestinationPolicy(config.DestinationPolicyConfig{
+		DeniedNetworks: []*net.IPNet{{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}},
+	})
+	listener := listenLocal(t, "tcp4", "127.0.0.1:0")
+
+	clientConn, proxyConn := net.Pipe()
+	defer clientConn.Close()
+	request, _ := http.NewRequest(http.MethodConnect, "http://"+listener.Addr().String(), nil)
+	go func() {
+		proxy.HandleHttps(proxyConn, request, 1, 0, valueobjects.ConnectionOptions{})
+		_ = proxyConn.Close()
+	}()
+
+	response, err := http.ReadResponse(bufio.NewReader(clientConn), request)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+	assert.Contains(t, response.Header.Get(destinationDeniedReasonHeader), "127.0.0.1")
+}
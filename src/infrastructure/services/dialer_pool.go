@@ -3,8 +3,11 @@ package services
 import (
 	"context"
 	"errors"
+	"fmt"
 	"goproxy/application/aplication_errors"
 	"goproxy/application/contracts"
+	"goproxy/domain/valueobjects"
+	"goproxy/infrastructure/config"
 	"log"
 	"math/rand"
 	"net"
@@ -13,29 +16,65 @@ import (
 	"time"
 )
 
-const defaultRotationRecordTTL = time.Minute
+const (
+	defaultRotationRecordTTL = time.Minute
+	defaultStickySessionTTL  = 30 * time.Minute
+)
 
 type DialerPool struct {
 	mu        sync.RWMutex
 	randGen   *rand.Rand
-	ipPool    []net.IP
-	dialers   map[string]*net.Dialer
+	ipPool4   []net.IP
+	ipPool6   []net.IP
+	ips       map[string]bool
 	userCache contracts.CacheWithTTL[net.IP]
 
+	// routed prefixes egress IPv6 addresses are allocated from, in addition to ipPool6
+	ipv6Prefixes []*net.IPNet
+
+	// policies applied when the connection does not request one
+	defaultPolicy    valueobjects.EgressPolicy
+	planPolicies     map[int]valueobjects.EgressPolicy
+	stickySessionTTL time.Duration
+	planLimits       contracts.UserPlanLimitsService
+
 	// used to resolve new public IPs assigned to server
 	ipResolver contracts.IPResolver
+	// used by dialers to resolve target host names, dialers resolve them on their own if nil
+	dnsResolver contracts.DnsResolver
 }
 
 func NewDialerPool(ipResolver contracts.IPResolver) *DialerPool {
+	defaultPolicy, _ := valueobjects.NewRotateEveryEgressPolicy(defaultRotationRecordTTL)
+
 	return &DialerPool{
-		ipPool:     []net.IP{},
-		dialers:    make(map[string]*net.Dialer),
-		userCache:  NewMapCacheWithTTL[net.IP](),
-		randGen:    rand.New(rand.NewSource(time.Now().UnixNano())),
-		ipResolver: ipResolver,
+		ips:              make(map[string]bool),
+		userCache:        NewMapCacheWithTTL[net.IP](),
+		randGen:          rand.New(rand.NewSource(time.Now().UnixNano())),
+		ipResolver:       ipResolver,
+		defaultPolicy:    defaultPolicy,
+		planPolicies:     make(map[int]valueobjects.EgressPolicy),
+		stickySessionTTL: defaultStickySessionTTL,
 	}
 }
 
+// WithEgressPolicies makes the pool apply configured default and per-plan policies
+// to connections that do not request a policy, and allocate IPv6 addresses from the configured prefixes.
+func (dp *DialerPool) WithEgressPolicies(config config.EgressPolicyConfig, planLimits contracts.UserPlanLimitsService) *DialerPool {
+	dp.defaultPolicy = config.Default
+	dp.planPolicies = config.Plans
+	dp.stickySessionTTL = config.StickySessionTTL
+	dp.ipv6Prefixes = config.IPv6Prefixes
+	dp.planLimits = planLimits
+	return dp
+}
+
+// WithDnsResolver makes dialers of the pool resolve target host names with the resolver.
+func (dp *DialerPool) WithDnsResolver(resolver contracts.DnsResolver) *DialerPool {
+	dp.dnsResolver = resolver
+	return dp
+}
+
 func (dp *DialerPool) StartExploringNewPublicIps(ctx context.Context, interval time.Duration) {
 	go func() {
 		dp.ipResolver = NewIPResolver()
@@ -62,63 +101,205 @@ func (dp *DialerPool) SetPool(ips []net.IP) {
 	dp.mu.Lock()
 	defer dp.mu.Unlock()
 
-	dp.ipPool = ips
-	dp.dialers = make(map[string]*net.Dialer, len(ips))
+	dp.ipPool4 = nil
+	dp.ipPool6 = nil
+	dp.ips = make(map[string]bool, len(ips))
 
 	for _, ip := range ips {
-		ipStr := ip.String()
-		dp.dialers[ipStr] = &net.Dialer{
-			LocalAddr: &net.TCPAddr{IP: ip},
+		if ip.To4() != nil {
+			dp.ipPool4 = append(dp.ipPool4, ip)
+		} else {
+			dp.ipPool6 = append(dp.ipPool6, ip)
 		}
+		dp.ips[ip.String()] = true
 	}
 }
 
-func (dp *DialerPool) GetDialer(_ string, userId int) (*net.Dialer, error) {
+func (dp *DialerPool) GetDialer(_ string, userId int, policy valueobjects.EgressPolicy) (contracts.Dialer, error) {
+	dialer, err := dp.egressDialer(userId, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer.resolver = dp.dnsResolver
+	return dialer, nil
+}
+
+// egressDialer creates a dialer bound to the egress IPs chosen by the policy.
+func (dp *DialerPool) egressDialer(userId int, policy valueobjects.EgressPolicy) (*EgressDialer, error) {
 	dp.mu.RLock()
-	empty := len(dp.ipPool) == 0
+	has4 := len(dp.ipPool4) != 0
+	has6 := len(dp.ipPool6) != 0 || len(dp.ipv6Prefixes) != 0
 	dp.mu.RUnlock()
-	if empty {
-		return &net.Dialer{
-			LocalAddr: &net.TCPAddr{},
-		}, nil
+	if !has4 && !has6 {
+		return newEgressDialer(nil, nil), nil
 	}
 
-	key := strconv.Itoa(userId)
+	policy = policy.WithFallback(dp.policyOf(userId))
 
-	cachedIP, err := dp.userCache.Get(key)
-	if err != nil {
-		dp.mu.RLock()
-		ip := dp.randomIPLocked()
-		dp.mu.RUnlock()
+	if policy.Kind() == valueobjects.EgressPinned {
+		ip := policy.IP()
+		if !dp.ownsIP(ip) {
+			return nil, errors.New("failed to retrieve dialer for IP " + ip.String())
+		}
+		if ip.To4() != nil {
+			return newEgressDialer(ip, nil), nil
+		}
+		return newEgressDialer(nil, ip), nil
+	}
 
-		_ = dp.userCache.Set(key, ip)
-		_ = dp.userCache.Expire(key, defaultRotationRecordTTL)
-		cachedIP = ip
+	use4, use6 := has4, !has4
+	switch policy.Family() {
+	case valueobjects.IPFamilyV4:
+		if !has4 {
+			return nil, errors.New("no IPv4 egress addresses available")
+		}
+		use4, use6 = true, false
+	case valueobjects.IPFamilyV6:
+		if !has6 {
+			return nil, errors.New("no IPv6 egress addresses available")
+		}
+		use4, use6 = false, true
+	case valueobjects.IPFamilyDual:
+		use4, use6 = has4, has6
 	}
 
-	dp.mu.RLock()
-	dialer, ok := dp.dialers[cachedIP.String()]
-	dp.mu.RUnlock()
-	if !ok {
-		return nil, errors.New("failed to retrieve dialer for IP " + cachedIP.String())
+	var ip4, ip6 net.IP
+	if use4 {
+		ip4 = dp.egressIP(userId, policy, ipv4Family)
+	}
+	if use6 {
+		ip6 = dp.egressIP(userId, policy, ipv6Family)
+	}
+
+	return newEgressDialer(ip4, ip6), nil
+}
+
+const (
+	ipv4Family = "v4"
+	ipv6Family = "v6"
+)
+
+// egressIP picks the IP of the family according to the policy.
+func (dp *DialerPool) egressIP(userId int, policy valueobjects.EgressPolicy, family string) net.IP {
+	switch policy.Kind() {
+	case valueobjects.EgressRotatePerRequest:
+		dp.mu.Lock()
+		defer dp.mu.Unlock()
+		return dp.randomIPLocked(family)
+	case valueobjects.EgressSticky:
+		ttl := dp.stickySessionTTL
+		if policy.SessionTTL() > 0 {
+			ttl = policy.SessionTTL()
+		}
+		key := fmt.Sprintf("%d|session:%s|%s", userId, policy.Session(), family)
+		ip := dp.boundIP(key, family, ttl)
+		// the session keeps its IP for as long as it is used
+		_ = dp.userCache.Expire(key, ttl)
+		return ip
+	default:
+		return dp.boundIP(rotationKey(userId, family), family, policy.Interval())
 	}
-	return dialer, nil
+}
+
+func rotationKey(userId int, family string) string {
+	return strconv.Itoa(userId) + "|" + family
+}
+
+// policyOf returns the policy of the user plan, or the default policy if the plan has none.
+func (dp *DialerPool) policyOf(userId int) valueobjects.EgressPolicy {
+	if dp.planLimits != nil {
+		if policy, ok := dp.planPolicies[dp.planLimits.GetLimits(userId).PlanId]; ok {
+			return policy.WithFallback(dp.defaultPolicy)
+		}
+	}
+
+	return dp.defaultPolicy
+}
+
+// boundIP returns the IP bound to key, binding a random IP of the family for ttl if there is none
+// or the bound IP no longer belongs to the pool.
+func (dp *DialerPool) boundIP(key string, family string, ttl time.Duration) net.IP {
+	cachedIP, err := dp.userCache.Get(key)
+
+	dp.mu.Lock()
+	defer dp.mu.Unlock()
+
+	if err == nil && dp.ownsIPLocked(cachedIP) {
+		return cachedIP
+	}
+
+	ip := dp.randomIPLocked(family)
+	_ = dp.userCache.Set(key, ip)
+	_ = dp.userCache.Expire(key, ttl)
+	return ip
 }
 
 func (dp *DialerPool) BindDialerToUser(userId int, ttl time.Duration) error {
 	dp.mu.Lock()
 	defer dp.mu.Unlock()
 
-	if len(dp.ipPool) == 0 {
-		return aplication_errors.ErrIpPoolEmpty{}
+	family := ipv4Family
+	if len(dp.ipPool4) == 0 {
+		if len(dp.ipPool6) == 0 && len(dp.ipv6Prefixes) == 0 {
+			return aplication_errors.ErrIpPoolEmpty{}
+		}
+		family = ipv6Family
 	}
-	ip := dp.randomIPLocked()
-	key := strconv.Itoa(userId)
+
+	ip := dp.randomIPLocked(family)
+	key := rotationKey(userId, family)
 	_ = dp.userCache.Set(key, ip)
 	_ = dp.userCache.Expire(key, ttl)
 	return nil
 }
 
-func (dp *DialerPool) randomIPLocked() net.IP {
-	return dp.ipPool[dp.randGen.Intn(len(dp.ipPool))]
+func (dp *DialerPool) ownsIP(ip net.IP) bool {
+	dp.mu.RLock()
+	defer dp.mu.RUnlock()
+
+	return dp.ownsIPLocked(ip)
+}
+
+func (dp *DialerPool) ownsIPLocked(ip net.IP) bool {
+	if dp.ips[ip.String()] {
+		return true
+	}
+
+	if ip.To4() == nil {
+		for _, prefix := range dp.ipv6Prefixes {
+			if prefix.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// randomIPLocked picks a random IP of the family. IPv6 addresses are allocated from the configured prefixes
+// if there are any, otherwise they are picked from the IPv6 addresses of the host.
+func (dp *DialerPool) randomIPLocked(family string) net.IP {
+	if family == ipv4Family {
+		return dp.ipPool4[dp.randGen.Intn(len(dp.ipPool4))]
+	}
+
+	if len(dp.ipv6Prefixes) != 0 {
+		return dp.randomIPInPrefixLocked(dp.ipv6Prefixes[dp.randGen.Intn(len(dp.ipv6Prefixes))])
+	}
+
+	return dp.ipPool6[dp.randGen.Intn(len(dp.ipPool6))]
+}
+
+// randomIPInPrefixLocked fills the host bits of the prefix with random bits.
+func (dp *DialerPool) randomIPInPrefixLocked(prefix *net.IPNet) net.IP {
+	host := make([]byte, net.IPv6len)
+	dp.randGen.Read(host)
+
+	ip := make(net.IP, net.IPv6len)
+	for i := range ip {
+		ip[i] = prefix.IP[i]&prefix.Mask[i] | host[i]&^prefix.Mask[i]
+	}
+
+	return ip
 }
@@ -1,14 +1,21 @@
 package services
 
 import (
+	"goproxy/domain/dataobjects"
+	"goproxy/domain/valueobjects"
+	"goproxy/infrastructure/config"
 	"net"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestDialerPool_GetDialer_EmptyPool(t *testing.T) {
 	resolver := NewIPResolver()
 	pool := NewDialerPool(resolver)
-	dialer, dialerErr := pool.GetDialer("tcp", 1)
+	dialer, dialerErr := pool.GetDialer("tcp", 1, valueobjects.EgressPolicy{})
 	if dialerErr != nil {
 		t.Error(dialerErr)
 	}
@@ -29,7 +36,7 @@ func TestDialerPool_GetDialer_NotEmptyPool(t *testing.T) {
 	pool.SetPool(poolIps)
 
 	for i := 0; i < len(poolIps); i++ {
-		dialer, dialerErr := pool.GetDialer("tcp", 1)
+		dialer, dialerErr := pool.GetDialer("tcp", 1, valueobjects.EgressPolicy{})
 		if dialerErr != nil {
 			t.Error(dialerErr)
 		}
@@ -40,3 +47,149 @@ func TestDialerPool_GetDialer_NotEmptyPool(t *testing.T) {
 	}
 
 }
+
+func newTestDialerPool() *DialerPool {
+	pool := NewDialerPool(NewIPResolver())
+	pool.SetPool([]net.IP{
+		net.ParseIP("127.0.0.1"),
+		net.ParseIP("127.0.0.2"),
+		net.ParseIP("127.0.0.3"),
+		net.ParseIP("127.0.0.4"),
+	})
+	return pool
+}
+
+func egressIP(t *testing.T, pool *DialerPool, userId int, policy valueobjects.EgressPolicy) string {
+	dialer, err := pool.GetDialer("tcp", userId, policy)
+	require.NoError(t, err)
+	return dialer.LocalIP().String()
+}
+
+func TestDialerPool_GetDialer_StickySessionKeepsIP(t *testing.T) {
+	pool := newTestDialerPool()
+	session, _ := valueobjects.NewStickyEgressPolicy("abc123")
+
+	ip := egressIP(t, pool, 1, session)
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, ip, egressIP(t, pool, 1, session))
+	}
+}
+
+func TestDialerPool_GetDialer_RotatePerRequestChangesIP(t *testing.T) {
+	pool := newTestDialerPool()
+	policy := valueobjects.NewRotatePerRequestEgressPolicy()
+
+	ips := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		ips[egressIP(t, pool, 1, policy)] = true
+	}
+	assert.Greater(t, len(ips), 1)
+}
+
+func TestDialerPool_GetDialer_RotateEveryInterval(t *testing.T) {
+	pool := newTestDialerPool()
+	policy, _ := valueobjects.NewRotateEveryEgressPolicy(50 * time.Millisecond)
+
+	ip := egressIP(t, pool, 1, policy)
+	assert.Equal(t, ip, egressIP(t, pool, 1, policy))
+
+	// a new IP is picked once the interval passes, it could be the same one by chance
+	changed := false
+	for i := 0; i < 20 && !changed; i++ {
+		time.Sleep(60 * time.Millisecond)
+		changed = egressIP(t, pool, 1, policy) != ip
+	}
+	assert.True(t, changed)
+}
+
+func TestDialerPool_GetDialer_PinnedIP(t *testing.T) {
+	pool := newTestDialerPool()
+
+	pinned, _ := valueobjects.NewPinnedEgressPolicy("127.0.0.3")
+	assert.Equal(t, "127.0.0.3", egressIP(t, pool, 1, pinned))
+
+	outsidePool, _ := valueobjects.NewPinnedEgressPolicy("127.0.0.9")
+	_, err := pool.GetDialer("tcp", 1, outsidePool)
+	assert.Error(t, err)
+}
+
+func TestDialerPool_GetDialer_PlanPolicy(t *testing.T) {
+	planLimits := newTestUserPlanLimitsService()
+	_ = planLimits.SetLimits(1, dataobjects.UserPlanLimits{PlanId: 2})
+
+	pool := newTestDialerPool().WithEgressPolicies(config.EgressPolicyConfig{
+		Default:          valueobjects.NewRotatePerRequestEgressPolicy(),
+		Plans:            map[int]valueobjects.EgressPolicy{2: mustParseEgressPolicy(t, "sticky")},
+		StickySessionTTL: time.Minute,
+	}, planLimits)
+
+	// users of the plan are sticky
+	ip := egressIP(t, pool, 1, valueobjects.EgressPolicy{})
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, ip, egressIP(t, pool, 1, valueobjects.EgressPolicy{}))
+	}
+
+	// the requested policy overrides the plan one
+	ips := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		ips[egressIP(t, pool, 1, valueobjects.NewRotatePerRequestEgressPolicy())] = true
+	}
+	assert.Greater(t, len(ips), 1)
+}
+
+func mustParseEgressPolicy(t *testing.T, policy string) valueobjects.EgressPolicy {
+	parsed, err := valueobjects.ParseEgressPolicy(policy)
+	require.NoError(t, err)
+	return parsed
+}
+
+func TestDialerPool_GetDialer_AddressFamilies(t *testing.T) {
+	pool := NewDialerPool(NewIPResolver())
+	pool.SetPool([]net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")})
+
+	// IPv4 is used unless another family is requested
+	assert.Equal(t, "127.0.0.1", egressIP(t, pool, 1, valueobjects.EgressPolicy{}))
+	assert.Equal(t, "::1", egressIP(t, pool, 1, mustParseEgressPolicy(t, "v6")))
+
+	dialer, err := pool.GetDialer("tcp", 1, mustParseEgressPolicy(t, "dual"))
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", dialer.LocalIP().String())
+	assert.Equal(t, "::1", dialer.(*EgressDialer).v6.LocalAddr.(*net.TCPAddr).IP.String())
+}
+
+func TestDialerPool_GetDialer_MissingFamily(t *testing.T) {
+	pool := NewDialerPool(NewIPResolver())
+	pool.SetPool([]net.IP{net.ParseIP("127.0.0.1")})
+
+	_, err := pool.GetDialer("tcp", 1, mustParseEgressPolicy(t, "v6"))
+	assert.Error(t, err)
+
+	// dual-stack falls back to the available family
+	assert.Equal(t, "127.0.0.1", egressIP(t, pool, 1, mustParseEgressPolicy(t, "dual")))
+}
+
+func TestDialerPool_GetDialer_AllocatesFromIPv6Prefix(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("2001:db8:1::/48")
+	pool := NewDialerPool(NewIPResolver()).WithEgressPolicies(config.EgressPolicyConfig{
+		Default:          valueobjects.NewRotatePerRequestEgressPolicy(),
+		Plans:            map[int]valueobjects.EgressPolicy{},
+		StickySessionTTL: time.Minute,
+		IPv6Prefixes:     []*net.IPNet{prefix},
+	}, nil)
+
+	ips := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		ip := egressIP(t, pool, 1, valueobjects.EgressPolicy{})
+		assert.True(t, prefix.Contains(net.ParseIP(ip)), ip)
+		ips[ip] = true
+	}
+	assert.Len(t, ips, 20)
+
+	// sticky sessions keep the allocated address
+	session := mustParseEgressPolicy(t, "session-abc")
+	ip := egressIP(t, pool, 1, session)
+	assert.Equal(t, ip, egressIP(t, pool, 1, session))
+
+	pinned := mustParseEgressPolicy(t, "ip-2001:db8:1::42")
+	assert.Equal(t, "2001:db8:1::42", egressIP(t, pool, 1, pinned))
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"time"
+)
+
+const (
+	digestNonceKeyPrefix = "digest_nonce"
+	// digestNonceRedisTimeout bounds a single Redis call, so a slow Redis does not stall authentication
+	digestNonceRedisTimeout = 100 * time.Millisecond
+)
+
+// digestNonceUseScript takes the nonce count unless a count not less than it was taken before.
+// KEYS[1] - nonce key
+// ARGV - count, ttl (ms)
+var digestNonceUseScript = redis.NewScript(`
+local count = tonumber(ARGV[1])
+if count <= (tonumber(redis.call('GET', KEYS[1])) or 0) then
+	return 0
+end
+
+redis.call('SET', KEYS[1], count, 'PX', ARGV[2])
+return 1
+`)
+
+// RedisDigestNonceCounter shares Digest nonce counts between nodes through Redis, so a response accepted
+// by one node is refused by the others. Counts are not kept locally if Redis is unreachable, as that would
+// let responses be replayed on other nodes, Digest authentication fails instead.
+type RedisDigestNonceCounter struct {
+	client *redis.Client
+}
+
+func NewRedisDigestNonceCounter() (*RedisDigestNonceCounter, error) {
+	client, err := newRedisClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return newRedisDigestNonceCounter(client), nil
+}
+
+func newRedisDigestNonceCounter(client *redis.Client) *RedisDigestNonceCounter {
+	return &RedisDigestNonceCounter{
+		client: client,
+	}
+}
+
+func (c *RedisDigestNonceCounter) Use(nonce string, count uint64, expiresAt time.Time) (bool, error) {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), digestNonceRedisTimeout)
+	defer cancel()
+
+	taken, err := digestNonceUseScript.Run(ctx, c.client, []string{fmt.Sprintf("%s:%s", digestNonceKeyPrefix, nonce)},
+		count, max(ttl.Milliseconds(), 1)).Int()
+	if err != nil {
+		return false, fmt.Errorf("could not take digest nonce count in redis: %v", err)
+	}
+
+	return taken == 1, nil
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestRedisDigestNonceCounter(t *testing.T) {
+	redisContainer, address, err := setupRedisContainer()
+	require.NoError(t, err)
+	defer func(redisContainer testcontainers.Container, ctx context.Context) {
+		_ = redisContainer.Terminate(ctx)
+	}(redisContainer, context.Background())
+
+	// two counters share the counts like two proxy nodes do
+	first := newRedisDigestNonceCounter(redis.NewClient(&redis.Options{Addr: address}))
+	second := newRedisDigestNonceCounter(redis.NewClient(&redis.Options{Addr: address}))
+	expiresAt := time.Now().Add(time.Minute)
+
+	taken, err := first.Use("nonce", 1, expiresAt)
+	require.NoError(t, err)
+	assert.True(t, taken)
+
+	taken, err = second.Use("nonce", 1, expiresAt)
+	require.NoError(t, err)
+	assert.False(t, taken, "a count taken on one node must be refused on the others")
+
+	taken, err = second.Use("nonce", 2, expiresAt)
+	require.NoError(t, err)
+	assert.True(t, taken)
+
+	taken, err = first.Use("another nonce", 1, expiresAt)
+	require.NoError(t, err)
+	assert.True(t, taken)
+}
+
+func TestRedisDigestNonceCounter_Unavailable(t *testing.T) {
+	// nothing listens on port 1, so every Redis call fails
+	counter := newRedisDigestNonceCounter(redis.NewClient(&redis.Options{
+		Addr:        "127.0.0.1:1",
+		DialTimeout: 50 * time.Millisecond,
+	}))
+
+	taken, err := counter.Use("nonce", 1, time.Now().Add(time.Minute))
+	assert.Error(t, err)
+	assert.False(t, taken)
+}
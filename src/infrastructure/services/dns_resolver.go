@@ -0,0 +1,555 @@
+package services
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"goproxy/infrastructure/config"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// dnsUdpBufferSize is the EDNS payload size recommended to avoid IP fragmentation (DNS flag day 2020).
+	dnsUdpBufferSize = 1232
+	// systemResolverTTL is how long answers of the system resolver are cached, as it does not report TTLs.
+	systemResolverTTL  = time.Minute
+	maxDnsCacheEntries = 100_000
+	maxDnsMessageSize  = 65535
+)
+
+// DnsResolverStats describes resolver efficiency since the start.
+type DnsResolverStats struct {
+	Lookups   uint64
+	CacheHits uint64
+	Failures  uint64
+	// AverageLatency is the average duration of lookups not answered from the cache.
+	AverageLatency time.Duration
+}
+
+func (s DnsResolverStats) HitRate() float64 {
+	if s.Lookups == 0 {
+		return 0
+	}
+	return float64(s.CacheHits) / float64(s.Lookups)
+}
+
+// CachingDnsResolver resolves host names through configured upstreams (plain UDP, DNS-over-TLS or DNS-over-HTTPS)
+// or the system resolver, caching answers for their TTL. A and AAAA records are looked up and cached separately,
+// so dialers bound to an egress IP only ask for the addresses of its family.
+type CachingDnsResolver struct {
+	upstreams   []dnsUpstream
+	timeout     time.Duration
+	maxTTL      time.Duration
+	negativeTTL time.Duration
+
+	mu        sync.Mutex
+	cache     map[string]*list.Element
+	cacheSize int
+	// recentlyUsed orders cached answers from the most to the least recently used
+	recentlyUsed *list.List
+	// concurrent lookups of the same name share a single query
+	inflight singleflight.Group
+
+	queryIdMutex sync.Mutex
+	queryIdGen   *rand.Rand
+
+	lookups     atomic.Uint64
+	cacheHits   atomic.Uint64
+	failures    atomic.Uint64
+	misses      atomic.Uint64
+	missLatency atomic.Int64
+}
+
+// cachedDnsAnswer is an element of recentlyUsed.
+type cachedDnsAnswer struct {
+	key   string
+	entry dnsCacheEntry
+}
+
+type dnsCacheEntry struct {
+	ips        []net.IP
+	notFound   bool
+	expiration time.Time
+}
+
+// dnsUpstream sends a DNS query message and returns the response message.
+type dnsUpstream interface {
+	exchange(ctx context.Context, query []byte) ([]byte, error)
+}
+
+func NewCachingDnsResolver(config config.DnsResolverConfig) *CachingDnsResolver {
+	resolver := &CachingDnsResolver{
+		timeout:      config.Timeout,
+		maxTTL:       config.MaxTTL,
+		negativeTTL:  config.NegativeTTL,
+		cache:        make(map[string]*list.Element),
+		cacheSize:    maxDnsCacheEntries,
+		recentlyUsed: list.New(),
+		queryIdGen:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	for _, upstream := range config.Upstreams {
+		switch upstream.Scheme {
+		case "udp":
+			resolver.upstreams = append(resolver.upstreams, udpDnsUpstream{address: upstream.Host})
+		case "tls":
+			resolver.upstreams = append(resolver.upstreams, tlsDnsUpstream{
+				address: upstream.Host,
+				config:  &tls.Config{ServerName: upstream.Hostname()},
+			})
+		case "https":
+			resolver.upstreams = append(resolver.upstreams, httpsDnsUpstream{
+				url:    upstream.String(),
+				client: &http.Client{Timeout: config.Timeout},
+			})
+		}
+	}
+
+	return resolver
+}
+
+// StartStatsLogging logs resolver stats every interval until ctx is done.
+func (r *CachingDnsResolver) StartStatsLogging(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				stats := r.Stats()
+				log.Printf("dns resolver: %d lookups, cache hit rate %.1f%%, %d failures, average lookup latency %v",
+					stats.Lookups, stats.HitRate()*100, stats.Failures, stats.AverageLatency)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (r *CachingDnsResolver) Stats() DnsResolverStats {
+	stats := DnsResolverStats{
+		Lookups:   r.lookups.Load(),
+		CacheHits: r.cacheHits.Load(),
+		Failures:  r.failures.Load(),
+	}
+	if misses := r.misses.Load(); misses != 0 {
+		stats.AverageLatency = time.Duration(r.missLatency.Load() / int64(misses))
+	}
+
+	return stats
+}
+
+func (r *CachingDnsResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	switch network {
+	case "ip4":
+		return r.lookup(ctx, host, dnsmessage.TypeA)
+	case "ip6":
+		return r.lookup(ctx, host, dnsmessage.TypeAAAA)
+	case "ip":
+	default:
+		return nil, fmt.Errorf("unsupported lookup network: %s", network)
+	}
+
+	// both families are looked up at once, IPv4 addresses go first as the more widely reachable ones
+	var ips6 []net.IP
+	var err6 error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ips6, err6 = r.lookup(ctx, host, dnsmessage.TypeAAAA)
+	}()
+
+	ips4, err4 := r.lookup(ctx, host, dnsmessage.TypeA)
+	<-done
+
+	if err4 != nil && err6 != nil {
+		return nil, err4
+	}
+
+	return append(ips4, ips6...), nil
+}
+
+func (r *CachingDnsResolver) lookup(ctx context.Context, host string, recordType dnsmessage.Type) ([]net.IP, error) {
+	r.lookups.Add(1)
+	key := strings.ToLower(strings.TrimSuffix(host, ".")) + "|" + recordType.String()
+
+	if entry, ok := r.cached(key); ok {
+		r.cacheHits.Add(1)
+		return entry.result(host)
+	}
+
+	// the shared query outlives callers giving up early, so that its answer is cached for the next ones
+	resultChan := r.inflight.DoChan(key, func() (any, error) {
+		start := time.Now()
+		entry, ttl, err := r.resolve(host, recordType)
+		r.misses.Add(1)
+		r.missLatency.Add(int64(time.Since(start)))
+		if err != nil {
+			return nil, err
+		}
+
+		r.store(key, entry, ttl)
+		return entry, nil
+	})
+
+	select {
+	case result := <-resultChan:
+		if result.Err != nil {
+			r.failures.Add(1)
+			return nil, &net.DNSError{Err: result.Err.Error(), Name: host, IsTemporary: true}
+		}
+		return result.Val.(dnsCacheEntry).result(host)
+	case <-ctx.Done():
+		return nil, &net.DNSError{Err: ctx.Err().Error(), Name: host, IsTimeout: true}
+	}
+}
+
+func (e dnsCacheEntry) result(host string) ([]net.IP, error) {
+	if e.notFound {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return e.ips, nil
+}
+
+func (r *CachingDnsResolver) cached(key string) (dnsCacheEntry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	element, ok := r.cache[key]
+	if !ok {
+		return dnsCacheEntry{}, false
+	}
+
+	cached := element.Value.(*cachedDnsAnswer)
+	if time.Now().After(cached.entry.expiration) {
+		r.recentlyUsed.Remove(element)
+		delete(r.cache, key)
+		return dnsCacheEntry{}, false
+	}
+
+	r.recentlyUsed.MoveToFront(element)
+	return cached.entry, true
+}
+
+func (r *CachingDnsResolver) store(key string, entry dnsCacheEntry, ttl time.Duration) {
+	if entry.notFound {
+		ttl = r.negativeTTL
+	}
+	ttl = min(ttl, r.maxTTL)
+	if ttl <= 0 {
+		return
+	}
+	entry.expiration = time.Now().Add(ttl)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if element, ok := r.cache[key]; ok {
+		element.Value.(*cachedDnsAnswer).entry = entry
+		r.recentlyUsed.MoveToFront(element)
+		return
+	}
+
+	r.cache[key] = r.recentlyUsed.PushFront(&cachedDnsAnswer{key: key, entry: entry})
+	if r.recentlyUsed.Len() > r.cacheSize {
+		oldest := r.recentlyUsed.Remove(r.recentlyUsed.Back()).(*cachedDnsAnswer)
+		delete(r.cache, oldest.key)
+	}
+}
+
+// resolve queries upstreams in order until one of them answers. Missing names are an answer, not an error.
+func (r *CachingDnsResolver) resolve(host string, recordType dnsmessage.Type) (dnsCacheEntry, time.Duration, error) {
+	if len(r.upstreams) == 0 {
+		return r.resolveWithSystem(host, recordType)
+	}
+
+	query, err := r.buildQuery(host, recordType)
+	if err != nil {
+		return dnsCacheEntry{}, 0, err
+	}
+
+	var lastErr error
+	for _, upstream := range r.upstreams {
+		ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+		response, exchangeErr := upstream.exchange(ctx, query)
+		cancel()
+		if exchangeErr != nil {
+			lastErr = exchangeErr
+			continue
+		}
+
+		entry, ttl, parseErr := parseDnsResponse(response, recordType)
+		if parseErr != nil {
+			lastErr = parseErr
+			continue
+		}
+
+		return entry, ttl, nil
+	}
+
+	return dnsCacheEntry{}, 0, lastErr
+}
+
+func (r *CachingDnsResolver) resolveWithSystem(host string, recordType dnsmessage.Type) (dnsCacheEntry, time.Duration, error) {
+	network := "ip4"
+	if recordType == dnsmessage.TypeAAAA {
+		network = "ip6"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, network, host)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return dnsCacheEntry{notFound: true}, 0, nil
+		}
+		return dnsCacheEntry{}, 0, err
+	}
+
+	return dnsCacheEntry{ips: ips}, systemResolverTTL, nil
+}
+
+func (r *CachingDnsResolver) buildQuery(host string, recordType dnsmessage.Type) ([]byte, error) {
+	name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return nil, fmt.Errorf("invalid host name %s: %v", host, err)
+	}
+
+	r.queryIdMutex.Lock()
+	id := uint16(r.queryIdGen.Intn(1 << 16))
+	r.queryIdMutex.Unlock()
+
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	builder.EnableCompression()
+	if err = builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err = builder.Question(dnsmessage.Question{Name: name, Type: recordType, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	if err = builder.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	var optHeader dnsmessage.ResourceHeader
+	if err = optHeader.SetEDNS0(dnsUdpBufferSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, err
+	}
+	if err = builder.OPTResource(optHeader, dnsmessage.OPTResource{}); err != nil {
+		return nil, err
+	}
+
+	return builder.Finish()
+}
+
+// parseDnsResponse returns addresses of the record type and the lowest TTL of the answers.
+func parseDnsResponse(response []byte, recordType dnsmessage.Type) (dnsCacheEntry, time.Duration, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(response)
+	if err != nil {
+		return dnsCacheEntry{}, 0, err
+	}
+
+	if !header.Response {
+		return dnsCacheEntry{}, 0, errors.New("dns message is not a response")
+	}
+	switch header.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return dnsCacheEntry{notFound: true}, 0, nil
+	default:
+		return dnsCacheEntry{}, 0, fmt.Errorf("dns server responded with %v", header.RCode)
+	}
+
+	if err = parser.SkipAllQuestions(); err != nil {
+		return dnsCacheEntry{}, 0, err
+	}
+
+	var entry dnsCacheEntry
+	var ttl time.Duration
+	for {
+		answerHeader, answerErr := parser.AnswerHeader()
+		if errors.Is(answerErr, dnsmessage.ErrSectionDone) {
+			break
+		}
+		if answerErr != nil {
+			return dnsCacheEntry{}, 0, answerErr
+		}
+
+		answerTTL := time.Duration(answerHeader.TTL) * time.Second
+		if ttl == 0 || answerTTL < ttl {
+			ttl = answerTTL
+		}
+
+		switch {
+		case answerHeader.Type == dnsmessage.TypeA && recordType == dnsmessage.TypeA:
+			resource, resourceErr := parser.AResource()
+			if resourceErr != nil {
+				return dnsCacheEntry{}, 0, resourceErr
+			}
+			entry.ips = append(entry.ips, net.IP(resource.A[:]))
+		case answerHeader.Type == dnsmessage.TypeAAAA && recordType == dnsmessage.TypeAAAA:
+			resource, resourceErr := parser.AAAAResource()
+			if resourceErr != nil {
+				return dnsCacheEntry{}, 0, resourceErr
+			}
+			entry.ips = append(entry.ips, net.IP(resource.AAAA[:]))
+		default:
+			if err = parser.SkipAnswer(); err != nil {
+				return dnsCacheEntry{}, 0, err
+			}
+		}
+	}
+
+	// the name exists, but has no records of the type
+	if len(entry.ips) == 0 {
+		entry.notFound = true
+	}
+
+	return entry, ttl, nil
+}
+
+type udpDnsUpstream struct {
+	address string
+}
+
+// exchange falls back to TCP if the response does not fit into a datagram.
+func (u udpDnsUpstream) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", u.address)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if _, err = conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, dnsUdpBufferSize)
+	for {
+		n, readErr := conn.Read(buf)
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		// responses to other queries are ignored, they could be spoofed
+		if n < 12 || !bytes.Equal(buf[:2], query[:2]) {
+			continue
+		}
+
+		const truncatedFlag = 0x02
+		if buf[2]&truncatedFlag != 0 {
+			tcpConn, tcpErr := dialer.DialContext(ctx, "tcp", u.address)
+			if tcpErr != nil {
+				return nil, tcpErr
+			}
+			defer func() {
+				_ = tcpConn.Close()
+			}()
+			return exchangeDnsStream(ctx, tcpConn, query)
+		}
+
+		return buf[:n], nil
+	}
+}
+
+type tlsDnsUpstream struct {
+	address string
+	config  *tls.Config
+}
+
+func (u tlsDnsUpstream) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	dialer := tls.Dialer{Config: u.config}
+	conn, err := dialer.DialContext(ctx, "tcp", u.address)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	return exchangeDnsStream(ctx, conn, query)
+}
+
+// exchangeDnsStream sends the query over a stream connection, where messages are prefixed with their length.
+func exchangeDnsStream(ctx context.Context, conn net.Conn, query []byte) ([]byte, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	message := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(query)), uint16(len(query)))
+	if _, err := conn.Write(append(message, query...)); err != nil {
+		return nil, err
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+
+	response := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+type httpsDnsUpstream struct {
+	url    string
+	client *http.Client
+}
+
+func (u httpsDnsUpstream) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	// RFC 8484 recommends zero message ID, so that responses can be cached by HTTP caches
+	message := append([]byte{0, 0}, query[2:]...)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, u.url, bytes.NewReader(message))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/dns-message")
+	request.Header.Set("Accept", "application/dns-message")
+
+	response, err := u.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("dns-over-https server responded with %s", response.Status)
+	}
+
+	return io.ReadAll(io.LimitReader(response.Body, maxDnsMessageSize))
+}
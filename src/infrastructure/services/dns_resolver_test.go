@@ -0,0 +1,248 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"goproxy/infrastructure/config"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// testDnsRecords answers A and AAAA queries of example.test, other names do not exist.
+var testDnsRecords = map[string][]net.IP{
+	"example.test.": {net.ParseIP("192.0.2.10"), net.ParseIP("2001:db8::10")},
+}
+
+func answerDnsQuery(t *testing.T, query []byte) []byte {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	require.NoError(t, err)
+	question, err := parser.Question()
+	require.NoError(t, err)
+
+	records, exists := testDnsRecords[strings.ToLower(question.Name.String())]
+	responseHeader := dnsmessage.Header{ID: header.ID, Response: true, RecursionAvailable: true}
+	if !exists {
+		responseHeader.RCode = dnsmessage.RCodeNameError
+	}
+
+	builder := dnsmessage.NewBuilder(nil, responseHeader)
+	require.NoError(t, builder.StartQuestions())
+	require.NoError(t, builder.Question(question))
+	require.NoError(t, builder.StartAnswers())
+	for _, ip := range records {
+		resourceHeader := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 60}
+		if ip4 := ip.To4(); ip4 != nil && question.Type == dnsmessage.TypeA {
+			require.NoError(t, builder.AResource(resourceHeader, dnsmessage.AResource{A: [4]byte(ip4)}))
+		} else if ip4 == nil && question.Type == dnsmessage.TypeAAAA {
+			require.NoError(t, builder.AAAAResource(resourceHeader, dnsmessage.AAAAResource{AAAA: [16]byte(ip)}))
+		}
+	}
+
+	response, err := builder.Finish()
+	require.NoError(t, err)
+	return response
+}
+
+// startUdpDnsServer starts a DNS server counting received queries.
+func startUdpDnsServer(t *testing.T, queries *atomic.Int32) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		buf := make([]byte, dnsUdpBufferSize)
+		for {
+			n, addr, readErr := conn.ReadFrom(buf)
+			if readErr != nil {
+				return
+			}
+			queries.Add(1)
+			_, _ = conn.WriteTo(answerDnsQuery(t, buf[:n]), addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func newTestDnsResolver(upstreams ...dnsUpstream) *CachingDnsResolver {
+	resolver := NewCachingDnsResolver(config.DnsResolverConfig{
+		Timeout:     time.Second,
+		MaxTTL:      time.Minute,
+		NegativeTTL: time.Minute,
+	})
+	resolver.upstreams = upstreams
+	return resolver
+}
+
+func TestCachingDnsResolver_ResolvesFamiliesAndCaches(t *testing.T) {
+	var queries atomic.Int32
+	resolver := newTestDnsResolver(udpDnsUpstream{address: startUdpDnsServer(t, &queries)})
+
+	ips, err := resolver.LookupIP(context.Background(), "ip4", "example.test")
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.10", ips[0].String())
+
+	ips, err = resolver.LookupIP(context.Background(), "ip6", "Example.Test.")
+	require.NoError(t, err)
+	assert.Equal(t, "2001:db8::10", ips[0].String())
+
+	ips, err = resolver.LookupIP(context.Background(), "ip", "example.test")
+	require.NoError(t, err)
+	assert.Len(t, ips, 2)
+
+	assert.Equal(t, int32(2), queries.Load())
+	stats := resolver.Stats()
+	assert.Equal(t, uint64(4), stats.Lookups)
+	assert.Equal(t, uint64(2), stats.CacheHits)
+	assert.Equal(t, 0.5, stats.HitRate())
+	assert.Positive(t, stats.AverageLatency)
+}
+
+func TestCachingDnsResolver_CachesMissingNames(t *testing.T) {
+	var queries atomic.Int32
+	resolver := newTestDnsResolver(udpDnsUpstream{address: startUdpDnsServer(t, &queries)})
+
+	for i := 0; i < 2; i++ {
+		_, err := resolver.LookupIP(context.Background(), "ip4", "missing.test")
+		var dnsErr *net.DNSError
+		require.ErrorAs(t, err, &dnsErr)
+		assert.True(t, dnsErr.IsNotFound)
+	}
+
+	assert.Equal(t, int32(1), queries.Load())
+}
+
+func TestCachingDnsResolver_EvictsLeastRecentlyUsed(t *testing.T) {
+	resolver := newTestDnsResolver()
+	resolver.cacheSize = 2
+
+	entry := dnsCacheEntry{ips: []net.IP{net.ParseIP("192.0.2.10")}}
+	resolver.store("a", entry, time.Minute)
+	resolver.store("b", entry, time.Minute)
+	_, ok := resolver.cached("a")
+	assert.True(t, ok)
+
+	resolver.store("c", entry, time.Minute)
+
+	for key, expected := range map[string]bool{"a": true, "b": false, "c": true} {
+		_, ok = resolver.cached(key)
+		assert.Equal(t, expected, ok, key)
+	}
+	assert.Len(t, resolver.cache, 2)
+	assert.Equal(t, 2, resolver.recentlyUsed.Len())
+}
+
+func TestCachingDnsResolver_FailsOverToNextUpstream(t *testing.T) {
+	var queries atomic.Int32
+	resolver := newTestDnsResolver(
+		udpDnsUpstream{address: unusedAddress(t)},
+		udpDnsUpstream{address: startUdpDnsServer(t, &queries)},
+	)
+
+	ips, err := resolver.LookupIP(context.Background(), "ip4", "example.test")
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.10", ips[0].String())
+	assert.Equal(t, uint64(0), resolver.Stats().Failures)
+}
+
+func TestCachingDnsResolver_DnsOverHttps(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" || !bytes.Equal(query[:2], []byte{0, 0}) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(answerDnsQuery(t, query))
+	}))
+	t.Cleanup(server.Close)
+
+	resolver := newTestDnsResolver(httpsDnsUpstream{url: server.URL + "/dns-query", client: server.Client()})
+
+	ips, err := resolver.LookupIP(context.Background(), "ip4", "example.test")
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.10", ips[0].String())
+}
+
+func TestCachingDnsResolver_DnsOverTls(t *testing.T) {
+	certificateServer := httptest.NewTLSServer(nil)
+	certificateServer.Close()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certificateServer.TLS.Certificates})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, acceptErr := listener.Accept()
+			if acceptErr != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var length [2]byte
+				if _, readErr := io.ReadFull(conn, length[:]); readErr != nil {
+					return
+				}
+				query := make([]byte, int(length[0])<<8|int(length[1]))
+				if _, readErr := io.ReadFull(conn, query); readErr != nil {
+					return
+				}
+				response := answerDnsQuery(t, query)
+				_, _ = conn.Write(append([]byte{byte(len(response) >> 8), byte(len(response))}, response...))
+			}()
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(certificateServer.Certificate())
+	resolver := newTestDnsResolver(tlsDnsUpstream{
+		address: listener.Addr().String(),
+		config:  &tls.Config{RootCAs: roots, ServerName: "example.com"},
+	})
+
+	ips, err := resolver.LookupIP(context.Background(), "ip6", "example.test")
+	require.NoError(t, err)
+	assert.Equal(t, "2001:db8::10", ips[0].String())
+}
+
+// staticDnsResolver resolves every name to loopback addresses.
+type staticDnsResolver struct {
+	networks []string
+}
+
+func (r *staticDnsResolver) LookupIP(_ context.Context, network, _ string) ([]net.IP, error) {
+	r.networks = append(r.networks, network)
+	if network == "ip6" {
+		return []net.IP{net.ParseIP("::1")}, nil
+	}
+	return []net.IP{net.ParseIP("127.0.0.1")}, nil
+}
+
+func TestEgressDialer_ResolvesFamilyOfEgressIP(t *testing.T) {
+	listener := listenLocal(t, "tcp4", "127.0.0.1:0")
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	resolver := &staticDnsResolver{}
+	dialer := newEgressDialer(net.ParseIP("127.0.0.1"), nil)
+	dialer.resolver = resolver
+
+	conn, err := dialer.Dial("tcp", net.JoinHostPort("example.test", port))
+	require.NoError(t, err)
+	_ = conn.Close()
+
+	assert.Equal(t, []string{"ip4"}, resolver.networks)
+}
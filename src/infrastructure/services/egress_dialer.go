@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"goproxy/application/contracts"
+	"net"
+	"strings"
+	"time"
+)
+
+// happyEyeballsFallbackDelay is how long an IPv6 attempt runs alone before IPv4 is tried as well (RFC 8305).
+const happyEyeballsFallbackDelay = 300 * time.Millisecond
+
+// EgressDialer dials from an IPv4 egress IP, an IPv6 egress IP, or both.
+// With both IPs it races the families Happy Eyeballs style, giving IPv6 a head start.
+// Contexts guarded by DestinationPolicy.Guard make it check every resolved target IP before connecting.
+type EgressDialer struct {
+	v4            *net.Dialer
+	v6            *net.Dialer
+	fallbackDelay time.Duration
+	// resolver is nil unless target host names are resolved by a DnsResolver instead of the dialers
+	resolver contracts.DnsResolver
+}
+
+// newEgressDialer creates a dialer bound to the given IPs, nil IPs are not used.
+// Without any IP the dialer does not bind to a specific address.
+func newEgressDialer(v4, v6 net.IP) *EgressDialer {
+	dialer := &EgressDialer{fallbackDelay: happyEyeballsFallbackDelay}
+	if v4 != nil {
+		dialer.v4 = &net.Dialer{LocalAddr: &net.TCPAddr{IP: v4}, ControlContext: guardDestination}
+	}
+	if v6 != nil {
+		dialer.v6 = &net.Dialer{LocalAddr: &net.TCPAddr{IP: v6}, ControlContext: guardDestination}
+	}
+
+	return dialer
+}
+
+func (d *EgressDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *EgressDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	switch {
+	case d.v4 == nil && d.v6 == nil:
+		lookupNetwork := "ip"
+		if strings.HasSuffix(network, "4") || strings.HasSuffix(network, "6") {
+			lookupNetwork += network[len(network)-1:]
+		}
+		return d.dialFrom(ctx, &net.Dialer{ControlContext: guardDestination}, lookupNetwork, network, address)
+	case d.v6 == nil:
+		return d.dialFrom(ctx, d.v4, "ip4", network, address)
+	case d.v4 == nil:
+		return d.dialFrom(ctx, d.v6, "ip6", network, address)
+	}
+
+	return d.dialDualStack(ctx, network, address)
+}
+
+func (d *EgressDialer) LocalIP() net.IP {
+	if d.v4 != nil {
+		return d.v4.LocalAddr.(*net.TCPAddr).IP
+	}
+	if d.v6 != nil {
+		return d.v6.LocalAddr.(*net.TCPAddr).IP
+	}
+
+	return nil
+}
+
+// dialFrom resolves the target host for the family of the egress IP and tries its addresses in order.
+// Without a resolver the dialer resolves the host itself.
+func (d *EgressDialer) dialFrom(ctx context.Context, dialer *net.Dialer, lookupNetwork, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if d.resolver == nil || err != nil || net.ParseIP(host) != nil {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	ips, err := d.resolver.LookupIP(ctx, lookupNetwork, host)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+
+	var lastErr error
+	for _, ip := range ips {
+		conn, dialErr := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if dialErr == nil {
+			return conn, nil
+		}
+
+		lastErr = dialErr
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return nil, lastErr
+}
+
+// dialDualStack starts dialing over IPv6 and over IPv4 once the fallback delay passes or IPv6 fails.
+// A dialer bound to an egress IP only connects to target addresses of the same family,
+// so each attempt resolves the target addresses of its own family.
+func (d *EgressDialer) dialDualStack(ctx context.Context, network, address string) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan dialResult, 2)
+	dial := func(dialer *net.Dialer, lookupNetwork string) {
+		conn, err := d.dialFrom(ctx, dialer, lookupNetwork, network, address)
+		results <- dialResult{conn: conn, err: err}
+	}
+
+	go dial(d.v6, "ip6")
+	pending := 1
+	fallbackStarted := false
+	startFallback := func() {
+		if !fallbackStarted {
+			fallbackStarted = true
+			pending++
+			go dial(d.v4, "ip4")
+		}
+	}
+
+	fallbackTimer := time.NewTimer(d.fallbackDelay)
+	defer fallbackTimer.Stop()
+
+	var lastErr error
+	for pending > 0 {
+		select {
+		case <-fallbackTimer.C:
+			startFallback()
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				if pending > 0 {
+					// the other attempt is canceled, but could still connect before noticing it
+					go func() {
+						if other := <-results; other.conn != nil {
+							_ = other.conn.Close()
+						}
+					}()
+				}
+				return result.conn, nil
+			}
+
+			// "no suitable address" or a missing record of one family says less about the target
+			// than an error of the other family
+			var addrErr *net.AddrError
+			var dnsErr *net.DNSError
+			missingFamily := errors.As(result.err, &addrErr) || (errors.As(result.err, &dnsErr) && dnsErr.IsNotFound)
+			if lastErr == nil || !missingFamily {
+				lastErr = result.err
+			}
+			startFallback()
+		}
+	}
+
+	return nil, lastErr
+}
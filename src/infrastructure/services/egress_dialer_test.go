@@ -0,0 +1,70 @@
+package services
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func listenLocal(t *testing.T, network, address string) net.Listener {
+	listener, err := net.Listen(network, address)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	return listener
+}
+
+func TestEgressDialer_DualStackPrefersIPv6(t *testing.T) {
+	listener := listenLocal(t, "tcp6", "[::1]:0")
+	dialer := newEgressDialer(net.ParseIP("127.0.0.1"), net.ParseIP("::1"))
+
+	conn, err := dialer.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	assert.Equal(t, "::1", conn.LocalAddr().(*net.TCPAddr).IP.String())
+}
+
+func TestEgressDialer_DualStackFallsBackToIPv4(t *testing.T) {
+	listener := listenLocal(t, "tcp4", "127.0.0.1:0")
+	dialer := newEgressDialer(net.ParseIP("127.0.0.1"), net.ParseIP("::1"))
+
+	// IPv6 dialer has no suitable address for IPv4 target, so IPv4 is tried without waiting for the fallback delay
+	start := time.Now()
+	conn, err := dialer.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	assert.Equal(t, "127.0.0.1", conn.LocalAddr().(*net.TCPAddr).IP.String())
+	assert.Less(t, time.Since(start), happyEyeballsFallbackDelay)
+}
+
+func TestEgressDialer_SingleFamily(t *testing.T) {
+	listener := listenLocal(t, "tcp4", "127.0.0.1:0")
+
+	_, err := newEgressDialer(nil, net.ParseIP("::1")).Dial("tcp", listener.Addr().String())
+	assert.Error(t, err)
+
+	conn, err := newEgressDialer(net.ParseIP("127.0.0.1"), nil).Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	_ = conn.Close()
+}
+
+func TestEgressDialer_LocalIP(t *testing.T) {
+	assert.Nil(t, newEgressDialer(nil, nil).LocalIP())
+	assert.Equal(t, "::1", newEgressDialer(nil, net.ParseIP("::1")).LocalIP().String())
+	assert.Equal(t, "127.0.0.1", newEgressDialer(net.ParseIP("127.0.0.1"), net.ParseIP("::1")).LocalIP().String())
+}
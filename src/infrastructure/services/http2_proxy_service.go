@@ -0,0 +1,283 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"goproxy/domain/valueobjects"
+	"goproxy/infrastructure/masque"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// ServeHttp2 serves streams of an HTTP/2 client connection, either over TLS negotiated with ALPN
+// or with prior knowledge (h2c), until the client closes the connection or leaves it idle.
+func (p *Proxy) ServeHttp2(clientConn net.Conn, handler http.Handler) {
+	// the server times out the client preface itself and closes connections without streams after IdleTimeout
+	_ = clientConn.SetReadDeadline(time.Time{})
+	server := &http2.Server{IdleTimeout: p.timeouts.Default().Idle}
+	server.ServeConn(clientConn, &http2.ServeConnOpts{Handler: handler})
+}
+
+// HandleHttp2 tunnels CONNECT streams (RFC 9113, section 8.5) to their targets, relays UDP datagrams of connect-udp
+// streams and forwards other requests over keep-alive connections of the user, the same way HTTP/1.1 requests are served.
+func (p *Proxy) HandleHttp2(w http.ResponseWriter, r *http.Request, userId, credentialId int, options valueobjects.ConnectionOptions) {
+	if r.Method == http.MethodConnect {
+		p.connectHttp2(w, r, userId, credentialId, options)
+	} else {
+		p.forwardHttp2(w, r, userId, credentialId, options)
+	}
+	go p.trafficReporter.FlushBuckets()
+}
+
+func (p *Proxy) connectHttp2(w http.ResponseWriter, r *http.Request, userId, credentialId int, options valueobjects.ConnectionOptions) {
+	// extended CONNECT (RFC 8441) carries the protocol to run over the stream
+	switch protocol := r.Header.Get(":protocol"); protocol {
+	case "":
+	case masque.Protocol:
+		p.connectUdp(w, r, userId, credentialId, options)
+		return
+	default:
+		log.Printf("Unsupported CONNECT protocol: %s", protocol)
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	host := r.Host
+	if _, port, err := net.SplitHostPort(host); err != nil || port == "" {
+		log.Printf("CONNECT authority without port: %s", host)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if p.intercepts(userId, host) {
+		p.interceptHttp2(w, r, userId, credentialId, options, host)
+		return
+	}
+
+	serverConn, err := p.dial(userId, options, TunnelDestination, host)
+	if err != nil {
+		log.Println("Could not connect:", err)
+		writeHttp2DialError(w, err)
+		return
+	}
+	defer func(serverConn net.Conn) {
+		_ = serverConn.Close()
+	}(serverConn)
+
+	controller := http.NewResponseController(w)
+	w.WriteHeader(http.StatusOK)
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	streamConn := newHttp2StreamConn(w, r, controller)
+	// the stream is ended once the target closes the connection, the client does not have to reset it
+	p.tunnel(streamConn, &endStreamOnEOFConn{Conn: serverConn, stream: streamConn}, userId, credentialId, options, host)
+}
+
+// interceptHttp2 intercepts the HTTP/2 CONNECT stream to host the way HTTP/1.1 CONNECT tunnels are intercepted.
+func (p *Proxy) interceptHttp2(w http.ResponseWriter, r *http.Request, userId, credentialId int, options valueobjects.ConnectionOptions, host string) {
+	certificate, err := p.interceptionCertificate(userId, host)
+	if err != nil {
+		log.Println("Could not intercept tunnel:", err)
+		if errors.As(err, &DestinationDeniedError{}) {
+			writeHttp2DialError(w, err)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	controller := http.NewResponseController(w)
+	w.WriteHeader(http.StatusOK)
+	if err = controller.Flush(); err != nil {
+		return
+	}
+
+	streamConn := newHttp2StreamConn(w, r, controller)
+	p.interceptTunnel(streamConn, certificate, userId, credentialId, options, host, func(serverConn net.Conn) net.Conn {
+		return &endStreamOnEOFConn{Conn: serverConn, stream: streamConn}
+	})
+}
+
+func (p *Proxy) forwardHttp2(w http.ResponseWriter, r *http.Request, userId, credentialId int, options valueobjects.ConnectionOptions) {
+	if r.Host == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	host := r.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "80")
+	}
+	r.URL.Scheme = "http"
+
+	p.forwardHttp2To(w, r, userId, credentialId, options, host)
+}
+
+// forwardHttp2To forwards the request to host with the scheme of its URL through the HTTP hooks of the user.
+func (p *Proxy) forwardHttp2To(w http.ResponseWriter, r *http.Request, userId, credentialId int, options valueobjects.ConnectionOptions, host string) {
+	if err := p.destinationPolicy.Check(userId, HttpDestination, host); err != nil {
+		log.Println("Destination denied:", err)
+		writeHttp2DialError(w, err)
+		return
+	}
+
+	dialer, dialerErr := p.dialerService.GetDialer("tcp", userId, options.Egress())
+	if dialerErr != nil {
+		log.Printf("Failed to get dialer: %v", dialerErr)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	outgoing := r.Clone(ctx)
+	outgoing.URL.Host = host
+	p.prepareOutgoingRequest(outgoing, r.RemoteAddr)
+
+	roundTrip := p.httpHooks.Wrap(userId, p.httpTransports.get(userId, credentialId, options, dialer, p.dialHttpTarget).RoundTrip)
+	response, err := roundTrip(outgoing)
+	if err != nil {
+		log.Println("Could not forward request:", err)
+		writeHttp2DialError(w, err)
+		return
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	removeHopByHopHeaders(response.Header)
+	if p.httpForward.Via != "" {
+		addVia(response.Header, response.ProtoMajor, response.ProtoMinor, p.httpForward.Via)
+	}
+	for name, values := range response.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(response.StatusCode)
+
+	// the response body is read as long as the target keeps sending it
+	idleTimeout := p.timeouts.For(userId).Idle
+	idleTimer := time.AfterFunc(idleTimeout, cancel)
+	defer idleTimer.Stop()
+	body := &idleTimeoutBody{ReadCloser: response.Body, timer: idleTimer, timeout: idleTimeout}
+
+	controller := http.NewResponseController(w)
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+			if err := controller.Flush(); err != nil {
+				return
+			}
+		}
+		if readErr != nil {
+			if !errors.Is(readErr, io.EOF) {
+				log.Println("Could not read response:", readErr)
+			}
+			return
+		}
+	}
+}
+
+// writeHttp2DialError tells the HTTP/2 client why the target could not be reached.
+func writeHttp2DialError(w http.ResponseWriter, err error) {
+	var deniedErr DestinationDeniedError
+	if errors.As(err, &deniedErr) {
+		w.Header().Set(destinationDeniedReasonHeader, deniedErr.Reason)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// http2StreamConn lets a CONNECT stream be tunneled like a client connection:
+// the request body carries client bytes, the response body carries target bytes.
+type http2StreamConn struct {
+	body       io.ReadCloser
+	writer     io.Writer
+	controller *http.ResponseController
+	localAddr  net.Addr
+	remoteAddr net.Addr
+}
+
+func newHttp2StreamConn(w http.ResponseWriter, r *http.Request, controller *http.ResponseController) *http2StreamConn {
+	localAddr, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	remoteAddr, _ := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+
+	return &http2StreamConn{
+		body:       r.Body,
+		writer:     w,
+		controller: controller,
+		localAddr:  localAddr,
+		remoteAddr: remoteAddr,
+	}
+}
+
+func (c *http2StreamConn) Read(b []byte) (int, error) {
+	return c.body.Read(b)
+}
+
+func (c *http2StreamConn) Write(b []byte) (int, error) {
+	n, err := c.writer.Write(b)
+	if err != nil {
+		return n, err
+	}
+
+	return n, c.controller.Flush()
+}
+
+// Close stops reading the client side of the stream, the stream is ended when the handler returns.
+func (c *http2StreamConn) Close() error {
+	return c.body.Close()
+}
+
+func (c *http2StreamConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *http2StreamConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *http2StreamConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+
+	return c.SetWriteDeadline(t)
+}
+
+func (c *http2StreamConn) SetReadDeadline(t time.Time) error {
+	return c.controller.SetReadDeadline(t)
+}
+
+func (c *http2StreamConn) SetWriteDeadline(t time.Time) error {
+	return c.controller.SetWriteDeadline(t)
+}
+
+// endStreamOnEOFConn closes the stream once the target side of the tunnel is closed,
+// so the tunnel does not wait for the client to end the stream.
+type endStreamOnEOFConn struct {
+	net.Conn
+	stream *http2StreamConn
+}
+
+func (c *endStreamOnEOFConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil {
+		_ = c.stream.Close()
+	}
+
+	return n, err
+}
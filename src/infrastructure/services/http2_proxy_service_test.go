@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"goproxy/domain/valueobjects"
+	"goproxy/infrastructure/config"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+// startHttp2Proxy serves HTTP/2 connections with prior knowledge as user 1 and returns a client of the proxy.
+func startHttp2Proxy(t *testing.T, proxy *Proxy, connections *atomic.Int32) *http.Client {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			connections.Add(1)
+			go func() {
+				defer conn.Close()
+				proxy.ServeHttp2(conn, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					proxy.HandleHttp2(w, r, 1, 0, valueobjects.ConnectionOptions{})
+				}))
+			}()
+		}
+	}()
+
+	transport := &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, _ string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, listener.Addr().String())
+		},
+	}
+	t.Cleanup(transport.CloseIdleConnections)
+
+	return &http.Client{Transport: transport}
+}
+
+// connectHttp2 opens a CONNECT stream to target, returning the writer of client bytes and the response.
+func connectHttp2(t *testing.T, client *http.Client, target string) (*io.PipeWriter, *http.Response) {
+	bodyReader, bodyWriter := io.Pipe()
+	request, err := http.NewRequest(http.MethodConnect, "http://"+target, bodyReader)
+	require.NoError(t, err)
+
+	response, err := client.Do(request)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = bodyWriter.Close()
+		_ = response.Body.Close()
+	})
+
+	return bodyWriter, response
+}
+
+func TestProxy_HandleHttp2_TunnelsConcurrentStreamsOverOneConnection(t *testing.T) {
+	target := startEchoServer(t)
+	var connections atomic.Int32
+	client := startHttp2Proxy(t, newTestProxy(t), &connections)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			writer, response := connectHttp2(t, client, target)
+			assert.Equal(t, http.StatusOK, response.StatusCode)
+
+			_, err := writer.Write([]byte("ping"))
+			assert.NoError(t, err)
+			reply := make([]byte, 4)
+			_, err = io.ReadFull(response.Body, reply)
+			assert.NoError(t, err)
+			assert.Equal(t, "ping", string(reply))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), connections.Load())
+}
+
+func TestProxy_HandleHttp2_EndsStreamWhenTargetCloses(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_, _ = conn.Write([]byte("bye"))
+		_ = conn.Close()
+	}()
+
+	var connections atomic.Int32
+	client := startHttp2Proxy(t, newTestProxy(t), &connections)
+
+	_, response := connectHttp2(t, client, listener.Addr().String())
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "bye", string(body))
+}
+
+func TestProxy_HandleHttp2_RejectsConnectWithoutPort(t *testing.T) {
+	var connections atomic.Int32
+	client := startHttp2Proxy(t, newTestProxy(t), &connections)
+
+	_, response := connectHttp2(t, client, "example.com")
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
+
+func TestProxy_HandleHttp2_DeniedDestination(t *testing.T) {
+	proxy := newTestProxy(t)
+	proxy.destinationPolicy = NewDestinationPolicy(config.DestinationPolicyConfig{
+		DeniedNetworks: []*net.IPNet{{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}},
+	})
+	var connections atomic.Int32
+	client := startHttp2Proxy(t, proxy, &connections)
+
+	_, response := connectHttp2(t, client, startEchoServer(t))
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+	assert.Contains(t, response.Header.Get(destinationDeniedReasonHeader), "127.0.0.1")
+}
+
+func TestProxy_HandleHttp2_ForwardsPlainRequests(t *testing.T) {
+	var serverConnections atomic.Int32
+	server := startCountingHttpServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-Via", r.Header.Get("Via"))
+		_, _ = io.WriteString(w, "hello "+r.URL.Path)
+	}, &serverConnections)
+
+	proxy := newTestProxy(t)
+	proxy.httpForward = config.HttpForwardConfig{Via: "goproxy"}
+	var connections atomic.Int32
+	client := startHttp2Proxy(t, proxy, &connections)
+
+	for _, path := range []string{"/first", "/second"} {
+		response, err := client.Get(server.URL + path)
+		require.NoError(t, err)
+		body, err := io.ReadAll(response.Body)
+		_ = response.Body.Close()
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Equal(t, "hello "+path, string(body))
+		assert.Equal(t, "2.0 goproxy", response.Header.Get("X-Seen-Via"))
+		assert.Equal(t, "1.1 goproxy", response.Header.Get("Via"))
+	}
+
+	assert.Equal(t, int32(1), serverConnections.Load())
+}
@@ -0,0 +1,540 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"goproxy/application/contracts"
+	"goproxy/domain/valueobjects"
+	"goproxy/infrastructure/config"
+	"goproxy/infrastructure/infraerrs"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// hopByHopHeaders are meaningful for a single connection only and are never forwarded (RFC 9110, section 7.6.1),
+// along with the headers listed in Connection header.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// HandleHttp forwards a plain HTTP request over a keep-alive connection to the target and writes the response back
+// framed so the client connection can be reused for the next request. The client connection is closed otherwise.
+// Upgrade requests, e.g. WebSocket, are tunneled to the target.
+func (p *Proxy) HandleHttp(clientConn net.Conn, r *http.Request, userId, credentialId int, options valueobjects.ConnectionOptions) {
+	if !p.forwardHttp(clientConn, r, userId, credentialId, options) {
+		_ = clientConn.Close()
+	}
+	go p.trafficReporter.FlushBuckets()
+}
+
+// forwardHttp returns whether the client connection could be reused.
+func (p *Proxy) forwardHttp(clientConn net.Conn, r *http.Request, userId, credentialId int, options valueobjects.ConnectionOptions) bool {
+	if r.URL.Host == "" || (r.URL.Scheme != "" && r.URL.Scheme != "http") {
+		log.Printf("Unsupported request target: %s", r.RequestURI)
+		_, _ = clientConn.Write([]byte("HTTP/1.1 400 Bad Request\r\nConnection: close\r\n\r\n"))
+		return false
+	}
+
+	host := r.URL.Host
+	if r.URL.Port() == "" {
+		host = net.JoinHostPort(r.URL.Hostname(), "80")
+	}
+	r.URL.Scheme = "http"
+
+	return p.forwardHttpTo(clientConn, r, userId, credentialId, options, host)
+}
+
+// forwardHttpTo forwards the request to host with the scheme of its URL through the HTTP hooks of the user
+// and returns whether the client connection could be reused.
+func (p *Proxy) forwardHttpTo(clientConn net.Conn, r *http.Request, userId, credentialId int, options valueobjects.ConnectionOptions, host string) bool {
+	if err := p.destinationPolicy.Check(userId, HttpDestination, host); err != nil {
+		log.Println("Destination denied:", err)
+		writeDialError(clientConn, err)
+		return false
+	}
+
+	dialer, dialerErr := p.dialerService.GetDialer("tcp", userId, options.Egress())
+	if dialerErr != nil {
+		log.Printf("Failed to get dialer: %v", dialerErr)
+		_, _ = clientConn.Write([]byte("HTTP/1.1 500 Internal Server Error\r\n\r\n"))
+		return false
+	}
+
+	if isUpgradeRequest(r) {
+		p.upgradeHttp(clientConn, r, dialer, userId, credentialId, options, host)
+		return false
+	}
+
+	clientClose := r.Close
+	p.prepareOutgoingRequest(r, clientConn.RemoteAddr().String())
+
+	if httpguts.HeaderValuesContainsToken(r.Header["Expect"], "100-continue") {
+		r.Header.Del("Expect")
+		if _, err := clientConn.Write([]byte("HTTP/1.1 100 Continue\r\n\r\n")); err != nil {
+			return false
+		}
+	}
+
+	timeouts := p.timeouts.For(userId)
+	_ = clientConn.SetReadDeadline(time.Now().Add(timeouts.Idle))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	roundTrip := p.httpHooks.Wrap(userId, p.httpTransports.get(userId, credentialId, options, dialer, p.dialHttpTarget).RoundTrip)
+	response, err := roundTrip(r.WithContext(ctx))
+	if err != nil {
+		log.Println("Could not forward request:", err)
+		writeDialError(clientConn, err)
+		return false
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	// the response body is read as long as the target keeps sending it
+	idleTimer := time.AfterFunc(timeouts.Idle, cancel)
+	defer idleTimer.Stop()
+	response.Body = &idleTimeoutBody{ReadCloser: response.Body, timer: idleTimer, timeout: timeouts.Idle}
+
+	keepAlive := p.prepareResponse(r, response, clientClose)
+	if err := response.Write(clientConn); err != nil {
+		log.Println("Could not write response:", err)
+		return false
+	}
+
+	// the client body is drained on close, so the next request is read from the right position
+	if r.Body != nil && r.Body.Close() != nil {
+		return false
+	}
+
+	// the next request of a kept-alive client must come within the idle timeout
+	_ = clientConn.SetReadDeadline(time.Now().Add(timeouts.Idle))
+	return keepAlive
+}
+
+// prepareOutgoingRequest turns the proxy request of the client at clientAddr into a request to the target.
+func (p *Proxy) prepareOutgoingRequest(r *http.Request, clientAddr string) {
+	r.RequestURI = ""
+	r.Close = false
+	removeHopByHopHeaders(r.Header)
+
+	// keeps the transport from adding its own User-Agent
+	if _, ok := r.Header["User-Agent"]; !ok {
+		r.Header.Set("User-Agent", "")
+	}
+
+	if p.httpForward.Via != "" {
+		addVia(r.Header, r.ProtoMajor, r.ProtoMinor, p.httpForward.Via)
+	}
+
+	if p.httpForward.ForwardedFor {
+		if clientIP, _, err := net.SplitHostPort(clientAddr); err == nil {
+			if prior := r.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+				clientIP = strings.Join(prior, ", ") + ", " + clientIP
+			}
+			r.Header.Set("X-Forwarded-For", clientIP)
+		}
+	}
+}
+
+// prepareResponse frames the target response for the client and returns whether the client connection stays open.
+// Responses of unknown length are chunked for HTTP/1.1 clients and delimited by closing the connection otherwise.
+func (p *Proxy) prepareResponse(r *http.Request, response *http.Response, clientClose bool) bool {
+	removeHopByHopHeaders(response.Header)
+	if p.httpForward.Via != "" {
+		addVia(response.Header, response.ProtoMajor, response.ProtoMinor, p.httpForward.Via)
+	}
+
+	response.Proto, response.ProtoMajor, response.ProtoMinor = "HTTP/1.1", 1, 1
+	response.Close = false
+	response.TransferEncoding = nil
+
+	keepAlive := !clientClose
+	if response.ContentLength < 0 && responseHasBody(response) {
+		if r.ProtoAtLeast(1, 1) {
+			response.TransferEncoding = []string{"chunked"}
+		} else {
+			keepAlive = false
+		}
+	}
+
+	if !keepAlive {
+		response.Close = true
+	} else if !r.ProtoAtLeast(1, 1) {
+		response.Header.Set("Connection", "keep-alive")
+	}
+
+	return keepAlive
+}
+
+// upgradeHttp sends the upgrade request to the target through the HTTP hooks of the user and tunnels the connection
+// whatever protocol it switches to. Responses not switching protocols, including those of hooks, end the connection.
+func (p *Proxy) upgradeHttp(clientConn net.Conn, r *http.Request, dialer contracts.Dialer, userId, credentialId int,
+	options valueobjects.ConnectionOptions, host string) {
+	upgrade := r.Header.Get("Upgrade")
+	p.prepareOutgoingRequest(r, clientConn.RemoteAddr().String())
+
+	var serverConn net.Conn
+	defer func() {
+		if serverConn != nil {
+			_ = serverConn.Close()
+		}
+	}()
+
+	roundTrip := p.httpHooks.Wrap(userId, func(r *http.Request) (*http.Response, error) {
+		conn, err := p.dialUpgradeTarget(r, dialer, userId, options, host)
+		if err != nil {
+			return nil, err
+		}
+		serverConn = conn
+
+		// hooks see requests without hop-by-hop headers, the upgrade is requested again right before sending
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", upgrade)
+		if err = r.Write(conn); err != nil {
+			return nil, err
+		}
+
+		reader := bufio.NewReader(conn)
+		response, err := http.ReadResponse(reader, r)
+		if err != nil {
+			return nil, err
+		}
+		// the target may send data of the new protocol right after the response
+		serverConn = &peekedConn{Conn: conn, reader: reader}
+
+		return response, nil
+	})
+
+	response, err := roundTrip(r)
+	if err != nil {
+		log.Println("Could not forward upgrade request:", err)
+		writeDialError(clientConn, err)
+		return
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+
+	if response.StatusCode != http.StatusSwitchingProtocols || serverConn == nil {
+		p.prepareResponse(r, response, true)
+		_ = response.Write(clientConn)
+		return
+	}
+
+	if p.httpForward.Via != "" {
+		addVia(response.Header, response.ProtoMajor, response.ProtoMinor, p.httpForward.Via)
+	}
+	if err = response.Write(clientConn); err != nil {
+		return
+	}
+
+	p.tunnel(clientConn, serverConn, userId, credentialId, options, host)
+}
+
+// dialUpgradeTarget connects to the target of the upgrade request, over TLS if the request is sent with https scheme.
+func (p *Proxy) dialUpgradeTarget(r *http.Request, dialer contracts.Dialer, userId int, options valueobjects.ConnectionOptions,
+	host string) (net.Conn, error) {
+	serverConn, err := p.dialWith(context.Background(), dialer, userId, options.Country(), host)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.URL.Scheme != "https" {
+		return serverConn, nil
+	}
+
+	handshakeCtx, cancel := context.WithTimeout(context.Background(), p.timeouts.For(userId).Handshake)
+	defer cancel()
+
+	tlsConn := tls.Client(serverConn, p.httpTransports.targetTlsConfig(r.URL.Hostname()))
+	if err = tlsConn.HandshakeContext(handshakeCtx); err != nil {
+		_ = serverConn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
+// dialHttpTarget connects transports of the user to HTTP targets and meters the traffic of the connection.
+func (p *Proxy) dialHttpTarget(ctx context.Context, dialer contracts.Dialer, userId, credentialId int,
+	options valueobjects.ConnectionOptions, host string) (net.Conn, error) {
+	if !p.admitShaped(userId, host) {
+		return nil, infraerrs.RateLimitExceededError{}
+	}
+
+	conn, err := p.dialWith(ctx, dialer, userId, options.Country(), host)
+	if err != nil {
+		p.releaseShaped(userId, host)
+		return nil, err
+	}
+
+	return p.newMeteredConn(conn, userId, credentialId, options.SpeedLimit(), host), nil
+}
+
+func isUpgradeRequest(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != "" && httpguts.HeaderValuesContainsToken(r.Header["Connection"], "Upgrade")
+}
+
+func removeHopByHopHeaders(header http.Header) {
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
+
+func addVia(header http.Header, protoMajor, protoMinor int, pseudonym string) {
+	via := fmt.Sprintf("%d.%d %s", protoMajor, protoMinor, pseudonym)
+	if prior := header.Values("Via"); len(prior) > 0 {
+		via = strings.Join(prior, ", ") + ", " + via
+	}
+	header.Set("Via", via)
+}
+
+func responseHasBody(response *http.Response) bool {
+	if response.Request != nil && response.Request.Method == http.MethodHead {
+		return false
+	}
+
+	status := response.StatusCode
+	return status >= http.StatusOK && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// idleTimeoutBody fires the timer when no bytes are read from the body for the timeout.
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.timer.Reset(b.timeout)
+	return n, err
+}
+
+// httpTransportPool keeps a transport with keep-alive connections per user, proxy credential, egress IPs
+// and connection options, so a connection to a target is only reused by requests of the same user and credential
+// leaving from the same IPs and country at the same speed.
+// Transports unused for the idle connection timeout are dropped.
+type httpTransportPool struct {
+	mu         sync.Mutex
+	config     config.HttpForwardConfig
+	transports map[string]*pooledHttpTransport
+	lastSweep  time.Time
+	// tlsConfig verifies HTTPS targets of intercepted tunnels, system roots are used if it is nil
+	tlsConfig        *tls.Config
+	handshakeTimeout time.Duration
+}
+
+type pooledHttpTransport struct {
+	transport *http.Transport
+	lastUsed  time.Time
+}
+
+type httpDialFunc func(ctx context.Context, dialer contracts.Dialer, userId, credentialId int, options valueobjects.ConnectionOptions,
+	host string) (net.Conn, error)
+
+func newHttpTransportPool(config config.HttpForwardConfig, timeouts config.ProxyTimeouts) *httpTransportPool {
+	return &httpTransportPool{
+		config:           config,
+		transports:       make(map[string]*pooledHttpTransport),
+		lastSweep:        time.Now(),
+		handshakeTimeout: timeouts.Handshake,
+	}
+}
+
+// get returns the transport of the user, the credential, the options and the egress IPs of dialer,
+// creating one dialing targets with dial.
+func (tp *httpTransportPool) get(userId, credentialId int, options valueobjects.ConnectionOptions, dialer contracts.Dialer,
+	dial httpDialFunc) *http.Transport {
+	key := fmt.Sprintf("%d|%d|%s|%s|%d", userId, credentialId, egressKey(dialer), options.Country(), options.SpeedLimit())
+	now := time.Now()
+
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	if now.Sub(tp.lastSweep) >= tp.config.IdleConnTimeout {
+		tp.sweepLocked(now)
+	}
+
+	pooled, ok := tp.transports[key]
+	if !ok {
+		pooled = &pooledHttpTransport{
+			transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, address string) (net.Conn, error) {
+					return dial(ctx, dialer, userId, credentialId, options, address)
+				},
+				TLSClientConfig:       tp.tlsConfig,
+				TLSHandshakeTimeout:   tp.handshakeTimeout,
+				DisableCompression:    true,
+				DisableKeepAlives:     tp.config.MaxIdleConnsPerHost == 0,
+				MaxIdleConnsPerHost:   tp.config.MaxIdleConnsPerHost,
+				IdleConnTimeout:       tp.config.IdleConnTimeout,
+				ResponseHeaderTimeout: tp.config.ResponseHeaderTimeout,
+			},
+		}
+		tp.transports[key] = pooled
+	}
+	pooled.lastUsed = now
+
+	return pooled.transport
+}
+
+// targetTlsConfig returns the config verifying the HTTPS target at hostname.
+func (tp *httpTransportPool) targetTlsConfig(hostname string) *tls.Config {
+	tlsConfig := &tls.Config{}
+	if tp.tlsConfig != nil {
+		tlsConfig = tp.tlsConfig.Clone()
+	}
+	tlsConfig.ServerName = hostname
+
+	return tlsConfig
+}
+
+func (tp *httpTransportPool) sweepLocked(now time.Time) {
+	for key, pooled := range tp.transports {
+		if now.Sub(pooled.lastUsed) >= tp.config.IdleConnTimeout {
+			pooled.transport.CloseIdleConnections()
+			delete(tp.transports, key)
+		}
+	}
+	tp.lastSweep = now
+}
+
+func (tp *httpTransportPool) closeIdleConnections() {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+
+	for _, pooled := range tp.transports {
+		pooled.transport.CloseIdleConnections()
+	}
+}
+
+// egressKey identifies the egress IPs a dialer connects from.
+func egressKey(dialer contracts.Dialer) string {
+	egressDialer, ok := dialer.(*EgressDialer)
+	if !ok {
+		return dialer.LocalIP().String()
+	}
+
+	var v4, v6 net.IP
+	if egressDialer.v4 != nil {
+		v4 = egressDialer.v4.LocalAddr.(*net.TCPAddr).IP
+	}
+	if egressDialer.v6 != nil {
+		v6 = egressDialer.v6.LocalAddr.(*net.TCPAddr).IP
+	}
+
+	return fmt.Sprintf("%v|%v", v4, v6)
+}
+
+// meteredConn reports traffic of a keep-alive connection to an HTTP target and applies rate and speed limits
+// of its user, the way copyTrafficAndReport does for tunnels.
+type meteredConn struct {
+	net.Conn
+	proxy        *Proxy
+	userId       int
+	credentialId int
+	host         string
+	shapedConn   *ShapedConnection
+	reader       io.Reader
+	ctx          context.Context
+	cancel       context.CancelFunc
+	// accumulatedBytes is only changed by Write, which the transport calls from a single goroutine
+	accumulatedBytes int64
+	closeOnce        sync.Once
+}
+
+func (p *Proxy) newMeteredConn(conn net.Conn, userId, credentialId int, speedLimit int64, host string) *meteredConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	shapedConn := p.openShapedConnection(userId, speedLimit)
+
+	return &meteredConn{
+		Conn:         conn,
+		proxy:        p,
+		userId:       userId,
+		credentialId: credentialId,
+		host:         host,
+		shapedConn:   shapedConn,
+		reader:       shapedConn.Reader(ctx, "out", conn),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+func (c *meteredConn) Read(b []byte) (int, error) {
+	n, err := c.reader.Read(b)
+	if n > 0 {
+		c.proxy.trafficReporter.AddOutBytes(c.userId, c.credentialId, int64(n))
+	}
+
+	return n, err
+}
+
+func (c *meteredConn) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		chunk := b[written:]
+		if c.shapedConn != nil {
+			chunk = chunk[:min(len(chunk), c.shapedConn.maxChunk("in"))]
+			if err := c.shapedConn.WaitN(c.ctx, "in", len(chunk)); err != nil {
+				return written, err
+			}
+		}
+
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if n > 0 {
+			c.accumulatedBytes += int64(n)
+			if c.accumulatedBytes >= rateLimitAccountingThreshold {
+				// in shaping mode writes are already throttled, so exceeding the limit must not cut the connection
+				if c.proxy.bandwidthShaper == nil && !c.proxy.rateLimiter.Allow(c.userId, c.host, c.accumulatedBytes) {
+					return written, infraerrs.RateLimitExceededError{}
+				}
+				c.accumulatedBytes = 0
+			}
+			c.proxy.trafficReporter.AddInBytes(c.userId, c.credentialId, int64(n))
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+func (c *meteredConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.cancel()
+		c.shapedConn.Close()
+		c.proxy.rateLimiter.Done(c.userId, c.host)
+	})
+
+	return c.Conn.Close()
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"bufio"
+	"goproxy/domain/valueobjects"
+	"goproxy/infrastructure/config"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startHttpForwarder serves plain HTTP requests of user 1 with the proxy the way the proxy use cases do.
+func startHttpForwarder(t *testing.T, proxy *Proxy) net.Conn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+
+				reader := bufio.NewReader(conn)
+				for {
+					request, err := http.ReadRequest(reader)
+					if err != nil {
+						return
+					}
+					proxy.HandleHttp(newBufferedTestConn(conn, reader), request, 1, 0, valueobjects.ConnectionOptions{})
+				}
+			}()
+		}
+	}()
+
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = clientConn.Close() })
+
+	return clientConn
+}
+
+// bufferedTestConn reads through the reader requests are read from, as the proxy use cases connection does.
+type bufferedTestConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func newBufferedTestConn(conn net.Conn, reader *bufio.Reader) net.Conn {
+	return &bufferedTestConn{Conn: conn, reader: reader}
+}
+
+func (c *bufferedTestConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// startCountingHttpServer starts an HTTP server counting accepted connections.
+func startCountingHttpServer(t *testing.T, handler http.HandlerFunc, connections *atomic.Int32) *httptest.Server {
+	server := httptest.NewUnstartedServer(handler)
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func sendProxyRequest(t *testing.T, clientConn net.Conn, reader *bufio.Reader, request string) *http.Response {
+	_, err := io.WriteString(clientConn, request)
+	require.NoError(t, err)
+
+	response, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	return response
+}
+
+func TestProxy_HandleHttp_StripsHopByHopHeadersAndReusesConnection(t *testing.T) {
+	var connections atomic.Int32
+	server := startCountingHttpServer(t, func(w http.ResponseWriter, r *http.Request) {
+		for _, name := range []string{"Proxy-Authorization", "Proxy-Connection", "Keep-Alive", "Te", "X-Hop", "Via", "X-Forwarded-For"} {
+			if r.Header.Get(name) != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = io.WriteString(w, name+" forwarded")
+				return
+			}
+		}
+		w.Header().Set("Keep-Alive", "timeout=5")
+		_, _ = io.WriteString(w, "hello "+r.URL.Path)
+	}, &connections)
+
+	proxy := newTestProxy(t)
+	clientConn := startHttpForwarder(t, proxy)
+	reader := bufio.NewReader(clientConn)
+
+	for _, path := range []string{"/first", "/second"} {
+		response := sendProxyRequest(t, clientConn, reader, "GET "+server.URL+path+" HTTP/1.1\r\n"+
+			"Host: "+strings.TrimPrefix(server.URL, "http://")+"\r\n"+
+			"Proxy-Authorization: 1\r\nProxy-Connection: keep-alive\r\nKeep-Alive: timeout=5\r\n"+
+			"Te: trailers\r\nConnection: X-Hop\r\nX-Hop: 1\r\n\r\n")
+		body, err := io.ReadAll(response.Body)
+		require.NoError(t, err)
+
+		assert.Equal(t, http.StatusOK, response.StatusCode, string(body))
+		assert.Equal(t, "hello "+path, string(body))
+		assert.Empty(t, response.Header.Get("Keep-Alive"))
+		assert.Empty(t, response.Header.Get("Via"))
+		assert.False(t, response.Close)
+	}
+
+	assert.Equal(t, int32(1), connections.Load())
+}
+
+func TestProxy_HandleHttp_FramesResponsesOfUnknownLength(t *testing.T) {
+	var connections atomic.Int32
+	server := startCountingHttpServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "part one, ")
+		w.(http.Flusher).Flush()
+		_, _ = io.WriteString(w, "part two")
+	}, &connections)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	proxy := newTestProxy(t)
+	clientConn := startHttpForwarder(t, proxy)
+	reader := bufio.NewReader(clientConn)
+
+	// HTTP/1.1 clients get a chunked response and keep the connection
+	response := sendProxyRequest(t, clientConn, reader, "GET "+server.URL+"/ HTTP/1.1\r\nHost: "+host+"\r\n\r\n")
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "part one, part two", string(body))
+	assert.Equal(t, []string{"chunked"}, response.TransferEncoding)
+	assert.False(t, response.Close)
+
+	// HTTP/1.0 clients get the body delimited by closing the connection
+	response = sendProxyRequest(t, clientConn, reader, "GET "+server.URL+"/ HTTP/1.0\r\nHost: "+host+"\r\nConnection: keep-alive\r\n\r\n")
+	body, err = io.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "part one, part two", string(body))
+	assert.Empty(t, response.TransferEncoding)
+	assert.True(t, response.Close)
+
+	_ = clientConn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = reader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestProxy_HandleHttp_AddsViaAndForwardedFor(t *testing.T) {
+	var connections atomic.Int32
+	server := startCountingHttpServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen-Via", r.Header.Get("Via"))
+		w.Header().Set("X-Seen-Forwarded-For", r.Header.Get("X-Forwarded-For"))
+		w.Header().Set("Via", "1.1 origin-cache")
+	}, &connections)
+
+	proxy := newTestProxy(t)
+	proxy.httpForward = config.HttpForwardConfig{Via: "goproxy", ForwardedFor: true}
+	clientConn := startHttpForwarder(t, proxy)
+
+	response := sendProxyRequest(t, clientConn, bufio.NewReader(clientConn), "GET "+server.URL+"/ HTTP/1.1\r\n"+
+		"Host: "+strings.TrimPrefix(server.URL, "http://")+"\r\nX-Forwarded-For: 203.0.113.1\r\n\r\n")
+	_ = response.Body.Close()
+
+	assert.Equal(t, "1.1 goproxy", response.Header.Get("X-Seen-Via"))
+	assert.Equal(t, "203.0.113.1, 127.0.0.1", response.Header.Get("X-Seen-Forwarded-For"))
+	assert.Equal(t, "1.1 origin-cache, 1.1 goproxy", response.Header.Get("Via"))
+}
+
+func TestProxy_HandleHttp_ReportsTraffic(t *testing.T) {
+	var connections atomic.Int32
+	server := startCountingHttpServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		_, _ = io.WriteString(w, strings.Repeat("x", 1000))
+	}, &connections)
+
+	proxy := newTestProxy(t)
+	clientConn := startHttpForwarder(t, proxy)
+
+	response := sendProxyRequest(t, clientConn, bufio.NewReader(clientConn), "POST "+server.URL+"/ HTTP/1.1\r\n"+
+		"Host: "+strings.TrimPrefix(server.URL, "http://")+"\r\nContent-Length: 500\r\n\r\n"+strings.Repeat("y", 500))
+	_, _ = io.Copy(io.Discard, response.Body)
+
+	var inBytes, outBytes int64
+	timeout := time.After(time.Second)
+	for outBytes < 1000 {
+		select {
+		case event := <-proxy.trafficReporter.eventQueue:
+			inBytes += event.InBytes
+			outBytes += event.OutBytes
+		case <-timeout:
+			t.Fatalf("traffic is not reported, in = %d, out = %d", inBytes, outBytes)
+		}
+	}
+
+	assert.Greater(t, inBytes, int64(500))
+	assert.Greater(t, outBytes, int64(1000))
+}
+
+func TestProxy_HandleHttp_DeniedResolvedDestination(t *testing.T) {
+	proxy := newTestProxy(t)
+	proxy.destinationPolicy = NewDestinationPolicy(config.DestinationPolicyConfig{
+		DeniedNetworks: []*net.IPNet{{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}},
+	})
+	listener := listenLocal(t, "tcp4", "127.0.0.1:0")
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	clientConn := startHttpForwarder(t, proxy)
+	response := sendProxyRequest(t, clientConn, bufio.NewReader(clientConn),
+		"GET http://localhost:"+port+"/ HTTP/1.1\r\nHost: localhost:"+port+"\r\n\r\n")
+
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+	assert.NotEmpty(t, response.Header.Get(destinationDeniedReasonHeader))
+}
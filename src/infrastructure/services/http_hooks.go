@@ -0,0 +1,269 @@
+package services
+
+import (
+	"encoding/base64"
+	"fmt"
+	"goproxy/application/contracts"
+	"goproxy/infrastructure/config"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// HttpRoundTrip sends a forwarded request to its target and returns the response.
+type HttpRoundTrip func(r *http.Request) (*http.Response, error)
+
+// HttpHook wraps the round trip of forwarded requests. It may change the request before calling next,
+// change the response next returns, or answer the request itself without calling next.
+// Requests reach hooks with hop-by-hop headers already removed, responses are framed for the client after hooks.
+type HttpHook func(next HttpRoundTrip) HttpRoundTrip
+
+// HttpHookFactory creates a hook from its configured argument.
+type HttpHookFactory func(arg string) (HttpHook, error)
+
+var (
+	httpHookFactoriesMu sync.RWMutex
+	httpHookFactories   = map[string]HttpHookFactory{
+		"set-request-header":    newSetRequestHeaderHook,
+		"remove-request-header": newRemoveRequestHeaderHook,
+		"set-response-header":   newSetResponseHeaderHook,
+		"user-agent":            newUserAgentHook,
+		"block-url":             newBlockUrlHook,
+		"upstream-basic-auth":   newUpstreamBasicAuthHook,
+	}
+)
+
+// RegisterHttpHook makes a custom hook available to HTTP_HOOKS and HTTP_PLAN_HOOKS under name.
+// It must be called before the proxy is created, e.g. from an init function.
+func RegisterHttpHook(name string, factory HttpHookFactory) {
+	httpHookFactoriesMu.Lock()
+	defer httpHookFactoriesMu.Unlock()
+
+	httpHookFactories[name] = factory
+}
+
+// HttpHooks keeps the hook chains of plans, built from the configured hooks.
+type HttpHooks struct {
+	defaultHook HttpHook
+	planHooks   map[int]HttpHook
+	planLimits  contracts.UserPlanLimitsService
+}
+
+func NewHttpHooks(config config.HttpHooksConfig) (*HttpHooks, error) {
+	defaultHook, err := chainHttpHooks(config.Default)
+	if err != nil {
+		return nil, err
+	}
+
+	planHooks := make(map[int]HttpHook, len(config.Plans))
+	for planId, specs := range config.Plans {
+		hook, chainErr := chainHttpHooks(specs)
+		if chainErr != nil {
+			return nil, fmt.Errorf("plan %d: %v", planId, chainErr)
+		}
+		planHooks[planId] = hook
+	}
+
+	return &HttpHooks{
+		defaultHook: defaultHook,
+		planHooks:   planHooks,
+	}, nil
+}
+
+// WithPlanLimits enables hooks of users active plans.
+func (h *HttpHooks) WithPlanLimits(planLimits contracts.UserPlanLimitsService) *HttpHooks {
+	h.planLimits = planLimits
+	return h
+}
+
+// Wrap returns the round trip of requests of the user passing through the hooks of their plan.
+// Nil HttpHooks returns roundTrip as is.
+func (h *HttpHooks) Wrap(userId int, roundTrip HttpRoundTrip) HttpRoundTrip {
+	if h == nil {
+		return roundTrip
+	}
+
+	hook := h.defaultHook
+	if h.planLimits != nil && len(h.planHooks) != 0 {
+		if planHook, ok := h.planHooks[h.planLimits.GetLimits(userId).PlanId]; ok {
+			hook = planHook
+		}
+	}
+
+	if hook == nil {
+		return roundTrip
+	}
+
+	return hook(roundTrip)
+}
+
+// chainHttpHooks composes hooks so the first listed one sees the request first and the response last.
+func chainHttpHooks(specs []config.HttpHookSpec) (HttpHook, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+
+	httpHookFactoriesMu.RLock()
+	defer httpHookFactoriesMu.RUnlock()
+
+	hooks := make([]HttpHook, 0, len(specs))
+	for _, spec := range specs {
+		factory, ok := httpHookFactories[spec.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown http hook: %s", spec.Name)
+		}
+
+		hook, err := factory(spec.Arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s hook argument: %v", spec.Name, err)
+		}
+		hooks = append(hooks, hook)
+	}
+
+	return func(next HttpRoundTrip) HttpRoundTrip {
+		for i := len(hooks) - 1; i >= 0; i-- {
+			next = hooks[i](next)
+		}
+		return next
+	}, nil
+}
+
+// newHookResponse creates a response of a hook answering the request itself.
+func newHookResponse(r *http.Request, statusCode int, header http.Header) *http.Response {
+	if header == nil {
+		header = make(http.Header)
+	}
+
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode: statusCode,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Body:       http.NoBody,
+		Request:    r,
+	}
+}
+
+// parseHeaderArg parses "Name=Value" arguments of header hooks.
+func parseHeaderArg(arg string) (string, string, error) {
+	name, value, ok := strings.Cut(arg, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return "", "", fmt.Errorf("expected Name=Value, got %q", arg)
+	}
+
+	return http.CanonicalHeaderKey(name), strings.TrimSpace(value), nil
+}
+
+// newSetRequestHeaderHook sets a request header, "Name=Value".
+func newSetRequestHeaderHook(arg string) (HttpHook, error) {
+	name, value, err := parseHeaderArg(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next HttpRoundTrip) HttpRoundTrip {
+		return func(r *http.Request) (*http.Response, error) {
+			r.Header.Set(name, value)
+			return next(r)
+		}
+	}, nil
+}
+
+// newRemoveRequestHeaderHook removes a request header, "Name".
+func newRemoveRequestHeaderHook(arg string) (HttpHook, error) {
+	name := strings.TrimSpace(arg)
+	if name == "" {
+		return nil, fmt.Errorf("expected a header name")
+	}
+
+	return func(next HttpRoundTrip) HttpRoundTrip {
+		return func(r *http.Request) (*http.Response, error) {
+			r.Header.Del(name)
+			return next(r)
+		}
+	}, nil
+}
+
+// newSetResponseHeaderHook sets a response header, "Name=Value".
+func newSetResponseHeaderHook(arg string) (HttpHook, error) {
+	name, value, err := parseHeaderArg(arg)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next HttpRoundTrip) HttpRoundTrip {
+		return func(r *http.Request) (*http.Response, error) {
+			response, err := next(r)
+			if err == nil {
+				response.Header.Set(name, value)
+			}
+			return response, err
+		}
+	}, nil
+}
+
+// newUserAgentHook replaces User-Agent of every request with the argument, so users look alike to targets.
+// An empty argument removes User-Agent.
+func newUserAgentHook(arg string) (HttpHook, error) {
+	userAgent := strings.TrimSpace(arg)
+
+	return func(next HttpRoundTrip) HttpRoundTrip {
+		return func(r *http.Request) (*http.Response, error) {
+			// the empty value keeps the transport from adding its own User-Agent
+			r.Header.Set("User-Agent", userAgent)
+			return next(r)
+		}
+	}, nil
+}
+
+// newBlockUrlHook answers requests to URLs matching the pattern with 403 Forbidden. The pattern matches "host/path"
+// without the scheme, port and query, "*" stands for any characters, e.g. "*.doubleclick.net/*".
+func newBlockUrlHook(arg string) (HttpHook, error) {
+	pattern := strings.ToLower(strings.TrimSpace(arg))
+	if pattern == "" {
+		return nil, fmt.Errorf("expected a url pattern")
+	}
+
+	matcher, err := regexp.Compile("^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$")
+	if err != nil {
+		return nil, err
+	}
+
+	return func(next HttpRoundTrip) HttpRoundTrip {
+		return func(r *http.Request) (*http.Response, error) {
+			url := strings.ToLower(r.URL.Hostname()) + r.URL.EscapedPath()
+			if !matcher.MatchString(url) {
+				return next(r)
+			}
+
+			header := make(http.Header)
+			header.Set(destinationDeniedReasonHeader, fmt.Sprintf("url %s is blocked", url))
+			return newHookResponse(r, http.StatusForbidden, header), nil
+		}
+	}, nil
+}
+
+// newUpstreamBasicAuthHook authorizes requests to a host with basic credentials, "host=user:password".
+// Credentials sent by the client to the host are replaced.
+func newUpstreamBasicAuthHook(arg string) (HttpHook, error) {
+	host, credentials, ok := strings.Cut(arg, "=")
+	host = strings.ToLower(strings.TrimSpace(host))
+	if !ok || host == "" || !strings.Contains(credentials, ":") {
+		return nil, fmt.Errorf("expected host=user:password")
+	}
+
+	authorization := "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+
+	return func(next HttpRoundTrip) HttpRoundTrip {
+		return func(r *http.Request) (*http.Response, error) {
+			if strings.ToLower(r.URL.Hostname()) == host {
+				r.Header.Set("Authorization", authorization)
+			}
+			return next(r)
+		}
+	}, nil
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"bufio"
+	"goproxy/domain/dataobjects"
+	"goproxy/infrastructure/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// okRoundTrip answers every request with 200 OK, letting hook tests see the request the target would get.
+func okRoundTrip(r *http.Request) (*http.Response, error) {
+	return newHookResponse(r, http.StatusOK, nil), nil
+}
+
+func TestHttpHooks_Wrap(t *testing.T) {
+	RegisterHttpHook("test-tag", func(arg string) (HttpHook, error) {
+		return func(next HttpRoundTrip) HttpRoundTrip {
+			return func(r *http.Request) (*http.Response, error) {
+				r.Header.Add("X-Tags", arg)
+				return next(r)
+			}
+		}, nil
+	})
+
+	hooks, err := NewHttpHooks(config.HttpHooksConfig{
+		Default: []config.HttpHookSpec{{Name: "test-tag", Arg: "default"}},
+		Plans: map[int][]config.HttpHookSpec{
+			2: {{Name: "test-tag", Arg: "first"}, {Name: "test-tag", Arg: "second"}},
+		},
+	})
+	require.NoError(t, err)
+	planLimits := newTestUserPlanLimitsService()
+	_ = planLimits.SetLimits(1, dataobjects.UserPlanLimits{PlanId: 1})
+	_ = planLimits.SetLimits(2, dataobjects.UserPlanLimits{PlanId: 2})
+	hooks.WithPlanLimits(planLimits)
+
+	tests := []struct {
+		name     string
+		hooks    *HttpHooks
+		userId   int
+		expected []string
+	}{
+		{name: "plan without own hooks", hooks: hooks, userId: 1, expected: []string{"default"}},
+		{name: "plan hooks in order", hooks: hooks, userId: 2, expected: []string{"first", "second"}},
+		{name: "no hooks", hooks: nil, userId: 2, expected: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			_, err := tt.hooks.Wrap(tt.userId, okRoundTrip)(request)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, request.Header.Values("X-Tags"))
+		})
+	}
+}
+
+func TestNewHttpHooks_InvalidHooks(t *testing.T) {
+	_, err := NewHttpHooks(config.HttpHooksConfig{Default: []config.HttpHookSpec{{Name: "unknown"}}})
+	assert.ErrorContains(t, err, "unknown http hook")
+
+	_, err = NewHttpHooks(config.HttpHooksConfig{Plans: map[int][]config.HttpHookSpec{
+		3: {{Name: "set-request-header", Arg: "no value"}},
+	}})
+	assert.ErrorContains(t, err, "plan 3")
+
+	_, err = NewHttpHooks(config.HttpHooksConfig{Default: []config.HttpHookSpec{{Name: "upstream-basic-auth", Arg: "example.com"}}})
+	assert.Error(t, err)
+}
+
+func TestHttpHooks_BuiltIn(t *testing.T) {
+	tests := []struct {
+		name  string
+		specs []config.HttpHookSpec
+		url   string
+		check func(t *testing.T, r *http.Request, response *http.Response)
+	}{
+		{
+			name:  "set and remove request headers",
+			specs: []config.HttpHookSpec{{Name: "set-request-header", Arg: "x-team = red"}, {Name: "remove-request-header", Arg: "Cookie"}},
+			url:   "http://example.com/",
+			check: func(t *testing.T, r *http.Request, _ *http.Response) {
+				assert.Equal(t, "red", r.Header.Get("X-Team"))
+				assert.Empty(t, r.Header.Get("Cookie"))
+			},
+		},
+		{
+			name:  "set response header",
+			specs: []config.HttpHookSpec{{Name: "set-response-header", Arg: "Cache-Control=no-store"}},
+			url:   "http://example.com/",
+			check: func(t *testing.T, _ *http.Request, response *http.Response) {
+				assert.Equal(t, "no-store", response.Header.Get("Cache-Control"))
+			},
+		},
+		{
+			name:  "normalize user agent",
+			specs: []config.HttpHookSpec{{Name: "user-agent", Arg: "Mozilla/5.0"}},
+			url:   "http://example.com/",
+			check: func(t *testing.T, r *http.Request, _ *http.Response) {
+				assert.Equal(t, []string{"Mozilla/5.0"}, r.Header.Values("User-Agent"))
+			},
+		},
+		{
+			name:  "block matching url",
+			specs: []config.HttpHookSpec{{Name: "block-url", Arg: "*.doubleclick.net/ads/*"}},
+			url:   "http://Ad.DoubleClick.net:8080/ads/banner?size=1",
+			check: func(t *testing.T, r *http.Request, response *http.Response) {
+				assert.Equal(t, http.StatusForbidden, response.StatusCode)
+				assert.Equal(t, "url ad.doubleclick.net/ads/banner is blocked", response.Header.Get(destinationDeniedReasonHeader))
+				assert.Empty(t, r.Header.Get("X-Forwarded"))
+			},
+		},
+		{
+			name:  "pass other urls",
+			specs: []config.HttpHookSpec{{Name: "block-url", Arg: "*.doubleclick.net/ads/*"}},
+			url:   "http://doubleclick.net/ads/banner",
+			check: func(t *testing.T, r *http.Request, response *http.Response) {
+				assert.Equal(t, http.StatusOK, response.StatusCode)
+				assert.Equal(t, "1", r.Header.Get("X-Forwarded"))
+			},
+		},
+		{
+			name:  "authorize specific upstream",
+			specs: []config.HttpHookSpec{{Name: "upstream-basic-auth", Arg: "api.example.com=svc:s3:cret"}},
+			url:   "http://API.example.com/v1",
+			check: func(t *testing.T, r *http.Request, _ *http.Response) {
+				user, password, ok := r.BasicAuth()
+				assert.True(t, ok)
+				assert.Equal(t, "svc", user)
+				assert.Equal(t, "s3:cret", password)
+			},
+		},
+		{
+			name:  "leave other upstreams unauthorized",
+			specs: []config.HttpHookSpec{{Name: "upstream-basic-auth", Arg: "api.example.com=svc:secret"}},
+			url:   "http://example.com/v1",
+			check: func(t *testing.T, r *http.Request, _ *http.Response) {
+				assert.Empty(t, r.Header.Get("Authorization"))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hooks, err := NewHttpHooks(config.HttpHooksConfig{Default: tt.specs})
+			require.NoError(t, err)
+
+			request := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			request.Header.Set("Cookie", "session=1")
+			request.Header.Set("User-Agent", "curl/8.0")
+			response, err := hooks.Wrap(1, func(r *http.Request) (*http.Response, error) {
+				r.Header.Set("X-Forwarded", "1")
+				return okRoundTrip(r)
+			})(request)
+			require.NoError(t, err)
+
+			tt.check(t, request, response)
+		})
+	}
+}
+
+func TestProxy_HandleHttp_PassesThroughHooks(t *testing.T) {
+	var connections atomic.Int32
+	server := startCountingHttpServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "team "+r.Header.Get("X-Team"))
+	}, &connections)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	hooks, err := NewHttpHooks(config.HttpHooksConfig{Default: []config.HttpHookSpec{
+		{Name: "block-url", Arg: "*/private/*"},
+		{Name: "set-request-header", Arg: "X-Team=red"},
+		{Name: "set-response-header", Arg: "X-Hooked=1"},
+	}})
+	require.NoError(t, err)
+	proxy := newTestProxy(t)
+	proxy.httpHooks = hooks
+	clientConn := startHttpForwarder(t, proxy)
+	reader := bufio.NewReader(clientConn)
+
+	response := sendProxyRequest(t, clientConn, reader, "GET "+server.URL+"/private/data HTTP/1.1\r\nHost: "+host+"\r\n\r\n")
+	_, _ = io.Copy(io.Discard, response.Body)
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+	assert.False(t, response.Close)
+	assert.Equal(t, int32(0), connections.Load())
+
+	// the client connection is kept after the blocked request
+	response = sendProxyRequest(t, clientConn, reader, "GET "+server.URL+"/public HTTP/1.1\r\nHost: "+host+"\r\n\r\n")
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "team red", string(body))
+	assert.Equal(t, "1", response.Header.Get("X-Hooked"))
+}
+
+func TestProxy_HandleHttp_PassesUpgradeRequestsThroughHooks(t *testing.T) {
+	var connections atomic.Int32
+	server := startCountingHttpServer(t, func(w http.ResponseWriter, r *http.Request) {
+		conn, buffered, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, _ = io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n"+
+			"X-Team: "+r.Header.Get("X-Team")+"\r\n\r\n")
+		_, _ = io.Copy(conn, buffered)
+	}, &connections)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	hooks, err := NewHttpHooks(config.HttpHooksConfig{Default: []config.HttpHookSpec{
+		{Name: "block-url", Arg: "*/private/*"},
+		{Name: "set-request-header", Arg: "X-Team=red"},
+	}})
+	require.NoError(t, err)
+	proxy := newTestProxy(t)
+	proxy.httpHooks = hooks
+
+	clientConn := startHttpForwarder(t, proxy)
+	response := sendProxyRequest(t, clientConn, bufio.NewReader(clientConn), "GET "+server.URL+"/private/ws HTTP/1.1\r\n"+
+		"Host: "+host+"\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+	assert.Equal(t, int32(0), connections.Load())
+
+	clientConn = startHttpForwarder(t, proxy)
+	reader := bufio.NewReader(clientConn)
+	response = sendProxyRequest(t, clientConn, reader, "GET "+server.URL+"/public/ws HTTP/1.1\r\n"+
+		"Host: "+host+"\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	require.Equal(t, http.StatusSwitchingProtocols, response.StatusCode)
+	assert.Equal(t, "red", response.Header.Get("X-Team"))
+	assertEcho(t, newBufferedTestConn(clientConn, reader))
+}
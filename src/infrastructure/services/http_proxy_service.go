@@ -1,11 +1,12 @@
 package services
 
 import (
-	"bufio"
 	"context"
 	"errors"
+	"fmt"
 	"goproxy/application/aplication_errors"
 	"goproxy/application/contracts"
+	"goproxy/domain/valueobjects"
 	"goproxy/infrastructure/config"
 	"goproxy/infrastructure/infraerrs"
 	"io"
@@ -18,12 +19,30 @@ import (
 	"time"
 )
 
-const connectionReadDeadLine = time.Second * 30
+// rateLimitAccountingThreshold is how many client bytes are sent to the target before the rate limiter is asked again.
+const rateLimitAccountingThreshold = 1_000_000
+
+// destinationDeniedReasonHeader explains 403 responses to targets denied by the destination policy.
+const destinationDeniedReasonHeader = "X-Proxy-Deny-Reason"
 
 type Proxy struct {
 	rateLimiter     contracts.RateLimiterService
 	dialerService   contracts.DialerPool
 	trafficReporter *TrafficReporter
+	// bandwidthShaper is nil unless the rate limiter runs in shaping mode
+	bandwidthShaper *BandwidthShaper
+	// upstreamProxies is nil unless parent proxies are configured
+	upstreamProxies   *UpstreamProxyPool
+	destinationPolicy *DestinationPolicy
+	// dnsResolver resolves targets of datagrams, TCP targets are resolved by dialers
+	dnsResolver    contracts.DnsResolver
+	httpForward    config.HttpForwardConfig
+	httpTransports *httpTransportPool
+	// tlsInterceptor is nil unless some users or plans opted in to TLS interception
+	tlsInterceptor *TlsInterceptor
+	// httpHooks is nil unless HTTP hooks are configured
+	httpHooks *HttpHooks
+	timeouts  *TimeoutPolicy
 }
 
 var bufPool = sync.Pool{
@@ -33,20 +52,122 @@ var bufPool = sync.Pool{
 	},
 }
 
-func NewProxy(dialerService contracts.DialerPool) *Proxy {
+func NewProxy(dialerService contracts.DialerPool, planLimits contracts.UserPlanLimitsService) *Proxy {
 	rateLimiterConfig := config.LoadRateLimiterConfig()
 	trafficReporter, trafficReporterErr := NewTrafficReporter()
 	if trafficReporterErr != nil {
 		log.Fatalf("failed to create traffic reporterErr: %s", trafficReporterErr)
 	}
 
+	var bandwidthShaper *BandwidthShaper
+	if rateLimiterConfig.ShapingEnabled {
+		bandwidthShaper = NewBandwidthShaper(rateLimiterConfig).WithPlanLimits(planLimits)
+	}
+
+	upstreamProxyConfig, upstreamProxyConfigErr := config.LoadUpstreamProxyConfig()
+	if upstreamProxyConfigErr != nil {
+		log.Fatalf("failed to load upstream proxy config: %s", upstreamProxyConfigErr)
+	}
+
+	var upstreamProxies *UpstreamProxyPool
+	if upstreamProxyConfig.Enabled() {
+		upstreamProxies = NewUpstreamProxyPool(upstreamProxyConfig).WithPlanLimits(planLimits)
+		upstreamProxies.StartHealthChecks(context.Background())
+	}
+
+	destinationPolicyConfig, destinationPolicyConfigErr := config.LoadDestinationPolicyConfig()
+	if destinationPolicyConfigErr != nil {
+		log.Fatalf("failed to load destination policy config: %s", destinationPolicyConfigErr)
+	}
+
+	httpForwardConfig, httpForwardConfigErr := config.LoadHttpForwardConfig()
+	if httpForwardConfigErr != nil {
+		log.Fatalf("failed to load http forward config: %s", httpForwardConfigErr)
+	}
+
+	tlsInterceptionConfig, tlsInterceptionConfigErr := config.LoadTlsInterceptionConfig()
+	if tlsInterceptionConfigErr != nil {
+		log.Fatalf("failed to load tls interception config: %s", tlsInterceptionConfigErr)
+	}
+
+	var tlsInterceptor *TlsInterceptor
+	if tlsInterceptionConfig.Enabled() {
+		interceptor, interceptorErr := NewTlsInterceptor(tlsInterceptionConfig)
+		if interceptorErr != nil {
+			log.Fatalf("failed to create tls interceptor: %s", interceptorErr)
+		}
+		tlsInterceptor = interceptor.WithPlanLimits(planLimits)
+	}
+
+	httpHooksConfig, httpHooksConfigErr := config.LoadHttpHooksConfig()
+	if httpHooksConfigErr != nil {
+		log.Fatalf("failed to load http hooks config: %s", httpHooksConfigErr)
+	}
+
+	var httpHooks *HttpHooks
+	if httpHooksConfig.Enabled() {
+		hooks, hooksErr := NewHttpHooks(httpHooksConfig)
+		if hooksErr != nil {
+			log.Fatalf("failed to create http hooks: %s", hooksErr)
+		}
+		httpHooks = hooks.WithPlanLimits(planLimits)
+	}
+
+	timeoutsConfig, timeoutsConfigErr := config.LoadProxyTimeoutsConfig()
+	if timeoutsConfigErr != nil {
+		log.Fatalf("failed to load proxy timeouts config: %s", timeoutsConfigErr)
+	}
+
+	var rateLimiter contracts.RateLimiterService
+	if rateLimiterConfig.Distributed {
+		redisRateLimiter, redisRateLimiterErr := NewRedisRateLimiter(rateLimiterConfig)
+		if redisRateLimiterErr != nil {
+			log.Fatalf("failed to create redis rate limiter: %s", redisRateLimiterErr)
+		}
+		rateLimiter = redisRateLimiter.WithPlanLimits(planLimits)
+	} else {
+		rateLimiter = NewRateLimiter(rateLimiterConfig).WithPlanLimits(planLimits)
+	}
+
 	return &Proxy{
-		rateLimiter:     NewRateLimiter(rateLimiterConfig),
-		dialerService:   dialerService,
-		trafficReporter: trafficReporter,
+		rateLimiter:       rateLimiter,
+		dialerService:     dialerService,
+		trafficReporter:   trafficReporter,
+		bandwidthShaper:   bandwidthShaper,
+		upstreamProxies:   upstreamProxies,
+		destinationPolicy: NewDestinationPolicy(destinationPolicyConfig).WithPlanLimits(planLimits),
+		dnsResolver:       net.DefaultResolver,
+		httpForward:       httpForwardConfig,
+		httpTransports:    newHttpTransportPool(httpForwardConfig, timeoutsConfig.Default),
+		tlsInterceptor:    tlsInterceptor,
+		httpHooks:         httpHooks,
+		timeouts:          NewTimeoutPolicy(timeoutsConfig).WithPlanLimits(planLimits),
 	}
 }
 
+// WithUserNodes makes traffic shaping split per-user limits between proxy nodes carrying connections of the user.
+func (p *Proxy) WithUserNodes(userNodes UserNodesCounter) *Proxy {
+	if p.bandwidthShaper != nil {
+		p.bandwidthShaper.WithUserNodes(userNodes)
+	}
+	return p
+}
+
+// WithDnsResolver makes the proxy resolve datagram targets with the resolver instead of the system one.
+func (p *Proxy) WithDnsResolver(resolver contracts.DnsResolver) *Proxy {
+	p.dnsResolver = resolver
+	return p
+}
+
+// Shutdown closes keep-alive connections to HTTP targets, reports traffic not reported yet and stops the rate limiter.
+// It must be called once client connections are closed, as the rate limiter does not serve them after that.
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	p.httpTransports.closeIdleConnections()
+	reportErr := p.trafficReporter.Shutdown(ctx)
+	p.rateLimiter.Stop()
+	return reportErr
+}
+
 func (p *Proxy) Proxy(clientConn net.Conn, r *http.Request) {
 	defer func(clientConn net.Conn) {
 		_ = clientConn.Close()
@@ -59,13 +180,13 @@ func (p *Proxy) Proxy(clientConn net.Conn, r *http.Request) {
 	}
 
 	if r.Method == http.MethodConnect {
-		p.HandleHttps(clientConn, r, userId)
+		p.HandleHttps(clientConn, r, userId, 0, valueobjects.ConnectionOptions{})
 	} else {
-		p.HandleHttp(clientConn, r, userId)
+		p.HandleHttp(clientConn, r, userId, 0, valueobjects.ConnectionOptions{})
 	}
 }
 
-func (p *Proxy) HandleHttps(clientConn net.Conn, r *http.Request, userId int) {
+func (p *Proxy) HandleHttps(clientConn net.Conn, r *http.Request, userId, credentialId int, options valueobjects.ConnectionOptions) {
 	host := r.URL.Host
 	if !strings.Contains(host, ":") {
 		host += ":443"
@@ -76,30 +197,106 @@ func (p *Proxy) HandleHttps(clientConn net.Conn, r *http.Request, userId int) {
 		return
 	}
 
-	dialer, dialerErr := p.dialerService.GetDialer("tcp", userId)
-	if dialerErr != nil && errors.Is(dialerErr, aplication_errors.ErrIpPoolEmpty{}) {
-		dialer = &net.Dialer{}
-	} else if dialerErr != nil {
-		log.Printf("failed to get dialer: %v", dialerErr)
-		_, _ = clientConn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+	if p.intercepts(userId, host) {
+		p.interceptHttps(clientConn, userId, credentialId, options, host)
 		return
 	}
 
-	serverConn, err := dialer.Dial("tcp", host)
+	serverConn, err := p.dial(userId, options, TunnelDestination, host)
 	if err != nil {
 		log.Println("Could not connect:", err)
-		_, _ = clientConn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+		writeDialError(clientConn, err)
 		return
 	}
 	defer func(serverConn net.Conn) {
 		_ = serverConn.Close()
 	}(serverConn)
 
-	_ = clientConn.SetReadDeadline(time.Now().Add(connectionReadDeadLine))
-	_ = serverConn.SetReadDeadline(time.Now().Add(connectionReadDeadLine))
-
 	_, _ = clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
 
+	p.tunnel(clientConn, serverConn, userId, credentialId, options, host)
+}
+
+// dial checks the destination policy and connects to host using the egress dialer chosen for the user by the egress policy.
+func (p *Proxy) dial(userId int, options valueobjects.ConnectionOptions, kind DestinationKind, host string) (net.Conn, error) {
+	if err := p.destinationPolicy.Check(userId, kind, host); err != nil {
+		return nil, err
+	}
+
+	dialer, dialerErr := p.dialerService.GetDialer("tcp", userId, options.Egress())
+	if dialerErr != nil && errors.Is(dialerErr, aplication_errors.ErrIpPoolEmpty{}) {
+		dialer = newEgressDialer(nil, nil)
+	} else if dialerErr != nil {
+		return nil, fmt.Errorf("failed to get dialer: %v", dialerErr)
+	}
+
+	return p.dialWith(context.Background(), dialer, userId, options.Country(), host)
+}
+
+// dialWith connects to host through the parent proxies of the user or of the requested country,
+// or directly if the user has none. Direct connections are only made to IPs allowed by the destination policy.
+func (p *Proxy) dialWith(ctx context.Context, dialer contracts.Dialer, userId int, country, host string) (net.Conn, error) {
+	if p.upstreamProxies != nil {
+		if conn, chained, err := p.upstreamProxies.Dial(dialer, userId, country, host); chained {
+			return conn, err
+		}
+	} else if country != "" {
+		return nil, CountryUnavailableError{Country: country}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeouts.For(userId).Dial)
+	defer cancel()
+
+	return dialer.DialContext(p.destinationPolicy.Guard(ctx), "tcp", host)
+}
+
+// writeDialError tells the HTTP client why the target could not be reached.
+func writeDialError(clientConn net.Conn, err error) {
+	var deniedErr DestinationDeniedError
+	if errors.As(err, &deniedErr) {
+		_, _ = fmt.Fprintf(clientConn, "HTTP/1.1 403 Forbidden\r\n%s: %s\r\nConnection: close\r\n\r\n",
+			destinationDeniedReasonHeader, deniedErr.Reason)
+		return
+	}
+
+	_, _ = clientConn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+}
+
+// tunnel copies traffic between client and server in both directions until one of the sides is closed,
+// the tunnel is idle for the idle timeout of the user or outlives their maximum lifetime.
+// Traffic is reported for the user and the proxy credential they authorized with.
+// Tunnels between TCP connections are spliced in the kernel where supported, unless their traffic is shaped
+// or the client limited the speed of the connection.
+func (p *Proxy) tunnel(clientConn, serverConn net.Conn, userId, credentialId int, options valueobjects.ConnectionOptions, host string) {
+	// the handshake deadline of the client is replaced by the watchdog
+	_ = clientConn.SetDeadline(time.Time{})
+	watchdog := newActivityWatchdog(p.timeouts.For(userId), expireConns(clientConn, serverConn))
+	defer watchdog.stop()
+
+	counter := p.trafficReporter.OpenCounter(userId, credentialId)
+	defer func() {
+		p.trafficReporter.CloseCounter(counter)
+		go p.trafficReporter.FlushBuckets()
+	}()
+
+	if p.bandwidthShaper == nil && options.SpeedLimit() == 0 && p.spliceTunnel(clientConn, serverConn, watchdog, counter, userId, host) {
+		return
+	}
+
+	p.copyTunnel(watchdog.conn(clientConn), watchdog.conn(serverConn), counter, userId, options.SpeedLimit(), host)
+}
+
+// copyTunnel copies traffic of a tunnel through pooled buffers, throttling it in shaping mode
+// or to the speed limit requested by the client.
+func (p *Proxy) copyTunnel(clientConn, serverConn net.Conn, counter *TrafficCounter, userId int, speedLimit int64, host string) {
+	if !p.admitShaped(userId, host) {
+		log.Printf("Rate limit exceeded, closing tunnel of user %d to %s", userId, host)
+		return
+	}
+
+	shapedConn := p.openShapedConnection(userId, speedLimit)
+	defer shapedConn.Close()
+
 	var wg sync.WaitGroup
 	wg.Add(2)
 
@@ -107,21 +304,47 @@ func (p *Proxy) HandleHttps(clientConn net.Conn, r *http.Request, userId int) {
 	// client → server
 	go func() {
 		defer wg.Done()
-		_ = p.copyTrafficAndReport(reportCtx, userId, host, serverConn, clientConn, "in")
+		_ = p.copyTrafficAndReport(reportCtx, counter, userId, host, serverConn, shapedConn.Reader(reportCtx, "in", clientConn), "in")
 		cancelFunc()
 	}()
 	// server → client
 	go func() {
 		defer wg.Done()
-		_ = p.copyTrafficAndReport(reportCtx, userId, host, clientConn, serverConn, "out")
+		_ = p.copyTrafficAndReport(reportCtx, counter, userId, host, clientConn, shapedConn.Reader(reportCtx, "out", serverConn), "out")
 		cancelFunc()
 	}()
 
 	wg.Wait()
-	go p.trafficReporter.FlushBuckets()
 }
 
-func (p *Proxy) copyTrafficAndReport(ctx context.Context, userId int, host string, dst io.Writer, src io.Reader, direction string) error {
+// admitShaped takes a connection slot of the target in shaping mode, where relayed traffic is not checked against
+// the rate limiter, so blocked users and users over the connections limit of the target are still refused.
+// The slot is released by rateLimiter.Done. True is returned outside of shaping mode.
+func (p *Proxy) admitShaped(userId int, host string) bool {
+	return p.bandwidthShaper == nil || p.rateLimiter.Allow(userId, host, 0)
+}
+
+// releaseShaped releases the slot taken by admitShaped if the connection ends before its traffic is relayed.
+func (p *Proxy) releaseShaped(userId int, host string) {
+	if p.bandwidthShaper != nil {
+		p.rateLimiter.Done(userId, host)
+	}
+}
+
+// openShapedConnection returns nil when shaping is disabled and the client did not limit the connection speed;
+// nil ShapedConnection leaves traffic untouched. speedLimit is in bytes per second, 0 means no limit.
+func (p *Proxy) openShapedConnection(userId int, speedLimit int64) *ShapedConnection {
+	if p.bandwidthShaper == nil {
+		if speedLimit == 0 {
+			return nil
+		}
+		return newConnectionShapedConnection(float64(speedLimit))
+	}
+
+	return p.bandwidthShaper.Open(userId, float64(speedLimit))
+}
+
+func (p *Proxy) copyTrafficAndReport(ctx context.Context, counter *TrafficCounter, userId int, host string, dst io.Writer, src io.Reader, direction string) error {
 	if direction == "in" {
 		defer p.rateLimiter.Done(userId, host)
 	}
@@ -131,7 +354,6 @@ func (p *Proxy) copyTrafficAndReport(ctx context.Context, userId int, host strin
 	defer bufPool.Put(bufPtr)
 
 	var accumulatedBytes int64
-	const threshold = 1_000_000
 
 	for {
 		select {
@@ -149,15 +371,16 @@ func (p *Proxy) copyTrafficAndReport(ctx context.Context, userId int, host strin
 				// direction == "out" → server → client
 				if direction == "in" {
 					accumulatedBytes += int64(written)
-					if accumulatedBytes >= threshold {
-						if !p.rateLimiter.Allow(userId, host, accumulatedBytes) {
+					if accumulatedBytes >= rateLimitAccountingThreshold {
+						// in shaping mode src is already throttled, so exceeding the limit must not cut the connection
+						if p.bandwidthShaper == nil && !p.rateLimiter.Allow(userId, host, accumulatedBytes) {
 							return infraerrs.RateLimitExceededError{}
 						}
 						accumulatedBytes = 0
 					}
-					p.trafficReporter.AddInBytes(userId, int64(written))
+					counter.AddInBytes(int64(written))
 				} else {
-					p.trafficReporter.AddOutBytes(userId, int64(written))
+					counter.AddOutBytes(int64(written))
 				}
 			}
 
@@ -170,76 +393,3 @@ func (p *Proxy) copyTrafficAndReport(ctx context.Context, userId int, host strin
 		}
 	}
 }
-
-func (p *Proxy) HandleHttp(clientConn net.Conn, firstReq *http.Request, userId int) {
-	br := bufio.NewReaderSize(clientConn, 128*1024)
-	req := firstReq
-
-	for {
-		if req == nil {
-			var err error
-			req, err = http.ReadRequest(br)
-			if err != nil {
-				break
-			}
-		}
-
-		if err := p.handleHttpOnce(clientConn, req, userId); err != nil {
-			log.Printf("handleHttpOnce error: %v", err)
-			break
-		}
-
-		// req.Close indicates whether to close the connection after replying to this request
-		if req.Close || strings.EqualFold(req.Header.Get("Connection"), "close") {
-			break
-		}
-
-		// clear req to handle next request
-		req = nil
-	}
-}
-
-func (p *Proxy) handleHttpOnce(clientConn net.Conn, r *http.Request, userId int) error {
-	host := r.URL.Host
-	if !strings.Contains(host, ":") {
-		host += ":80"
-	}
-
-	dialer, dialerErr := p.dialerService.GetDialer("tcp", userId)
-	if dialerErr != nil {
-		log.Printf("Failed to get dialer: %v", dialerErr)
-		_, _ = clientConn.Write([]byte("HTTP/1.1 500 Internal Server Error\r\n\r\n"))
-		return dialerErr
-	}
-
-	serverConn, err := dialer.Dial("tcp", host)
-	if err != nil {
-		log.Println("Could not connect:", err)
-		_, _ = clientConn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
-		return err
-	}
-	defer func(serverConn net.Conn) {
-		_ = serverConn.Close()
-	}(serverConn)
-
-	_ = clientConn.SetReadDeadline(time.Now().Add(connectionReadDeadLine))
-	_ = serverConn.SetReadDeadline(time.Now().Add(connectionReadDeadLine))
-
-	pr, pw := io.Pipe()
-	go func() {
-		_ = r.Write(pw)
-		_ = pw.Close()
-	}()
-
-	reportCtx, cancelFunc := context.WithCancel(context.Background())
-	// Request: PipeReader→serverConn (inBytes)
-	_ = p.copyTrafficAndReport(reportCtx, userId, host, serverConn, pr, "in")
-
-	// Response: serverConn→clientConn (outBytes)
-	_ = p.copyTrafficAndReport(reportCtx, userId, host, clientConn, serverConn, "out")
-
-	cancelFunc()
-
-	go p.trafficReporter.FlushBuckets()
-	return nil
-}
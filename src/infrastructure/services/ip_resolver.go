@@ -35,7 +35,7 @@ func (r *HostIPResolver) GetHostPublicIPs() ([]net.IP, error) {
 				continue
 			}
 
-			if isPublicIPv4(ip) {
+			if isPublicIPv4(ip) || isPublicIPv6(ip) {
 				publicIPs = append(publicIPs, ip)
 			}
 		}
@@ -67,6 +67,15 @@ func isPublicIPv4(ip net.IP) bool {
 	return !ip.IsLoopback() && !ip.IsUnspecified()
 }
 
+// isPublicIPv6 accepts global unicast addresses outside of unique local fc00::/7 range.
+func isPublicIPv6(ip net.IP) bool {
+	if ip == nil || ip.To4() != nil || ip.To16() == nil {
+		return false
+	}
+
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
 func bytesCompare(ip1, ip2 net.IP) int {
 	return bytes.Compare(ip1.To4(), ip2.To4())
 }
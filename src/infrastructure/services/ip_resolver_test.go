@@ -0,0 +1,32 @@
+package services
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"203.0.113.7", true},
+		{"10.1.2.3", false},
+		{"192.168.0.1", false},
+		{"127.0.0.1", false},
+		{"2a01:4f8:1:2::1", true},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::1", false},
+		{"::", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			ip := net.ParseIP(tt.ip)
+			assert.Equal(t, tt.public, isPublicIPv4(ip) || isPublicIPv6(ip))
+		})
+	}
+}
@@ -2,14 +2,19 @@ package services
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"goproxy/application/contracts"
 	"goproxy/domain/events"
 	"goproxy/infrastructure/config"
 	"log"
+	"time"
 )
 
+// kafkaConsumeTimeout limits how long Consume waits for a message, so that consumers notice they are stopped.
+const kafkaConsumeTimeout = time.Second
+
 type KafkaService struct {
 	consumer *kafka.Consumer
 	producer *kafka.Producer
@@ -56,7 +61,11 @@ func (k KafkaService) Subscribe(topics []string) error {
 }
 
 func (k KafkaService) Consume() (*events.OutboxEvent, error) {
-	msg, err := k.consumer.ReadMessage(-1)
+	msg, err := k.consumer.ReadMessage(kafkaConsumeTimeout)
+	var kafkaErr kafka.Error
+	if errors.As(err, &kafkaErr) && kafkaErr.Code() == kafka.ErrTimedOut {
+		return nil, contracts.ErrNoEvent
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to consume message: %v", err)
 	}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"goproxy/application/contracts"
+	"goproxy/infrastructure/config"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TimeoutPolicy chooses timeouts of users by their plans.
+type TimeoutPolicy struct {
+	config     config.ProxyTimeoutsConfig
+	planLimits contracts.UserPlanLimitsService
+}
+
+func NewTimeoutPolicy(config config.ProxyTimeoutsConfig) *TimeoutPolicy {
+	return &TimeoutPolicy{
+		config: config,
+	}
+}
+
+// WithPlanLimits enables timeouts of users active plans.
+func (t *TimeoutPolicy) WithPlanLimits(planLimits contracts.UserPlanLimitsService) *TimeoutPolicy {
+	t.planLimits = planLimits
+	return t
+}
+
+// Default returns timeouts of clients not authorized yet.
+func (t *TimeoutPolicy) Default() config.ProxyTimeouts {
+	return t.config.Default
+}
+
+// For returns timeouts of the user plan, or the default ones if the plan has none.
+func (t *TimeoutPolicy) For(userId int) config.ProxyTimeouts {
+	if t.planLimits != nil && len(t.config.Plans) != 0 {
+		if timeouts, ok := t.config.Plans[t.planLimits.GetLimits(userId).PlanId]; ok {
+			return timeouts
+		}
+	}
+
+	return t.config.Default
+}
+
+// activityWatchdog ends a tunnel once no data flows either way for the idle timeout or the tunnel outlives
+// its maximum lifetime. Reads are not given deadlines of their own, so a tunnel carrying data in one direction
+// only, e.g. a long download, stays open.
+type activityWatchdog struct {
+	idle         time.Duration
+	lastActivity atomic.Int64
+	onExpire     func()
+
+	mu            sync.Mutex
+	stopped       bool
+	idleTimer     *time.Timer
+	lifetimeTimer *time.Timer
+}
+
+// newActivityWatchdog starts a watchdog calling onExpire once, when the tunnel is idle or too old.
+// onExpire is expected to unblock reads and writes of the tunnel, e.g. by setting a past deadline.
+func newActivityWatchdog(timeouts config.ProxyTimeouts, onExpire func()) *activityWatchdog {
+	w := &activityWatchdog{
+		idle:     timeouts.Idle,
+		onExpire: onExpire,
+	}
+	w.touch()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.idleTimer = time.AfterFunc(w.idle, w.checkIdle)
+	if timeouts.MaxLifetime > 0 {
+		w.lifetimeTimer = time.AfterFunc(timeouts.MaxLifetime, w.expire)
+	}
+
+	return w
+}
+
+// touch records that data went through the tunnel.
+func (w *activityWatchdog) touch() {
+	w.lastActivity.Store(time.Now().UnixNano())
+}
+
+// checkIdle expires the tunnel if it has been idle long enough, otherwise it checks again when it could be.
+func (w *activityWatchdog) checkIdle() {
+	idleUntil := time.Unix(0, w.lastActivity.Load()).Add(w.idle)
+	if wait := time.Until(idleUntil); wait > 0 {
+		w.mu.Lock()
+		if !w.stopped {
+			w.idleTimer.Reset(wait)
+		}
+		w.mu.Unlock()
+		return
+	}
+
+	w.expire()
+}
+
+// expire ends the tunnel now, unless it is already stopped.
+func (w *activityWatchdog) expire() {
+	w.mu.Lock()
+	if w.stopped {
+		w.mu.Unlock()
+		return
+	}
+	w.stopTimers()
+	w.mu.Unlock()
+
+	w.onExpire()
+}
+
+// stop releases the timers once the tunnel is closed.
+func (w *activityWatchdog) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.stopped {
+		w.stopTimers()
+	}
+}
+
+func (w *activityWatchdog) stopTimers() {
+	w.stopped = true
+	w.idleTimer.Stop()
+	if w.lifetimeTimer != nil {
+		w.lifetimeTimer.Stop()
+	}
+}
+
+// conn returns conn recording its reads as activity of the tunnel.
+func (w *activityWatchdog) conn(conn net.Conn) net.Conn {
+	return &watchedConn{Conn: conn, watchdog: w}
+}
+
+type watchedConn struct {
+	net.Conn
+	watchdog *activityWatchdog
+}
+
+func (c *watchedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.watchdog.touch()
+	}
+	return n, err
+}
+
+// expireConns returns a function ending reads and writes of all conns, to be called by a watchdog.
+func expireConns(conns ...net.Conn) func() {
+	return func() {
+		for _, conn := range conns {
+			_ = conn.SetDeadline(time.Now())
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"goproxy/domain/dataobjects"
+	"goproxy/infrastructure/config"
+	"goproxy/infrastructure/socks5"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeoutPolicy_For(t *testing.T) {
+	planTimeouts := config.ProxyTimeouts{Idle: time.Hour, Dial: time.Second, Handshake: time.Second}
+	policy := NewTimeoutPolicy(config.ProxyTimeoutsConfig{
+		Default: testProxyTimeouts,
+		Plans:   map[int]config.ProxyTimeouts{2: planTimeouts},
+	})
+	planLimits := newTestUserPlanLimitsService()
+	_ = planLimits.SetLimits(1, dataobjects.UserPlanLimits{PlanId: 1})
+	_ = planLimits.SetLimits(2, dataobjects.UserPlanLimits{PlanId: 2})
+
+	assert.Equal(t, testProxyTimeouts, policy.For(2), "plans are ignored without plan limits")
+
+	policy.WithPlanLimits(planLimits)
+	assert.Equal(t, testProxyTimeouts, policy.For(1))
+	assert.Equal(t, planTimeouts, policy.For(2))
+	assert.Equal(t, testProxyTimeouts, policy.Default())
+}
+
+func TestActivityWatchdog(t *testing.T) {
+	const idle = 100 * time.Millisecond
+
+	t.Run("expires idle tunnel", func(t *testing.T) {
+		expired := make(chan time.Time, 1)
+		started := time.Now()
+		watchdog := newActivityWatchdog(config.ProxyTimeouts{Idle: idle}, func() { expired <- time.Now() })
+		defer watchdog.stop()
+
+		select {
+		case at := <-expired:
+			assert.GreaterOrEqual(t, at.Sub(started), idle)
+		case <-time.After(5 * time.Second):
+			t.Fatal("idle tunnel was not expired")
+		}
+	})
+
+	t.Run("activity postpones expiry", func(t *testing.T) {
+		var expired atomic.Bool
+		watchdog := newActivityWatchdog(config.ProxyTimeouts{Idle: idle}, func() { expired.Store(true) })
+		defer watchdog.stop()
+
+		for i := 0; i < 6; i++ {
+			time.Sleep(idle / 2)
+			watchdog.touch()
+		}
+		assert.False(t, expired.Load())
+
+		assert.Eventually(t, expired.Load, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("expires active tunnel at max lifetime", func(t *testing.T) {
+		var expirations atomic.Int32
+		watchdog := newActivityWatchdog(config.ProxyTimeouts{Idle: time.Hour, MaxLifetime: idle}, func() { expirations.Add(1) })
+		defer watchdog.stop()
+
+		deadline := time.Now().Add(3 * idle)
+		for time.Now().Before(deadline) {
+			watchdog.touch()
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(t, int32(1), expirations.Load())
+	})
+
+	t.Run("stopped watchdog does not expire", func(t *testing.T) {
+		var expired atomic.Bool
+		watchdog := newActivityWatchdog(config.ProxyTimeouts{Idle: idle, MaxLifetime: idle}, func() { expired.Store(true) })
+		watchdog.stop()
+
+		time.Sleep(2 * idle)
+		assert.False(t, expired.Load())
+	})
+}
+
+// startDripServer accepts a connection and sends it a byte every interval count times, then keeps it open silently.
+func startDripServer(t *testing.T, interval time.Duration, count int) *net.TCPAddr {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		conn, acceptErr := listener.Accept()
+		if acceptErr != nil {
+			return
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+
+		for i := 0; i < count; i++ {
+			time.Sleep(interval)
+			if _, writeErr := conn.Write([]byte("x")); writeErr != nil {
+				return
+			}
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr)
+}
+
+func TestProxy_Tunnel_IdleTimeout(t *testing.T) {
+	const idle = 200 * time.Millisecond
+
+	tests := []struct {
+		name        string
+		maxLifetime time.Duration
+		complete    bool
+	}{
+		// the client never writes, the tunnel is kept open by the download alone
+		{name: "one way traffic keeps tunnel open", complete: true},
+		{name: "max lifetime ends active tunnel", maxLifetime: 2 * idle},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := newTestProxy(t)
+			proxy.timeouts = NewTimeoutPolicy(config.ProxyTimeoutsConfig{Default: config.ProxyTimeouts{
+				Idle:        idle,
+				Dial:        time.Second,
+				Handshake:   time.Second,
+				MaxLifetime: tt.maxLifetime,
+			}})
+			target := startDripServer(t, 50*time.Millisecond, 12)
+			socksServer := serveSocks5(t, proxy, 1)
+
+			conn, err := net.Dial("tcp", socksServer.Addr().String())
+			require.NoError(t, err)
+			defer func() {
+				_ = conn.Close()
+			}()
+
+			socks5ClientHandshake(t, conn)
+			writeSocks5Request(t, conn, socks5.CmdConnect, target)
+
+			_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			started := time.Now()
+			received, _ := io.Copy(io.Discard, conn)
+
+			// the tunnel is closed by the proxy either way, once the target is silent for the idle timeout
+			assert.Less(t, time.Since(started), 4*time.Second)
+			if tt.complete {
+				assert.Equal(t, int64(12), received)
+			} else {
+				assert.Less(t, received, int64(12))
+			}
+		})
+	}
+}
@@ -3,16 +3,26 @@ package services
 import (
 	"fmt"
 	"github.com/cespare/xxhash/v2"
+	"goproxy/application/contracts"
+	"goproxy/domain/dataobjects"
 	serviceconfigurations "goproxy/infrastructure/config"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+const (
+	// planBucketBurst is how long a user may consume traffic without refill at the plan speed
+	planBucketBurst = 10 * time.Second
+	// minPlanBucketCapacity keeps plan buckets large enough for a single traffic accounting chunk
+	minPlanBucketCapacity = 1_000_000
+)
+
 type request struct {
 	userID int
 	target string
 	tokens int64
+	limits dataobjects.UserPlanLimits
 	resp   chan bool
 }
 
@@ -34,6 +44,11 @@ type RateLimiter struct {
 	shardsCapacity int64   // Capacity per bucket
 	shardsFillRate float64 // Fill rate per bucket
 
+	planLimits contracts.UserPlanLimitsService // Optional per-user limits overriding the defaults
+	// planBuckets are buckets of users with plan speed shared by all targets of the user,
+	// only the loop goroutine accesses them
+	planBuckets map[int]*tokenBucket
+
 	wg sync.WaitGroup
 }
 
@@ -63,6 +78,7 @@ func NewRateLimiter(config serviceconfigurations.RateLimiterConfig) *RateLimiter
 		shardsCapacity: config.Capacity,
 		shardsFillRate: config.FillRate,
 		shards:         make([]*shard, config.ShardCount),
+		planBuckets:    make(map[int]*tokenBucket),
 	}
 
 	for i := 0; i < config.ShardCount; i++ {
@@ -75,6 +91,13 @@ func NewRateLimiter(config serviceconfigurations.RateLimiterConfig) *RateLimiter
 	return rl
 }
 
+// WithPlanLimits makes the RateLimiter apply speed of users active plans instead of the configured defaults.
+// The plan speed is shared by all targets of the user, plan connection limits are enforced by ConnectionLimiter.
+func (rl *RateLimiter) WithPlanLimits(planLimits contracts.UserPlanLimitsService) *RateLimiter {
+	rl.planLimits = planLimits
+	return rl
+}
+
 // Stop gracefully shuts down the RateLimiter.
 func (rl *RateLimiter) Stop() {
 	close(rl.stopChan)
@@ -88,6 +111,7 @@ func (rl *RateLimiter) Allow(userID int, target string, tokens int64) bool {
 		userID: userID,
 		target: target,
 		tokens: tokens,
+		limits: rl.userLimits(userID),
 		resp:   respChan,
 	}
 	rl.reqChan <- req
@@ -107,7 +131,7 @@ func (rl *RateLimiter) loop() {
 	for {
 		select {
 		case req := <-rl.reqChan:
-			allowed := rl.allowInternal(req.userID, req.target, req.tokens)
+			allowed := rl.allowInternal(req.userID, req.target, req.tokens, req.limits)
 			req.resp <- allowed
 
 		case dreq := <-rl.doneChan:
@@ -122,7 +146,16 @@ func (rl *RateLimiter) loop() {
 	}
 }
 
-func (rl *RateLimiter) allowInternal(userID int, target string, tokens int64) bool {
+// userLimits is called outside the loop goroutine, so slow lookups do not delay other users.
+func (rl *RateLimiter) userLimits(userID int) dataobjects.UserPlanLimits {
+	if rl.planLimits == nil {
+		return dataobjects.UserPlanLimits{}
+	}
+
+	return rl.planLimits.GetLimits(userID)
+}
+
+func (rl *RateLimiter) allowInternal(userID int, target string, tokens int64, limits dataobjects.UserPlanLimits) bool {
 	// Create a unique key for user and target
 	key := fmt.Sprintf("%d|%s", userID, target)
 	s := rl.getShard(key)
@@ -157,8 +190,9 @@ func (rl *RateLimiter) allowInternal(userID int, target string, tokens int64) bo
 	}
 
 	// Check if there are enough tokens
-	if bucket.available >= tokens {
-		bucket.available -= tokens
+	speedBucket := rl.speedBucket(bucket, userID, limits, now)
+	if speedBucket.available >= tokens {
+		speedBucket.available -= tokens
 		bucket.connections++
 		return true
 	}
@@ -168,6 +202,36 @@ func (rl *RateLimiter) allowInternal(userID int, target string, tokens int64) bo
 	return false
 }
 
+// planBucketLimits returns capacity and fill rate of the bucket shared by all targets of a user with plan speed.
+func (rl *RateLimiter) planBucketLimits(limits dataobjects.UserPlanLimits) (int64, float64) {
+	fillRate := float64(limits.MaxBytesPerSecond)
+	return max(int64(fillRate*planBucketBurst.Seconds()), minPlanBucketCapacity), fillRate
+}
+
+// speedBucket returns the bucket traffic tokens are taken from: the user bucket if the plan limits speed,
+// otherwise the target bucket with the configured defaults.
+func (rl *RateLimiter) speedBucket(bucket *tokenBucket, userID int, limits dataobjects.UserPlanLimits, now int64) *tokenBucket {
+	if limits.MaxBytesPerSecond <= 0 {
+		return bucket
+	}
+
+	capacity, fillRate := rl.planBucketLimits(limits)
+	planBucket, ok := rl.planBuckets[userID]
+	if !ok {
+		planBucket = &tokenBucket{available: capacity, lastUpdateNano: now}
+		rl.planBuckets[userID] = planBucket
+	}
+
+	planBucket.capacity = capacity
+	planBucket.fillRate = fillRate
+	planBucket.refill(now)
+	if planBucket.available > capacity {
+		planBucket.available = capacity
+	}
+
+	return planBucket
+}
+
 func (rl *RateLimiter) doneInternal(userID int, target string) {
 	key := fmt.Sprintf("%d|%s", userID, target)
 	s := rl.getShard(key)
@@ -196,6 +260,12 @@ func (rl *RateLimiter) cleanupInternal() {
 		}
 		s.mu.Unlock()
 	}
+
+	for userID, bucket := range rl.planBuckets {
+		if (now - bucket.lastUpdateNano) > int64(5*time.Minute) {
+			delete(rl.planBuckets, userID)
+		}
+	}
 }
 
 func (rl *RateLimiter) getShard(key string) *shard {
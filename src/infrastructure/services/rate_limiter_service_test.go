@@ -1,6 +1,7 @@
 package services
 
 import (
+	"goproxy/domain/dataobjects"
 	"goproxy/infrastructure/config"
 	"testing"
 	"time"
@@ -132,3 +133,44 @@ func TestRateLimiter_Cleanup(t *testing.T) {
 	// Ensure the bucket is cleaned up
 	rl.doneInternal(userID, target) // Simulate request completion
 }
+
+func TestRateLimiter_AllowWithPlanLimits(t *testing.T) {
+	rlConf := config.RateLimiterConfig{
+		MaxConns:   10,
+		BlockDur:   50 * time.Millisecond,
+		CleanupInt: time.Minute,
+		Capacity:   100 * 1024 * 1024,
+		FillRate:   100 * 1024 * 1024,
+		ShardCount: 2,
+	}
+
+	planLimits := newTestUserPlanLimitsService()
+	_ = planLimits.SetLimits(1, dataobjects.UserPlanLimits{MaxBytesPerSecond: 1000, MaxConnections: 2})
+	_ = planLimits.SetLimits(3, dataobjects.UserPlanLimits{MaxConnections: 2})
+
+	rl := NewRateLimiter(rlConf).WithPlanLimits(planLimits)
+	defer rl.Stop()
+
+	// plan bucket capacity is minPlanBucketCapacity as 10 seconds at plan speed is less than that
+	if !rl.Allow(1, "plan_target", minPlanBucketCapacity) {
+		t.Fatalf("expected Allow to return true within plan bucket capacity")
+	}
+	if rl.Allow(1, "plan_target", minPlanBucketCapacity) {
+		t.Fatalf("expected Allow to return false when exceeding plan bucket capacity")
+	}
+
+	// plan speed is shared by all targets of the user
+	if rl.Allow(1, "other_target", minPlanBucketCapacity) {
+		t.Fatalf("expected Allow to return false for another target of the user")
+	}
+
+	// plan connections limit is enforced by ConnectionLimiter, not by the traffic accounting
+	if !rl.Allow(3, "conns_target", 1) || !rl.Allow(3, "conns_target", 1) || !rl.Allow(3, "conns_target", 1) {
+		t.Fatalf("expected Allow to return true within MaxConns")
+	}
+
+	// users without plan limits get the defaults
+	if !rl.Allow(2, "plan_target", minPlanBucketCapacity) || !rl.Allow(2, "plan_target", minPlanBucketCapacity) {
+		t.Fatalf("expected Allow to return true for user without plan limits")
+	}
+}
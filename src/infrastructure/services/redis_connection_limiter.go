@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"goproxy/application/contracts"
+	serviceconfigurations "goproxy/infrastructure/config"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	redisConnectionLimiterKeyPrefix = "connection_limiter"
+	// redisConnectionLimiterRefreshInterval is how often the node reports connections it holds, so counts
+	// of nodes which stopped without releasing their connections are dropped after redisConnectionLimiterStaleAfter
+	redisConnectionLimiterRefreshInterval = 20 * time.Second
+	redisConnectionLimiterStaleAfter      = 3 * redisConnectionLimiterRefreshInterval
+	redisConnectionLimiterTTL             = 5 * time.Minute
+)
+
+// redisConnectionLimiterTime is the Redis time in milliseconds, so clocks of proxy nodes do not need to agree.
+const redisConnectionLimiterTime = `
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+`
+
+// acquireUserScript drops counts of nodes not seen for the stale period, then takes a connection slot of the node
+// unless the user is at limit. Returns whether the slot was taken and the number of nodes with connections of the user.
+// KEYS[1] - hash of connection counts by node, KEYS[2] - hash of times the nodes were last seen by node
+// ARGV - max connections (0 means no limit), node id, stale period (ms), ttl (ms)
+var acquireUserScript = redis.NewScript(redisConnectionLimiterTime + `
+local maxConns = tonumber(ARGV[1])
+local node = ARGV[2]
+local staleAfter = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+
+local counts = redis.call('HGETALL', KEYS[1])
+local total = 0
+local nodes = 0
+for i = 1, #counts, 2 do
+	local seen = tonumber(redis.call('HGET', KEYS[2], counts[i])) or 0
+	if now - seen > staleAfter then
+		redis.call('HDEL', KEYS[1], counts[i])
+		redis.call('HDEL', KEYS[2], counts[i])
+	else
+		total = total + tonumber(counts[i + 1])
+		nodes = nodes + 1
+	end
+end
+
+if maxConns > 0 and total >= maxConns then
+	return {0, nodes}
+end
+
+if redis.call('HINCRBY', KEYS[1], node, 1) == 1 then
+	nodes = nodes + 1
+end
+redis.call('HSET', KEYS[2], node, now)
+redis.call('PEXPIRE', KEYS[1], ttl)
+redis.call('PEXPIRE', KEYS[2], ttl)
+return {1, nodes}
+`)
+
+// releaseUserScript releases a connection slot of the node taken by acquireUserScript.
+// KEYS[1] - hash of connection counts by node, KEYS[2] - hash of times the nodes were last seen by node
+// ARGV - node id
+var releaseUserScript = redis.NewScript(redisConnectionLimiterTime + `
+local node = ARGV[1]
+if redis.call('HINCRBY', KEYS[1], node, -1) <= 0 then
+	redis.call('HDEL', KEYS[1], node)
+	redis.call('HDEL', KEYS[2], node)
+else
+	redis.call('HSET', KEYS[2], node, now)
+end
+return 0
+`)
+
+// refreshUserScript sets the connection count of the node and marks the node as seen.
+// Returns the number of nodes with connections of the user.
+// KEYS[1] - hash of connection counts by node, KEYS[2] - hash of times the nodes were last seen by node
+// ARGV - node id, connections, ttl (ms)
+var refreshUserScript = redis.NewScript(redisConnectionLimiterTime + `
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+redis.call('HSET', KEYS[2], ARGV[1], now)
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+redis.call('PEXPIRE', KEYS[2], ARGV[3])
+return redis.call('HLEN', KEYS[1])
+`)
+
+// RedisConnectionLimiter counts concurrent connections of every user across all proxy nodes through Redis.
+// Connections of the node are counted locally. If Redis is unreachable, connections of users are counted
+// by the local ConnectionLimiter until Redis becomes available again, and released by the store which counted them.
+type RedisConnectionLimiter struct {
+	client *redis.Client
+	local  *ConnectionLimiter
+	nodeId string
+
+	mu sync.Mutex
+	// redisConns are connections of users the node counted in Redis
+	redisConns map[int]int
+	// userNodes are numbers of nodes carrying connections of users as last seen in Redis
+	userNodes map[int]int
+
+	unavailableUntil atomic.Int64
+}
+
+func NewRedisConnectionLimiter(config serviceconfigurations.RateLimiterConfig) (*RedisConnectionLimiter, error) {
+	client, err := newRedisClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return newRedisConnectionLimiter(client, NewConnectionLimiter(config))
+}
+
+func newRedisConnectionLimiter(client *redis.Client, local *ConnectionLimiter) (*RedisConnectionLimiter, error) {
+	nodeId := make([]byte, 8)
+	if _, err := rand.Read(nodeId); err != nil {
+		return nil, fmt.Errorf("could not generate node id: %v", err)
+	}
+
+	return &RedisConnectionLimiter{
+		client:     client,
+		local:      local,
+		nodeId:     hex.EncodeToString(nodeId),
+		redisConns: make(map[int]int),
+		userNodes:  make(map[int]int),
+	}, nil
+}
+
+// WithPlanLimits makes the limiter apply connection limits of users active plans instead of MaxUserConns.
+func (c *RedisConnectionLimiter) WithPlanLimits(planLimits contracts.UserPlanLimitsService) *RedisConnectionLimiter {
+	c.local.WithPlanLimits(planLimits)
+	return c
+}
+
+// StartRefreshing reports connections held by the node to Redis until ctx is done,
+// so they are not dropped as connections of a stopped node.
+func (c *RedisConnectionLimiter) StartRefreshing(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(redisConnectionLimiterRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				_ = c.client.Close()
+				return
+			case <-ticker.C:
+				c.refresh()
+			}
+		}
+	}()
+}
+
+func (c *RedisConnectionLimiter) AcquireNode() bool {
+	return c.local.AcquireNode()
+}
+
+func (c *RedisConnectionLimiter) ReleaseNode() {
+	c.local.ReleaseNode()
+}
+
+func (c *RedisConnectionLimiter) AcquireUser(userId int) bool {
+	if !c.available() {
+		return c.local.AcquireUser(userId)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisRateLimiterTimeout)
+	defer cancel()
+
+	result, err := acquireUserScript.Run(ctx, c.client, c.keys(userId),
+		c.local.userMaxConns(userId), c.nodeId, redisConnectionLimiterStaleAfter.Milliseconds(),
+		redisConnectionLimiterTTL.Milliseconds()).Int64Slice()
+	if err != nil {
+		c.markUnavailable(err)
+		return c.local.AcquireUser(userId)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if result[0] != 1 {
+		return false
+	}
+	c.redisConns[userId]++
+	c.userNodes[userId] = int(result[1])
+	return true
+}
+
+// ReleaseUser releases the connection in Redis if it was counted there, even while the local fallback is used.
+// A connection which could not be released is corrected by the next refresh of the node connections,
+// or dropped with the node count once the node is not seen for the stale period.
+func (c *RedisConnectionLimiter) ReleaseUser(userId int) {
+	c.mu.Lock()
+	inRedis := c.redisConns[userId] > 0
+	if inRedis {
+		c.redisConns[userId]--
+		if c.redisConns[userId] == 0 {
+			delete(c.redisConns, userId)
+			delete(c.userNodes, userId)
+		}
+	}
+	c.mu.Unlock()
+
+	if !inRedis {
+		c.local.ReleaseUser(userId)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisRateLimiterTimeout)
+	defer cancel()
+
+	if err := releaseUserScript.Run(ctx, c.client, c.keys(userId), c.nodeId).Err(); err != nil {
+		c.markUnavailable(err)
+	}
+}
+
+// UserNodes returns the number of proxy nodes carrying connections of the user, at least 1.
+func (c *RedisConnectionLimiter) UserNodes(userId int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return max(c.userNodes[userId], 1)
+}
+
+func (c *RedisConnectionLimiter) refresh() {
+	c.mu.Lock()
+	conns := make(map[int]int, len(c.redisConns))
+	for userId, count := range c.redisConns {
+		conns[userId] = count
+	}
+	c.mu.Unlock()
+
+	for userId, count := range conns {
+		ctx, cancel := context.WithTimeout(context.Background(), redisRateLimiterTimeout)
+		nodes, err := refreshUserScript.Run(ctx, c.client, c.keys(userId),
+			c.nodeId, count, redisConnectionLimiterTTL.Milliseconds()).Int()
+		cancel()
+		if err != nil {
+			c.markUnavailable(err)
+			return
+		}
+
+		c.mu.Lock()
+		if c.redisConns[userId] > 0 {
+			c.userNodes[userId] = nodes
+		}
+		c.mu.Unlock()
+	}
+}
+
+func (c *RedisConnectionLimiter) available() bool {
+	return time.Now().UnixNano() >= c.unavailableUntil.Load()
+}
+
+func (c *RedisConnectionLimiter) markUnavailable(err error) {
+	log.Printf("Redis connection limiter is unavailable, counting connections locally for %v: %v", redisRateLimiterRetryInterval, err)
+	c.unavailableUntil.Store(time.Now().Add(redisRateLimiterRetryInterval).UnixNano())
+}
+
+func (c *RedisConnectionLimiter) keys(userId int) []string {
+	return []string{
+		fmt.Sprintf("%s:%d:conns", redisConnectionLimiterKeyPrefix, userId),
+		fmt.Sprintf("%s:%d:seen", redisConnectionLimiterKeyPrefix, userId),
+	}
+}
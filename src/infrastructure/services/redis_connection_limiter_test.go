@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"goproxy/infrastructure/config"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func newTestRedisConnectionLimiter(t *testing.T, address string, rlConf config.RateLimiterConfig) *RedisConnectionLimiter {
+	client := redis.NewClient(&redis.Options{
+		Addr:        address,
+		DialTimeout: 50 * time.Millisecond,
+	})
+	limiter, err := newRedisConnectionLimiter(client, NewConnectionLimiter(rlConf))
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+
+	return limiter
+}
+
+func TestRedisConnectionLimiter_SharesUserLimit(t *testing.T) {
+	redisContainer, address, err := setupRedisContainer()
+	assert.NoError(t, err)
+	defer func(redisContainer testcontainers.Container, ctx context.Context) {
+		_ = redisContainer.Terminate(ctx)
+	}(redisContainer, context.Background())
+
+	rlConf := config.RateLimiterConfig{MaxUserConns: 2}
+
+	// two limiters share the state like two proxy nodes do
+	first := newTestRedisConnectionLimiter(t, address, rlConf)
+	second := newTestRedisConnectionLimiter(t, address, rlConf)
+
+	assert.True(t, first.AcquireUser(1))
+	assert.True(t, second.AcquireUser(1))
+	assert.False(t, first.AcquireUser(1))
+	assert.Equal(t, 2, second.UserNodes(1))
+
+	// the connection counted in Redis is released there even while the local limiter is used
+	second.unavailableUntil.Store(time.Now().Add(time.Minute).UnixNano())
+	second.ReleaseUser(1)
+	assert.Empty(t, second.redisConns)
+	assert.Empty(t, second.local.userConns)
+
+	assert.True(t, first.AcquireUser(1))
+	assert.Equal(t, 1, first.UserNodes(1))
+}
+
+func TestRedisConnectionLimiter_DropsConnectionsOfStoppedNodes(t *testing.T) {
+	redisContainer, address, err := setupRedisContainer()
+	assert.NoError(t, err)
+	defer func(redisContainer testcontainers.Container, ctx context.Context) {
+		_ = redisContainer.Terminate(ctx)
+	}(redisContainer, context.Background())
+
+	rlConf := config.RateLimiterConfig{MaxUserConns: 1}
+	stopped := newTestRedisConnectionLimiter(t, address, rlConf)
+	running := newTestRedisConnectionLimiter(t, address, rlConf)
+
+	assert.True(t, stopped.AcquireUser(1))
+	assert.False(t, running.AcquireUser(1))
+
+	// the stopped node is last seen longer than the stale period ago
+	client := redis.NewClient(&redis.Options{Addr: address})
+	defer func() { _ = client.Close() }()
+	assert.NoError(t, client.HSet(context.Background(), running.keys(1)[1], stopped.nodeId, 0).Err())
+
+	assert.True(t, running.AcquireUser(1))
+}
+
+func TestRedisConnectionLimiter_FallsBackToLocal(t *testing.T) {
+	// nothing listens on port 1, so every Redis call fails
+	limiter := newTestRedisConnectionLimiter(t, "127.0.0.1:1", config.RateLimiterConfig{MaxUserConns: 1})
+
+	assert.True(t, limiter.AcquireUser(1))
+	assert.False(t, limiter.available())
+
+	// the local limiter still enforces the limits and releases connections it counted
+	assert.False(t, limiter.AcquireUser(1))
+	limiter.ReleaseUser(1)
+	assert.Empty(t, limiter.local.userConns)
+	assert.Equal(t, 1, limiter.UserNodes(1))
+}
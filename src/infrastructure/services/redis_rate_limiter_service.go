@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"goproxy/application/contracts"
+	serviceconfigurations "goproxy/infrastructure/config"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	redisRateLimiterKeyPrefix = "rate_limiter"
+	// redisRateLimiterTimeout bounds a single Redis call, so a slow Redis does not stall proxied traffic
+	redisRateLimiterTimeout = 100 * time.Millisecond
+	// redisRateLimiterRetryInterval is how long the local fallback is used after Redis became unreachable
+	redisRateLimiterRetryInterval = 5 * time.Second
+	// redisRateLimiterBucketTTL matches the idle bucket cleanup of the local RateLimiter
+	redisRateLimiterBucketTTL = 5 * time.Minute
+)
+
+// allowScript checks block and connections limit of the target bucket, refills the speed bucket
+// and takes tokens in one atomic step. Both keys are the same unless the user plan limits speed.
+// Time is taken from Redis, so clocks of proxy nodes do not need to agree.
+// KEYS[1] - target bucket key, KEYS[2] - speed bucket key
+// ARGV - capacity, fill rate (bytes per second), tokens, max connections, block duration (ms), ttl (ms)
+var allowScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local fillRate = tonumber(ARGV[2])
+local tokens = tonumber(ARGV[3])
+local maxConns = tonumber(ARGV[4])
+local blockDur = tonumber(ARGV[5])
+local ttl = tonumber(ARGV[6])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call('HMGET', KEYS[1], 'connections', 'block_until')
+local connections = tonumber(bucket[1]) or 0
+local blockUntil = tonumber(bucket[2]) or 0
+
+if now < blockUntil then
+	return 0
+end
+
+local speed = redis.call('HMGET', KEYS[2], 'available', 'updated')
+local available = tonumber(speed[1]) or capacity
+local updated = tonumber(speed[2]) or now
+
+if now > updated then
+	available = math.min(capacity, available + (now - updated) * fillRate / 1000)
+	updated = now
+end
+available = math.min(capacity, available)
+
+local allowed = 0
+if connections >= maxConns or available < tokens then
+	blockUntil = now + blockDur
+else
+	available = available - tokens
+	connections = connections + 1
+	allowed = 1
+end
+
+redis.call('HSET', KEYS[2], 'available', available, 'updated', updated)
+redis.call('HSET', KEYS[1], 'connections', connections, 'block_until', blockUntil)
+redis.call('PEXPIRE', KEYS[1], ttl)
+redis.call('PEXPIRE', KEYS[2], ttl)
+return allowed
+`)
+
+// doneScript releases a connection slot taken by allowScript.
+// KEYS[1] - bucket key
+var doneScript = redis.NewScript(`
+local connections = tonumber(redis.call('HGET', KEYS[1], 'connections')) or 0
+if connections > 0 then
+	redis.call('HINCRBY', KEYS[1], 'connections', -1)
+end
+return 0
+`)
+
+// RedisRateLimiter shares token buckets and connection counters between proxy nodes through Redis.
+// If Redis is unreachable, the local RateLimiter is used until Redis becomes available again.
+// Connection slots are released by the store which took them.
+type RedisRateLimiter struct {
+	client   *redis.Client
+	local    *RateLimiter
+	blockDur time.Duration
+
+	mu sync.Mutex
+	// redisConns are connection slots taken in Redis by the node per bucket key
+	redisConns map[string]int
+
+	unavailableUntil atomic.Int64
+}
+
+func NewRedisRateLimiter(config serviceconfigurations.RateLimiterConfig) (*RedisRateLimiter, error) {
+	client, err := newRedisClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return newRedisRateLimiter(client, NewRateLimiter(config), config.BlockDur), nil
+}
+
+func newRedisRateLimiter(client *redis.Client, local *RateLimiter, blockDur time.Duration) *RedisRateLimiter {
+	return &RedisRateLimiter{
+		client:     client,
+		local:      local,
+		blockDur:   blockDur,
+		redisConns: make(map[string]int),
+	}
+}
+
+// WithPlanLimits makes the RateLimiter apply speed of users active plans instead of the configured defaults.
+func (r *RedisRateLimiter) WithPlanLimits(planLimits contracts.UserPlanLimitsService) *RedisRateLimiter {
+	r.local.WithPlanLimits(planLimits)
+	return r
+}
+
+func (r *RedisRateLimiter) Allow(userID int, target string, tokens int64) bool {
+	if !r.available() {
+		return r.local.Allow(userID, target, tokens)
+	}
+
+	key := r.key(userID, target)
+	speedKey, capacity, fillRate := key, r.local.shardsCapacity, r.local.shardsFillRate
+	if limits := r.local.userLimits(userID); limits.MaxBytesPerSecond > 0 {
+		speedKey = r.userKey(userID)
+		capacity, fillRate = r.local.planBucketLimits(limits)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisRateLimiterTimeout)
+	defer cancel()
+
+	allowed, err := allowScript.Run(ctx, r.client, []string{key, speedKey},
+		capacity, fillRate, tokens, r.local.maxConns, r.blockDur.Milliseconds(),
+		redisRateLimiterBucketTTL.Milliseconds()).Int()
+	if err != nil {
+		r.markUnavailable(err)
+		return r.local.Allow(userID, target, tokens)
+	}
+	if allowed != 1 {
+		return false
+	}
+
+	r.mu.Lock()
+	r.redisConns[key]++
+	r.mu.Unlock()
+	return true
+}
+
+// Done releases a slot in Redis if the slot was taken there, even while the local fallback is used,
+// so Redis counters do not leak. A slot which could not be released expires with its idle bucket.
+func (r *RedisRateLimiter) Done(userID int, target string) {
+	key := r.key(userID, target)
+
+	r.mu.Lock()
+	inRedis := r.redisConns[key] > 0
+	if inRedis {
+		r.redisConns[key]--
+		if r.redisConns[key] == 0 {
+			delete(r.redisConns, key)
+		}
+	}
+	r.mu.Unlock()
+
+	if !inRedis {
+		r.local.Done(userID, target)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisRateLimiterTimeout)
+	defer cancel()
+
+	if err := doneScript.Run(ctx, r.client, []string{key}).Err(); err != nil {
+		r.markUnavailable(err)
+	}
+}
+
+func (r *RedisRateLimiter) Stop() {
+	r.local.Stop()
+	_ = r.client.Close()
+}
+
+func (r *RedisRateLimiter) available() bool {
+	return time.Now().UnixNano() >= r.unavailableUntil.Load()
+}
+
+func (r *RedisRateLimiter) markUnavailable(err error) {
+	log.Printf("Redis rate limiter is unavailable, using local rate limiter for %v: %v", redisRateLimiterRetryInterval, err)
+	r.unavailableUntil.Store(time.Now().Add(redisRateLimiterRetryInterval).UnixNano())
+}
+
+func (r *RedisRateLimiter) key(userID int, target string) string {
+	return fmt.Sprintf("%s:%d|%s", redisRateLimiterKeyPrefix, userID, target)
+}
+
+// userKey is the key of the speed bucket shared by all targets of a user with plan speed.
+func (r *RedisRateLimiter) userKey(userID int) string {
+	return fmt.Sprintf("%s:%d", redisRateLimiterKeyPrefix, userID)
+}
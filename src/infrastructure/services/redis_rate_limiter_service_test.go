@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"goproxy/infrastructure/config"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func newTestRedisRateLimiter(t *testing.T, address string, rlConf config.RateLimiterConfig) *RedisRateLimiter {
+	client := redis.NewClient(&redis.Options{
+		Addr:        address,
+		DialTimeout: 50 * time.Millisecond,
+	})
+	rl := newRedisRateLimiter(client, NewRateLimiter(rlConf), rlConf.BlockDur)
+	t.Cleanup(rl.Stop)
+
+	return rl
+}
+
+func TestRedisRateLimiter_Allow(t *testing.T) {
+	redisContainer, address, err := setupRedisContainer()
+	assert.NoError(t, err)
+	defer func(redisContainer testcontainers.Container, ctx context.Context) {
+		_ = redisContainer.Terminate(ctx)
+	}(redisContainer, context.Background())
+
+	rlConf := config.RateLimiterConfig{
+		MaxConns:   10,
+		BlockDur:   50 * time.Millisecond,
+		CleanupInt: time.Minute,
+		Capacity:   100,
+		FillRate:   50,
+		ShardCount: 2,
+	}
+
+	// two limiters share the state like two proxy nodes do
+	first := newTestRedisRateLimiter(t, address, rlConf)
+	second := newTestRedisRateLimiter(t, address, rlConf)
+
+	assert.True(t, first.Allow(1, "test_target", rlConf.Capacity))
+	assert.False(t, second.Allow(1, "test_target", rlConf.Capacity))
+
+	time.Sleep(rlConf.BlockDur + 10*time.Millisecond)
+	assert.True(t, second.Allow(1, "test_target", 1))
+}
+
+func TestRedisRateLimiter_ConnectionsLimit(t *testing.T) {
+	redisContainer, address, err := setupRedisContainer()
+	assert.NoError(t, err)
+	defer func(redisContainer testcontainers.Container, ctx context.Context) {
+		_ = redisContainer.Terminate(ctx)
+	}(redisContainer, context.Background())
+
+	rlConf := config.RateLimiterConfig{
+		MaxConns:   1,
+		BlockDur:   time.Millisecond,
+		CleanupInt: time.Minute,
+		Capacity:   100,
+		FillRate:   100,
+		ShardCount: 2,
+	}
+	rl := newTestRedisRateLimiter(t, address, rlConf)
+
+	assert.True(t, rl.Allow(1, "test_target", 1))
+	assert.False(t, rl.Allow(1, "test_target", 1))
+
+	rl.Done(1, "test_target")
+	time.Sleep(2 * rlConf.BlockDur)
+	assert.True(t, rl.Allow(1, "test_target", 1))
+}
+
+func TestRedisRateLimiter_FallsBackToLocal(t *testing.T) {
+	rlConf := config.RateLimiterConfig{
+		MaxConns:   10,
+		BlockDur:   time.Minute,
+		CleanupInt: time.Minute,
+		Capacity:   100,
+		FillRate:   50,
+		ShardCount: 2,
+	}
+
+	// nothing listens on port 1, so every Redis call fails
+	rl := newTestRedisRateLimiter(t, "127.0.0.1:1", rlConf)
+
+	assert.True(t, rl.Allow(1, "test_target", rlConf.Capacity))
+	assert.False(t, rl.available())
+
+	// the local rate limiter still enforces the limits
+	assert.False(t, rl.Allow(1, "test_target", rlConf.Capacity))
+	rl.Done(1, "test_target")
+}
+
+func TestRedisRateLimiter_DoneReleasesWhereAcquired(t *testing.T) {
+	redisContainer, address, err := setupRedisContainer()
+	assert.NoError(t, err)
+	defer func(redisContainer testcontainers.Container, ctx context.Context) {
+		_ = redisContainer.Terminate(ctx)
+	}(redisContainer, context.Background())
+
+	rlConf := config.RateLimiterConfig{
+		MaxConns:   1,
+		BlockDur:   time.Millisecond,
+		CleanupInt: time.Minute,
+		Capacity:   100,
+		FillRate:   100,
+		ShardCount: 2,
+	}
+	rl := newTestRedisRateLimiter(t, address, rlConf)
+
+	assert.True(t, rl.Allow(1, "test_target", 1))
+
+	// the slot taken in Redis is released there even while the local rate limiter is used
+	rl.unavailableUntil.Store(time.Now().Add(time.Minute).UnixNano())
+	rl.Done(1, "test_target")
+	assert.Empty(t, rl.redisConns)
+
+	rl.unavailableUntil.Store(0)
+	time.Sleep(2 * rlConf.BlockDur)
+	assert.True(t, rl.Allow(1, "test_target", 1))
+}
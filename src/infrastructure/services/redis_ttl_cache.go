@@ -17,6 +17,25 @@ type RedisCache[T any] struct {
 }
 
 func NewRedisCache[T any]() (contracts.CacheWithTTL[T], error) {
+	cacheClient, err := newRedisClient()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	_, err = cacheClient.Ping(ctx).Result()
+	if err != nil {
+		log.Fatalf("Could not connect to Redis: %v", err)
+	}
+	log.Println("Connected to Redis successfully")
+
+	return &RedisCache[T]{
+		client: cacheClient,
+	}, nil
+}
+
+// newRedisClient creates a client from TC_CACHE_* env variables. The connection is not checked.
+func newRedisClient() (*redis.Client, error) {
 	host := os.Getenv("TC_CACHE_HOST")
 	if host == "" {
 		return nil, errors.New("env variable TC_CACHE_HOST is not set")
@@ -34,23 +53,12 @@ func NewRedisCache[T any]() (contracts.CacheWithTTL[T], error) {
 
 	password := os.Getenv("TC_CACHE_PASSWORD")
 
-	cacheClient := redis.NewClient(&redis.Options{
+	return redis.NewClient(&redis.Options{
 		Addr:     fmt.Sprintf("%s:%s", host, port),
 		Username: user,
 		Password: password,
 		DB:       0,
-	})
-
-	ctx := context.Background()
-	_, err := cacheClient.Ping(ctx).Result()
-	if err != nil {
-		log.Fatalf("Could not connect to Redis: %v", err)
-	}
-	log.Println("Connected to Redis successfully")
-
-	return &RedisCache[T]{
-		client: cacheClient,
-	}, nil
+	}), nil
 }
 
 func (r *RedisCache[T]) Get(key string) (T, error) {
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"fmt"
+	"goproxy/domain/valueobjects"
+	"goproxy/infrastructure/socks4"
+	"log"
+	"net"
+	"strings"
+)
+
+// ReadSocks4Request reads a SOCKS4/SOCKS4a CONNECT request.
+// SOCKS4 has no password field, so credentials are expected in the USERID field as "username:password".
+func (p *Proxy) ReadSocks4Request(clientConn net.Conn) (*valueobjects.BasicCredentials, string, error) {
+	request, err := socks4.ReadRequest(clientConn)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if request.Command != socks4.CmdConnect {
+		_ = p.WriteSocks4Rejected(clientConn)
+		return nil, "", fmt.Errorf("unsupported socks4 command: %d", request.Command)
+	}
+
+	// clients without credentials may be authorized by their IP
+	if request.UserId == "" {
+		return nil, request.Address, nil
+	}
+
+	username, password, ok := strings.Cut(request.UserId, ":")
+	if !ok {
+		_ = p.WriteSocks4Rejected(clientConn)
+		return nil, "", fmt.Errorf("invalid format, missing ':'")
+	}
+
+	return &valueobjects.BasicCredentials{
+		Username: username,
+		Password: password,
+	}, request.Address, nil
+}
+
+func (p *Proxy) WriteSocks4Rejected(clientConn net.Conn) error {
+	return socks4.WriteReply(clientConn, socks4.ReplyRejected, nil)
+}
+
+func (p *Proxy) HandleSocks4(clientConn net.Conn, host string, userId, credentialId int, options valueobjects.ConnectionOptions) {
+	if p.intercepts(userId, host) {
+		p.interceptSocks4(clientConn, host, userId, credentialId, options)
+		return
+	}
+
+	serverConn, err := p.dial(userId, options, TunnelDestination, host)
+	if err != nil {
+		log.Println("Could not connect:", err)
+		_ = p.WriteSocks4Rejected(clientConn)
+		return
+	}
+	defer func(serverConn net.Conn) {
+		_ = serverConn.Close()
+	}(serverConn)
+
+	localAddr, _ := serverConn.LocalAddr().(*net.TCPAddr)
+	if err = socks4.WriteReply(clientConn, socks4.ReplyGranted, localAddr); err != nil {
+		return
+	}
+
+	p.tunnel(clientConn, serverConn, userId, credentialId, options, host)
+}
+
+// interceptSocks4 intercepts the SOCKS4 tunnel to host the way HTTP CONNECT tunnels are intercepted.
+func (p *Proxy) interceptSocks4(clientConn net.Conn, host string, userId, credentialId int, options valueobjects.ConnectionOptions) {
+	certificate, err := p.interceptionCertificate(userId, host)
+	if err != nil {
+		log.Println("Could not intercept tunnel:", err)
+		_ = p.WriteSocks4Rejected(clientConn)
+		return
+	}
+
+	if err = socks4.WriteReply(clientConn, socks4.ReplyGranted, nil); err != nil {
+		return
+	}
+
+	p.interceptTunnel(clientConn, certificate, userId, credentialId, options, host, nil)
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"encoding/binary"
+	"goproxy/domain/valueobjects"
+	"goproxy/infrastructure/socks4"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxy_HandleSocks4a_Connect(t *testing.T) {
+	proxy := newTestProxy(t)
+	echoServer := startTcpEchoServer(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	go func() {
+		conn, acceptErr := listener.Accept()
+		if acceptErr != nil {
+			return
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+
+		credentials, host, requestErr := proxy.ReadSocks4Request(conn)
+		if requestErr != nil || credentials.Username != "alice" || credentials.Password != "secret" {
+			_ = proxy.WriteSocks4Rejected(conn)
+			return
+		}
+		proxy.HandleSocks4(conn, host, 1, 0, valueobjects.ConnectionOptions{})
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	request := []byte{socks4.Version, socks4.CmdConnect, 0, 0, 0, 0, 0, 1}
+	binary.BigEndian.PutUint16(request[2:4], uint16(echoServer.Addr().(*net.TCPAddr).Port))
+	request = append(request, "alice:secret"...)
+	request = append(request, 0)
+	request = append(request, "localhost"...)
+	request = append(request, 0)
+	_, err = conn.Write(request)
+	assert.NoError(t, err)
+
+	reply := make([]byte, 8)
+	_, err = io.ReadFull(conn, reply)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(socks4.ReplyGranted), reply[1])
+
+	_, err = conn.Write([]byte("ping"))
+	assert.NoError(t, err)
+
+	response := make([]byte, 4)
+	_, err = io.ReadFull(conn, response)
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", string(response))
+}
+
+func TestProxy_ReadSocks4Request_WithoutUserId(t *testing.T) {
+	proxy := newTestProxy(t)
+	clientConn, serverConn := net.Pipe()
+	defer func() {
+		_ = clientConn.Close()
+		_ = serverConn.Close()
+	}()
+
+	go func() {
+		request := []byte{socks4.Version, socks4.CmdConnect, 0, 80, 0, 0, 0, 1, 0}
+		request = append(request, "example.com"...)
+		_, _ = clientConn.Write(append(request, 0))
+	}()
+
+	credentials, host, err := proxy.ReadSocks4Request(serverConn)
+	assert.NoError(t, err)
+	assert.Nil(t, credentials)
+	assert.Equal(t, "example.com:80", host)
+}
@@ -0,0 +1,435 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goproxy/domain/valueobjects"
+	"goproxy/infrastructure/socks5"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	socks5UdpBufferSize        = 64 * 1024
+	socks5UdpRateLimiterTarget = "socks5-udp"
+	socks5UdpResolveTimeout    = 5 * time.Second
+)
+
+func (p *Proxy) ReadSocks5Credentials(clientConn net.Conn, allowNoAuth bool) (*valueobjects.BasicCredentials, error) {
+	methods, err := socks5.ReadGreeting(clientConn)
+	if err != nil {
+		return nil, err
+	}
+
+	supported, noAuthOffered := false, false
+	for _, method := range methods {
+		switch method {
+		case socks5.MethodUsernamePassword:
+			supported = true
+		case socks5.MethodNoAuth:
+			noAuthOffered = true
+		}
+	}
+
+	// credentials are preferred when the client has them
+	if !supported && noAuthOffered && allowNoAuth {
+		return nil, socks5.WriteMethodSelection(clientConn, socks5.MethodNoAuth)
+	}
+
+	if !supported {
+		_ = socks5.WriteMethodSelection(clientConn, socks5.MethodNoAcceptable)
+		return nil, fmt.Errorf("client does not support username/password authentication")
+	}
+
+	if err = socks5.WriteMethodSelection(clientConn, socks5.MethodUsernamePassword); err != nil {
+		return nil, err
+	}
+
+	username, password, err := socks5.ReadUsernamePassword(clientConn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &valueobjects.BasicCredentials{
+		Username: username,
+		Password: password,
+	}, nil
+}
+
+func (p *Proxy) WriteSocks5AuthStatus(clientConn net.Conn, authorized bool) error {
+	return socks5.WriteAuthStatus(clientConn, authorized)
+}
+
+func (p *Proxy) WriteSocks5NoAcceptableMethods(clientConn net.Conn) error {
+	return socks5.WriteMethodSelection(clientConn, socks5.MethodNoAcceptable)
+}
+
+func (p *Proxy) RejectSocks5Request(clientConn net.Conn) error {
+	if _, err := socks5.ReadRequest(clientConn); err != nil {
+		return err
+	}
+
+	return socks5.WriteReply(clientConn, socks5.ReplyNotAllowed, nil)
+}
+
+func (p *Proxy) HandleSocks5(clientConn net.Conn, userId, credentialId int, options valueobjects.ConnectionOptions) {
+	request, err := socks5.ReadRequest(clientConn)
+	if err != nil {
+		if errors.Is(err, socks5.ErrAddressTypeUnsupported) {
+			_ = socks5.WriteReply(clientConn, socks5.ReplyAddressTypeNotSupported, nil)
+		}
+		log.Printf("failed to read socks5 request: %v", err)
+		return
+	}
+
+	switch request.Command {
+	case socks5.CmdConnect:
+		p.handleSocks5Connect(clientConn, request.Address, userId, credentialId, options)
+	case socks5.CmdUdpAssociate:
+		p.handleSocks5UdpAssociate(clientConn, userId, credentialId, options)
+	default:
+		_ = socks5.WriteReply(clientConn, socks5.ReplyCommandNotSupported, nil)
+	}
+}
+
+func (p *Proxy) handleSocks5Connect(clientConn net.Conn, host string, userId, credentialId int, options valueobjects.ConnectionOptions) {
+	if p.intercepts(userId, host) {
+		p.interceptSocks5(clientConn, host, userId, credentialId, options)
+		return
+	}
+
+	serverConn, err := p.dial(userId, options, TunnelDestination, host)
+	if err != nil {
+		log.Println("Could not connect:", err)
+		_ = socks5.WriteReply(clientConn, socks5DialErrorToReply(err), nil)
+		return
+	}
+	defer func(serverConn net.Conn) {
+		_ = serverConn.Close()
+	}(serverConn)
+
+	if err = socks5.WriteReply(clientConn, socks5.ReplySucceeded, serverConn.LocalAddr()); err != nil {
+		return
+	}
+
+	p.tunnel(clientConn, serverConn, userId, credentialId, options, host)
+}
+
+// handleSocks5UdpAssociate relays UDP datagrams for the client while the control connection is open.
+// interceptSocks5 intercepts the SOCKS5 tunnel to host the way HTTP CONNECT tunnels are intercepted.
+func (p *Proxy) interceptSocks5(clientConn net.Conn, host string, userId, credentialId int, options valueobjects.ConnectionOptions) {
+	certificate, err := p.interceptionCertificate(userId, host)
+	if err != nil {
+		log.Println("Could not intercept tunnel:", err)
+		_ = socks5.WriteReply(clientConn, socks5DialErrorToReply(err), nil)
+		return
+	}
+
+	if err = socks5.WriteReply(clientConn, socks5.ReplySucceeded, nil); err != nil {
+		return
+	}
+
+	p.interceptTunnel(clientConn, certificate, userId, credentialId, options, host, nil)
+}
+
+func (p *Proxy) handleSocks5UdpAssociate(clientConn net.Conn, userId, credentialId int, options valueobjects.ConnectionOptions) {
+	controlAddr, ok := clientConn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		_ = socks5.WriteReply(clientConn, socks5.ReplyGeneralFailure, nil)
+		return
+	}
+
+	relayConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: controlAddr.IP})
+	if err != nil {
+		log.Printf("failed to open socks5 udp relay: %v", err)
+		_ = socks5.WriteReply(clientConn, socks5.ReplyGeneralFailure, nil)
+		return
+	}
+	defer func(relayConn *net.UDPConn) {
+		_ = relayConn.Close()
+	}(relayConn)
+
+	egressConn, err := p.listenUdpEgress(userId, options)
+	if err != nil {
+		log.Printf("failed to open socks5 udp egress: %v", err)
+		_ = socks5.WriteReply(clientConn, socks5.ReplyGeneralFailure, nil)
+		return
+	}
+	defer func(egressConn *net.UDPConn) {
+		_ = egressConn.Close()
+	}(egressConn)
+
+	if !p.admitShaped(userId, socks5UdpRateLimiterTarget) {
+		_ = socks5.WriteReply(clientConn, socks5.ReplyNotAllowed, nil)
+		return
+	}
+	if err = socks5.WriteReply(clientConn, socks5.ReplySucceeded, relayConn.LocalAddr()); err != nil {
+		p.releaseShaped(userId, socks5UdpRateLimiterTarget)
+		return
+	}
+
+	clientAddr, ok := clientConn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return
+	}
+	association := &socks5UdpAssociation{
+		clientIP: clientAddr.IP,
+		targets:  make(map[netip.AddrPort]struct{}),
+	}
+
+	shapedConn := p.openShapedConnection(userId, options.SpeedLimit())
+	defer shapedConn.Close()
+
+	// the association ends when no datagrams are relayed either way for the idle timeout
+	_ = clientConn.SetDeadline(time.Time{})
+	watchdog := newActivityWatchdog(p.timeouts.For(userId), expireConns(clientConn, relayConn, egressConn))
+	defer watchdog.stop()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// client → target
+	go func() {
+		defer wg.Done()
+		defer func() {
+			_ = clientConn.Close()
+		}()
+		p.relaySocks5ClientDatagrams(relayConn, egressConn, association, shapedConn, watchdog, userId, credentialId)
+	}()
+	// target → client
+	go func() {
+		defer wg.Done()
+		defer func() {
+			_ = clientConn.Close()
+		}()
+		p.relaySocks5TargetDatagrams(relayConn, egressConn, association, shapedConn, watchdog, userId, credentialId)
+	}()
+
+	// the association terminates when the control connection is closed
+	_, _ = io.Copy(io.Discard, clientConn)
+	_ = relayConn.Close()
+	_ = egressConn.Close()
+
+	wg.Wait()
+	go p.trafficReporter.FlushBuckets()
+}
+
+func (p *Proxy) relaySocks5ClientDatagrams(relayConn, egressConn *net.UDPConn, association *socks5UdpAssociation, shapedConn *ShapedConnection, watchdog *activityWatchdog, userId, credentialId int) {
+	defer p.rateLimiter.Done(userId, socks5UdpRateLimiterTarget)
+
+	buf := make([]byte, socks5UdpBufferSize)
+	var accumulatedBytes int64
+
+	for {
+		n, clientAddr, err := relayConn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		// datagrams from any host other than the one that requested the association are dropped
+		if !association.accept(clientAddr) {
+			continue
+		}
+
+		target, payload, parseErr := socks5.ParseDatagram(buf[:n])
+		if parseErr != nil {
+			continue
+		}
+
+		if p.destinationPolicy.Check(userId, DatagramDestination, target) != nil {
+			continue
+		}
+
+		targetAddr, resolveErr := p.resolveDatagramTarget(egressConn, target)
+		if resolveErr != nil || p.destinationPolicy.CheckIP(targetAddr.IP) != nil {
+			continue
+		}
+
+		if waitErr := shapedConn.WaitN(context.Background(), "in", len(payload)); waitErr != nil {
+			return
+		}
+
+		// remembered before sending, so that an immediate reply is not dropped
+		association.addTarget(targetAddr)
+		written, writeErr := egressConn.WriteToUDP(payload, targetAddr)
+		if writeErr != nil {
+			continue
+		}
+		watchdog.touch()
+
+		accumulatedBytes += int64(written)
+		if accumulatedBytes >= rateLimitAccountingThreshold {
+			if p.bandwidthShaper == nil && !p.rateLimiter.Allow(userId, socks5UdpRateLimiterTarget, accumulatedBytes) {
+				return
+			}
+			accumulatedBytes = 0
+		}
+		p.trafficReporter.AddInBytes(userId, credentialId, int64(written))
+	}
+}
+
+func (p *Proxy) relaySocks5TargetDatagrams(relayConn, egressConn *net.UDPConn, association *socks5UdpAssociation, shapedConn *ShapedConnection, watchdog *activityWatchdog, userId, credentialId int) {
+	buf := make([]byte, socks5UdpBufferSize)
+
+	for {
+		n, sourceAddr, err := egressConn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		clientAddr := association.client()
+		if clientAddr == nil {
+			continue
+		}
+
+		// the socket is not connected, so datagrams of hosts the client did not send to are dropped here
+		if !association.sentTo(sourceAddr) {
+			continue
+		}
+
+		if waitErr := shapedConn.WaitN(context.Background(), "out", n); waitErr != nil {
+			return
+		}
+
+		datagram, buildErr := socks5.BuildDatagram(sourceAddr, buf[:n])
+		if buildErr != nil {
+			continue
+		}
+
+		if _, writeErr := relayConn.WriteToUDP(datagram, clientAddr); writeErr != nil {
+			continue
+		}
+		watchdog.touch()
+
+		p.trafficReporter.AddOutBytes(userId, credentialId, int64(n))
+	}
+}
+
+// resolveDatagramTarget resolves the target to an address of the egress socket family.
+func (p *Proxy) resolveDatagramTarget(egressConn *net.UDPConn, target string) (*net.UDPAddr, error) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+
+	lookupNetwork := "ip"
+	if localAddr, ok := egressConn.LocalAddr().(*net.UDPAddr); ok && !localAddr.IP.IsUnspecified() {
+		lookupNetwork = "ip6"
+		if localAddr.IP.To4() != nil {
+			lookupNetwork = "ip4"
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), socks5UdpResolveTimeout)
+	defer cancel()
+
+	ips, err := p.dnsResolver.LookupIP(ctx, lookupNetwork, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	return &net.UDPAddr{IP: ips[0], Port: port}, nil
+}
+
+// listenUdpEgress opens a UDP socket on the egress IP assigned to the user.
+// Datagrams are not chained through parent proxies, so they cannot leave from a requested country.
+func (p *Proxy) listenUdpEgress(userId int, options valueobjects.ConnectionOptions) (*net.UDPConn, error) {
+	if options.Country() != "" {
+		return nil, CountryUnavailableError{Country: options.Country()}
+	}
+
+	egressAddr := &net.UDPAddr{}
+
+	dialer, dialerErr := p.dialerService.GetDialer("udp", userId, options.Egress())
+	if dialerErr == nil {
+		egressAddr.IP = dialer.LocalIP()
+	}
+
+	return net.ListenUDP("udp", egressAddr)
+}
+
+func socks5DialErrorToReply(err error) byte {
+	var deniedErr DestinationDeniedError
+	if errors.As(err, &deniedErr) {
+		return socks5.ReplyNotAllowed
+	}
+
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return socks5.ReplyConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return socks5.ReplyNetworkUnreachable
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return socks5.ReplyHostUnreachable
+	}
+
+	return socks5.ReplyGeneralFailure
+}
+
+// socks5UdpAssociation remembers the client UDP endpoint, which is learnt from the first datagram,
+// and the targets the client sent datagrams to, which are the only hosts allowed to reply.
+type socks5UdpAssociation struct {
+	mu       sync.RWMutex
+	clientIP net.IP
+	addr     *net.UDPAddr
+	targets  map[netip.AddrPort]struct{}
+}
+
+func (a *socks5UdpAssociation) accept(addr *net.UDPAddr) bool {
+	if !addr.IP.Equal(a.clientIP) {
+		return false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.addr == nil {
+		a.addr = addr
+		return true
+	}
+
+	return a.addr.Port == addr.Port
+}
+
+func (a *socks5UdpAssociation) client() *net.UDPAddr {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.addr
+}
+
+func (a *socks5UdpAssociation) addTarget(addr *net.UDPAddr) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.targets[udpAddrKey(addr)] = struct{}{}
+}
+
+func (a *socks5UdpAssociation) sentTo(addr *net.UDPAddr) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	_, ok := a.targets[udpAddrKey(addr)]
+	return ok
+}
+
+// udpAddrKey identifies the address the same way whether its IPv4 address is in the IPv4-mapped IPv6 form or not.
+func udpAddrKey(addr *net.UDPAddr) netip.AddrPort {
+	addrPort := addr.AddrPort()
+	return netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port())
+}
@@ -0,0 +1,399 @@
+package services
+
+import (
+	"goproxy/domain/events"
+	"goproxy/domain/valueobjects"
+	"goproxy/infrastructure/config"
+	"goproxy/infrastructure/socks5"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestProxy(t testing.TB) *Proxy {
+	rateLimiter := NewRateLimiter(config.RateLimiterConfig{
+		MaxConns:   10,
+		BlockDur:   time.Second,
+		CleanupInt: time.Minute,
+		Capacity:   100 * 1024 * 1024,
+		FillRate:   100 * 1024 * 1024,
+		ShardCount: 2,
+	})
+	t.Cleanup(rateLimiter.Stop)
+
+	return &Proxy{
+		rateLimiter:   rateLimiter,
+		dialerService: NewDialerPool(NewIPResolver()),
+		trafficReporter: &TrafficReporter{
+			buckets:    make(map[trafficAccount]*TrafficBucket),
+			eventQueue: make(chan events.UserConsumedTrafficEvent, 100),
+		},
+		// test targets listen on loopback
+		destinationPolicy: NewDestinationPolicy(config.DestinationPolicyConfig{}),
+		dnsResolver:       net.DefaultResolver,
+		httpTransports: newHttpTransportPool(config.HttpForwardConfig{
+			MaxIdleConnsPerHost:   8,
+			IdleConnTimeout:       time.Minute,
+			ResponseHeaderTimeout: 10 * time.Second,
+		}, testProxyTimeouts),
+		timeouts: NewTimeoutPolicy(config.ProxyTimeoutsConfig{Default: testProxyTimeouts}),
+	}
+}
+
+var testProxyTimeouts = config.ProxyTimeouts{
+	Idle:      30 * time.Second,
+	Dial:      10 * time.Second,
+	Handshake: 10 * time.Second,
+}
+
+func startTcpEchoServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, acceptErr := listener.Accept()
+			if acceptErr != nil {
+				return
+			}
+			go func() {
+				defer func() {
+					_ = conn.Close()
+				}()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return listener
+}
+
+// serveSocks5 accepts a single client connection and handles it as an already authorized user.
+func serveSocks5(t *testing.T, proxy *Proxy, userId int) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+
+	go func() {
+		conn, acceptErr := listener.Accept()
+		if acceptErr != nil {
+			return
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+
+		credentials, credentialsErr := proxy.ReadSocks5Credentials(conn, false)
+		if credentialsErr != nil || credentials.Username != "alice" || credentials.Password != "secret" {
+			_ = proxy.WriteSocks5AuthStatus(conn, false)
+			return
+		}
+		_ = proxy.WriteSocks5AuthStatus(conn, true)
+		proxy.HandleSocks5(conn, userId, 0, valueobjects.ConnectionOptions{})
+	}()
+
+	return listener
+}
+
+func socks5ClientHandshake(t *testing.T, conn net.Conn) {
+	_, err := conn.Write([]byte{socks5.Version, 1, socks5.MethodUsernamePassword})
+	assert.NoError(t, err)
+
+	selection := make([]byte, 2)
+	_, err = io.ReadFull(conn, selection)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{socks5.Version, socks5.MethodUsernamePassword}, selection)
+
+	auth := []byte{socks5.AuthVersion, 5}
+	auth = append(auth, "alice"...)
+	auth = append(auth, 6)
+	auth = append(auth, "secret"...)
+	_, err = conn.Write(auth)
+	assert.NoError(t, err)
+
+	status := make([]byte, 2)
+	_, err = io.ReadFull(conn, status)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{socks5.AuthVersion, socks5.AuthSuccess}, status)
+}
+
+func writeSocks5Request(t *testing.T, conn net.Conn, command byte, addr *net.TCPAddr) string {
+	request, err := socks5.AppendAddress([]byte{socks5.Version, command, 0}, addr)
+	assert.NoError(t, err)
+	_, err = conn.Write(request)
+	assert.NoError(t, err)
+
+	reply := make([]byte, 3)
+	_, err = io.ReadFull(conn, reply)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(socks5.ReplySucceeded), reply[1])
+
+	boundAddr, err := socks5.ReadAddress(conn)
+	assert.NoError(t, err)
+	return boundAddr
+}
+
+func TestProxy_ReadSocks5Credentials_MethodSelection(t *testing.T) {
+	tests := []struct {
+		name         string
+		methods      []byte
+		allowNoAuth  bool
+		wantSelected byte
+	}{
+		{name: "no authentication allowed", methods: []byte{socks5.MethodNoAuth}, allowNoAuth: true, wantSelected: socks5.MethodNoAuth},
+		{name: "no authentication not allowed", methods: []byte{socks5.MethodNoAuth}, wantSelected: socks5.MethodNoAcceptable},
+		{
+			name:         "credentials preferred",
+			methods:      []byte{socks5.MethodNoAuth, socks5.MethodUsernamePassword},
+			allowNoAuth:  true,
+			wantSelected: socks5.MethodUsernamePassword,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := newTestProxy(t)
+			clientConn, serverConn := net.Pipe()
+			defer func() {
+				_ = clientConn.Close()
+				_ = serverConn.Close()
+			}()
+
+			type result struct {
+				credentials *valueobjects.BasicCredentials
+				err         error
+			}
+			results := make(chan result, 1)
+			go func() {
+				credentials, err := proxy.ReadSocks5Credentials(serverConn, tt.allowNoAuth)
+				results <- result{credentials, err}
+			}()
+
+			_, err := clientConn.Write(append([]byte{socks5.Version, byte(len(tt.methods))}, tt.methods...))
+			assert.NoError(t, err)
+			selection := make([]byte, 2)
+			_, err = io.ReadFull(clientConn, selection)
+			assert.NoError(t, err)
+			assert.Equal(t, []byte{socks5.Version, tt.wantSelected}, selection)
+
+			switch tt.wantSelected {
+			case socks5.MethodNoAuth:
+				r := <-results
+				assert.NoError(t, r.err)
+				assert.Nil(t, r.credentials)
+			case socks5.MethodNoAcceptable:
+				assert.Error(t, (<-results).err)
+			default:
+				_ = clientConn.Close()
+				assert.Error(t, (<-results).err)
+			}
+		})
+	}
+}
+
+func TestProxy_HandleSocks5_Connect(t *testing.T) {
+	proxy := newTestProxy(t)
+	echoServer := startTcpEchoServer(t)
+	socksServer := serveSocks5(t, proxy, 1)
+
+	conn, err := net.Dial("tcp", socksServer.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	socks5ClientHandshake(t, conn)
+	writeSocks5Request(t, conn, socks5.CmdConnect, echoServer.Addr().(*net.TCPAddr))
+
+	_, err = conn.Write([]byte("ping"))
+	assert.NoError(t, err)
+
+	response := make([]byte, 4)
+	_, err = io.ReadFull(conn, response)
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", string(response))
+}
+
+func TestProxy_HandleSocks5_RejectsNoAuthClients(t *testing.T) {
+	proxy := newTestProxy(t)
+	socksServer := serveSocks5(t, proxy, 1)
+
+	conn, err := net.Dial("tcp", socksServer.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	_, err = conn.Write([]byte{socks5.Version, 1, socks5.MethodNoAuth})
+	assert.NoError(t, err)
+
+	selection := make([]byte, 2)
+	_, err = io.ReadFull(conn, selection)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{socks5.Version, socks5.MethodNoAcceptable}, selection)
+}
+
+func TestProxy_HandleSocks5_UdpAssociate(t *testing.T) {
+	proxy := newTestProxy(t)
+	socksServer := serveSocks5(t, proxy, 1)
+
+	udpEchoServer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = udpEchoServer.Close()
+	}()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, readErr := udpEchoServer.ReadFromUDP(buf)
+			if readErr != nil {
+				return
+			}
+			_, _ = udpEchoServer.WriteToUDP(buf[:n], addr)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", socksServer.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	socks5ClientHandshake(t, conn)
+	relayAddr := writeSocks5Request(t, conn, socks5.CmdUdpAssociate, nil)
+
+	clientUdp, err := net.Dial("udp", relayAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = clientUdp.Close()
+	}()
+
+	datagram, err := socks5.BuildDatagram(udpEchoServer.LocalAddr().(*net.UDPAddr), []byte("ping"))
+	assert.NoError(t, err)
+	_, err = clientUdp.Write(datagram)
+	assert.NoError(t, err)
+
+	_ = clientUdp.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1024)
+	n, err := clientUdp.Read(buf)
+	assert.NoError(t, err)
+
+	source, payload, err := socks5.ParseDatagram(buf[:n])
+	assert.NoError(t, err)
+	assert.Equal(t, udpEchoServer.LocalAddr().String(), source)
+	assert.Equal(t, "ping", string(payload))
+}
+
+func TestProxy_HandleSocks5_UdpAssociateDropsDatagramsOfOtherHosts(t *testing.T) {
+	proxy := newTestProxy(t)
+	socksServer := serveSocks5(t, proxy, 1)
+
+	udpEchoServer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer func() {
+		_ = udpEchoServer.Close()
+	}()
+	egressAddrs := make(chan *net.UDPAddr, 2)
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, readErr := udpEchoServer.ReadFromUDP(buf)
+			if readErr != nil {
+				return
+			}
+			egressAddrs <- addr
+			_, _ = udpEchoServer.WriteToUDP(buf[:n], addr)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", socksServer.Addr().String())
+	require.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	socks5ClientHandshake(t, conn)
+	relayAddr := writeSocks5Request(t, conn, socks5.CmdUdpAssociate, nil)
+
+	clientUdp, err := net.Dial("udp", relayAddr)
+	require.NoError(t, err)
+	defer func() {
+		_ = clientUdp.Close()
+	}()
+
+	exchange := func(payload string) string {
+		datagram, buildErr := socks5.BuildDatagram(udpEchoServer.LocalAddr().(*net.UDPAddr), []byte(payload))
+		require.NoError(t, buildErr)
+		_, writeErr := clientUdp.Write(datagram)
+		require.NoError(t, writeErr)
+
+		_ = clientUdp.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 1024)
+		n, readErr := clientUdp.Read(buf)
+		require.NoError(t, readErr)
+		_, reply, parseErr := socks5.ParseDatagram(buf[:n])
+		require.NoError(t, parseErr)
+		return string(reply)
+	}
+
+	assert.Equal(t, "first", exchange("first"))
+
+	// a host the client never sent to learns the egress port and tries to inject a datagram
+	injector, err := net.DialUDP("udp", nil, <-egressAddrs)
+	require.NoError(t, err)
+	defer func() {
+		_ = injector.Close()
+	}()
+	_, err = injector.Write([]byte("injected"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "second", exchange("second"))
+}
+
+func TestProxy_RejectSocks5Request(t *testing.T) {
+	proxy := newTestProxy(t)
+	serverConn, clientConn := net.Pipe()
+	defer func() {
+		_ = clientConn.Close()
+	}()
+
+	go func() {
+		defer func() {
+			_ = serverConn.Close()
+		}()
+		_ = proxy.RejectSocks5Request(serverConn)
+	}()
+
+	request, err := socks5.AppendAddress([]byte{socks5.Version, socks5.CmdConnect, 0}, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80})
+	assert.NoError(t, err)
+	_, err = clientConn.Write(request)
+	assert.NoError(t, err)
+
+	reply := make([]byte, 3)
+	_, err = io.ReadFull(clientConn, reply)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(socks5.ReplyNotAllowed), reply[1])
+}
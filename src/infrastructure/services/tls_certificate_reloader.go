@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// TlsCertificateReloader serves a certificate loaded from files and reloads it when the files change,
+// so renewed certificates are picked up without restarting the proxy.
+type TlsCertificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+func NewTlsCertificateReloader(certFile, keyFile string) (*TlsCertificateReloader, error) {
+	reloader := &TlsCertificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if _, err := reloader.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+// Watch checks certificate files for changes with the given interval until ctx is done.
+func (r *TlsCertificateReloader) Watch(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				reloaded, err := r.reloadIfChanged()
+				if err != nil {
+					log.Printf("failed to reload TLS certificate, keeping the previous one: %v", err)
+					continue
+				}
+				if reloaded {
+					log.Printf("TLS certificate reloaded from %s", r.certFile)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *TlsCertificateReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.certificate, nil
+}
+
+// TlsConfig returns a server TLS config which always uses the latest loaded certificate.
+func (r *TlsCertificateReloader) TlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+}
+
+func (r *TlsCertificateReloader) reloadIfChanged() (bool, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat certificate file: %v", err)
+	}
+
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to stat key file: %v", err)
+	}
+
+	r.mu.RLock()
+	unchanged := r.certificate != nil &&
+		certInfo.ModTime().Equal(r.certModTime) &&
+		keyInfo.ModTime().Equal(r.keyModTime)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to load key pair: %v", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.certificate = &certificate
+	r.certModTime = certInfo.ModTime()
+	r.keyModTime = keyInfo.ModTime()
+
+	return true, nil
+}
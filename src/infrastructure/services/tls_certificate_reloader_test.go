@@ -0,0 +1,111 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeSelfSignedCertificate(t *testing.T, certFile, keyFile, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func certificateCommonName(t *testing.T, reloader *TlsCertificateReloader) string {
+	certificate, err := reloader.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return leaf.Subject.CommonName
+}
+
+func TestTlsCertificateReloader_ReloadsChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	writeSelfSignedCertificate(t, certFile, keyFile, "first")
+
+	reloader, err := NewTlsCertificateReloader(certFile, keyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", certificateCommonName(t, reloader))
+
+	reloaded, err := reloader.reloadIfChanged()
+	assert.NoError(t, err)
+	assert.False(t, reloaded)
+
+	writeSelfSignedCertificate(t, certFile, keyFile, "second")
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(certFile, future, future)
+	_ = os.Chtimes(keyFile, future, future)
+
+	reloaded, err = reloader.reloadIfChanged()
+	assert.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.Equal(t, "second", certificateCommonName(t, reloader))
+}
+
+func TestTlsCertificateReloader_KeepsCertificateOnInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	writeSelfSignedCertificate(t, certFile, keyFile, "valid")
+
+	reloader, err := NewTlsCertificateReloader(certFile, keyFile)
+	assert.NoError(t, err)
+
+	_ = os.WriteFile(certFile, []byte("not a certificate"), 0600)
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(certFile, future, future)
+
+	_, err = reloader.reloadIfChanged()
+	assert.Error(t, err)
+	assert.Equal(t, "valid", certificateCommonName(t, reloader))
+}
+
+func TestNewTlsCertificateReloader_MissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	_, err := NewTlsCertificateReloader(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,342 @@
+package services
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"goproxy/application/contracts"
+	"goproxy/domain/valueobjects"
+	"goproxy/infrastructure/config"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+const (
+	// interceptedCertificateValidity is how long minted certificates are valid for,
+	// they are minted again a day before they expire
+	interceptedCertificateValidity    = 7 * 24 * time.Hour
+	interceptedCertificateRenewBefore = 24 * time.Hour
+
+	// tlsRecordTypeHandshake starts every TLS ClientHello
+	tlsRecordTypeHandshake = 0x16
+)
+
+// TlsInterceptor decides which tunnels are intercepted and mints certificates the proxy presents to their clients.
+// Clients of intercepted users must trust the CA of the interceptor.
+type TlsInterceptor struct {
+	users         map[int]bool
+	plans         map[int]bool
+	bypassDomains []string
+	planLimits    contracts.UserPlanLimitsService
+
+	caCertificate *x509.Certificate
+	caKey         crypto.Signer
+	// leafKey is shared by minted certificates, as generating a key per target would slow down new tunnels
+	leafKey *ecdsa.PrivateKey
+
+	mu           sync.Mutex
+	cacheSize    int
+	certificates map[string]*list.Element
+	// recentlyUsed orders cached certificates from the most to the least recently used
+	recentlyUsed *list.List
+}
+
+type mintedCertificate struct {
+	hostname    string
+	certificate *tls.Certificate
+}
+
+func NewTlsInterceptor(config config.TlsInterceptionConfig) (*TlsInterceptor, error) {
+	ca, err := tls.LoadX509KeyPair(config.CaCertFile, config.CaKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA key pair: %v", err)
+	}
+
+	caCertificate, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %v", err)
+	}
+	if !caCertificate.IsCA {
+		return nil, errors.New("CA certificate is not allowed to sign certificates")
+	}
+
+	caKey, ok := ca.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("CA key can not sign certificates")
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate certificate key: %v", err)
+	}
+
+	return &TlsInterceptor{
+		users:         config.Users,
+		plans:         config.Plans,
+		bypassDomains: config.BypassDomains,
+		caCertificate: caCertificate,
+		caKey:         caKey,
+		leafKey:       leafKey,
+		cacheSize:     config.CertCacheSize,
+		certificates:  make(map[string]*list.Element),
+		recentlyUsed:  list.New(),
+	}, nil
+}
+
+// WithPlanLimits enables interception for users of the configured plans.
+func (i *TlsInterceptor) WithPlanLimits(planLimits contracts.UserPlanLimitsService) *TlsInterceptor {
+	i.planLimits = planLimits
+	return i
+}
+
+// Intercepts reports whether tunnels of the user to hostname are intercepted. Nil TlsInterceptor intercepts nothing.
+func (i *TlsInterceptor) Intercepts(userId int, hostname string) bool {
+	if i == nil {
+		return false
+	}
+
+	if matchesDomain(strings.ToLower(strings.TrimSuffix(hostname, ".")), i.bypassDomains) {
+		return false
+	}
+
+	if i.users[userId] {
+		return true
+	}
+
+	return i.planLimits != nil && len(i.plans) != 0 && i.plans[i.planLimits.GetLimits(userId).PlanId]
+}
+
+// Certificate returns a certificate for hostname signed by the CA, minting it unless a valid one is cached.
+func (i *TlsInterceptor) Certificate(hostname string) (*tls.Certificate, error) {
+	hostname = strings.ToLower(hostname)
+	now := time.Now()
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if element, ok := i.certificates[hostname]; ok {
+		cached := element.Value.(*mintedCertificate)
+		if now.Add(interceptedCertificateRenewBefore).Before(cached.certificate.Leaf.NotAfter) {
+			i.recentlyUsed.MoveToFront(element)
+			return cached.certificate, nil
+		}
+		i.recentlyUsed.Remove(element)
+		delete(i.certificates, hostname)
+	}
+
+	certificate, err := i.mint(hostname, now)
+	if err != nil {
+		return nil, err
+	}
+
+	i.certificates[hostname] = i.recentlyUsed.PushFront(&mintedCertificate{hostname: hostname, certificate: certificate})
+	if i.recentlyUsed.Len() > i.cacheSize {
+		oldest := i.recentlyUsed.Remove(i.recentlyUsed.Back()).(*mintedCertificate)
+		delete(i.certificates, oldest.hostname)
+	}
+
+	return certificate, nil
+}
+
+func (i *TlsInterceptor) mint(hostname string, now time.Time) (*tls.Certificate, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      pkix.Name{CommonName: hostname},
+		// clocks of clients may be slightly behind
+		NotBefore:   now.Add(-time.Hour),
+		NotAfter:    now.Add(interceptedCertificateValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(hostname); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{hostname}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, i.caCertificate, &i.leafKey.PublicKey, i.caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mint certificate for %s: %v", hostname, err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate for %s: %v", hostname, err)
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der, i.caCertificate.Raw},
+		PrivateKey:  i.leafKey,
+		Leaf:        leaf,
+	}, nil
+}
+
+// WithTlsInterceptor makes the proxy intercept tunnels of users the interceptor chooses.
+func (p *Proxy) WithTlsInterceptor(interceptor *TlsInterceptor) *Proxy {
+	p.tlsInterceptor = interceptor
+	return p
+}
+
+// intercepts reports whether the tunnel of the user to host is intercepted.
+func (p *Proxy) intercepts(userId int, host string) bool {
+	hostname, _, err := net.SplitHostPort(host)
+	return err == nil && p.tlsInterceptor.Intercepts(userId, hostname)
+}
+
+// interceptionCertificate checks the destination policy and returns the certificate presented to the client
+// of the intercepted tunnel to host.
+func (p *Proxy) interceptionCertificate(userId int, host string) (*tls.Certificate, error) {
+	if err := p.destinationPolicy.Check(userId, TunnelDestination, host); err != nil {
+		return nil, err
+	}
+
+	hostname, _, _ := net.SplitHostPort(host)
+	return p.tlsInterceptor.Certificate(hostname)
+}
+
+// interceptHttps intercepts the HTTP/1.1 CONNECT tunnel to host.
+func (p *Proxy) interceptHttps(clientConn net.Conn, userId, credentialId int, options valueobjects.ConnectionOptions, host string) {
+	certificate, err := p.interceptionCertificate(userId, host)
+	if err != nil {
+		log.Println("Could not intercept tunnel:", err)
+		if errors.As(err, &DestinationDeniedError{}) {
+			writeDialError(clientConn, err)
+		} else {
+			_, _ = clientConn.Write([]byte("HTTP/1.1 500 Internal Server Error\r\n\r\n"))
+		}
+		return
+	}
+
+	_, _ = clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+
+	p.interceptTunnel(clientConn, certificate, userId, credentialId, options, host, nil)
+}
+
+// interceptTunnel terminates TLS of the established tunnel to host with the certificate minted for the target and
+// forwards decrypted HTTP/1.1 and HTTP/2 requests the way plain HTTP requests are forwarded, over TLS connections to
+// the target. Tunnels not starting with a TLS handshake are tunneled untouched, wrapServerConn wraps their target
+// connection unless it is nil.
+func (p *Proxy) interceptTunnel(clientConn net.Conn, certificate *tls.Certificate, userId, credentialId int,
+	options valueobjects.ConnectionOptions, host string, wrapServerConn func(net.Conn) net.Conn) {
+	timeouts := p.timeouts.For(userId)
+	_ = clientConn.SetReadDeadline(time.Now().Add(timeouts.Handshake))
+	reader := bufio.NewReader(clientConn)
+	firstByte, err := reader.Peek(1)
+	if err != nil {
+		return
+	}
+	clientConn = &peekedConn{Conn: clientConn, reader: reader}
+
+	if firstByte[0] != tlsRecordTypeHandshake {
+		serverConn, dialErr := p.dial(userId, options, TunnelDestination, host)
+		if dialErr != nil {
+			log.Println("Could not connect:", dialErr)
+			return
+		}
+		defer func(serverConn net.Conn) {
+			_ = serverConn.Close()
+		}(serverConn)
+
+		if wrapServerConn != nil {
+			serverConn = wrapServerConn(serverConn)
+		}
+		p.tunnel(clientConn, serverConn, userId, credentialId, options, host)
+		return
+	}
+
+	tlsConn := tls.Server(clientConn, &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*certificate},
+		NextProtos:   []string{http2.NextProtoTLS, "http/1.1"},
+	})
+
+	handshakeCtx, cancel := context.WithTimeout(context.Background(), timeouts.Handshake)
+	defer cancel()
+	if err = tlsConn.HandshakeContext(handshakeCtx); err != nil {
+		log.Printf("TLS handshake with intercepted client failed: %v", err)
+		return
+	}
+
+	if tlsConn.ConnectionState().NegotiatedProtocol == http2.NextProtoTLS {
+		p.ServeHttp2(tlsConn, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p.forwardInterceptedHttp2(w, r, userId, credentialId, options, host)
+		}))
+		return
+	}
+
+	p.forwardInterceptedHttp(tlsConn, userId, credentialId, options, host)
+}
+
+// forwardInterceptedHttp forwards HTTP/1.1 requests of the intercepted tunnel until the client connection
+// can not be reused. Requests are sent to the tunnel target whatever their Host header says.
+func (p *Proxy) forwardInterceptedHttp(tlsConn *tls.Conn, userId, credentialId int, options valueobjects.ConnectionOptions, host string) {
+	idleTimeout := p.timeouts.For(userId).Idle
+	reader := bufio.NewReader(tlsConn)
+	for {
+		_ = tlsConn.SetReadDeadline(time.Now().Add(idleTimeout))
+		r, err := http.ReadRequest(reader)
+		if err != nil {
+			return
+		}
+
+		r.RemoteAddr = tlsConn.RemoteAddr().String()
+		r.URL.Scheme = "https"
+		r.URL.Host = host
+
+		keepAlive := p.forwardHttpTo(tlsConn, r, userId, credentialId, options, host)
+		go p.trafficReporter.FlushBuckets()
+		if !keepAlive {
+			return
+		}
+	}
+}
+
+func (p *Proxy) forwardInterceptedHttp2(w http.ResponseWriter, r *http.Request, userId, credentialId int, options valueobjects.ConnectionOptions, host string) {
+	if r.Method == http.MethodConnect {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.URL.Scheme = "https"
+	p.forwardHttp2To(w, r, userId, credentialId, options, host)
+	go p.trafficReporter.FlushBuckets()
+}
+
+// peekedConn reads bytes peeked from the connection before the rest of them.
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *peekedConn) Buffered() int {
+	return c.reader.Buffered()
+}
+
+func (c *peekedConn) NetConn() net.Conn {
+	return c.Conn
+}
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"goproxy/domain/dataobjects"
+	"goproxy/domain/valueobjects"
+	"goproxy/infrastructure/config"
+	"goproxy/infrastructure/socks4"
+	"goproxy/infrastructure/socks5"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+)
+
+// newTestTlsInterceptor creates an interceptor of user 1 with a fresh CA, returning the pool trusting the CA.
+func newTestTlsInterceptor(t *testing.T, interceptionConfig config.TlsInterceptionConfig) (*TlsInterceptor, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "Test Interception CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	interceptionConfig.CaCertFile = filepath.Join(dir, "ca.crt")
+	interceptionConfig.CaKeyFile = filepath.Join(dir, "ca.key")
+	require.NoError(t, os.WriteFile(interceptionConfig.CaCertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(interceptionConfig.CaKeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	if interceptionConfig.Users == nil {
+		interceptionConfig.Users = map[int]bool{1: true}
+	}
+	if interceptionConfig.CertCacheSize == 0 {
+		interceptionConfig.CertCacheSize = 10
+	}
+
+	interceptor, err := NewTlsInterceptor(interceptionConfig)
+	require.NoError(t, err)
+
+	caCertificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(caCertificate)
+
+	return interceptor, roots
+}
+
+// connectIntercepted opens a CONNECT tunnel of user 1 to target and returns the client end of it.
+func connectIntercepted(t *testing.T, proxy *Proxy, target string) net.Conn {
+	clientConn, proxyConn := net.Pipe()
+	t.Cleanup(func() { _ = clientConn.Close() })
+
+	request, _ := http.NewRequest(http.MethodConnect, "http://"+target, nil)
+	go func() {
+		proxy.HandleHttps(proxyConn, request, 1, 0, valueobjects.ConnectionOptions{})
+		_ = proxyConn.Close()
+	}()
+
+	reader := bufio.NewReader(clientConn)
+	response, err := http.ReadResponse(reader, request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	return newBufferedTestConn(clientConn, reader)
+}
+
+// startInterceptedTarget starts an HTTPS target and a proxy intercepting tunnels of user 1 and trusting the target.
+func startInterceptedTarget(t *testing.T, handler http.HandlerFunc) (*Proxy, *httptest.Server, *x509.CertPool) {
+	target := httptest.NewTLSServer(handler)
+	t.Cleanup(target.Close)
+
+	proxy := newTestProxy(t)
+	interceptor, roots := newTestTlsInterceptor(t, config.TlsInterceptionConfig{})
+	proxy.WithTlsInterceptor(interceptor)
+	proxy.httpTransports.tlsConfig = &tls.Config{RootCAs: target.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs}
+
+	return proxy, target, roots
+}
+
+func TestTlsInterceptor_Intercepts(t *testing.T) {
+	interceptor, _ := newTestTlsInterceptor(t, config.TlsInterceptionConfig{
+		Users:         map[int]bool{7: true},
+		Plans:         map[int]bool{1: true},
+		BypassDomains: []string{"pinned.example"},
+	})
+	planLimits := newTestUserPlanLimitsService()
+	_ = planLimits.SetLimits(1, dataobjects.UserPlanLimits{PlanId: 1})
+	_ = planLimits.SetLimits(2, dataobjects.UserPlanLimits{PlanId: 2})
+	interceptor.WithPlanLimits(planLimits)
+
+	assert.True(t, interceptor.Intercepts(7, "example.com"))
+	assert.True(t, interceptor.Intercepts(1, "example.com"))
+	assert.False(t, interceptor.Intercepts(2, "example.com"))
+	assert.False(t, interceptor.Intercepts(7, "pinned.example"))
+	assert.False(t, interceptor.Intercepts(1, "API.Pinned.Example."))
+
+	var disabled *TlsInterceptor
+	assert.False(t, disabled.Intercepts(7, "example.com"))
+}
+
+func TestTlsInterceptor_Certificate(t *testing.T) {
+	interceptor, roots := newTestTlsInterceptor(t, config.TlsInterceptionConfig{CertCacheSize: 2})
+
+	for _, hostname := range []string{"example.com", "192.0.2.6"} {
+		certificate, err := interceptor.Certificate(hostname)
+		require.NoError(t, err)
+
+		_, err = certificate.Leaf.Verify(x509.VerifyOptions{DNSName: hostname, Roots: roots})
+		assert.NoError(t, err, hostname)
+	}
+
+	cached, err := interceptor.Certificate("Example.com")
+	require.NoError(t, err)
+	first, _ := interceptor.Certificate("example.com")
+	assert.Same(t, first, cached)
+
+	// the least recently used certificate is evicted: 192.0.2.6, then example.com
+	_, _ = interceptor.Certificate("example.org")
+	minted, _ := interceptor.Certificate("192.0.2.6")
+	assert.Equal(t, 2, interceptor.recentlyUsed.Len())
+	cachedMinted, _ := interceptor.Certificate("192.0.2.6")
+	assert.Same(t, minted, cachedMinted)
+	again, _ := interceptor.Certificate("example.com")
+	assert.NotSame(t, first, again)
+}
+
+func TestProxy_HandleHttps_InterceptsHttp1(t *testing.T) {
+	proxy, target, roots := startInterceptedTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Method+" "+r.URL.Path+" "+r.Header.Get("Via"))
+	})
+	proxy.httpForward.Via = "goproxy"
+
+	targetAddr := target.Listener.Addr().String()
+	tlsConn := tls.Client(connectIntercepted(t, proxy, targetAddr), &tls.Config{
+		RootCAs:    roots,
+		ServerName: "127.0.0.1",
+		NextProtos: []string{"http/1.1"},
+	})
+	require.NoError(t, tlsConn.Handshake())
+
+	reader := bufio.NewReader(tlsConn)
+	// both requests are sent over the same intercepted connection
+	for _, path := range []string{"/first", "/second"} {
+		request, _ := http.NewRequest(http.MethodGet, "https://"+targetAddr+path, nil)
+		require.NoError(t, request.Write(tlsConn))
+
+		response, err := http.ReadResponse(reader, request)
+		require.NoError(t, err)
+		body, _ := io.ReadAll(response.Body)
+		assert.Equal(t, "GET "+path+" 1.1 goproxy", string(body))
+		assert.Equal(t, "1.1 goproxy", response.Header.Get("Via"))
+	}
+
+	event := <-proxy.trafficReporter.eventQueue
+	assert.Positive(t, event.InBytes+event.OutBytes)
+}
+
+func TestProxy_HandleHttps_InterceptsHttp2(t *testing.T) {
+	proxy, target, roots := startInterceptedTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Method+" "+r.URL.Path)
+	})
+
+	targetAddr := target.Listener.Addr().String()
+	tlsConn := tls.Client(connectIntercepted(t, proxy, targetAddr), &tls.Config{
+		RootCAs:    roots,
+		ServerName: "127.0.0.1",
+		NextProtos: []string{http2.NextProtoTLS},
+	})
+	require.NoError(t, tlsConn.Handshake())
+	require.Equal(t, http2.NextProtoTLS, tlsConn.ConnectionState().NegotiatedProtocol)
+
+	clientConn, err := (&http2.Transport{}).NewClientConn(tlsConn)
+	require.NoError(t, err)
+
+	request, _ := http.NewRequest(http.MethodGet, "https://"+targetAddr+"/h2", nil)
+	response, err := clientConn.RoundTrip(request)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(response.Body)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, "GET /h2", string(body))
+}
+
+func TestProxy_HandleHttps_TunnelsNonTlsTraffic(t *testing.T) {
+	proxy := newTestProxy(t)
+	interceptor, _ := newTestTlsInterceptor(t, config.TlsInterceptionConfig{})
+	proxy.WithTlsInterceptor(interceptor)
+
+	assertEcho(t, connectIntercepted(t, proxy, startEchoServer(t)))
+}
+
+// assertInterceptedGet sends a request over TLS trusting only the interception CA, so it succeeds only if the tunnel
+// to target is intercepted.
+func assertInterceptedGet(t *testing.T, conn net.Conn, roots *x509.CertPool, targetAddr string) {
+	tlsConn := tls.Client(conn, &tls.Config{
+		RootCAs:    roots,
+		ServerName: "127.0.0.1",
+		NextProtos: []string{"http/1.1"},
+	})
+	require.NoError(t, tlsConn.Handshake())
+
+	request, _ := http.NewRequest(http.MethodGet, "https://"+targetAddr+"/intercepted", nil)
+	require.NoError(t, request.Write(tlsConn))
+
+	response, err := http.ReadResponse(bufio.NewReader(tlsConn), request)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(response.Body)
+	assert.Equal(t, "GET /intercepted", string(body))
+}
+
+func TestProxy_HandleHttp2_InterceptsConnectStreams(t *testing.T) {
+	proxy, target, roots := startInterceptedTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Method+" "+r.URL.Path)
+	})
+	var connections atomic.Int32
+	client := startHttp2Proxy(t, proxy, &connections)
+
+	targetAddr := target.Listener.Addr().String()
+	writer, response := connectHttp2(t, client, targetAddr)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	clientConn, streamConn := net.Pipe()
+	t.Cleanup(func() { _ = clientConn.Close() })
+	go func() { _, _ = io.Copy(writer, streamConn) }()
+	go func() { _, _ = io.Copy(streamConn, response.Body) }()
+
+	assertInterceptedGet(t, clientConn, roots, targetAddr)
+}
+
+func TestProxy_HandleSocks4_InterceptsTunnels(t *testing.T) {
+	proxy, target, roots := startInterceptedTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Method+" "+r.URL.Path)
+	})
+
+	clientConn, proxyConn := net.Pipe()
+	t.Cleanup(func() { _ = clientConn.Close() })
+	go func() {
+		defer proxyConn.Close()
+		_, host, err := proxy.ReadSocks4Request(proxyConn)
+		if err != nil {
+			return
+		}
+		proxy.HandleSocks4(proxyConn, host, 1, 0, valueobjects.ConnectionOptions{})
+	}()
+
+	targetAddr := target.Listener.Addr().(*net.TCPAddr)
+	request := []byte{socks4.Version, socks4.CmdConnect, 0, 0, 127, 0, 0, 1}
+	binary.BigEndian.PutUint16(request[2:4], uint16(targetAddr.Port))
+	request = append(request, "alice:secret"...)
+	request = append(request, 0)
+	_, err := clientConn.Write(request)
+	require.NoError(t, err)
+
+	reply := make([]byte, 8)
+	_, err = io.ReadFull(clientConn, reply)
+	require.NoError(t, err)
+	require.Equal(t, byte(socks4.ReplyGranted), reply[1])
+
+	assertInterceptedGet(t, clientConn, roots, targetAddr.String())
+}
+
+func TestProxy_HandleSocks5_InterceptsTunnels(t *testing.T) {
+	proxy, target, roots := startInterceptedTarget(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Method+" "+r.URL.Path)
+	})
+	socksServer := serveSocks5(t, proxy, 1)
+
+	conn, err := net.Dial("tcp", socksServer.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	targetAddr := target.Listener.Addr().(*net.TCPAddr)
+	socks5ClientHandshake(t, conn)
+	writeSocks5Request(t, conn, socks5.CmdConnect, targetAddr)
+
+	assertInterceptedGet(t, conn, roots, targetAddr.String())
+}
@@ -10,14 +10,23 @@ import (
 	"goproxy/infrastructure/config"
 	"log"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// trafficFlushInterval is how often traffic of open tunnels is reported, so long-lived tunnels are billed while they run.
+const trafficFlushInterval = 30 * time.Second
+
 type TrafficReporter struct {
-	mu              sync.RWMutex
-	buckets         map[int]*TrafficBucket
+	mu      sync.RWMutex
+	buckets map[trafficAccount]*TrafficBucket
+	// counters of open tunnels are added to buckets when they are flushed
+	counters        map[*TrafficCounter]struct{}
 	messageBus      contracts.MessageBusService
 	eventQueue      chan events.UserConsumedTrafficEvent
 	stopEventWorker chan struct{}
+	// closed is set on shutdown, traffic flushed after it is not reported
+	closed bool
 }
 
 type TrafficBucket struct {
@@ -25,6 +34,12 @@ type TrafficBucket struct {
 	OutBytes int64
 }
 
+// trafficAccount is who traffic is reported for, the credential id is 0 for traffic of the account password.
+type trafficAccount struct {
+	userId       int
+	credentialId int
+}
+
 func NewTrafficReporter() (*TrafficReporter, error) {
 	kafkaConfig, kafkaConfigErr := config.NewKafkaConfig(domain.PROXY)
 	if kafkaConfigErr != nil {
@@ -37,81 +52,203 @@ func NewTrafficReporter() (*TrafficReporter, error) {
 	}
 
 	reporter := &TrafficReporter{
-		buckets:         make(map[int]*TrafficBucket),
+		buckets:         make(map[trafficAccount]*TrafficBucket),
 		messageBus:      messageBusService,
 		eventQueue:      make(chan events.UserConsumedTrafficEvent, 100), // Буфер для очереди событий
 		stopEventWorker: make(chan struct{}),
 	}
 
 	go reporter.startEventWorker(context.Background())
+	go reporter.startFlushWorker(trafficFlushInterval)
 	return reporter, nil
 }
 
-func (tr *TrafficReporter) AddInBytes(userId int, n int64) {
+func (tr *TrafficReporter) AddInBytes(userId, credentialId int, n int64) {
 	tr.mu.Lock()
 	defer tr.mu.Unlock()
 
 	if n == 0 {
 		return
 	}
-	bucket, exists := tr.buckets[userId]
+	tr.bucketLocked(trafficAccount{userId, credentialId}).InBytes += n
+}
+
+func (tr *TrafficReporter) AddOutBytes(userId, credentialId int, n int64) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	tr.bucketLocked(trafficAccount{userId, credentialId}).OutBytes += n
+}
+
+func (tr *TrafficReporter) bucketLocked(account trafficAccount) *TrafficBucket {
+	bucket, exists := tr.buckets[account]
 	if !exists {
 		bucket = &TrafficBucket{}
-		tr.buckets[userId] = bucket
+		tr.buckets[account] = bucket
 	}
+	return bucket
+}
+
+// TrafficCounter counts traffic of a single tunnel without locking the reporter on every read.
+type TrafficCounter struct {
+	account  trafficAccount
+	inBytes  atomic.Int64
+	outBytes atomic.Int64
+}
+
+func (c *TrafficCounter) AddInBytes(n int64) {
+	c.inBytes.Add(n)
+}
 
-	bucket.InBytes += n
+func (c *TrafficCounter) AddOutBytes(n int64) {
+	c.outBytes.Add(n)
 }
 
-func (tr *TrafficReporter) AddOutBytes(userId int, n int64) {
+// OpenCounter returns a counter of the user traffic, collected into buckets until it is closed.
+func (tr *TrafficReporter) OpenCounter(userId, credentialId int) *TrafficCounter {
 	tr.mu.Lock()
 	defer tr.mu.Unlock()
 
-	bucket, exists := tr.buckets[userId]
-	if !exists {
-		bucket = &TrafficBucket{}
-		tr.buckets[userId] = bucket
+	if tr.counters == nil {
+		tr.counters = make(map[*TrafficCounter]struct{})
+	}
+	counter := &TrafficCounter{account: trafficAccount{userId, credentialId}}
+	tr.counters[counter] = struct{}{}
+
+	return counter
+}
+
+// CloseCounter adds the traffic left in the counter to its user bucket and stops collecting it.
+func (tr *TrafficReporter) CloseCounter(counter *TrafficCounter) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	tr.collectLocked(counter)
+	delete(tr.counters, counter)
+}
+
+// collectCountersLocked moves traffic counted by open tunnels to the buckets.
+func (tr *TrafficReporter) collectCountersLocked() {
+	for counter := range tr.counters {
+		tr.collectLocked(counter)
 	}
-	bucket.OutBytes += n
 }
+
+func (tr *TrafficReporter) collectLocked(counter *TrafficCounter) {
+	in, out := counter.inBytes.Swap(0), counter.outBytes.Swap(0)
+	if in == 0 && out == 0 {
+		return
+	}
+
+	bucket := tr.bucketLocked(counter.account)
+	bucket.InBytes += in
+	bucket.OutBytes += out
+}
+
 func (tr *TrafficReporter) startEventWorker(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case event := <-tr.eventQueue:
-			eventJson, err := json.Marshal(event)
-			if err != nil {
-				log.Printf("Failed to serialize event: %v", err)
-				continue
-			}
-
-			outboxEvent, err := events.NewOutboxEvent(0, string(eventJson), false, "UserConsumedTrafficEvent")
-			if err != nil {
-				log.Printf("Failed to create outbox event: %v", err)
-				continue
-			}
-
-			if err := tr.messageBus.Produce(fmt.Sprintf("%s", domain.PLAN), outboxEvent); err != nil {
-				log.Printf("Failed to produce event: %v", err)
-			}
+			tr.produce(event)
+		case <-tr.stopEventWorker:
+			return
+		}
+	}
+}
+
+// startFlushWorker flushes buckets along with counters of open tunnels every interval until shutdown.
+func (tr *TrafficReporter) startFlushWorker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			tr.FlushBuckets()
 		case <-tr.stopEventWorker:
 			return
 		}
 	}
 }
 
+func (tr *TrafficReporter) produce(event events.UserConsumedTrafficEvent) {
+	eventJson, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to serialize event: %v", err)
+		return
+	}
+
+	outboxEvent, err := events.NewOutboxEvent(0, string(eventJson), false, "UserConsumedTrafficEvent")
+	if err != nil {
+		log.Printf("Failed to create outbox event: %v", err)
+		return
+	}
+
+	if err := tr.messageBus.Produce(fmt.Sprintf("%s", domain.PLAN), outboxEvent); err != nil {
+		log.Printf("Failed to produce event: %v", err)
+	}
+}
+
 func (tr *TrafficReporter) FlushBuckets() {
 	tr.mu.Lock()
 	defer tr.mu.Unlock()
 
-	for userId, bucket := range tr.buckets {
+	// nobody reads the queue after shutdown
+	if tr.closed {
+		return
+	}
+
+	tr.collectCountersLocked()
+	for account, bucket := range tr.buckets {
+		if bucket.InBytes > 0 || bucket.OutBytes > 0 {
+			tr.eventQueue <- events.NewUserConsumedTrafficEvent(account.userId, account.credentialId, bucket.InBytes, bucket.OutBytes)
+			bucket.InBytes = 0
+			bucket.OutBytes = 0
+		}
+	}
+}
+
+// Shutdown stops the event worker and produces the queued events and the traffic left in buckets,
+// so that it is billed before the node exits. Traffic added after shutdown is not reported.
+func (tr *TrafficReporter) Shutdown(ctx context.Context) error {
+	tr.mu.Lock()
+	if tr.closed {
+		tr.mu.Unlock()
+		return nil
+	}
+	tr.closed = true
+	close(tr.stopEventWorker)
+
+	tr.collectCountersLocked()
+
+	var pending []events.UserConsumedTrafficEvent
+	for drained := false; !drained; {
+		select {
+		case event := <-tr.eventQueue:
+			pending = append(pending, event)
+		default:
+			drained = true
+		}
+	}
+	for account, bucket := range tr.buckets {
 		if bucket.InBytes > 0 || bucket.OutBytes > 0 {
-			tr.eventQueue <- events.NewUserConsumedTrafficEvent(userId, bucket.InBytes, bucket.OutBytes)
+			pending = append(pending, events.NewUserConsumedTrafficEvent(account.userId, account.credentialId, bucket.InBytes, bucket.OutBytes))
 			bucket.InBytes = 0
 			bucket.OutBytes = 0
 		}
 	}
+	tr.mu.Unlock()
+
+	for i, event := range pending {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%d traffic events were not reported: %v", len(pending)-i, ctx.Err())
+		}
+		tr.produce(event)
+	}
+
+	return nil
 }
 
 func (tr *TrafficReporter) Stop() {
@@ -119,8 +256,8 @@ func (tr *TrafficReporter) Stop() {
 	close(tr.eventQueue)
 }
 
-func (tr *TrafficReporter) ProduceTrafficConsumedEvent(userId int, in, out int64) error {
-	event := events.NewUserConsumedTrafficEvent(userId, in, out)
+func (tr *TrafficReporter) ProduceTrafficConsumedEvent(userId, credentialId int, in, out int64) error {
+	event := events.NewUserConsumedTrafficEvent(userId, credentialId, in, out)
 	eventJson, serializationErr := json.Marshal(event)
 	if serializationErr != nil {
 		log.Printf("Could not serialize consumed traffic event: %v", serializationErr)
@@ -12,7 +12,7 @@ import (
 func TestNewTrafficReporter(t *testing.T) {
 	mockBus := mocks.NewMockMessageBusService()
 	reporter := &TrafficReporter{
-		buckets:    make(map[int]*TrafficBucket),
+		buckets:    make(map[trafficAccount]*TrafficBucket),
 		messageBus: mockBus,
 	}
 
@@ -26,17 +26,78 @@ func TestNewTrafficReporter(t *testing.T) {
 
 func TestAddInBytesAndOutBytes(t *testing.T) {
 	reporter := &TrafficReporter{
-		buckets: make(map[int]*TrafficBucket),
+		buckets: make(map[trafficAccount]*TrafficBucket),
 	}
 
-	reporter.AddInBytes(1, 100)
-	reporter.AddOutBytes(1, 200)
+	reporter.AddInBytes(1, 0, 100)
+	reporter.AddOutBytes(1, 0, 200)
 
-	if reporter.buckets[1].InBytes != 100 {
-		t.Errorf("Expected InBytes to be 100, got %d", reporter.buckets[1].InBytes)
+	if reporter.buckets[trafficAccount{userId: 1}].InBytes != 100 {
+		t.Errorf("Expected InBytes to be 100, got %d", reporter.buckets[trafficAccount{userId: 1}].InBytes)
 	}
-	if reporter.buckets[1].OutBytes != 200 {
-		t.Errorf("Expected OutBytes to be 200, got %d", reporter.buckets[1].OutBytes)
+	if reporter.buckets[trafficAccount{userId: 1}].OutBytes != 200 {
+		t.Errorf("Expected OutBytes to be 200, got %d", reporter.buckets[trafficAccount{userId: 1}].OutBytes)
+	}
+}
+
+func TestFlushBuckets_ReportsCredentialTrafficSeparately(t *testing.T) {
+	reporter := &TrafficReporter{
+		buckets:    make(map[trafficAccount]*TrafficBucket),
+		eventQueue: make(chan events.UserConsumedTrafficEvent, 10),
+	}
+
+	reporter.AddInBytes(1, 0, 100)
+	reporter.AddInBytes(1, 7, 200)
+	counter := reporter.OpenCounter(1, 7)
+	counter.AddOutBytes(300)
+	reporter.FlushBuckets()
+
+	reported := make(map[int]events.UserConsumedTrafficEvent)
+	for i := 0; i < 2; i++ {
+		event := <-reporter.eventQueue
+		if event.UserId != 1 {
+			t.Errorf("Expected traffic of user 1, got user %d", event.UserId)
+		}
+		reported[event.CredentialId] = event
+	}
+
+	if reported[0].InBytes != 100 || reported[0].OutBytes != 0 {
+		t.Errorf("Expected 100/0 bytes of the account password, got %d/%d", reported[0].InBytes, reported[0].OutBytes)
+	}
+	if reported[7].InBytes != 200 || reported[7].OutBytes != 300 {
+		t.Errorf("Expected 200/300 bytes of credential 7, got %d/%d", reported[7].InBytes, reported[7].OutBytes)
+	}
+}
+
+func TestTrafficCounter(t *testing.T) {
+	reporter := &TrafficReporter{
+		buckets:    make(map[trafficAccount]*TrafficBucket),
+		eventQueue: make(chan events.UserConsumedTrafficEvent, 10),
+	}
+
+	counter := reporter.OpenCounter(1, 0)
+	counter.AddInBytes(100)
+	counter.AddOutBytes(200)
+	if _, exists := reporter.buckets[trafficAccount{userId: 1}]; exists {
+		t.Fatal("Expected counted traffic to stay in the counter until flush")
+	}
+
+	reporter.FlushBuckets()
+	event := <-reporter.eventQueue
+	if event.InBytes != 100 || event.OutBytes != 200 {
+		t.Errorf("Expected 100/200 bytes to be flushed, got %d/%d", event.InBytes, event.OutBytes)
+	}
+
+	counter.AddInBytes(50)
+	reporter.CloseCounter(counter)
+	counter.AddInBytes(1000)
+	reporter.FlushBuckets()
+	event = <-reporter.eventQueue
+	if event.InBytes != 50 || event.OutBytes != 0 {
+		t.Errorf("Expected 50/0 bytes of the closed counter to be flushed, got %d/%d", event.InBytes, event.OutBytes)
+	}
+	if len(reporter.counters) != 0 {
+		t.Errorf("Expected closed counter to be removed, got %d counters", len(reporter.counters))
 	}
 }
 
@@ -44,14 +105,14 @@ func TestFlushbuckets(t *testing.T) {
 	testCtx, testCtxCancelFunc := context.WithCancel(context.Background())
 	mockBus := mocks.NewMockMessageBusService()
 	reporter := &TrafficReporter{
-		buckets:    make(map[int]*TrafficBucket),
+		buckets:    make(map[trafficAccount]*TrafficBucket),
 		eventQueue: make(chan events.UserConsumedTrafficEvent, 10),
 		messageBus: mockBus,
 	}
 
 	go reporter.startEventWorker(testCtx)
 
-	reporter.AddInBytes(1, 500)
+	reporter.AddInBytes(1, 0, 500)
 	reporter.FlushBuckets()
 
 	// wait for mockBus to process event queue
@@ -83,7 +144,7 @@ func TestProduceTrafficConsumedEvent(t *testing.T) {
 		messageBus: mockBus,
 	}
 
-	err := reporter.ProduceTrafficConsumedEvent(1, 100, 200)
+	err := reporter.ProduceTrafficConsumedEvent(1, 0, 100, 200)
 	if err != nil {
 		t.Fatalf("Failed to produce traffic event: %v", err)
 	}
@@ -132,3 +193,48 @@ func TestStop(t *testing.T) {
 		t.Error("Stop did not close stopEventWorker channel")
 	}
 }
+
+func TestShutdownReportsQueuedAndBucketedTraffic(t *testing.T) {
+	mockBus := mocks.NewMockMessageBusService()
+	reporter := &TrafficReporter{
+		buckets:         make(map[trafficAccount]*TrafficBucket),
+		eventQueue:      make(chan events.UserConsumedTrafficEvent, 10),
+		stopEventWorker: make(chan struct{}),
+		messageBus:      mockBus,
+	}
+
+	reporter.AddInBytes(1, 0, 100)
+	reporter.FlushBuckets()
+	reporter.AddOutBytes(2, 0, 200)
+
+	if err := reporter.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	reported := make(map[int]events.UserConsumedTrafficEvent)
+	for i := 0; i < 2; i++ {
+		event, err := mockBus.Consume()
+		if err != nil {
+			t.Fatalf("Expected 2 reported events, got %d: %v", i, err)
+		}
+		var consumedEvent events.UserConsumedTrafficEvent
+		if err = json.Unmarshal([]byte(event.Payload), &consumedEvent); err != nil {
+			t.Fatalf("Failed to unmarshal event: %v", err)
+		}
+		reported[consumedEvent.UserId] = consumedEvent
+	}
+
+	if reported[1].InBytes != 100 {
+		t.Errorf("Expected InBytes of user 1 to be 100, got %d", reported[1].InBytes)
+	}
+	if reported[2].OutBytes != 200 {
+		t.Errorf("Expected OutBytes of user 2 to be 200, got %d", reported[2].OutBytes)
+	}
+
+	// traffic of connections closed after shutdown is not queued, so flushing does not block
+	reporter.AddInBytes(1, 0, 100)
+	reporter.FlushBuckets()
+	if _, err := mockBus.Consume(); err == nil {
+		t.Error("Expected no events reported after shutdown")
+	}
+}
@@ -0,0 +1,170 @@
+//go:build linux
+
+package services
+
+import (
+	"goproxy/infrastructure/infraerrs"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// spliceChunkSize caps the bytes moved by a single splice, so traffic is accounted as often as in the buffered path.
+const spliceChunkSize = rateLimitAccountingThreshold
+
+// bufferedNetConn reads bytes buffered while detecting the protocol before those of the connection it wraps.
+type bufferedNetConn interface {
+	net.Conn
+	Buffered() int
+	NetConn() net.Conn
+}
+
+// spliceTunnel moves traffic between the TCP connections under clientConn and serverConn with splice(2), so it is never
+// copied to user space. It returns false without touching the connections if either side is not a TCP connection,
+// e.g. a TLS connection or an HTTP/2 stream, which are copied through buffers instead.
+func (p *Proxy) spliceTunnel(clientConn, serverConn net.Conn, watchdog *activityWatchdog, counter *TrafficCounter, userId int, host string) bool {
+	clientTcpConn, clientOk := tcpConnOf(clientConn)
+	serverTcpConn, serverOk := tcpConnOf(serverConn)
+	if !clientOk || !serverOk {
+		return false
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	// client → server
+	go func() {
+		defer wg.Done()
+		defer p.rateLimiter.Done(userId, host)
+
+		var accumulatedBytes int64
+		_ = spliceTraffic(serverTcpConn, clientConn, clientTcpConn, watchdog, func(n int64) error {
+			counter.AddInBytes(n)
+			accumulatedBytes += n
+			if accumulatedBytes >= rateLimitAccountingThreshold {
+				if !p.rateLimiter.Allow(userId, host, accumulatedBytes) {
+					return infraerrs.RateLimitExceededError{}
+				}
+				accumulatedBytes = 0
+			}
+			return nil
+		})
+	}()
+	// server → client
+	go func() {
+		defer wg.Done()
+		_ = spliceTraffic(clientTcpConn, serverConn, serverTcpConn, watchdog, func(n int64) error {
+			counter.AddOutBytes(n)
+			return nil
+		})
+	}()
+
+	wg.Wait()
+	return true
+}
+
+// spliceTraffic moves bytes of src to dst until src is closed. Bytes buffered by wrappers of src are written first,
+// the rest is spliced from srcTcpConn in chunks of the bytes queued in the socket, so the tunnel is marked active
+// as soon as they arrive and every chunk is accounted right after it is sent.
+func spliceTraffic(dst *net.TCPConn, src net.Conn, srcTcpConn *net.TCPConn, watchdog *activityWatchdog, account func(n int64) error) error {
+	written, err := writeBuffered(dst, src)
+	if written > 0 {
+		watchdog.touch()
+		if accountErr := account(written); accountErr != nil {
+			return accountErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	rawConn, err := srcTcpConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	for {
+		queued, waitErr := waitQueued(rawConn)
+		if waitErr != nil {
+			return waitErr
+		}
+		watchdog.touch()
+
+		// a readable socket with nothing queued is closed, splicing a byte tells it apart from a spurious wakeup
+		chunk := int64(min(max(queued, 1), spliceChunkSize))
+		// TCPConn.ReadFrom splices from a TCP connection limited by io.LimitedReader
+		n, copyErr := io.CopyN(dst, srcTcpConn, chunk)
+		if n > 0 {
+			if accountErr := account(n); accountErr != nil {
+				return accountErr
+			}
+		}
+
+		if copyErr != nil {
+			if copyErr == io.EOF {
+				return nil
+			}
+			return copyErr
+		}
+	}
+}
+
+// waitQueued waits until the socket is readable and returns how many bytes are queued in it.
+// Deadlines of the connection apply, so the watchdog ends the wait of an expired tunnel.
+func waitQueued(rawConn syscall.RawConn) (int, error) {
+	var queued int
+	var ioctlErr error
+	waited := false
+
+	err := rawConn.Read(func(fd uintptr) bool {
+		queued, ioctlErr = unix.IoctlGetInt(int(fd), unix.SIOCINQ)
+		if ioctlErr != nil || queued > 0 || waited {
+			return true
+		}
+
+		waited = true
+		return false
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return queued, ioctlErr
+}
+
+// tcpConnOf returns the TCP connection under conn and the wrappers reading bytes buffered before it.
+func tcpConnOf(conn net.Conn) (*net.TCPConn, bool) {
+	for {
+		switch c := conn.(type) {
+		case *net.TCPConn:
+			return c, true
+		case bufferedNetConn:
+			conn = c.NetConn()
+		default:
+			return nil, false
+		}
+	}
+}
+
+// writeBuffered writes bytes buffered by wrappers of src to dst, outer wrappers first as they were read ahead of inner ones.
+func writeBuffered(dst io.Writer, src net.Conn) (int64, error) {
+	var written int64
+	for {
+		buffered, ok := src.(bufferedNetConn)
+		if !ok {
+			return written, nil
+		}
+
+		if size := buffered.Buffered(); size > 0 {
+			n, err := io.CopyN(dst, buffered, int64(size))
+			written += n
+			if err != nil {
+				return written, err
+			}
+		}
+		src = buffered.NetConn()
+	}
+}
@@ -0,0 +1,344 @@
+//go:build linux
+
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"goproxy/application/use_cases"
+	"goproxy/domain/valueobjects"
+	"goproxy/infrastructure/config"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTcpPair returns both ends of a loopback TCP connection.
+func newTcpPair(t testing.TB) (*net.TCPConn, *net.TCPConn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := listener.Accept()
+		accepted <- conn
+	}()
+
+	dialed, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	conn := <-accepted
+	require.NotNil(t, conn)
+	t.Cleanup(func() {
+		_ = dialed.Close()
+		_ = conn.Close()
+	})
+
+	return dialed.(*net.TCPConn), conn.(*net.TCPConn)
+}
+
+// opaqueConn hides the TCP connection it wraps, so its tunnel is copied through buffers.
+type opaqueConn struct {
+	net.Conn
+}
+
+// testBufferedConn reads bytes peeked by reader before those of the connection.
+type testBufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *testBufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *testBufferedConn) Buffered() int {
+	return c.reader.Buffered()
+}
+
+func (c *testBufferedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// startTunnel tunnels a client to a target through the proxy and returns the client and target ends.
+func startTunnel(t testing.TB, proxy *Proxy, wrap func(clientConn, serverConn net.Conn) (net.Conn, net.Conn)) (net.Conn, net.Conn, chan struct{}) {
+	client, proxyClientConn := newTcpPair(t)
+	proxyServerConn, target := newTcpPair(t)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		clientConn, serverConn := wrap(proxyClientConn, proxyServerConn)
+		proxy.tunnel(clientConn, serverConn, 1, 0, valueobjects.ConnectionOptions{}, "example.com:443")
+		_ = proxyClientConn.Close()
+		_ = proxyServerConn.Close()
+	}()
+
+	return client, target, done
+}
+
+func TestTcpConnOf(t *testing.T) {
+	client, _ := newTcpPair(t)
+	peeked := &peekedConn{Conn: &testBufferedConn{Conn: client, reader: bufio.NewReader(client)}, reader: bufio.NewReader(client)}
+
+	tests := []struct {
+		name     string
+		conn     net.Conn
+		expected bool
+	}{
+		{name: "tcp connection", conn: client, expected: true},
+		{name: "buffered wrappers", conn: peeked, expected: true},
+		{name: "opaque wrapper", conn: &opaqueConn{Conn: client}, expected: false},
+		{name: "tls connection", conn: tls.Client(client, &tls.Config{}), expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tcpConn, ok := tcpConnOf(tt.conn)
+			assert.Equal(t, tt.expected, ok)
+			if tt.expected {
+				assert.Same(t, client, tcpConn)
+			}
+		})
+	}
+}
+
+func TestProxy_Tunnel_Splices(t *testing.T) {
+	proxy := newTestProxy(t)
+	client, target, done := startTunnel(t, proxy, func(clientConn, serverConn net.Conn) (net.Conn, net.Conn) {
+		// the client sent its first bytes along with the request, they were read ahead by the protocol detection
+		reader := bufio.NewReader(io.MultiReader(strings.NewReader("early data|"), clientConn))
+		_, _ = reader.Peek(1)
+		return &testBufferedConn{Conn: clientConn, reader: reader}, serverConn
+	})
+
+	payload := strings.Repeat("x", 3*spliceChunkSize+17)
+	go func() {
+		_, _ = io.WriteString(client, payload)
+	}()
+	received := make([]byte, len("early data|")+len(payload))
+	_, err := io.ReadFull(target, received)
+	require.NoError(t, err)
+	assert.Equal(t, "early data|"+payload, string(received))
+
+	_, err = target.Write([]byte("pong"))
+	require.NoError(t, err)
+	response := make([]byte, 4)
+	_, err = io.ReadFull(client, response)
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(response))
+
+	_ = client.Close()
+	_ = target.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("tunnel was not closed")
+	}
+
+	var inBytes, outBytes int64
+	timeout := time.After(time.Second)
+	for inBytes < int64(len(received)) || outBytes < 4 {
+		select {
+		case event := <-proxy.trafficReporter.eventQueue:
+			inBytes += event.InBytes
+			outBytes += event.OutBytes
+		case <-timeout:
+			t.Fatalf("traffic is not reported, in = %d, out = %d", inBytes, outBytes)
+		}
+	}
+	assert.Equal(t, int64(len(received)), inBytes)
+	assert.Equal(t, int64(4), outBytes)
+}
+
+func TestProxy_Tunnel_ReportsTrafficOfOpenSplicedTunnel(t *testing.T) {
+	proxy := newTestProxy(t)
+	proxy.trafficReporter.stopEventWorker = make(chan struct{})
+	t.Cleanup(func() { close(proxy.trafficReporter.stopEventWorker) })
+	go proxy.trafficReporter.startFlushWorker(10 * time.Millisecond)
+
+	client, target, done := startTunnel(t, proxy, func(clientConn, serverConn net.Conn) (net.Conn, net.Conn) {
+		return clientConn, serverConn
+	})
+	defer func() {
+		_ = client.Close()
+		_ = target.Close()
+		<-done
+	}()
+
+	// traffic is reported every flush while the tunnel stays open
+	received := make([]byte, 4)
+	for i := 0; i < 2; i++ {
+		_, err := client.Write([]byte("ping"))
+		require.NoError(t, err)
+		_, err = io.ReadFull(target, received)
+		require.NoError(t, err)
+
+		select {
+		case event := <-proxy.trafficReporter.eventQueue:
+			assert.Equal(t, int64(4), event.InBytes)
+		case <-time.After(time.Second):
+			t.Fatal("traffic of the open tunnel is not reported")
+		}
+	}
+
+	select {
+	case <-done:
+		t.Fatal("tunnel was closed")
+	default:
+	}
+}
+
+// testProxyListener hands the listener to the proxy use cases regardless of the port.
+type testProxyListener struct {
+	listener net.Listener
+}
+
+func (l testProxyListener) Listen(int) (net.Listener, error) {
+	return l.listener, nil
+}
+
+// testProxyAuthenticator authenticates every client as the user 1.
+type testProxyAuthenticator struct{}
+
+func (testProxyAuthenticator) Scheme() string {
+	return "Basic"
+}
+
+func (testProxyAuthenticator) Challenges(error) []string {
+	return nil
+}
+
+func (testProxyAuthenticator) Authenticate(string, *http.Request, string) (use_cases.ProxyIdentity, error) {
+	return use_cases.ProxyIdentity{UserId: 1}, nil
+}
+
+// spliceProbe reports whether tunnels of the client connections it is handed can be spliced.
+type spliceProbe struct {
+	*Proxy
+	spliceable chan bool
+}
+
+func (p *spliceProbe) HandleHttps(clientConn net.Conn, r *http.Request, userId, credentialId int, options valueobjects.ConnectionOptions) {
+	_, ok := tcpConnOf(clientConn)
+	p.spliceable <- ok
+	p.Proxy.HandleHttps(clientConn, r, userId, credentialId, options)
+}
+
+func TestProxyUseCases_Serve_SplicesTunnels(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	targetListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		_ = targetListener.Close()
+	}()
+
+	probe := &spliceProbe{Proxy: newTestProxy(t), spliceable: make(chan bool, 1)}
+	proxyUseCases := use_cases.NewProxyUseCases(probe, nil, nil, testProxyListener{listener: listener},
+		NewConnectionLimiter(config.RateLimiterConfig{}), use_cases.AuthUseCases{}).
+		WithAuthenticators(use_cases.NewProxyAuthenticators(testProxyAuthenticator{}))
+	go proxyUseCases.ServeOnPort(0)
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = proxyUseCases.Shutdown(ctx)
+	}()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer func() {
+		_ = client.Close()
+	}()
+	// the first bytes of the tunnel are sent along with the request, so they are read ahead by the protocol detection
+	_, err = io.WriteString(client, "CONNECT "+targetListener.Addr().String()+" HTTP/1.1\r\n"+
+		"Host: "+targetListener.Addr().String()+"\r\nProxy-Authorization: Basic dXNlcjpwYXNz\r\n\r\nearly data|")
+	require.NoError(t, err)
+
+	target, err := targetListener.Accept()
+	require.NoError(t, err)
+	defer func() {
+		_ = target.Close()
+	}()
+
+	clientReader := bufio.NewReader(client)
+	response, err := http.ReadResponse(clientReader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	select {
+	case spliceable := <-probe.spliceable:
+		assert.True(t, spliceable, "tunnels of tracked client connections must be spliced")
+	case <-time.After(5 * time.Second):
+		t.Fatal("tunnel was not started")
+	}
+
+	payload := strings.Repeat("x", 2*spliceChunkSize)
+	go func() {
+		_, _ = io.WriteString(client, payload)
+	}()
+	received := make([]byte, len("early data|")+len(payload))
+	_, err = io.ReadFull(target, received)
+	require.NoError(t, err)
+	assert.Equal(t, "early data|"+payload, string(received))
+
+	_, err = target.Write([]byte("pong"))
+	require.NoError(t, err)
+	pong := make([]byte, 4)
+	_, err = io.ReadFull(clientReader, pong)
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(pong))
+}
+
+// BenchmarkTunnel compares downloads through spliced tunnels with the ones copied through buffers.
+func BenchmarkTunnel(b *testing.B) {
+	const chunkSize = 1024 * 1024
+
+	benchmarks := []struct {
+		name string
+		wrap func(clientConn, serverConn net.Conn) (net.Conn, net.Conn)
+	}{
+		{name: "buffered", wrap: func(clientConn, serverConn net.Conn) (net.Conn, net.Conn) {
+			return &opaqueConn{Conn: clientConn}, &opaqueConn{Conn: serverConn}
+		}},
+		{name: "splice", wrap: func(clientConn, serverConn net.Conn) (net.Conn, net.Conn) {
+			return clientConn, serverConn
+		}},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			proxy := newTestProxy(b)
+			client, target, done := startTunnel(b, proxy, bm.wrap)
+			chunk := make([]byte, chunkSize)
+
+			b.SetBytes(chunkSize)
+			b.ResetTimer()
+
+			go func() {
+				for i := 0; i < b.N; i++ {
+					if _, err := target.Write(chunk); err != nil {
+						return
+					}
+				}
+			}()
+			if _, err := io.CopyN(io.Discard, client, int64(b.N)*chunkSize); err != nil {
+				b.Fatal(err)
+			}
+
+			b.StopTimer()
+			_ = client.Close()
+			_ = target.Close()
+			<-done
+		})
+	}
+}
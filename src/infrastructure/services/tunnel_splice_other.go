@@ -0,0 +1,10 @@
+//go:build !linux
+
+package services
+
+import "net"
+
+// spliceTunnel is only supported on Linux, tunnels of other systems are copied through buffers.
+func (p *Proxy) spliceTunnel(net.Conn, net.Conn, *activityWatchdog, *TrafficCounter, int, string) bool {
+	return false
+}
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"goproxy/application/contracts"
+	"goproxy/infrastructure/config"
+	"goproxy/infrastructure/socks5"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	upstreamProxyDialTimeout      = 10 * time.Second
+	upstreamProxyHandshakeTimeout = 10 * time.Second
+)
+
+// CountryUnavailableError means the client asked to leave from a country no parent proxies are configured for.
+type CountryUnavailableError struct {
+	Country string
+}
+
+func (e CountryUnavailableError) Error() string {
+	return fmt.Sprintf("no parent proxies of country %s", e.Country)
+}
+
+// upstreamTargetError means the parent proxy is available, but refused to connect to the target.
+type upstreamTargetError struct {
+	err error
+}
+
+func (e upstreamTargetError) Error() string {
+	return fmt.Sprintf("parent proxy refused to connect: %v", e.err)
+}
+
+func (e upstreamTargetError) Unwrap() error {
+	return e.err
+}
+
+// UpstreamProxyPool chains traffic of selected users through parent HTTP CONNECT or SOCKS5 proxies.
+// Parents of a group are used in round-robin order, unavailable parents are skipped until health check
+// finds them available again.
+type UpstreamProxyPool struct {
+	groups              map[string]*upstreamProxyGroup
+	users               map[int]string
+	plans               map[int]string
+	countries           map[string]string
+	planLimits          contracts.UserPlanLimitsService
+	healthCheckInterval time.Duration
+}
+
+type upstreamProxyGroup struct {
+	name    string
+	parents []*upstreamProxy
+	next    atomic.Uint32
+}
+
+type upstreamProxy struct {
+	url     *url.URL
+	healthy atomic.Bool
+}
+
+func NewUpstreamProxyPool(config config.UpstreamProxyConfig) *UpstreamProxyPool {
+	groups := make(map[string]*upstreamProxyGroup, len(config.Groups))
+	for name, urls := range config.Groups {
+		group := &upstreamProxyGroup{name: name}
+		for _, parentUrl := range urls {
+			parent := &upstreamProxy{url: parentUrl}
+			parent.healthy.Store(true)
+			group.parents = append(group.parents, parent)
+		}
+		groups[name] = group
+	}
+
+	return &UpstreamProxyPool{
+		groups:              groups,
+		users:               config.Users,
+		plans:               config.Plans,
+		countries:           config.Countries,
+		healthCheckInterval: config.HealthCheckInterval,
+	}
+}
+
+// WithPlanLimits enables selecting parent proxies by the user active plan.
+func (u *UpstreamProxyPool) WithPlanLimits(planLimits contracts.UserPlanLimitsService) *UpstreamProxyPool {
+	u.planLimits = planLimits
+	return u
+}
+
+// StartHealthChecks checks availability of all parents every health check interval until ctx is done.
+func (u *UpstreamProxyPool) StartHealthChecks(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(u.healthCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				u.checkHealth()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Dial connects to host through the parents of the country, or the parents assigned to the user if country is empty.
+// False is returned if the user has no parents assigned and host must be dialed directly.
+func (u *UpstreamProxyPool) Dial(dialer contracts.Dialer, userId int, country, host string) (net.Conn, bool, error) {
+	if country != "" {
+		name, ok := u.countries[country]
+		if !ok {
+			return nil, true, CountryUnavailableError{Country: country}
+		}
+		conn, err := u.groups[name].dial(dialer, host)
+		return conn, true, err
+	}
+
+	group, err := u.groupFor(userId)
+	if err != nil {
+		return nil, true, err
+	}
+	if group == nil {
+		return nil, false, nil
+	}
+
+	conn, err := group.dial(dialer, host)
+	return conn, true, err
+}
+
+// groupFor returns the group assigned to the user or the user plan, nil if there is none.
+// Users with unknown plan get an error while groups are assigned to plans, as they could be on one of them.
+func (u *UpstreamProxyPool) groupFor(userId int) (*upstreamProxyGroup, error) {
+	if name, ok := u.users[userId]; ok {
+		return u.groups[name], nil
+	}
+
+	if u.planLimits == nil || len(u.plans) == 0 {
+		return nil, nil
+	}
+
+	planId := u.planLimits.GetLimits(userId).PlanId
+	if planId == 0 {
+		return nil, fmt.Errorf("plan of user %d is not known, parent proxies can not be chosen", userId)
+	}
+	if name, ok := u.plans[planId]; ok {
+		return u.groups[name], nil
+	}
+
+	return nil, nil
+}
+
+func (u *UpstreamProxyPool) checkHealth() {
+	for _, group := range u.groups {
+		for _, parent := range group.parents {
+			conn, err := net.DialTimeout("tcp", parent.url.Host, upstreamProxyDialTimeout)
+			if err == nil {
+				_ = conn.Close()
+			}
+
+			healthy := err == nil
+			if parent.healthy.Swap(healthy) != healthy {
+				log.Printf("parent proxy %s of group %s is available: %t", parent.url.Redacted(), group.name, healthy)
+			}
+		}
+	}
+}
+
+// dial tries available parents first, unavailable ones are tried last as their state could be outdated.
+func (g *upstreamProxyGroup) dial(dialer contracts.Dialer, host string) (net.Conn, error) {
+	start := int(g.next.Add(1))
+	ordered := make([]*upstreamProxy, 0, len(g.parents))
+	var unavailable []*upstreamProxy
+	for i := range g.parents {
+		parent := g.parents[(start+i)%len(g.parents)]
+		if parent.healthy.Load() {
+			ordered = append(ordered, parent)
+		} else {
+			unavailable = append(unavailable, parent)
+		}
+	}
+	ordered = append(ordered, unavailable...)
+
+	var lastErr error
+	for _, parent := range ordered {
+		conn, err := parent.dial(dialer, host)
+		if err == nil {
+			parent.healthy.Store(true)
+			return conn, nil
+		}
+
+		// the parent works, the target is unreachable - other parents are unlikely to reach it either
+		var targetErr upstreamTargetError
+		if errors.As(err, &targetErr) {
+			return nil, err
+		}
+
+		log.Printf("parent proxy %s of group %s failed: %v", parent.url.Redacted(), g.name, err)
+		parent.healthy.Store(false)
+		lastErr = err
+	}
+
+	return nil, fmt.Errorf("all parent proxies of group %s failed, last error: %v", g.name, lastErr)
+}
+
+func (p *upstreamProxy) dial(dialer contracts.Dialer, host string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), upstreamProxyDialTimeout)
+	defer cancel()
+
+	conn, err := dialer.DialContext(ctx, "tcp", p.url.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(upstreamProxyHandshakeTimeout))
+
+	var tunnel net.Conn
+	switch p.url.Scheme {
+	case "socks5":
+		tunnel, err = p.socks5Connect(conn, host)
+	default:
+		tunnel, err = p.httpConnect(conn, host)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	return tunnel, nil
+}
+
+func (p *upstreamProxy) httpConnect(conn net.Conn, host string) (net.Conn, error) {
+	request := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", host, host)
+	if p.url.User != nil {
+		password, _ := p.url.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(p.url.User.Username() + ":" + password))
+		request += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+	request += "\r\n"
+
+	if _, err := io.WriteString(conn, request); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return nil, err
+	}
+	_ = response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusProxyAuthRequired, http.StatusServiceUnavailable:
+		// the parent refused the credentials or is overloaded, other parents could still serve the target
+		return nil, fmt.Errorf("unexpected status: %s", response.Status)
+	default:
+		return nil, upstreamTargetError{err: fmt.Errorf("unexpected status: %s", response.Status)}
+	}
+
+	// the parent could send tunneled bytes right after the response, they are already in reader buffer
+	if reader.Buffered() > 0 {
+		return &upstreamConn{Conn: conn, reader: reader}, nil
+	}
+
+	return conn, nil
+}
+
+func (p *upstreamProxy) socks5Connect(conn net.Conn, host string) (net.Conn, error) {
+	var username, password string
+	if p.url.User != nil {
+		username = p.url.User.Username()
+		password, _ = p.url.User.Password()
+	}
+
+	if _, err := socks5.ClientConnect(conn, username, password, host); err != nil {
+		var replyErr socks5.ReplyError
+		if errors.As(err, &replyErr) {
+			return nil, upstreamTargetError{err: err}
+		}
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// upstreamConn reads bytes buffered during the CONNECT handshake before reading from the connection.
+type upstreamConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *upstreamConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
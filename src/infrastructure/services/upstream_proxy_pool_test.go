@@ -0,0 +1,354 @@
+package services
+
+import (
+	"bufio"
+	"encoding/base64"
+	"goproxy/domain/dataobjects"
+	"goproxy/infrastructure/config"
+	"goproxy/infrastructure/socks5"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startEchoServer starts a TCP server which writes back everything it reads.
+func startEchoServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// startHttpParent starts a parent proxy accepting CONNECT requests with the given basic credentials.
+func startHttpParent(t *testing.T, credentials string, connects *atomic.Int32) *url.URL {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+
+				r, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				connects.Add(1)
+
+				expected := "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+				if r.Method != http.MethodConnect || r.Header.Get("Proxy-Authorization") != expected {
+					_, _ = conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+					return
+				}
+
+				target, err := net.Dial("tcp", r.Host)
+				if err != nil {
+					_, _ = conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\n\r\n"))
+					return
+				}
+				defer target.Close()
+
+				_, _ = conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+				go func() { _, _ = io.Copy(target, conn) }()
+				_, _ = io.Copy(conn, target)
+			}()
+		}
+	}()
+
+	return &url.URL{Scheme: "http", Host: listener.Addr().String(), User: url.UserPassword("parent", "secret")}
+}
+
+// startSocks5Parent starts a parent proxy accepting SOCKS5 CONNECT requests without authentication.
+func startSocks5Parent(t *testing.T, connects *atomic.Int32) *url.URL {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+
+				if _, err := socks5.ReadGreeting(conn); err != nil {
+					return
+				}
+				_ = socks5.WriteMethodSelection(conn, socks5.MethodNoAuth)
+
+				request, err := socks5.ReadRequest(conn)
+				if err != nil {
+					return
+				}
+				connects.Add(1)
+
+				target, err := net.Dial("tcp", request.Address)
+				if err != nil {
+					_ = socks5.WriteReply(conn, socks5.ReplyHostUnreachable, nil)
+					return
+				}
+				defer target.Close()
+
+				_ = socks5.WriteReply(conn, socks5.ReplySucceeded, target.LocalAddr())
+				go func() { _, _ = io.Copy(target, conn) }()
+				_, _ = io.Copy(conn, target)
+			}()
+		}
+	}()
+
+	return &url.URL{Scheme: "socks5", Host: listener.Addr().String()}
+}
+
+// unusedAddress returns an address nobody listens on.
+func unusedAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	_ = listener.Close()
+	return address
+}
+
+func assertEcho(t *testing.T, conn net.Conn) {
+	_, err := conn.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(reply))
+}
+
+func TestUpstreamProxyPool_DialsThroughHttpParent(t *testing.T) {
+	target := startEchoServer(t)
+	var connects atomic.Int32
+	parent := startHttpParent(t, "parent:secret", &connects)
+
+	pool := NewUpstreamProxyPool(config.UpstreamProxyConfig{
+		Groups: map[string][]*url.URL{"http": {parent}},
+		Users:  map[int]string{1: "http"},
+	})
+
+	conn, chained, err := pool.Dial(newEgressDialer(nil, nil), 1, "", target)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	assert.True(t, chained)
+	assertEcho(t, conn)
+	assert.Equal(t, int32(1), connects.Load())
+}
+
+func TestUpstreamProxyPool_DialsThroughSocks5ParentByPlan(t *testing.T) {
+	target := startEchoServer(t)
+	var connects atomic.Int32
+	parent := startSocks5Parent(t, &connects)
+
+	planLimits := newTestUserPlanLimitsService()
+	_ = planLimits.SetLimits(1, dataobjects.UserPlanLimits{PlanId: 7})
+
+	pool := NewUpstreamProxyPool(config.UpstreamProxyConfig{
+		Groups: map[string][]*url.URL{"socks": {parent}},
+		Plans:  map[int]string{7: "socks"},
+	}).WithPlanLimits(planLimits)
+
+	conn, chained, err := pool.Dial(newEgressDialer(nil, nil), 1, "", target)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	assert.True(t, chained)
+	assertEcho(t, conn)
+	assert.Equal(t, int32(1), connects.Load())
+}
+
+func TestUpstreamProxyPool_DialsThroughParentOfCountry(t *testing.T) {
+	target := startEchoServer(t)
+	var userConnects, countryConnects atomic.Int32
+	userParent := startHttpParent(t, "parent:secret", &userConnects)
+	countryParent := startSocks5Parent(t, &countryConnects)
+
+	pool := NewUpstreamProxyPool(config.UpstreamProxyConfig{
+		Groups:    map[string][]*url.URL{"user": {userParent}, "de": {countryParent}},
+		Users:     map[int]string{1: "user"},
+		Countries: map[string]string{"de": "de"},
+	})
+
+	conn, chained, err := pool.Dial(newEgressDialer(nil, nil), 1, "de", target)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	assert.True(t, chained)
+	assertEcho(t, conn)
+	assert.Equal(t, int32(1), countryConnects.Load())
+	assert.Equal(t, int32(0), userConnects.Load())
+
+	_, chained, err = pool.Dial(newEgressDialer(nil, nil), 2, "fr", target)
+	assert.True(t, chained)
+	assert.ErrorAs(t, err, &CountryUnavailableError{})
+}
+
+func TestUpstreamProxyPool_UserWithoutGroupDialsDirectly(t *testing.T) {
+	var connects atomic.Int32
+	parent := startHttpParent(t, "parent:secret", &connects)
+
+	pool := NewUpstreamProxyPool(config.UpstreamProxyConfig{
+		Groups: map[string][]*url.URL{"http": {parent}},
+		Users:  map[int]string{1: "http"},
+	}).WithPlanLimits(newTestUserPlanLimitsService())
+
+	conn, chained, err := pool.Dial(newEgressDialer(nil, nil), 2, "", startEchoServer(t))
+	assert.NoError(t, err)
+	assert.Nil(t, conn)
+	assert.False(t, chained)
+	assert.Equal(t, int32(0), connects.Load())
+}
+
+func TestUpstreamProxyPool_FailsOverToAvailableParent(t *testing.T) {
+	target := startEchoServer(t)
+	var connects atomic.Int32
+	dead := &url.URL{Scheme: "http", Host: unusedAddress(t)}
+	alive := startSocks5Parent(t, &connects)
+
+	pool := NewUpstreamProxyPool(config.UpstreamProxyConfig{
+		Groups: map[string][]*url.URL{"group": {dead, alive}},
+		Users:  map[int]string{1: "group"},
+	})
+
+	for i := 0; i < 3; i++ {
+		conn, _, err := pool.Dial(newEgressDialer(nil, nil), 1, "", target)
+		require.NoError(t, err)
+		assertEcho(t, conn)
+		_ = conn.Close()
+	}
+
+	assert.Equal(t, int32(3), connects.Load())
+	assert.False(t, pool.groups["group"].parents[0].healthy.Load())
+	assert.True(t, pool.groups["group"].parents[1].healthy.Load())
+}
+
+func TestUpstreamProxyPool_DoesNotFailOverWhenTargetIsRefused(t *testing.T) {
+	var firstConnects, secondConnects atomic.Int32
+	first := startHttpParent(t, "parent:secret", &firstConnects)
+	second := startHttpParent(t, "parent:secret", &secondConnects)
+
+	pool := NewUpstreamProxyPool(config.UpstreamProxyConfig{
+		Groups: map[string][]*url.URL{"group": {first, second}},
+		Users:  map[int]string{1: "group"},
+	})
+
+	_, chained, err := pool.Dial(newEgressDialer(nil, nil), 1, "", unusedAddress(t))
+	assert.True(t, chained)
+	assert.ErrorAs(t, err, &upstreamTargetError{})
+	assert.Equal(t, int32(1), firstConnects.Load()+secondConnects.Load())
+	assert.True(t, pool.groups["group"].parents[0].healthy.Load())
+	assert.True(t, pool.groups["group"].parents[1].healthy.Load())
+}
+
+// startRefusingHttpParent starts a parent proxy answering every CONNECT request with the status line.
+func startRefusingHttpParent(t *testing.T, status string) *url.URL {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+
+				if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+					return
+				}
+				_, _ = conn.Write([]byte("HTTP/1.1 " + status + "\r\n\r\n"))
+			}()
+		}
+	}()
+
+	return &url.URL{Scheme: "http", Host: listener.Addr().String()}
+}
+
+func TestUpstreamProxyPool_FailsOverWhenParentRefusesClient(t *testing.T) {
+	for _, status := range []string{"407 Proxy Authentication Required", "503 Service Unavailable"} {
+		t.Run(status, func(t *testing.T) {
+			target := startEchoServer(t)
+			var connects atomic.Int32
+			refusing := startRefusingHttpParent(t, status)
+			alive := startSocks5Parent(t, &connects)
+
+			pool := NewUpstreamProxyPool(config.UpstreamProxyConfig{
+				Groups: map[string][]*url.URL{"group": {refusing, alive}},
+				Users:  map[int]string{1: "group"},
+			})
+			// the refusing parent is tried first
+			pool.groups["group"].next.Store(uint32(len(pool.groups["group"].parents) - 1))
+
+			conn, _, err := pool.Dial(newEgressDialer(nil, nil), 1, "", target)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			assertEcho(t, conn)
+			assert.False(t, pool.groups["group"].parents[0].healthy.Load())
+			assert.Equal(t, int32(1), connects.Load())
+		})
+	}
+}
+
+func TestUpstreamProxyPool_UserWithUnknownPlanDoesNotDialDirectly(t *testing.T) {
+	var connects atomic.Int32
+	parent := startHttpParent(t, "parent:secret", &connects)
+
+	pool := NewUpstreamProxyPool(config.UpstreamProxyConfig{
+		Groups: map[string][]*url.URL{"http": {parent}},
+		Plans:  map[int]string{7: "http"},
+	}).WithPlanLimits(newTestUserPlanLimitsService())
+
+	conn, chained, err := pool.Dial(newEgressDialer(nil, nil), 1, "", startEchoServer(t))
+	assert.Error(t, err)
+	assert.Nil(t, conn)
+	assert.True(t, chained)
+	assert.Equal(t, int32(0), connects.Load())
+}
+
+func TestUpstreamProxyPool_HealthCheckRestoresParent(t *testing.T) {
+	var connects atomic.Int32
+	dead := &url.URL{Scheme: "http", Host: unusedAddress(t)}
+	alive := startHttpParent(t, "parent:secret", &connects)
+
+	pool := NewUpstreamProxyPool(config.UpstreamProxyConfig{
+		Groups: map[string][]*url.URL{"group": {dead, alive}},
+	})
+	pool.groups["group"].parents[1].healthy.Store(false)
+
+	pool.checkHealth()
+
+	assert.False(t, pool.groups["group"].parents[0].healthy.Load())
+	assert.True(t, pool.groups["group"].parents[1].healthy.Load())
+}
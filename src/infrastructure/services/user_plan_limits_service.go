@@ -0,0 +1,135 @@
+package services
+
+import (
+	"fmt"
+	"goproxy/application/contracts"
+	"goproxy/domain/dataobjects"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// userPlanLimitsRefreshInterval defines how fast changes made by other proxy nodes become visible.
+	userPlanLimitsRefreshInterval = time.Second * 15
+	// userPlanLimitsLocalTTL defines how long limits of idle users are kept locally.
+	userPlanLimitsLocalTTL = time.Hour
+	// userPlanLimitsLoadTimeout bounds how long GetLimits waits for limits unknown locally.
+	userPlanLimitsLoadTimeout = time.Millisecond * 100
+)
+
+// cachedUserPlanLimits are limits known locally, they are reloaded from the remote cache after RefreshAt.
+type cachedUserPlanLimits struct {
+	Limits    dataobjects.UserPlanLimits
+	RefreshAt time.Time
+}
+
+// UserPlanLimitsService keeps limits of users active plans.
+// Limits are stored in a remote cache shared by proxy nodes and cached locally,
+// local values are refreshed every userPlanLimitsRefreshInterval.
+type UserPlanLimitsService struct {
+	localCache  contracts.CacheWithTTL[cachedUserPlanLimits]
+	remoteCache contracts.CacheWithTTL[dataobjects.UserPlanLimits]
+	loading     sync.Map
+}
+
+func NewUserPlanLimitsService() *UserPlanLimitsService {
+	remoteCache, redisCacheErr := NewRedisCache[dataobjects.UserPlanLimits]()
+	if redisCacheErr != nil {
+		log.Fatalf("failed to initialize redis cache: %v", redisCacheErr)
+	}
+
+	return &UserPlanLimitsService{
+		localCache:  NewMapCacheWithTTL[cachedUserPlanLimits](),
+		remoteCache: remoteCache,
+	}
+}
+
+// GetLimits returns the last known limits and refreshes them in background once they are stale.
+// Limits unknown locally are loaded from the remote cache, waiting at most userPlanLimitsLoadTimeout.
+func (u *UserPlanLimitsService) GetLimits(userId int) dataobjects.UserPlanLimits {
+	key := u.userIdToKey(userId)
+
+	if cached, err := u.localCache.Get(key); err == nil {
+		if time.Now().After(cached.RefreshAt) {
+			u.load(userId, key)
+		}
+
+		return cached.Limits
+	}
+
+	select {
+	case <-u.load(userId, key):
+	case <-time.After(userPlanLimitsLoadTimeout):
+		log.Printf("Timed out loading user %d plan limits", userId)
+		return dataobjects.UserPlanLimits{}
+	}
+
+	cached, err := u.localCache.Get(key)
+	if err != nil {
+		return dataobjects.UserPlanLimits{}
+	}
+
+	return cached.Limits
+}
+
+func (u *UserPlanLimitsService) SetLimits(userId int, limits dataobjects.UserPlanLimits) error {
+	key := u.userIdToKey(userId)
+
+	setLocalErr := u.setToLocalCache(key, limits)
+	if setLocalErr != nil {
+		return fmt.Errorf("failed to set to local cache: %v", setLocalErr)
+	}
+
+	// remote record is kept until next plan limits change
+	setRemoteErr := u.remoteCache.Set(key, limits)
+	if setRemoteErr != nil {
+		return fmt.Errorf("failed to set to remote cache: %v", setRemoteErr)
+	}
+
+	return nil
+}
+
+// load reads limits from the remote cache in background, the returned channel is closed once it is done.
+// Only one load per user runs at a time, on remote cache errors the last known limits are kept.
+func (u *UserPlanLimitsService) load(userId int, key string) <-chan struct{} {
+	done := make(chan struct{})
+	if loading, loaded := u.loading.LoadOrStore(key, done); loaded {
+		return loading.(chan struct{})
+	}
+
+	go func() {
+		defer close(done)
+		defer u.loading.Delete(key)
+
+		limits, err := u.remoteCache.Get(key)
+		if err != nil {
+			if !strings.Contains(err.Error(), "not found") {
+				log.Printf("Error accessing Redis for user %d plan limits: %v", userId, err)
+				return
+			}
+
+			limits = dataobjects.UserPlanLimits{}
+		}
+		_ = u.setToLocalCache(key, limits)
+	}()
+
+	return done
+}
+
+func (u *UserPlanLimitsService) setToLocalCache(key string, limits dataobjects.UserPlanLimits) error {
+	setErr := u.localCache.Set(key, cachedUserPlanLimits{
+		Limits:    limits,
+		RefreshAt: time.Now().Add(userPlanLimitsRefreshInterval),
+	})
+	if setErr != nil {
+		return setErr
+	}
+
+	return u.localCache.Expire(key, userPlanLimitsLocalTTL)
+}
+
+func (u *UserPlanLimitsService) userIdToKey(userId int) string {
+	return fmt.Sprintf("user:%d:plan_limits", userId)
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"errors"
+	"goproxy/domain/dataobjects"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestUserPlanLimitsService() *UserPlanLimitsService {
+	return &UserPlanLimitsService{
+		localCache:  NewMapCacheWithTTL[cachedUserPlanLimits](),
+		remoteCache: NewMapCacheWithTTL[dataobjects.UserPlanLimits](),
+	}
+}
+
+func TestUserPlanLimitsService_SetLimits(t *testing.T) {
+	service := newTestUserPlanLimitsService()
+	limits := dataobjects.UserPlanLimits{MaxBytesPerSecond: 125000, MaxConnections: 5}
+
+	assert.NoError(t, service.SetLimits(1, limits))
+	assert.Equal(t, limits, service.GetLimits(1))
+
+	remote, err := service.remoteCache.Get(service.userIdToKey(1))
+	assert.NoError(t, err)
+	assert.Equal(t, limits, remote)
+}
+
+func TestUserPlanLimitsService_LoadsFromRemoteCache(t *testing.T) {
+	service := newTestUserPlanLimitsService()
+	limits := dataobjects.UserPlanLimits{PlanId: 2, MaxBytesPerSecond: 125000, MaxConnections: 5}
+	_ = service.remoteCache.Set(service.userIdToKey(1), limits)
+
+	// limits set by another proxy node are loaded on first use
+	assert.Equal(t, limits, service.GetLimits(1))
+}
+
+func TestUserPlanLimitsService_ServesStaleLimitsWhileRefreshing(t *testing.T) {
+	service := newTestUserPlanLimitsService()
+	stale := dataobjects.UserPlanLimits{PlanId: 2, MaxBytesPerSecond: 125000, MaxConnections: 5}
+	fresh := dataobjects.UserPlanLimits{PlanId: 3, MaxBytesPerSecond: 250000, MaxConnections: 10}
+	key := service.userIdToKey(1)
+	_ = service.localCache.Set(key, cachedUserPlanLimits{Limits: stale, RefreshAt: time.Now().Add(-time.Second)})
+	_ = service.remoteCache.Set(key, fresh)
+
+	assert.Equal(t, stale, service.GetLimits(1))
+
+	assert.Eventually(t, func() bool {
+		return service.GetLimits(1) == fresh
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestUserPlanLimitsService_KeepsLimitsOnRemoteCacheError(t *testing.T) {
+	service := newTestUserPlanLimitsService()
+	service.remoteCache = failingUserPlanLimitsCache{}
+	limits := dataobjects.UserPlanLimits{PlanId: 2, MaxBytesPerSecond: 125000, MaxConnections: 5}
+	key := service.userIdToKey(1)
+	_ = service.localCache.Set(key, cachedUserPlanLimits{Limits: limits, RefreshAt: time.Now().Add(-time.Second)})
+
+	<-service.load(1, key)
+
+	assert.Equal(t, limits, service.GetLimits(1))
+	assert.Equal(t, dataobjects.UserPlanLimits{}, service.GetLimits(2))
+}
+
+type failingUserPlanLimitsCache struct{}
+
+func (failingUserPlanLimitsCache) Get(string) (dataobjects.UserPlanLimits, error) {
+	return dataobjects.UserPlanLimits{}, errors.New("connection refused")
+}
+
+func (failingUserPlanLimitsCache) Set(string, dataobjects.UserPlanLimits) error {
+	return errors.New("connection refused")
+}
+
+func (failingUserPlanLimitsCache) Delete(string) error {
+	return errors.New("connection refused")
+}
+
+func (failingUserPlanLimitsCache) Expire(string, time.Duration) error {
+	return errors.New("connection refused")
+}
@@ -1,7 +1,9 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"goproxy/application/contracts"
 	"goproxy/domain"
@@ -117,7 +119,8 @@ func (u *UserRestrictionService) RemoveFromRestrictionList(user aggregates.User)
 	return nil
 }
 
-func (u *UserRestrictionService) ProcessEvents() {
+// ProcessEvents handles restriction events until ctx is done.
+func (u *UserRestrictionService) ProcessEvents(ctx context.Context) {
 	defer func(messageBus contracts.MessageBusService) {
 		_ = messageBus.Close()
 	}(u.messageBus)
@@ -130,8 +133,11 @@ func (u *UserRestrictionService) ProcessEvents() {
 
 	log.Printf("Subscribed to topics: %s", strings.Join(topics, ", "))
 
-	for {
+	for ctx.Err() == nil {
 		event, consumeErr := u.messageBus.Consume()
+		if errors.Is(consumeErr, contracts.ErrNoEvent) {
+			continue
+		}
 		if consumeErr != nil {
 			log.Printf("failed to consume from message bus: %s", consumeErr)
 		}
@@ -0,0 +1,104 @@
+package socks4
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+)
+
+// Protocol constants of SOCKS4 and its SOCKS4a extension.
+const (
+	Version = 0x04
+
+	CmdConnect = 0x01
+	CmdBind    = 0x02
+
+	ReplyVersion  = 0x00
+	ReplyGranted  = 0x5A
+	ReplyRejected = 0x5B
+
+	maxFieldLength = 255
+)
+
+var (
+	ErrInvalidVersion = errors.New("socks4: invalid protocol version")
+	ErrFieldTooLong   = errors.New("socks4: field exceeds maximum length")
+)
+
+// Request is a SOCKS4 or SOCKS4a client request.
+type Request struct {
+	Command byte
+	// Address is a target in host:port form. For SOCKS4a requests the host is a domain name.
+	Address string
+	UserId  string
+}
+
+// ReadRequest reads a SOCKS4 request, resolving the SOCKS4a domain form when DSTIP is 0.0.0.x.
+func ReadRequest(r io.Reader) (Request, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return Request{}, err
+	}
+
+	if header[0] != Version {
+		return Request{}, ErrInvalidVersion
+	}
+
+	port := binary.BigEndian.Uint16(header[2:4])
+	ip := net.IP(header[4:8])
+
+	userId, err := readNullTerminated(r)
+	if err != nil {
+		return Request{}, err
+	}
+
+	host := ip.String()
+	if ip[0] == 0 && ip[1] == 0 && ip[2] == 0 && ip[3] != 0 {
+		host, err = readNullTerminated(r)
+		if err != nil {
+			return Request{}, err
+		}
+	}
+
+	return Request{
+		Command: header[1],
+		Address: net.JoinHostPort(host, strconv.Itoa(int(port))),
+		UserId:  userId,
+	}, nil
+}
+
+// WriteReply writes a SOCKS4 reply. A nil address is sent as 0.0.0.0:0.
+func WriteReply(w io.Writer, reply byte, boundAddr *net.TCPAddr) error {
+	buf := []byte{ReplyVersion, reply, 0, 0, 0, 0, 0, 0}
+	if boundAddr != nil {
+		binary.BigEndian.PutUint16(buf[2:4], uint16(boundAddr.Port))
+		if ip4 := boundAddr.IP.To4(); ip4 != nil {
+			copy(buf[4:8], ip4)
+		}
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// readNullTerminated reads a null-terminated field byte by byte, so no data after the terminator is consumed.
+func readNullTerminated(r io.Reader) (string, error) {
+	buf := make([]byte, 0, 32)
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+
+		if b[0] == 0 {
+			return string(buf), nil
+		}
+
+		if len(buf) == maxFieldLength {
+			return "", ErrFieldTooLong
+		}
+		buf = append(buf, b[0])
+	}
+}
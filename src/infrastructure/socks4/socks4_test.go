@@ -0,0 +1,58 @@
+package socks4
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadRequest_Socks4(t *testing.T) {
+	payload := []byte{Version, CmdConnect, 0x01, 0xBB, 93, 184, 216, 34}
+	payload = append(payload, "alice:secret"...)
+	payload = append(payload, 0)
+
+	request, err := ReadRequest(bytes.NewReader(payload))
+	assert.NoError(t, err)
+	assert.Equal(t, Request{Command: CmdConnect, Address: "93.184.216.34:443", UserId: "alice:secret"}, request)
+}
+
+func TestReadRequest_Socks4a(t *testing.T) {
+	payload := []byte{Version, CmdConnect, 0x00, 0x50, 0, 0, 0, 1}
+	payload = append(payload, "alice:secret"...)
+	payload = append(payload, 0)
+	payload = append(payload, "example.com"...)
+	payload = append(payload, 0)
+	payload = append(payload, "GET"...)
+
+	reader := bytes.NewReader(payload)
+	request, err := ReadRequest(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, Request{Command: CmdConnect, Address: "example.com:80", UserId: "alice:secret"}, request)
+
+	// data sent after the request must stay unread
+	assert.Equal(t, 3, reader.Len())
+}
+
+func TestReadRequest_Invalid(t *testing.T) {
+	_, err := ReadRequest(bytes.NewReader([]byte{0x05, CmdConnect, 0, 80, 1, 1, 1, 1, 0}))
+	assert.ErrorIs(t, err, ErrInvalidVersion)
+
+	payload := []byte{Version, CmdConnect, 0, 80, 1, 1, 1, 1}
+	payload = append(payload, bytes.Repeat([]byte{'a'}, maxFieldLength+1)...)
+	_, err = ReadRequest(bytes.NewReader(payload))
+	assert.ErrorIs(t, err, ErrFieldTooLong)
+}
+
+func TestWriteReply(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteReply(&buf, ReplyGranted, &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1080})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{ReplyVersion, ReplyGranted, 0x04, 0x38, 10, 0, 0, 1}, buf.Bytes())
+
+	buf.Reset()
+	err = WriteReply(&buf, ReplyRejected, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{ReplyVersion, ReplyRejected, 0, 0, 0, 0, 0, 0}, buf.Bytes())
+}
@@ -0,0 +1,382 @@
+package socks5
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// Protocol constants as defined by RFC 1928 and RFC 1929.
+const (
+	Version = 0x05
+
+	MethodNoAuth           = 0x00
+	MethodUsernamePassword = 0x02
+	MethodNoAcceptable     = 0xFF
+
+	CmdConnect      = 0x01
+	CmdBind         = 0x02
+	CmdUdpAssociate = 0x03
+
+	AddrTypeIPv4   = 0x01
+	AddrTypeDomain = 0x03
+	AddrTypeIPv6   = 0x04
+
+	ReplySucceeded               = 0x00
+	ReplyGeneralFailure          = 0x01
+	ReplyNotAllowed              = 0x02
+	ReplyNetworkUnreachable      = 0x03
+	ReplyHostUnreachable         = 0x04
+	ReplyConnectionRefused       = 0x05
+	ReplyTTLExpired              = 0x06
+	ReplyCommandNotSupported     = 0x07
+	ReplyAddressTypeNotSupported = 0x08
+
+	AuthVersion = 0x01
+	AuthSuccess = 0x00
+	AuthFailure = 0x01
+)
+
+var (
+	ErrInvalidVersion         = errors.New("socks5: invalid protocol version")
+	ErrInvalidAuthVersion     = errors.New("socks5: invalid username/password auth version")
+	ErrAddressTypeUnsupported = errors.New("socks5: address type not supported")
+	ErrFragmentedDatagram     = errors.New("socks5: fragmented udp datagrams are not supported")
+	ErrShortDatagram          = errors.New("socks5: udp datagram is too short")
+	ErrDomainTooLong          = errors.New("socks5: domain name exceeds 255 bytes")
+	ErrAuthFailed             = errors.New("socks5: username/password authentication failed")
+	ErrNoAcceptableMethods    = errors.New("socks5: no acceptable authentication methods")
+)
+
+// ReplyError is returned by client side functions when the server replies with a failure.
+type ReplyError struct {
+	Reply byte
+}
+
+func (e ReplyError) Error() string {
+	return fmt.Sprintf("socks5: request failed with reply %d", e.Reply)
+}
+
+// Request is a client request sent after method negotiation.
+type Request struct {
+	Command byte
+	// Address is a target in host:port form.
+	Address string
+}
+
+// ReadGreeting reads the client greeting and returns the authentication methods offered by the client.
+func ReadGreeting(r io.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	if header[0] != Version {
+		return nil, ErrInvalidVersion
+	}
+
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return nil, err
+	}
+
+	return methods, nil
+}
+
+// WriteMethodSelection tells the client which authentication method was selected.
+func WriteMethodSelection(w io.Writer, method byte) error {
+	_, err := w.Write([]byte{Version, method})
+	return err
+}
+
+// ReadUsernamePassword reads the RFC 1929 username/password sub-negotiation request.
+func ReadUsernamePassword(r io.Reader) (string, string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", "", err
+	}
+
+	if header[0] != AuthVersion {
+		return "", "", ErrInvalidAuthVersion
+	}
+
+	username := make([]byte, header[1])
+	if _, err := io.ReadFull(r, username); err != nil {
+		return "", "", err
+	}
+
+	passwordLength := make([]byte, 1)
+	if _, err := io.ReadFull(r, passwordLength); err != nil {
+		return "", "", err
+	}
+
+	password := make([]byte, passwordLength[0])
+	if _, err := io.ReadFull(r, password); err != nil {
+		return "", "", err
+	}
+
+	return string(username), string(password), nil
+}
+
+// WriteAuthStatus writes the RFC 1929 sub-negotiation status.
+func WriteAuthStatus(w io.Writer, success bool) error {
+	status := byte(AuthFailure)
+	if success {
+		status = AuthSuccess
+	}
+
+	_, err := w.Write([]byte{AuthVersion, status})
+	return err
+}
+
+// ReadRequest reads a client request. Unsupported address types are returned as ErrAddressTypeUnsupported.
+func ReadRequest(r io.Reader) (Request, error) {
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return Request{}, err
+	}
+
+	if header[0] != Version {
+		return Request{}, ErrInvalidVersion
+	}
+
+	address, err := ReadAddress(r)
+	if err != nil {
+		return Request{}, err
+	}
+
+	return Request{
+		Command: header[1],
+		Address: address,
+	}, nil
+}
+
+// WriteReply writes a server reply with the given bound address. A nil address is sent as 0.0.0.0:0.
+func WriteReply(w io.Writer, reply byte, boundAddr net.Addr) error {
+	buf := []byte{Version, reply, 0x00}
+	buf, err := AppendAddress(buf, boundAddr)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(buf)
+	return err
+}
+
+// ReadAddress reads ATYP, DST.ADDR and DST.PORT fields and returns them in host:port form.
+func ReadAddress(r io.Reader) (string, error) {
+	addrType := make([]byte, 1)
+	if _, err := io.ReadFull(r, addrType); err != nil {
+		return "", err
+	}
+
+	var host string
+	switch addrType[0] {
+	case AddrTypeIPv4:
+		ip := make([]byte, net.IPv4len)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case AddrTypeIPv6:
+		ip := make([]byte, net.IPv6len)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case AddrTypeDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		return "", ErrAddressTypeUnsupported
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// AppendAddress appends ATYP, ADDR and PORT fields for addr to buf.
+// Supported addr types are *net.TCPAddr, *net.UDPAddr and nil.
+func AppendAddress(buf []byte, addr net.Addr) ([]byte, error) {
+	var ip net.IP
+	var port int
+
+	switch a := addr.(type) {
+	case nil:
+	case *net.TCPAddr:
+		if a != nil {
+			ip, port = a.IP, a.Port
+		}
+	case *net.UDPAddr:
+		if a != nil {
+			ip, port = a.IP, a.Port
+		}
+	default:
+		return nil, fmt.Errorf("socks5: unsupported address %T", addr)
+	}
+
+	if ip4 := ip.To4(); ip4 != nil || ip == nil {
+		if ip4 == nil {
+			ip4 = net.IPv4zero.To4()
+		}
+		buf = append(buf, AddrTypeIPv4)
+		buf = append(buf, ip4...)
+	} else {
+		buf = append(buf, AddrTypeIPv6)
+		buf = append(buf, ip.To16()...)
+	}
+
+	return binary.BigEndian.AppendUint16(buf, uint16(port)), nil
+}
+
+// AppendHostPort appends ATYP, ADDR and PORT fields for an address in host:port form, where host is an IP or a domain.
+func AppendHostPort(buf []byte, address string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("socks5: invalid port %s", portStr)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return AppendAddress(buf, &net.TCPAddr{IP: ip, Port: int(port)})
+	}
+
+	if len(host) > 255 {
+		return nil, ErrDomainTooLong
+	}
+
+	buf = append(buf, AddrTypeDomain, byte(len(host)))
+	buf = append(buf, host...)
+	return binary.BigEndian.AppendUint16(buf, uint16(port)), nil
+}
+
+// ClientConnect performs the client side of the handshake: negotiates authentication (username/password
+// if username is not empty, no authentication otherwise) and requests CONNECT to address.
+// The bound address reported by the server is returned.
+func ClientConnect(rw io.ReadWriter, username, password, address string) (string, error) {
+	method := byte(MethodNoAuth)
+	if username != "" {
+		method = MethodUsernamePassword
+	}
+
+	if _, err := rw.Write([]byte{Version, 1, method}); err != nil {
+		return "", err
+	}
+
+	selection := make([]byte, 2)
+	if _, err := io.ReadFull(rw, selection); err != nil {
+		return "", err
+	}
+	if selection[0] != Version {
+		return "", ErrInvalidVersion
+	}
+	if selection[1] != method {
+		return "", ErrNoAcceptableMethods
+	}
+
+	if method == MethodUsernamePassword {
+		if err := clientAuthenticate(rw, username, password); err != nil {
+			return "", err
+		}
+	}
+
+	request, err := AppendHostPort([]byte{Version, CmdConnect, 0x00}, address)
+	if err != nil {
+		return "", err
+	}
+	if _, err = rw.Write(request); err != nil {
+		return "", err
+	}
+
+	reply := make([]byte, 3)
+	if _, err = io.ReadFull(rw, reply); err != nil {
+		return "", err
+	}
+	if reply[0] != Version {
+		return "", ErrInvalidVersion
+	}
+	if reply[1] != ReplySucceeded {
+		return "", ReplyError{Reply: reply[1]}
+	}
+
+	return ReadAddress(rw)
+}
+
+func clientAuthenticate(rw io.ReadWriter, username, password string) error {
+	if len(username) > 255 || len(password) > 255 {
+		return fmt.Errorf("socks5: username and password must not exceed 255 bytes")
+	}
+
+	auth := make([]byte, 0, 3+len(username)+len(password))
+	auth = append(auth, AuthVersion, byte(len(username)))
+	auth = append(auth, username...)
+	auth = append(auth, byte(len(password)))
+	auth = append(auth, password...)
+	if _, err := rw.Write(auth); err != nil {
+		return err
+	}
+
+	status := make([]byte, 2)
+	if _, err := io.ReadFull(rw, status); err != nil {
+		return err
+	}
+	if status[1] != AuthSuccess {
+		return ErrAuthFailed
+	}
+
+	return nil
+}
+
+// ParseDatagram parses a UDP ASSOCIATE datagram and returns its destination and payload.
+func ParseDatagram(datagram []byte) (string, []byte, error) {
+	if len(datagram) < 4 {
+		return "", nil, ErrShortDatagram
+	}
+
+	if datagram[2] != 0x00 {
+		return "", nil, ErrFragmentedDatagram
+	}
+
+	reader := bytes.NewReader(datagram[3:])
+	address, err := ReadAddress(reader)
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return "", nil, ErrShortDatagram
+		}
+		return "", nil, err
+	}
+
+	return address, datagram[len(datagram)-reader.Len():], nil
+}
+
+// BuildDatagram prepends a UDP ASSOCIATE header with the source address to payload.
+func BuildDatagram(source *net.UDPAddr, payload []byte) ([]byte, error) {
+	buf := make([]byte, 0, 3+1+net.IPv6len+2+len(payload))
+	buf = append(buf, 0x00, 0x00, 0x00)
+
+	buf, err := AppendAddress(buf, source)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(buf, payload...), nil
+}
@@ -0,0 +1,170 @@
+package socks5
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadGreeting(t *testing.T) {
+	methods, err := ReadGreeting(bytes.NewReader([]byte{Version, 2, MethodNoAuth, MethodUsernamePassword}))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{MethodNoAuth, MethodUsernamePassword}, methods)
+
+	_, err = ReadGreeting(bytes.NewReader([]byte{0x04, 1, MethodNoAuth}))
+	assert.ErrorIs(t, err, ErrInvalidVersion)
+}
+
+func TestReadUsernamePassword(t *testing.T) {
+	payload := []byte{AuthVersion, 5}
+	payload = append(payload, "alice"...)
+	payload = append(payload, 6)
+	payload = append(payload, "secret"...)
+
+	username, password, err := ReadUsernamePassword(bytes.NewReader(payload))
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", username)
+	assert.Equal(t, "secret", password)
+
+	_, _, err = ReadUsernamePassword(bytes.NewReader([]byte{0x05, 0}))
+	assert.ErrorIs(t, err, ErrInvalidAuthVersion)
+}
+
+func TestReadRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  []byte
+		expected Request
+	}{
+		{
+			name:     "IPv4",
+			payload:  []byte{Version, CmdConnect, 0, AddrTypeIPv4, 93, 184, 216, 34, 0x01, 0xBB},
+			expected: Request{Command: CmdConnect, Address: "93.184.216.34:443"},
+		},
+		{
+			name:     "Domain",
+			payload:  append(append([]byte{Version, CmdConnect, 0, AddrTypeDomain, 11}, "example.com"...), 0x00, 0x50),
+			expected: Request{Command: CmdConnect, Address: "example.com:80"},
+		},
+		{
+			name: "IPv6",
+			payload: append(append([]byte{Version, CmdUdpAssociate, 0, AddrTypeIPv6},
+				net.ParseIP("2001:db8::1").To16()...), 0x00, 0x35),
+			expected: Request{Command: CmdUdpAssociate, Address: "[2001:db8::1]:53"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, err := ReadRequest(bytes.NewReader(tt.payload))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, request)
+		})
+	}
+
+	_, err := ReadRequest(bytes.NewReader([]byte{Version, CmdConnect, 0, 0x09}))
+	assert.ErrorIs(t, err, ErrAddressTypeUnsupported)
+}
+
+func TestWriteReply(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteReply(&buf, ReplySucceeded, &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1080})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{Version, ReplySucceeded, 0, AddrTypeIPv4, 10, 0, 0, 1, 0x04, 0x38}, buf.Bytes())
+
+	buf.Reset()
+	err = WriteReply(&buf, ReplyHostUnreachable, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{Version, ReplyHostUnreachable, 0, AddrTypeIPv4, 0, 0, 0, 0, 0, 0}, buf.Bytes())
+}
+
+func TestDatagramRoundTrip(t *testing.T) {
+	source := &net.UDPAddr{IP: net.ParseIP("8.8.8.8"), Port: 53}
+	datagram, err := BuildDatagram(source, []byte("payload"))
+	assert.NoError(t, err)
+
+	target, payload, err := ParseDatagram(datagram)
+	assert.NoError(t, err)
+	assert.Equal(t, "8.8.8.8:53", target)
+	assert.Equal(t, []byte("payload"), payload)
+}
+
+func TestParseDatagram_Invalid(t *testing.T) {
+	_, _, err := ParseDatagram([]byte{0, 0})
+	assert.ErrorIs(t, err, ErrShortDatagram)
+
+	_, _, err = ParseDatagram([]byte{0, 0, 1, AddrTypeIPv4, 1, 1, 1, 1, 0, 53})
+	assert.ErrorIs(t, err, ErrFragmentedDatagram)
+
+	_, _, err = ParseDatagram([]byte{0, 0, 0, AddrTypeIPv4, 1, 1})
+	assert.ErrorIs(t, err, ErrShortDatagram)
+}
+
+func TestAppendHostPort(t *testing.T) {
+	buf, err := AppendHostPort(nil, "example.com:443")
+	assert.NoError(t, err)
+	assert.Equal(t, append(append([]byte{AddrTypeDomain, 11}, "example.com"...), 0x01, 0xBB), buf)
+
+	buf, err = AppendHostPort(nil, "10.0.0.1:80")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{AddrTypeIPv4, 10, 0, 0, 1, 0x00, 0x50}, buf)
+
+	_, err = AppendHostPort(nil, "example.com")
+	assert.Error(t, err)
+}
+
+func TestClientConnect(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() {
+		_ = client.Close()
+	}()
+
+	go func() {
+		defer func() {
+			_ = server.Close()
+		}()
+
+		methods, _ := ReadGreeting(server)
+		if len(methods) != 1 || methods[0] != MethodUsernamePassword {
+			return
+		}
+		_ = WriteMethodSelection(server, MethodUsernamePassword)
+
+		username, password, _ := ReadUsernamePassword(server)
+		_ = WriteAuthStatus(server, username == "alice" && password == "secret")
+
+		request, _ := ReadRequest(server)
+		if request.Address != "example.com:443" {
+			_ = WriteReply(server, ReplyNotAllowed, nil)
+			return
+		}
+		_ = WriteReply(server, ReplySucceeded, &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 40000})
+	}()
+
+	boundAddr, err := ClientConnect(client, "alice", "secret", "example.com:443")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1:40000", boundAddr)
+}
+
+func TestClientConnect_Failures(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() {
+		_ = client.Close()
+	}()
+
+	go func() {
+		defer func() {
+			_ = server.Close()
+		}()
+
+		_, _ = ReadGreeting(server)
+		_ = WriteMethodSelection(server, MethodNoAuth)
+		_, _ = ReadRequest(server)
+		_ = WriteReply(server, ReplyConnectionRefused, nil)
+	}()
+
+	_, err := ClientConnect(client, "", "", "example.com:443")
+	assert.Equal(t, ReplyError{Reply: ReplyConnectionRefused}, err)
+}
@@ -22,7 +22,7 @@ func (app *App) Run(ctx context.Context) error {
 	case "migrator":
 		modules.NewMigrator().MigrateDb()
 	case "proxy":
-		modules.NewProxy().Start()
+		modules.NewProxy().Start(ctx)
 	case "rest-api":
 		modules.NewUsersApi().Start()
 	case "plan-controller":
@@ -1,6 +1,7 @@
 package modules
 
 import (
+	"context"
 	"database/sql"
 	"goproxy/application/use_cases"
 	"goproxy/dal"
@@ -8,6 +9,7 @@ import (
 	"goproxy/dal/repositories"
 	"goproxy/domain"
 	"goproxy/domain/aggregates"
+	"goproxy/domain/dataobjects"
 	"goproxy/infrastructure/api/api-http/google_auth"
 	"goproxy/infrastructure/config"
 	"goproxy/infrastructure/eventhandlers/UserPasswordChangedEvent"
@@ -49,7 +51,7 @@ func (api *GoogleAuthAPI) Start() {
 	}
 
 	eventHandleErr := UserPasswordChangedEvent.NewUserPasswordChangedEventProcessor[aggregates.User](domain.PROXY, userRepositoryCache).
-		ProcessEvents()
+		ProcessEvents(context.Background())
 	if eventHandleErr != nil {
 		log.Fatal(eventHandleErr)
 	}
@@ -57,7 +59,23 @@ func (api *GoogleAuthAPI) Start() {
 	userRepo := repositories.NewUserRepository(db, userRepositoryCache)
 	cryptoService := services.GetCryptoService()
 	userUseCases := use_cases.NewUserUseCases(userRepo, cryptoService)
-	authService := google_auth.NewGoogleAuthService(userUseCases, cryptoService, messageBusService)
+
+	proxyAuthConfig, proxyAuthConfigErr := config.LoadProxyAuthConfig()
+	if proxyAuthConfigErr != nil {
+		log.Fatalf("failed to load proxy auth config: %s", proxyAuthConfigErr)
+	}
+	if proxyAuthConfig.SchemeEnabled(config.ProxyAuthSchemeDigest) {
+		digestCredentialRepo := repositories.NewDigestCredentialRepository(db, services.NewMapCacheWithTTL[dataobjects.DigestCredential]())
+		userUseCases = userUseCases.WithDigestCredentials(digestCredentialRepo, proxyAuthConfig.Realm)
+	}
+	proxyCredentialRepo := repositories.NewProxyCredentialRepository(db, services.NewMapCacheWithTTL[aggregates.ProxyCredential]())
+	proxyCredentialUseCases := use_cases.NewProxyCredentialUseCases(proxyCredentialRepo, cryptoService)
+	authorizedNetworkUseCases := use_cases.NewAuthorizedNetworkUseCases(repositories.NewAuthorizedNetworkRepository(db))
+	authService := google_auth.NewGoogleAuthService(userUseCases, proxyCredentialUseCases, authorizedNetworkUseCases,
+		cryptoService, messageBusService)
+	if proxyAuthConfig.SchemeEnabled(config.ProxyAuthSchemeBearer) {
+		authService = authService.WithProxyTokens(services.NewHS256Jwt(), proxyAuthConfig.JwtSecret, proxyAuthConfig.JwtIssuer)
+	}
 	controller := google_auth.NewGoogleAuthController(authService)
 	controller.Listen(oauthConfig.Port)
 }
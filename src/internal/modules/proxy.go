@@ -3,22 +3,36 @@ package modules
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"goproxy/application/contracts"
 	"goproxy/application/use_cases"
 	"goproxy/dal"
 	"goproxy/dal/cache"
 	"goproxy/dal/repositories"
 	"goproxy/domain"
 	"goproxy/domain/aggregates"
+	"goproxy/domain/dataobjects"
 	"goproxy/infrastructure"
 	"goproxy/infrastructure/config"
+	"goproxy/infrastructure/eventhandlers/AuthorizedNetworksChangedEvent"
+	"goproxy/infrastructure/eventhandlers/ProxyCredentialChangedEvent"
+	"goproxy/infrastructure/eventhandlers/UserConsumedTrafficEvent"
 	"goproxy/infrastructure/eventhandlers/UserPasswordChangedEvent"
+	"goproxy/infrastructure/eventhandlers/UserPlanLimitsChangedEvent"
 	"goproxy/infrastructure/services"
 	"log"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 )
 
+const (
+	defaultShutdownDrainTimeout = 30 * time.Second
+	// shutdownTrafficReportTimeout is how long traffic left unreported is being sent on shutdown
+	shutdownTrafficReportTimeout = 10 * time.Second
+)
+
 type Proxy struct {
 }
 
@@ -26,7 +40,8 @@ func NewProxy() *Proxy {
 	return &Proxy{}
 }
 
-func (p *Proxy) Start() {
+// Start serves clients until ctx is done, then drains client connections and stops.
+func (p *Proxy) Start(ctx context.Context) {
 	strPort := os.Getenv("HTTP_LISTENER_PORT")
 	if strPort == "" {
 		log.Fatalf("'HTTP_LISTENER_PORT' env var must be set")
@@ -37,6 +52,31 @@ func (p *Proxy) Start() {
 		log.Fatalf("failed to parse HTTP_LISTENER_PORT: %s", err)
 	}
 
+	// HTTP listener port detects SOCKS clients as well, a dedicated SOCKS5 port is optional
+	socks5Port := 0
+	strSocks5Port := os.Getenv("SOCKS5_LISTENER_PORT")
+	if strSocks5Port != "" {
+		socks5Port, err = strconv.Atoi(strSocks5Port)
+		if err != nil {
+			log.Fatalf("failed to parse SOCKS5_LISTENER_PORT: %s", err)
+		}
+	}
+
+	drainTimeout, drainTimeoutErr := loadShutdownDrainTimeout()
+	if drainTimeoutErr != nil {
+		log.Fatal(drainTimeoutErr)
+	}
+
+	tlsListenerConfig, tlsListenerConfigErr := config.LoadTlsListenerConfig()
+	if tlsListenerConfigErr != nil {
+		log.Fatal(tlsListenerConfigErr)
+	}
+
+	timeoutsConfig, timeoutsConfigErr := config.LoadProxyTimeoutsConfig()
+	if timeoutsConfigErr != nil {
+		log.Fatalf("failed to load proxy timeouts config: %s", timeoutsConfigErr)
+	}
+
 	db, err := dal.ConnectDB()
 	defer func(db *sql.DB) {
 		_ = db.Close()
@@ -56,8 +96,12 @@ func (p *Proxy) Start() {
 	}
 	kafkaConfig.GroupID = "proxy"
 
+	// background workers are stopped after client connections are drained, as they serve them until then
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
 	eventHandleErr := UserPasswordChangedEvent.NewUserPasswordChangedEventProcessor[aggregates.User](domain.PROXY, userRepositoryCache).
-		ProcessEvents()
+		ProcessEvents(workersCtx)
 	if eventHandleErr != nil {
 		log.Fatal(eventHandleErr)
 	}
@@ -69,20 +113,215 @@ func (p *Proxy) Start() {
 	authCache := services.NewMapCacheWithTTL[services.ValidateResult]()
 
 	authCacheEventHandlerErr := UserPasswordChangedEvent.NewUserPasswordChangedEventProcessor[services.ValidateResult](domain.PROXY, authCache).
-		ProcessEvents()
+		ProcessEvents(workersCtx)
 	if authCacheEventHandlerErr != nil {
 		log.Fatal(eventHandleErr)
 	}
 
+	// credentials are reloaded when they are revoked or run out of traffic
+	proxyCredentialCache := services.NewMapCacheWithTTL[aggregates.ProxyCredential]()
+	proxyCredentialRepo := repositories.NewProxyCredentialRepository(db, proxyCredentialCache)
+	proxyCredentialEventHandlerErr := ProxyCredentialChangedEvent.NewProxyCredentialChangedEventProcessor(domain.PROXY, proxyCredentialCache).
+		ProcessEvents(workersCtx)
+	if proxyCredentialEventHandlerErr != nil {
+		log.Fatal(proxyCredentialEventHandlerErr)
+	}
+	credentialTrafficEventHandlerErr := UserConsumedTrafficEvent.NewCredentialTrafficEventProcessor(domain.PLAN, proxyCredentialRepo).
+		ProcessEvents(workersCtx)
+	if credentialTrafficEventHandlerErr != nil {
+		log.Fatal(credentialTrafficEventHandlerErr)
+	}
+
 	authService := services.NewAuthService(cryptoService, authCache)
-	authUseCases := use_cases.NewAuthUseCases(authService, userRepo, userRestrictionService)
+	authUseCases := use_cases.NewAuthUseCases(authService, userRepo, proxyCredentialRepo, userRestrictionService).
+		WithUnknownUsernames(services.NewMapCacheWithTTL[bool]())
+
+	authThrottleConfig, authThrottleConfigErr := config.LoadAuthThrottleConfig()
+	if authThrottleConfigErr != nil {
+		log.Fatalf("failed to load auth throttle config: %s", authThrottleConfigErr)
+	}
+	if authThrottleConfig.Enabled {
+		messageBusService, messageBusErr := services.NewKafkaService(kafkaConfig)
+		if messageBusErr != nil {
+			log.Fatal(messageBusErr)
+		}
+		authThrottle, authThrottleErr := services.NewAuthThrottle(authThrottleConfig)
+		if authThrottleErr != nil {
+			log.Fatalf("failed to create auth throttle: %s", authThrottleErr)
+		}
+		authUseCases = authUseCases.WithAuthThrottle(authThrottle.WithMessageBus(messageBusService))
+	}
+
+	ipAuthConfig, ipAuthConfigErr := config.LoadIpAuthConfig()
+	if ipAuthConfigErr != nil {
+		log.Fatalf("failed to load IP auth config: %s", ipAuthConfigErr)
+	}
+	if ipAuthConfig.Enabled {
+		// networks are reloaded on change events and periodically, in case a node misses events
+		authorizedNetworkIndex := services.NewAuthorizedNetworkIndex(repositories.NewAuthorizedNetworkRepository(db))
+		if reloadErr := authorizedNetworkIndex.Reload(); reloadErr != nil {
+			log.Fatalf("failed to load authorized networks: %s", reloadErr)
+		}
+		authorizedNetworkIndex.StartRefreshing(workersCtx, ipAuthConfig.RefreshInterval)
+		authorizedNetworksEventHandlerErr := AuthorizedNetworksChangedEvent.NewAuthorizedNetworksChangedEventProcessor(domain.PROXY, authorizedNetworkIndex).
+			ProcessEvents(workersCtx)
+		if authorizedNetworksEventHandlerErr != nil {
+			log.Fatal(authorizedNetworksEventHandlerErr)
+		}
+		authUseCases = authUseCases.WithAuthorizedNetworks(authorizedNetworkIndex)
+	}
+
+	proxyAuthConfig, proxyAuthConfigErr := config.LoadProxyAuthConfig()
+	if proxyAuthConfigErr != nil {
+		log.Fatalf("failed to load proxy auth config: %s", proxyAuthConfigErr)
+	}
+	authenticators, authenticatorsErr := newProxyAuthenticators(workersCtx, db, authUseCases, proxyAuthConfig)
+	if authenticatorsErr != nil {
+		log.Fatal(authenticatorsErr)
+	}
+
+	go userRestrictionService.ProcessEvents(workersCtx)
+
+	planLimitsService := services.NewUserPlanLimitsService()
+	planLimitsEventHandlerErr := UserPlanLimitsChangedEvent.NewUserPlanLimitsChangedEventProcessor(domain.PROXY, planLimitsService).
+		ProcessEvents(workersCtx)
+	if planLimitsEventHandlerErr != nil {
+		log.Fatal(planLimitsEventHandlerErr)
+	}
 
-	go userRestrictionService.ProcessEvents()
+	egressPolicyConfig, egressPolicyConfigErr := config.LoadEgressPolicyConfig()
+	if egressPolicyConfigErr != nil {
+		log.Fatalf("failed to load egress policy config: %s", egressPolicyConfigErr)
+	}
+	dnsResolverConfig, dnsResolverConfigErr := config.LoadDnsResolverConfig()
+	if dnsResolverConfigErr != nil {
+		log.Fatalf("failed to load dns resolver config: %s", dnsResolverConfigErr)
+	}
+	dnsResolver := services.NewCachingDnsResolver(dnsResolverConfig)
+	dnsResolver.StartStatsLogging(workersCtx, time.Minute*5)
+
+	dialerPool := services.NewDialerPool(services.NewIPResolver()).
+		WithEgressPolicies(egressPolicyConfig, planLimitsService).
+		WithDnsResolver(dnsResolver)
+	dialerPool.StartExploringNewPublicIps(workersCtx, time.Hour*8)
+
+	proxy := services.NewProxy(dialerPool, planLimitsService).WithDnsResolver(dnsResolver)
+	// connection limiter is shared by all listeners, so node and user limits apply to all ports together
+	var connectionLimiter contracts.ConnectionLimiterService
+	if rateLimiterConfig := config.LoadRateLimiterConfig(); rateLimiterConfig.Distributed {
+		redisConnectionLimiter, redisConnectionLimiterErr := services.NewRedisConnectionLimiter(rateLimiterConfig)
+		if redisConnectionLimiterErr != nil {
+			log.Fatalf("failed to create redis connection limiter: %s", redisConnectionLimiterErr)
+		}
+		redisConnectionLimiter.WithPlanLimits(planLimitsService).StartRefreshing(workersCtx)
+		proxy.WithUserNodes(redisConnectionLimiter)
+		connectionLimiter = redisConnectionLimiter
+	} else {
+		connectionLimiter = services.NewConnectionLimiter(rateLimiterConfig).WithPlanLimits(planLimitsService)
+	}
+	listener := infrastructure.NewHttpListener(proxy).WithHandshakeTimeout(timeoutsConfig.Default.Handshake)
+	proxyUseCases := use_cases.NewProxyUseCases(proxy, proxy, proxy, listener, connectionLimiter, authUseCases).
+		WithAuthenticators(authenticators)
+	servers := []*use_cases.ProxyUseCases{proxyUseCases}
+	if socks5Port != 0 {
+		go proxyUseCases.ServeSocks5OnPort(socks5Port)
+	}
+	if tlsListenerConfig.Enabled() {
+		certificateReloader, certificateReloaderErr := services.NewTlsCertificateReloader(tlsListenerConfig.CertFile, tlsListenerConfig.KeyFile)
+		if certificateReloaderErr != nil {
+			log.Fatalf("failed to load TLS certificate: %s", certificateReloaderErr)
+		}
+		certificateReloader.Watch(workersCtx, tlsListenerConfig.ReloadInterval)
+
+		tlsListener := infrastructure.NewTlsHttpListener(proxy, certificateReloader.TlsConfig()).
+			WithHandshakeTimeout(timeoutsConfig.Default.Handshake)
+		tlsProxyUseCases := use_cases.NewProxyUseCases(proxy, proxy, proxy, tlsListener, connectionLimiter, authUseCases).
+			WithAuthenticators(authenticators)
+		go tlsProxyUseCases.ServeOnPort(tlsListenerConfig.Port)
+		servers = append(servers, tlsProxyUseCases)
+	}
+	go proxyUseCases.ServeOnPort(port)
+
+	<-ctx.Done()
+	log.Printf("Shutting down, draining client connections for up to %v", drainTimeout)
+
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancelDrain()
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *use_cases.ProxyUseCases) {
+			defer wg.Done()
+			if shutdownErr := server.Shutdown(drainCtx); shutdownErr != nil {
+				log.Printf("Client connections were closed before finishing: %v", shutdownErr)
+			}
+		}(server)
+	}
+	wg.Wait()
+
+	reportCtx, cancelReport := context.WithTimeout(context.Background(), shutdownTrafficReportTimeout)
+	defer cancelReport()
+	if shutdownErr := proxy.Shutdown(reportCtx); shutdownErr != nil {
+		log.Printf("Failed to report traffic on shutdown: %v", shutdownErr)
+	}
+
+	stopWorkers()
+	log.Printf("Proxy stopped")
+}
+
+// newProxyAuthenticators creates authenticators of the enabled schemes, in the order they are offered to clients.
+func newProxyAuthenticators(ctx context.Context, db *sql.DB, authUseCases use_cases.AuthUseCases,
+	authConfig config.ProxyAuthConfig) (use_cases.ProxyAuthenticators, error) {
+	var authenticators []use_cases.ProxyAuthenticator
+	for _, scheme := range authConfig.Schemes {
+		switch scheme {
+		case config.ProxyAuthSchemeBasic:
+			authenticators = append(authenticators, use_cases.NewBasicAuthenticator(authUseCases, authConfig.Realm))
+		case config.ProxyAuthSchemeDigest:
+			// hashes are reloaded when passwords change
+			digestCredentialCache := services.NewMapCacheWithTTL[dataobjects.DigestCredential]()
+			eventHandleErr := UserPasswordChangedEvent.NewUserPasswordChangedEventProcessor[dataobjects.DigestCredential](domain.PROXY, digestCredentialCache).
+				ProcessEvents(ctx)
+			if eventHandleErr != nil {
+				return use_cases.ProxyAuthenticators{}, eventHandleErr
+			}
+
+			digestAuthenticator, err := use_cases.NewDigestAuthenticator(authUseCases,
+				repositories.NewDigestCredentialRepository(db, digestCredentialCache),
+				authConfig.Realm, authConfig.DigestNonceSecret, authConfig.DigestNonceTTL)
+			if err != nil {
+				return use_cases.ProxyAuthenticators{}, err
+			}
+			// nodes accepting nonces of each other must refuse responses taken by any of them
+			if authConfig.DigestNonceSecret != "" {
+				nonceCounter, nonceCounterErr := services.NewRedisDigestNonceCounter()
+				if nonceCounterErr != nil {
+					return use_cases.ProxyAuthenticators{}, fmt.Errorf("digest nonce counts must be shared by nodes sharing the nonce secret: %v", nonceCounterErr)
+				}
+				digestAuthenticator.WithNonceCounter(nonceCounter)
+			}
+			authenticators = append(authenticators, digestAuthenticator)
+		case config.ProxyAuthSchemeBearer:
+			authenticators = append(authenticators, use_cases.NewBearerAuthenticator(authUseCases, services.NewHS256Jwt(),
+				authConfig.JwtSecret, authConfig.JwtIssuer, authConfig.Realm))
+		}
+	}
+
+	return use_cases.NewProxyAuthenticators(authenticators...), nil
+}
+
+// loadShutdownDrainTimeout reads SHUTDOWN_DRAIN_TIMEOUT_SEC, how long client connections are waited for on shutdown.
+func loadShutdownDrainTimeout() (time.Duration, error) {
+	timeoutStr := os.Getenv("SHUTDOWN_DRAIN_TIMEOUT_SEC")
+	if timeoutStr == "" {
+		return defaultShutdownDrainTimeout, nil
+	}
+
+	timeoutSec, err := strconv.Atoi(timeoutStr)
+	if err != nil || timeoutSec < 0 {
+		return 0, fmt.Errorf("invalid SHUTDOWN_DRAIN_TIMEOUT_SEC value: %s", timeoutStr)
+	}
 
-	dialerPool := services.NewDialerPool(services.NewIPResolver())
-	dialerPool.StartExploringNewPublicIps(context.Background(), time.Hour*8)
-	proxy := services.NewProxy(dialerPool)
-	listener := infrastructure.NewHttpListener(proxy)
-	proxyUseCases := use_cases.NewProxyUseCases(proxy, listener, authUseCases)
-	proxyUseCases.ServeOnPort(port)
+	return time.Duration(timeoutSec) * time.Second, nil
 }
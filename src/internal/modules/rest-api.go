@@ -1,6 +1,7 @@
 package modules
 
 import (
+	"context"
 	"database/sql"
 	"goproxy/application/use_cases"
 	"goproxy/dal"
@@ -8,7 +9,9 @@ import (
 	"goproxy/dal/repositories"
 	"goproxy/domain"
 	"goproxy/domain/aggregates"
+	"goproxy/domain/dataobjects"
 	"goproxy/infrastructure/api/api-http/users"
+	"goproxy/infrastructure/config"
 	"goproxy/infrastructure/eventhandlers/UserPasswordChangedEvent"
 	"goproxy/infrastructure/services"
 	"log"
@@ -48,7 +51,7 @@ func (api *UsersApi) Start() {
 	}
 
 	eventHandleErr := UserPasswordChangedEvent.NewUserPasswordChangedEventProcessor[aggregates.User](domain.PROXY, userRepositoryCache).
-		ProcessEvents()
+		ProcessEvents(context.Background())
 	if eventHandleErr != nil {
 		log.Fatal(eventHandleErr)
 	}
@@ -58,6 +61,27 @@ func (api *UsersApi) Start() {
 	cryptoService := services.GetCryptoService()
 	useCases := use_cases.NewUserUseCases(userRepo, cryptoService)
 
+	proxyAuthConfig, proxyAuthConfigErr := config.LoadProxyAuthConfig()
+	if proxyAuthConfigErr != nil {
+		log.Fatalf("failed to load proxy auth config: %s", proxyAuthConfigErr)
+	}
+	if proxyAuthConfig.SchemeEnabled(config.ProxyAuthSchemeDigest) {
+		digestCredentialRepo := repositories.NewDigestCredentialRepository(db, services.NewMapCacheWithTTL[dataobjects.DigestCredential]())
+		useCases = useCases.WithDigestCredentials(digestCredentialRepo, proxyAuthConfig.Realm)
+	}
+
+	authThrottleConfig, authThrottleConfigErr := config.LoadAuthThrottleConfig()
+	if authThrottleConfigErr != nil {
+		log.Fatalf("failed to load auth throttle config: %s", authThrottleConfigErr)
+	}
+	if authThrottleConfig.Enabled {
+		authThrottle, authThrottleErr := services.NewAuthThrottle(authThrottleConfig)
+		if authThrottleErr != nil {
+			log.Fatalf("failed to create auth throttle: %s", authThrottleErr)
+		}
+		useCases = useCases.WithAuthThrottle(authThrottle)
+	}
+
 	usersController := users.NewUsersController(useCases)
 	usersController.Listen(port)
 }